@@ -41,6 +41,7 @@ import (
 	fprobes "github.com/skydive-project/skydive/flow/probes"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/oftrace"
 	"github.com/skydive-project/skydive/packet_injector"
 	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology"
@@ -153,6 +154,10 @@ func (a *Agent) Stop() {
 
 // NewAgent instanciates a new Agent aiming to launch probes (topology and flow)
 func NewAgent() (*Agent, error) {
+	if err := enroll(); err != nil {
+		return nil, err
+	}
+
 	backend, err := graph.NewMemoryBackend()
 	if err != nil {
 		return nil, err
@@ -180,6 +185,8 @@ func NewAgent() (*Agent, error) {
 
 	tr := traversal.NewGremlinTraversalParser()
 	tr.AddTraversalExtension(ge.NewMetricsTraversalExtension())
+	tr.AddTraversalExtension(ge.NewOfRuleTraversalExtension())
+	tr.AddTraversalExtension(ge.NewRouteToTraversalExtension())
 
 	rootNode, err := createRootNode(g)
 	if err != nil {
@@ -227,6 +234,8 @@ func NewAgent() (*Agent, error) {
 
 	packet_injector.NewServer(g, analyzerClientPool)
 
+	oftrace.NewServer(g, analyzerClientPool)
+
 	flowClientPool := analyzer.NewFlowClientPool(analyzerClientPool)
 
 	flowProbeBundle := fprobes.NewFlowProbeBundle(topologyProbeBundle, g, flowTableAllocator, flowClientPool)
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package agent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+var errEnrollmentRefused = errors.New("Enrollment refused by the analyzer")
+
+// enrollWith requests a client certificate to an analyzer
+func enrollWith(client *http.Client, sa common.ServiceAddress, request *types.EnrollmentRequest) (*types.EnrollmentReply, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	url := config.GetURL("https", sa.Addr, sa.Port, "/api/enroll")
+	resp, err := client.Post(url.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, errEnrollmentRefused
+	default:
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Failed to enroll with %s:%d: %s (%s)", sa.Addr, sa.Port, resp.Status, string(data))
+	}
+
+	var reply types.EnrollmentReply
+	if err := common.JSONDecode(resp.Body, &reply); err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+// enroll gets a client certificate from an analyzer using the enrollment
+// token when the certificate of the agent doesn't exist yet. It blocks until
+// an analyzer replied, the agent not being able to connect without it.
+func enroll() error {
+	token := config.GetConfig().GetString("agent.enrollment.token")
+	if token == "" {
+		return nil
+	}
+
+	certPEM := config.GetConfig().GetString("agent.X509_cert")
+	keyPEM := config.GetConfig().GetString("agent.X509_key")
+	if certPEM == "" || keyPEM == "" {
+		return errors.New("agent.X509_cert and agent.X509_key have to be set to store the enrollment certificate")
+	}
+
+	if _, err := os.Stat(certPEM); err == nil {
+		return nil
+	}
+
+	host := config.GetConfig().GetString("host_id")
+	csr, key, err := common.NewCertificateRequest(host)
+	if err != nil {
+		return err
+	}
+
+	addresses, err := config.GetAnalyzerServiceAddresses()
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return errors.New("No analyzer to enroll with")
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.GetConfig().GetBool("agent.X509_insecure")}
+	if analyzerCertPEM := config.GetConfig().GetString("analyzer.X509_cert"); analyzerCertPEM != "" {
+		tlsConfig.RootCAs = common.SetupTLSLoadCertificate(analyzerCertPEM)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		Timeout:   10 * time.Second,
+	}
+
+	request := &types.EnrollmentRequest{Token: token, Host: host, CSR: string(csr)}
+
+	var reply *types.EnrollmentReply
+	for reply == nil {
+		for _, sa := range addresses {
+			if reply, err = enrollWith(client, sa, request); err == nil {
+				break
+			}
+			if err == errEnrollmentRefused {
+				return err
+			}
+			logging.GetLogger().Errorf("Enrollment failed: %s", err)
+		}
+
+		if reply == nil {
+			time.Sleep(5 * time.Second)
+		}
+	}
+
+	if err := ioutil.WriteFile(keyPEM, key, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certPEM, []byte(reply.Certificate), 0644); err != nil {
+		return err
+	}
+
+	logging.GetLogger().Infof("Agent enrolled, certificate written to %s", certPEM)
+
+	return nil
+}
@@ -30,6 +30,8 @@ import (
 	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology/graph"
 	"github.com/skydive-project/skydive/topology/probes/docker"
+	"github.com/skydive-project/skydive/topology/probes/lldp"
+	"github.com/skydive-project/skydive/topology/probes/netfilter"
 	"github.com/skydive-project/skydive/topology/probes/netlink"
 	"github.com/skydive-project/skydive/topology/probes/netns"
 	"github.com/skydive-project/skydive/topology/probes/neutron"
@@ -82,6 +84,15 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph, n *graph.Node) (*probe.Pro
 				return nil, err
 			}
 			probes["neutron"] = neutron
+		case "netfilter":
+			netfilter, err := netfilter.NewProbe(g, n)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize netfilter probe: %s", err.Error())
+				return nil, err
+			}
+			probes[t] = netfilter
+		case "lldp":
+			probes[t] = lldp.NewProbe(g, n)
 		case "opencontrail":
 			opencontrail, err := opencontrail.NewOpenContrailProbeFromConfig(g, n)
 			if err != nil {
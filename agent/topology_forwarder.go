@@ -23,6 +23,12 @@
 package agent
 
 import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+
 	"github.com/skydive-project/skydive/config"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
@@ -30,67 +36,188 @@ import (
 )
 
 // TopologyForwarder forwards the topology to only one master server.
-// When switching from one analyzer to another one the agent does a full
-// re-sync since some messages could have been lost.
+// The forwarded messages are numbered and kept in a journal. When connecting
+// to an analyzer, the agent asks for the last message of its session the
+// analyzer got and replays the following ones. If the journal doesn't contain
+// them anymore or if the analyzer doesn't know the session, the whole graph is
+// sent so that the analyzer reconciles it with its own copy.
 type TopologyForwarder struct {
+	sync.Mutex
 	masterElection *shttp.WSMasterElection
 	graph          *graph.Graph
 	host           string
+	session        string
+	journal        *topologyJournal
+	// master the forwarder waits for the journal reply of, messages are only
+	// recorded in the meantime
+	resuming shttp.WSSpeaker
 }
 
 func (t *TopologyForwarder) triggerResync() {
 	logging.GetLogger().Infof("Start a re-sync for %s", t.host)
 
-	t.graph.RLock()
-	defer t.graph.RUnlock()
-
 	// request for deletion of everything belonging this host
 	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.HostGraphDeletedMsgType, t.host))
 
 	// re-add all the nodes and edges
-	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.SyncMsgType, t.graph))
+	msg := shttp.NewWSJSONMessage(graph.Namespace, graph.SyncMsgType, t.graph)
+	msg.Seq = t.journal.seq
+	t.masterElection.SendMessageToMaster(msg)
+}
+
+// resume sends to the master the messages it didn't get, or the whole graph
+// to be reconciled
+func (t *TopologyForwarder) resume(status int, seq int64) {
+	if status == http.StatusOK {
+		if msgs, ok := t.journal.since(seq); ok {
+			logging.GetLogger().Infof("Replay %d messages of the journal for %s", len(msgs), t.host)
+			for _, msg := range msgs {
+				t.masterElection.SendMessageToMaster(msg)
+			}
+			return
+		}
+	}
+
+	logging.GetLogger().Infof("Start a reconciliation for %s", t.host)
+
+	msg := shttp.NewWSJSONMessage(graph.Namespace, graph.HostSyncMsgType, t.graph)
+	msg.Seq = t.journal.seq
+	t.masterElection.SendMessageToMaster(msg)
+}
+
+// forward records the message in the journal and sends it to the master
+func (t *TopologyForwarder) forward(msgType string, obj interface{}) {
+	msg := shttp.NewWSJSONMessage(graph.Namespace, msgType, obj)
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.journal.append(msg)
+	if t.resuming == nil {
+		t.masterElection.SendMessageToMaster(msg)
+	}
 }
 
 // OnNewMaster is called by the master election mechanism when a new master is elected. In
-// such case a "Re-sync" is triggerd in order to be in sync with the new master.
+// such case the forwarder asks the new master where to resume from.
 func (t *TopologyForwarder) OnNewMaster(c shttp.WSSpeaker) {
 	if c == nil {
 		logging.GetLogger().Warn("Lost connection to master")
-	} else {
-		addr, port := c.GetAddrPort()
-		logging.GetLogger().Infof("Using %s:%d as master of topology forwarder", addr, port)
-		t.triggerResync()
+		return
+	}
+
+	addr, port := c.GetAddrPort()
+	logging.GetLogger().Infof("Using %s:%d as master of topology forwarder", addr, port)
+
+	t.Lock()
+	t.resuming = c
+	t.Unlock()
+
+	c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.JournalRequestMsgType, graph.JournalRequestMsg{Session: t.session}))
+
+	// analyzers not supporting the journal don't reply, fallback to a re-sync
+	time.AfterFunc(shttp.DefaultRequestTimeout, func() {
+		t.graph.RLock()
+		defer t.graph.RUnlock()
+
+		t.Lock()
+		defer t.Unlock()
+
+		if t.resuming == c {
+			t.resuming = nil
+			t.triggerResync()
+		}
+	})
+}
+
+func (t *TopologyForwarder) onJournalReply(c shttp.WSSpeaker, status int, reply graph.JournalReplyMsg) {
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.resuming == nil || t.resuming.GetHost() != c.GetHost() {
+		return
+	}
+	t.resuming = nil
+
+	t.resume(status, reply.Seq)
+}
+
+// onElementRequest forwards the whole element the master missed partial
+// updates of, through the journal to keep the order of the updates
+func (t *TopologyForwarder) onElementRequest(msgType string, r graph.ElementRequestMsg) {
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
+	switch msgType {
+	case graph.NodeRequestMsgType:
+		if n := t.graph.GetNode(r.ID); n != nil {
+			t.forward(graph.NodeUpdatedMsgType, n)
+		}
+	case graph.EdgeRequestMsgType:
+		if e := t.graph.GetEdge(r.ID); e != nil {
+			t.forward(graph.EdgeUpdatedMsgType, e)
+		}
+	}
+}
+
+// OnWSJSONMessage is triggered by the journal reply of the master, or by its
+// requests of the whole elements it missed partial updates of.
+func (t *TopologyForwarder) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	msgType, obj, err := graph.UnmarshalWSMessage(msg)
+	if err != nil {
+		logging.GetLogger().Errorf("Graph: Unable to parse the event %v: %s", msg, err.Error())
+		return
+	}
+
+	switch msgType {
+	case graph.JournalReplyMsgType:
+		t.onJournalReply(c, msg.Status, obj.(graph.JournalReplyMsg))
+	case graph.NodeRequestMsgType, graph.EdgeRequestMsgType:
+		t.onElementRequest(msgType, obj.(graph.ElementRequestMsg))
 	}
 }
 
 // OnNodeUpdated graph node updated event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnNodeUpdated(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeUpdatedMsgType, n))
+	t.forward(graph.NodeUpdatedMsgType, n)
+}
+
+// OnNodePartiallyUpdated graph node partially updated event. Implements the GraphPartialUpdateListener interface.
+func (t *TopologyForwarder) OnNodePartiallyUpdated(n *graph.Node, ops []graph.PartialUpdate) {
+	t.forward(graph.NodePartiallyUpdatedMsgType, graph.NewPartiallyUpdatedMsg(n, ops))
 }
 
 // OnNodeAdded graph node added event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnNodeAdded(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeAddedMsgType, n))
+	t.forward(graph.NodeAddedMsgType, n)
 }
 
 // OnNodeDeleted graph node deleted event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnNodeDeleted(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeDeletedMsgType, n))
+	t.forward(graph.NodeDeletedMsgType, n)
 }
 
 // OnEdgeUpdated graph edge updated event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnEdgeUpdated(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeUpdatedMsgType, e))
+	t.forward(graph.EdgeUpdatedMsgType, e)
+}
+
+// OnEdgePartiallyUpdated graph edge partially updated event. Implements the GraphPartialUpdateListener interface.
+func (t *TopologyForwarder) OnEdgePartiallyUpdated(e *graph.Edge, ops []graph.PartialUpdate) {
+	t.forward(graph.EdgePartiallyUpdatedMsgType, graph.NewPartiallyUpdatedMsg(e, ops))
 }
 
 // OnEdgeAdded graph edge added event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnEdgeAdded(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeAddedMsgType, e))
+	t.forward(graph.EdgeAddedMsgType, e)
 }
 
 // OnEdgeDeleted graph edge deleted event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnEdgeDeleted(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeDeletedMsgType, e))
+	t.forward(graph.EdgeDeletedMsgType, e)
 }
 
 // GetMaster returns the current analyzer the agent is sending its events to
@@ -103,15 +230,22 @@ func (t *TopologyForwarder) GetMaster() shttp.WSSpeaker {
 func NewTopologyForwarder(host string, g *graph.Graph, pool shttp.WSJSONSpeakerPool) *TopologyForwarder {
 	masterElection := shttp.NewWSMasterElection(pool)
 
+	session, _ := uuid.NewV4()
+
 	t := &TopologyForwarder{
 		masterElection: masterElection,
 		graph:          g,
 		host:           host,
+		session:        session.String(),
+		journal:        newTopologyJournal(config.GetConfig().GetInt("agent.topology.journal_size")),
 	}
 
 	masterElection.AddEventHandler(t)
 	g.AddEventListener(t)
 
+	// subscribe to the journal replies of the analyzers
+	pool.AddJSONMessageHandler(t, []string{graph.Namespace})
+
 	return t
 }
 
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package agent
+
+import (
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+// topologyJournal keeps the last graph messages forwarded to the analyzers,
+// numbered by a sequence, so that the ones lost during a disconnection can
+// be replayed. The messages reference the graph elements, so a replayed
+// message carries the current state of its element.
+type topologyJournal struct {
+	messages []*shttp.WSJSONMessage
+	size     int
+	seq      int64
+}
+
+// append numbers the message and records it, the oldest message is dropped
+// when the journal is full
+func (j *topologyJournal) append(msg *shttp.WSJSONMessage) {
+	j.seq++
+	msg.Seq = j.seq
+
+	if j.size <= 0 {
+		return
+	}
+
+	if len(j.messages) >= j.size {
+		j.messages = j.messages[len(j.messages)-j.size+1:]
+	}
+	j.messages = append(j.messages, msg)
+}
+
+// since returns the messages following the given sequence number, false if
+// some of them are not in the journal anymore
+func (j *topologyJournal) since(seq int64) ([]*shttp.WSJSONMessage, bool) {
+	if seq == j.seq {
+		return nil, true
+	}
+
+	if seq > j.seq || len(j.messages) == 0 || j.messages[0].Seq > seq+1 {
+		return nil, false
+	}
+
+	return j.messages[seq+1-j.messages[0].Seq:], true
+}
+
+func newTopologyJournal(size int) *topologyJournal {
+	return &topologyJournal{size: size}
+}
@@ -24,20 +24,22 @@ package alert
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os/exec"
-	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/robertkrimen/otto"
 	api "github.com/skydive-project/skydive/api/server"
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/etcd"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
@@ -50,6 +52,10 @@ const (
 	Namespace = "Alert"
 )
 
+// statusRefresh is the minimum interval between two reads of the firing state
+// persisted by the master, when reporting the status of a non master analyzer
+const statusRefresh = 10 * time.Second
+
 const (
 	actionWebHook = 1 + iota
 	actionScript
@@ -58,14 +64,29 @@ const (
 type GremlinAlert struct {
 	*types.Alert
 	graph             *graph.Graph
-	lastEval          interface{}
+	lastEval          string
 	kind              int
 	data              string
 	traversalSequence *traversal.GremlinTraversalSequence
-	gremlinParser     *traversal.GremlinTraversalParser
+	queryCache        *traversal.QueryCache
+	incremental       *traversal.IncrementalQuery
 }
 
 func (ga *GremlinAlert) Evaluate(lockGraph bool) (interface{}, error) {
+	// The result of an incremental query is maintained by the graph events
+	if ga.incremental != nil {
+		if lockGraph {
+			ga.graph.RLock()
+			defer ga.graph.RUnlock()
+		}
+
+		if values := ga.incremental.Values(); len(values) > 0 {
+			return values, nil
+		}
+
+		return nil, nil
+	}
+
 	// If the alert is a simple Gremlin query, avoid
 	// converting to JavaScript
 	if ga.traversalSequence != nil {
@@ -91,13 +112,13 @@ func (ga *GremlinAlert) Evaluate(lockGraph bool) (interface{}, error) {
 
 		query := call.Argument(0).String()
 
-		// TODO(sbaubeau) Cache the queries
-		ts, err := ga.gremlinParser.Parse(strings.NewReader(query))
+		ts, err := ga.queryCache.Get(query)
 		if err != nil {
 			return vm.MakeCustomError("ParseError", err.Error())
 		}
 
 		result, err := ts.Exec(ga.graph, lockGraph)
+		ga.queryCache.Put(query, ts)
 		if err != nil {
 			return vm.MakeCustomError("ExecuteError", err.Error())
 		}
@@ -202,13 +223,14 @@ func (ga *GremlinAlert) Trigger(payload []byte) error {
 	return nil
 }
 
-func NewGremlinAlert(alert *types.Alert, g *graph.Graph, p *traversal.GremlinTraversalParser) (*GremlinAlert, error) {
-	ts, _ := p.Parse(strings.NewReader(alert.Expression))
+func NewGremlinAlert(alert *types.Alert, g *graph.Graph, cache *traversal.QueryCache) (*GremlinAlert, error) {
+	// the sequence is kept by the alert, it is not given back to the cache
+	ts, _ := cache.Get(alert.Expression)
 
 	ga := &GremlinAlert{
 		Alert:             alert,
 		traversalSequence: ts,
-		gremlinParser:     p,
+		queryCache:        cache,
 		graph:             g,
 	}
 
@@ -225,14 +247,73 @@ func NewGremlinAlert(alert *types.Alert, g *graph.Graph, p *traversal.GremlinTra
 
 type AlertServer struct {
 	sync.RWMutex
-	*etcd.EtcdMasterElector
-	Graph         *graph.Graph
-	Pool          shttp.WSJSONSpeakerPool
-	AlertHandler  api.Handler
-	watcher       api.StoppableWatcher
-	graphAlerts   map[string]*GremlinAlert
-	alertTimers   map[string]chan bool
-	gremlinParser *traversal.GremlinTraversalParser
+	etcd.MasterElector
+	Graph          *graph.Graph
+	Pool           shttp.WSJSONSpeakerPool
+	AlertHandler   api.Handler
+	watcher        api.StoppableWatcher
+	graphAlerts    map[string]*GremlinAlert
+	durationAlerts map[string]*GremlinAlert
+	alertTimers    map[string]chan bool
+	queryCache     *traversal.QueryCache
+	host           string
+	state          etcd.State
+	firing         map[string]*alertState
+	resumed        int32
+	// evalLock guards the last evaluation of the alerts and the firing state
+	// reported by the status. It is always taken after the server lock as
+	// alerts are evaluated while holding it for reading.
+	evalLock  sync.Mutex
+	fired     map[string]time.Time
+	firedRead time.Time
+}
+
+// alertState describes the firing state of an alert. It is persisted in etcd
+// so that a new master does not trigger again the alerts already fired.
+type alertState struct {
+	Digest    string
+	Timestamp time.Time
+	Host      string
+}
+
+func normalizeEval(data interface{}) interface{} {
+	switch v := data.(type) {
+	case *graph.Node:
+		return "node:" + string(v.ID)
+	case *graph.Edge:
+		return "edge:" + string(v.ID)
+	case traversal.GraphTraversalStep:
+		return normalizeEval(v.Values())
+	case []*graph.Node:
+		values := make([]interface{}, len(v))
+		for i, n := range v {
+			values[i] = normalizeEval(n)
+		}
+		return values
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = normalizeEval(e)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			values[k] = normalizeEval(e)
+		}
+		return values
+	}
+	return data
+}
+
+// evalDigest returns a digest of an evaluation result, the graph elements
+// being identified by their ID.
+func evalDigest(data interface{}) (string, error) {
+	b, err := json.Marshal(normalizeEval(data))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(b)), nil
 }
 
 type AlertMessage struct {
@@ -269,7 +350,8 @@ func (a *AlertServer) TriggerAlert(al *GremlinAlert, data interface{}) error {
 }
 
 func (a *AlertServer) evaluateAlert(al *GremlinAlert, lockGraph bool) error {
-	if !a.IsMaster() {
+	// wait for the state of the previous master to be restored
+	if !a.IsMaster() || atomic.LoadInt32(&a.resumed) == 0 {
 		return nil
 	}
 
@@ -278,24 +360,161 @@ func (a *AlertServer) evaluateAlert(al *GremlinAlert, lockGraph bool) error {
 		return err
 	}
 
+	a.evalLock.Lock()
+	defer a.evalLock.Unlock()
+
 	if data != nil {
 		// Gremlin query/Javascript expression returned datas.
 		// Alert must but sent if those datas differ from the one that trigger
 		// the previous alert.
-		equal := reflect.DeepEqual(reflect.ValueOf(data).Interface(), al.lastEval)
-		if !equal {
-			al.lastEval = data
+		digest, err := evalDigest(data)
+		if err != nil {
+			return err
+		}
+
+		if digest != al.lastEval {
+			al.lastEval = digest
+			now := time.Now().UTC()
+			a.state.Set(al.UUID, &alertState{
+				Digest:    digest,
+				Timestamp: now,
+				Host:      a.host,
+			})
+			a.fired[al.UUID] = now
 			return a.TriggerAlert(al, data)
 		}
-	} else {
+	} else if al.lastEval != "" {
 		// Gremlin query returned no datas, or Javascript expression was unsuccessful
 		// Reset the lastEval to be able to trigger the alert next time
-		al.lastEval = nil
+		al.lastEval = ""
+		a.state.Delete(al.UUID)
+		delete(a.fired, al.UUID)
 	}
 
 	return nil
 }
 
+// loadState restores the firing state of the alerts left by the previous
+// master
+func (a *AlertServer) loadState() {
+	values, err := a.state.List()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to restore the state of the alerts: %s", err.Error())
+	}
+
+	firing := make(map[string]*alertState)
+	for id, value := range values {
+		var state alertState
+		if err := json.Unmarshal(value, &state); err != nil {
+			logging.GetLogger().Errorf("Invalid state for alert %s: %s", id, err.Error())
+			continue
+		}
+		firing[id] = &state
+	}
+
+	logging.GetLogger().Infof("Resuming with %d alerts firing", len(firing))
+
+	// the alerts not registered yet get their state when registered
+	a.Lock()
+	a.evalLock.Lock()
+	a.fired = make(map[string]time.Time)
+	for id, state := range firing {
+		a.fired[id] = state.Timestamp
+	}
+	for _, alerts := range []map[string]*GremlinAlert{a.graphAlerts, a.durationAlerts} {
+		for id, al := range alerts {
+			al.lastEval = ""
+			if state, found := firing[id]; found {
+				al.lastEval = state.Digest
+				delete(firing, id)
+			}
+		}
+	}
+	a.evalLock.Unlock()
+	a.firing = firing
+	a.Unlock()
+
+	atomic.StoreInt32(&a.resumed, 1)
+}
+
+// OnStartAsMaster event
+func (a *AlertServer) OnStartAsMaster() {
+	a.loadState()
+}
+
+// OnStartAsSlave event
+func (a *AlertServer) OnStartAsSlave() {
+}
+
+// OnSwitchToMaster event
+func (a *AlertServer) OnSwitchToMaster() {
+	a.loadState()
+}
+
+// OnSwitchToSlave event
+func (a *AlertServer) OnSwitchToSlave() {
+	atomic.StoreInt32(&a.resumed, 0)
+
+	// the firing state is now the one of the new master
+	a.evalLock.Lock()
+	a.firedRead = time.Time{}
+	a.evalLock.Unlock()
+}
+
+// readFired reads the firing state persisted by the master
+func (a *AlertServer) readFired() {
+	values, err := a.state.List()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to read the state of the alerts: %s", err.Error())
+		return
+	}
+
+	fired := make(map[string]time.Time)
+	for id, value := range values {
+		var state alertState
+		if err := json.Unmarshal(value, &state); err == nil {
+			fired[id] = state.Timestamp
+		}
+	}
+
+	a.evalLock.Lock()
+	a.fired = fired
+	a.evalLock.Unlock()
+}
+
+// Status returns the master of the alerts and the alerts firing. The master
+// reports its own state while the other analyzers report the state persisted
+// by the master, read at most every statusRefresh.
+func (a *AlertServer) Status() types.ElectionStatus {
+	status := types.ElectionStatus{
+		IsMaster: a.IsMaster(),
+		Master:   a.GetMaster(),
+	}
+
+	if !status.IsMaster {
+		a.evalLock.Lock()
+		refresh := time.Since(a.firedRead) > statusRefresh
+		if refresh {
+			a.firedRead = time.Now()
+		}
+		a.evalLock.Unlock()
+
+		if refresh {
+			a.readFired()
+		}
+	}
+
+	a.evalLock.Lock()
+	defer a.evalLock.Unlock()
+
+	status.State = make(map[string]string, len(a.fired))
+	for id, timestamp := range a.fired {
+		status.State[id] = timestamp.Format(time.RFC3339)
+	}
+
+	return status
+}
+
 func (a *AlertServer) EvaluateAlerts(alerts map[string]*GremlinAlert, lockGraph bool) {
 	a.RLock()
 	defer a.RUnlock()
@@ -307,28 +526,59 @@ func (a *AlertServer) EvaluateAlerts(alerts map[string]*GremlinAlert, lockGraph
 	}
 }
 
+// evaluateGraphAlerts evaluates the alerts triggered by the graph events.
+// Incremental alerts are only evaluated when the event modified their result.
+func (a *AlertServer) evaluateGraphAlerts(update func(q *traversal.IncrementalQuery) traversal.IncrementalDelta) {
+	a.RLock()
+	defer a.RUnlock()
+
+	for _, al := range a.graphAlerts {
+		if al.incremental != nil {
+			if delta := update(al.incremental); !delta.Changed() {
+				continue
+			}
+		}
+
+		if err := a.evaluateAlert(al, false); err != nil {
+			logging.GetLogger().Warning(err.Error())
+		}
+	}
+}
+
 func (a *AlertServer) OnNodeUpdated(n *graph.Node) {
-	a.EvaluateAlerts(a.graphAlerts, false)
+	a.evaluateGraphAlerts(func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnNodeUpdated(a.Graph, n)
+	})
 }
 
 func (a *AlertServer) OnNodeAdded(n *graph.Node) {
-	a.EvaluateAlerts(a.graphAlerts, false)
+	a.evaluateGraphAlerts(func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnNodeAdded(a.Graph, n)
+	})
 }
 
 func (a *AlertServer) OnNodeDeleted(n *graph.Node) {
-	a.EvaluateAlerts(a.graphAlerts, false)
+	a.evaluateGraphAlerts(func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnNodeDeleted(a.Graph, n)
+	})
 }
 
 func (a *AlertServer) OnEdgeAdded(e *graph.Edge) {
-	a.EvaluateAlerts(a.graphAlerts, false)
+	a.evaluateGraphAlerts(func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnEdgeAdded(a.Graph, e)
+	})
 }
 
 func (a *AlertServer) OnEdgeUpdated(e *graph.Edge) {
-	a.EvaluateAlerts(a.graphAlerts, false)
+	a.evaluateGraphAlerts(func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnEdgeUpdated(a.Graph, e)
+	})
 }
 
 func (a *AlertServer) OnEdgeDeleted(e *graph.Edge) {
-	a.EvaluateAlerts(a.graphAlerts, false)
+	a.evaluateGraphAlerts(func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnEdgeDeleted(a.Graph, e)
+	})
 }
 
 func parseTrigger(trigger string) (string, string) {
@@ -340,18 +590,26 @@ func parseTrigger(trigger string) (string, string) {
 }
 
 func (a *AlertServer) RegisterAlert(apiAlert *types.Alert) error {
-	alert, err := NewGremlinAlert(apiAlert, a.Graph, a.gremlinParser)
+	alert, err := NewGremlinAlert(apiAlert, a.Graph, a.queryCache)
 	if err != nil {
 		return err
 	}
 
 	logging.GetLogger().Debugf("Registering new alert: %+v", alert)
 
-	a.evaluateAlert(alert, true)
+	// resume from the state left by the previous master
+	a.Lock()
+	if state, found := a.firing[apiAlert.UUID]; found {
+		alert.lastEval = state.Digest
+		delete(a.firing, apiAlert.UUID)
+	}
+	a.Unlock()
 
 	trigger, data := parseTrigger(apiAlert.Trigger)
 	switch trigger {
 	case "duration":
+		a.evaluateAlert(alert, true)
+
 		duration, err := time.ParseDuration(data)
 		if err != nil {
 			return err
@@ -375,13 +633,27 @@ func (a *AlertServer) RegisterAlert(apiAlert *types.Alert) error {
 		}()
 		a.Lock()
 		a.alertTimers[apiAlert.UUID] = done
+		a.durationAlerts[apiAlert.UUID] = alert
 		a.Unlock()
 	case "graph":
 		fallthrough
 	default:
+		// the result of an incremental alert is computed and the alert
+		// registered while holding the graph lock so that no event is missed
+		a.Graph.RLock()
+		if alert.traversalSequence != nil {
+			if q := traversal.NewIncrementalQuery(alert.traversalSequence); q != nil && !q.SubGraph() {
+				q.Reset(a.Graph)
+				alert.incremental = q
+			}
+		}
+
+		a.evaluateAlert(alert, false)
+
 		a.Lock()
 		a.graphAlerts[apiAlert.UUID] = alert
 		a.Unlock()
+		a.Graph.RUnlock()
 	}
 
 	return nil
@@ -396,9 +668,17 @@ func (a *AlertServer) UnregisterAlert(id string) {
 	if ch, found := a.alertTimers[id]; found {
 		close(ch)
 		delete(a.alertTimers, id)
+		delete(a.durationAlerts, id)
 	} else {
 		delete(a.graphAlerts, id)
 	}
+
+	delete(a.firing, id)
+	a.state.Delete(id)
+
+	a.evalLock.Lock()
+	delete(a.fired, id)
+	a.evalLock.Unlock()
 }
 
 func (a *AlertServer) onAPIWatcherEvent(action string, id string, resource types.Resource) {
@@ -420,21 +700,33 @@ func (a *AlertServer) Start() {
 }
 
 func (a *AlertServer) Stop() {
-	a.EtcdMasterElector.Stop()
+	a.MasterElector.Stop()
+	a.state.Stop()
 }
 
-func NewAlertServer(ah api.Handler, pool shttp.WSJSONSpeakerPool, graph *graph.Graph, parser *traversal.GremlinTraversalParser, etcdClient *etcd.EtcdClient) *AlertServer {
-	elector := etcd.NewEtcdMasterElectorFromConfig(common.AnalyzerService, "alert-server", etcdClient)
-
+func newAlertServer(ah api.Handler, pool shttp.WSJSONSpeakerPool, graph *graph.Graph, parser *traversal.GremlinTraversalParser, host string, elector etcd.MasterElector, state etcd.State) *AlertServer {
 	as := &AlertServer{
-		EtcdMasterElector: elector,
-		Pool:              pool,
-		AlertHandler:      ah,
-		Graph:             graph,
-		graphAlerts:       make(map[string]*GremlinAlert),
-		alertTimers:       make(map[string]chan bool),
-		gremlinParser:     parser,
+		MasterElector:  elector,
+		Pool:           pool,
+		AlertHandler:   ah,
+		Graph:          graph,
+		graphAlerts:    make(map[string]*GremlinAlert),
+		durationAlerts: make(map[string]*GremlinAlert),
+		alertTimers:    make(map[string]chan bool),
+		queryCache:     traversal.NewQueryCache(parser, config.GetConfig().GetInt("graph.query_cache_size")),
+		host:           host,
+		state:          state,
+		firing:         make(map[string]*alertState),
+		fired:          make(map[string]time.Time),
 	}
+	elector.AddEventListener(as)
 
 	return as
 }
+
+func NewAlertServer(ah api.Handler, pool shttp.WSJSONSpeakerPool, graph *graph.Graph, parser *traversal.GremlinTraversalParser, etcdClient *etcd.EtcdClient) *AlertServer {
+	elector := etcd.NewEtcdMasterElectorFromConfig(common.AnalyzerService, "alert-server", etcdClient)
+	state := etcd.NewEtcdState(common.AnalyzerService, "alert-server", etcdClient)
+
+	return newAlertServer(ah, pool, graph, parser, elector.Host, elector, state)
+}
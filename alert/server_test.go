@@ -0,0 +1,252 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package alert
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/etcd"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// fakeElector lets the test decide when the server becomes the master
+type fakeElector struct {
+	sync.RWMutex
+	master    bool
+	listeners []etcd.EtcdMasterElectionListener
+}
+
+func (e *fakeElector) IsMaster() bool {
+	e.RLock()
+	defer e.RUnlock()
+	return e.master
+}
+
+func (e *fakeElector) GetMaster() string {
+	return ""
+}
+
+func (e *fakeElector) TTL() time.Duration {
+	return time.Second
+}
+
+func (e *fakeElector) Start() {
+}
+
+func (e *fakeElector) StartAndWait() {
+}
+
+func (e *fakeElector) Stop() {
+}
+
+func (e *fakeElector) AddEventListener(listener etcd.EtcdMasterElectionListener) {
+	e.listeners = append(e.listeners, listener)
+}
+
+func (e *fakeElector) setMaster(master bool) {
+	e.Lock()
+	e.master = master
+	e.Unlock()
+}
+
+func (e *fakeElector) startAsMaster() {
+	e.setMaster(true)
+	for _, listener := range e.listeners {
+		listener.OnStartAsMaster()
+	}
+}
+
+func (e *fakeElector) switchToMaster() {
+	e.setMaster(true)
+	for _, listener := range e.listeners {
+		listener.OnSwitchToMaster()
+	}
+}
+
+// fakeState is an in-memory state shared by the successive masters
+type fakeState struct {
+	sync.Mutex
+	values map[string]json.RawMessage
+}
+
+func (s *fakeState) Set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.values[key] = json.RawMessage(data)
+	s.Unlock()
+	return nil
+}
+
+func (s *fakeState) Delete(key string) {
+	s.Lock()
+	delete(s.values, key)
+	s.Unlock()
+}
+
+func (s *fakeState) List() (map[string]json.RawMessage, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	values := make(map[string]json.RawMessage, len(s.values))
+	for key, value := range s.values {
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (s *fakeState) Stop() {
+}
+
+// fakePool records the alerts broadcasted to the subscribers
+type fakePool struct {
+	shttp.WSJSONSpeakerPool
+	sync.Mutex
+	alerts int
+}
+
+func (p *fakePool) BroadcastMessage(m shttp.WSMessage) {
+	if msg, ok := m.(*shttp.WSJSONMessage); ok && msg.Namespace == Namespace {
+		p.Lock()
+		p.alerts++
+		p.Unlock()
+	}
+}
+
+func (p *fakePool) fired() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.alerts
+}
+
+func newTestAlertServer(g *graph.Graph, state etcd.State) (*AlertServer, *fakeElector, *fakePool) {
+	elector := &fakeElector{}
+	pool := &fakePool{}
+	return newAlertServer(nil, pool, g, traversal.NewGremlinTraversalParser(), "host1", elector, state), elector, pool
+}
+
+func newTestAlertGraph(t *testing.T) *graph.Graph {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph.NewGraphFromConfig(b)
+}
+
+func TestAlertHandover(t *testing.T) {
+	g := newTestAlertGraph(t)
+
+	g.Lock()
+	node := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0"})
+	g.Unlock()
+
+	alert := &types.Alert{UUID: "alert1", Expression: `G.V().Has("Name", "eth0")`, Trigger: "graph"}
+	state := &fakeState{values: make(map[string]json.RawMessage)}
+
+	master, elector, pool := newTestAlertServer(g, state)
+	elector.startAsMaster()
+
+	if err := master.RegisterAlert(alert); err != nil {
+		t.Fatal(err)
+	}
+	if pool.fired() != 1 {
+		t.Fatalf("Expected the alert to fire once, got %d", pool.fired())
+	}
+	if _, found := state.values["alert1"]; !found {
+		t.Fatalf("Expected the firing state to be persisted, got: %v", state.values)
+	}
+
+	// the alert is registered while the server is not the master yet
+	next, elector, pool := newTestAlertServer(g, state)
+	if err := next.RegisterAlert(alert); err != nil {
+		t.Fatal(err)
+	}
+
+	// the alerts are not evaluated until the state of the previous master
+	// has been restored
+	elector.setMaster(true)
+	next.EvaluateAlerts(next.graphAlerts, true)
+	if pool.fired() != 0 {
+		t.Fatalf("Alert fired before the state was restored")
+	}
+
+	elector.switchToMaster()
+
+	g.Lock()
+	g.AddMetadata(node, "MTU", 1500)
+	next.OnNodeUpdated(node)
+	g.Unlock()
+
+	next.EvaluateAlerts(next.graphAlerts, true)
+	if pool.fired() != 0 {
+		t.Fatalf("Alert fired again by the new master")
+	}
+
+	if status := next.Status(); status.State["alert1"] == "" {
+		t.Errorf("Expected the alert to be reported as firing, got: %v", status.State)
+	}
+
+	// a different result fires the alert again
+	g.Lock()
+	next.OnNodeAdded(g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0"}))
+	g.Unlock()
+
+	if pool.fired() != 1 {
+		t.Errorf("Expected the alert to fire on a new result, got %d", pool.fired())
+	}
+}
+
+func TestAlertHandoverRegisterAfterResume(t *testing.T) {
+	g := newTestAlertGraph(t)
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0"})
+	g.Unlock()
+
+	alert := &types.Alert{UUID: "alert1", Expression: `G.V().Has("Name", "eth0")`, Trigger: "graph"}
+	state := &fakeState{values: make(map[string]json.RawMessage)}
+
+	master, elector, _ := newTestAlertServer(g, state)
+	elector.startAsMaster()
+	master.RegisterAlert(alert)
+
+	// the new master resumes before the alert is registered
+	next, elector, pool := newTestAlertServer(g, state)
+	elector.switchToMaster()
+
+	if err := next.RegisterAlert(alert); err != nil {
+		t.Fatal(err)
+	}
+	if pool.fired() != 0 {
+		t.Errorf("Alert fired again by the new master")
+	}
+}
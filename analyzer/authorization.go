@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"sync"
+
+	api "github.com/skydive-project/skydive/api/server"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// EnrolledHosts tells whether a host was delivered a client certificate
+type EnrolledHosts interface {
+	Enrolled(host string) bool
+}
+
+// GraphWriteAuthorizer validates the graph messages of the agents and the
+// publishers. A sender is identified by its host, the one of its client
+// certificate when enrolled, and can only modify the graph elements of this
+// host unless its authenticated identity, the host of its certificate or its
+// user name, is allowed to write cross-host.
+//
+// The host ID of the senders not enrolled is trusted, unless this host was
+// enrolled in which case its certificate is required.
+type GraphWriteAuthorizer struct {
+	sync.RWMutex
+	crossHost map[string]bool
+	enrolled  EnrolledHosts
+	rejected  map[string]int64
+}
+
+// ownNode returns whether the node and its previous version, if any, belong
+// to the host
+func ownNode(g *graph.Graph, host string, n *graph.Node) bool {
+	if n.Host() != host {
+		return false
+	}
+	old := g.GetNode(n.ID)
+	return old == nil || old.Host() == host
+}
+
+// ownEdge returns whether the edge and its previous version, if any, belong
+// to the host
+func ownEdge(g *graph.Graph, host string, e *graph.Edge) bool {
+	if e.Host() != host {
+		return false
+	}
+	old := g.GetEdge(e.ID)
+	return old == nil || old.Host() == host
+}
+
+// hostMessageAuthorized returns whether a graph message only modifies the
+// graph of the given host. The graph lock has to be held.
+func hostMessageAuthorized(g *graph.Graph, host string, msgType string, obj interface{}) bool {
+	switch msgType {
+	case graph.HostGraphDeletedMsgType:
+		return obj.(string) == host
+	case graph.SyncMsgType, graph.SyncReplyMsgType, graph.HostSyncMsgType:
+		r := obj.(*graph.SyncMsg)
+		for _, n := range r.Nodes {
+			if !ownNode(g, host, n) {
+				return false
+			}
+		}
+		for _, e := range r.Edges {
+			if !ownEdge(g, host, e) {
+				return false
+			}
+		}
+	case graph.NodeUpdatedMsgType, graph.NodeDeletedMsgType, graph.NodeAddedMsgType:
+		return ownNode(g, host, obj.(*graph.Node))
+	case graph.EdgeUpdatedMsgType, graph.EdgeDeletedMsgType, graph.EdgeAddedMsgType:
+		return ownEdge(g, host, obj.(*graph.Edge))
+	case graph.NodePartiallyUpdatedMsgType:
+		n := g.GetNode(obj.(*graph.PartiallyUpdatedMsg).ID)
+		return n == nil || n.Host() == host
+	case graph.EdgePartiallyUpdatedMsgType:
+		e := g.GetEdge(obj.(*graph.PartiallyUpdatedMsg).ID)
+		return e == nil || e.Host() == host
+	}
+	return true
+}
+
+// Authorize returns whether the sender is allowed to apply the graph message,
+// rejected messages are logged and counted. The graph lock has to be held.
+func (a *GraphWriteAuthorizer) Authorize(g *graph.Graph, sender api.GraphSender, msgType string, obj interface{}) bool {
+	// only the authenticated identities can write cross-host
+	if sender.User != "" && a.crossHost[sender.User] {
+		return true
+	}
+
+	if !sender.Certified && a.enrolled != nil && a.enrolled.Enrolled(sender.Host) {
+		a.reject(sender.Host, msgType, "host enrolled but no client certificate")
+		return false
+	}
+
+	if !hostMessageAuthorized(g, sender.Host, msgType, obj) {
+		a.reject(sender.Host, msgType, "modifying the graph of another host")
+		return false
+	}
+
+	return true
+}
+
+func (a *GraphWriteAuthorizer) reject(host string, msgType string, reason string) {
+	logging.GetLogger().Warningf("Reject %s message of %s, %s", msgType, host, reason)
+
+	a.Lock()
+	a.rejected[host]++
+	a.Unlock()
+}
+
+// Rejected returns the number of messages rejected per sender
+func (a *GraphWriteAuthorizer) Rejected() map[string]int64 {
+	a.RLock()
+	defer a.RUnlock()
+
+	rejected := make(map[string]int64, len(a.rejected))
+	for host, count := range a.rejected {
+		rejected[host] = count
+	}
+	return rejected
+}
+
+// NewGraphWriteAuthorizer returns a new authorizer, the given certificate
+// hosts or user names being allowed to write cross-host
+func NewGraphWriteAuthorizer(crossHost []string) *GraphWriteAuthorizer {
+	a := &GraphWriteAuthorizer{
+		crossHost: make(map[string]bool),
+		rejected:  make(map[string]int64),
+	}
+	for _, host := range crossHost {
+		a.crossHost[host] = true
+	}
+	return a
+}
@@ -32,18 +32,29 @@ import (
 	"sync"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
 	"github.com/skydive-project/skydive/flow"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 )
 
-// FlowClientPool describes a flow client pool.
+// FlowClientPool describes a flow client pool. While no analyzer is
+// connected, the flows are kept to be sent once reconnected. When the
+// analyzers share the flows, each flow is sent to its owner.
 type FlowClientPool struct {
 	sync.RWMutex
 	shttp.DefaultWSSpeakerEventHandler
-	flowClients []*FlowClient
+	flowClients  []*FlowClient
+	pending      []*flow.Flow
+	pendingUUID  map[string]int
+	maxPending   int
+	ring         *common.HashRing
+	members      map[string]etcd.EtcdMember
+	shardClients map[string]*FlowClient
 }
 
 // FlowClient describes a flow client connection
@@ -221,6 +232,14 @@ func (p *FlowClientPool) OnConnected(c shttp.WSSpeaker) {
 	}
 
 	p.flowClients = append(p.flowClients, flowClient)
+
+	if len(p.pending) > 0 {
+		logging.GetLogger().Infof("Send %d flows kept while disconnected", len(p.pending))
+		p.sendFlows(p.pending)
+
+		p.pending = nil
+		p.pendingUUID = make(map[string]int)
+	}
 }
 
 // OnDisconnected websocket event handler
@@ -238,17 +257,156 @@ func (p *FlowClientPool) OnDisconnected(c shttp.WSSpeaker) {
 	}
 }
 
-// SendFlows sends flows using a random connection
+// mergeLastUpdateMetric extends the LastUpdateMetric of a flow update to the
+// interval of the previous update it replaces
+func mergeLastUpdateMetric(f, previous *flow.Flow) {
+	if previous.LastUpdateMetric == nil {
+		return
+	}
+
+	if f.LastUpdateMetric == nil {
+		f.LastUpdateMetric = previous.LastUpdateMetric
+		return
+	}
+
+	f.LastUpdateMetric.Add(previous.LastUpdateMetric)
+	if previous.LastUpdateMetric.Start < f.LastUpdateMetric.Start {
+		f.LastUpdateMetric.Start = previous.LastUpdateMetric.Start
+	}
+}
+
+// keep the flows until an analyzer gets connected. The flows are copied as the
+// flow table keeps updating them. Only the last update of a flow is kept, the
+// metrics of the previous ones being merged into its LastUpdateMetric, the
+// oldest flows are dropped above the maximum.
+func (p *FlowClientPool) keep(flows []*flow.Flow) {
+	for _, f := range flows {
+		f = proto.Clone(f).(*flow.Flow)
+
+		if i, found := p.pendingUUID[f.UUID]; found {
+			mergeLastUpdateMetric(f, p.pending[i])
+			p.pending[i] = f
+			continue
+		}
+		p.pendingUUID[f.UUID] = len(p.pending)
+		p.pending = append(p.pending, f)
+	}
+
+	if dropped := len(p.pending) - p.maxPending; dropped > 0 {
+		logging.GetLogger().Warningf("Drop %d flows while disconnected from the analyzers", dropped)
+
+		p.pending = p.pending[dropped:]
+		p.pendingUUID = make(map[string]int)
+		for i, f := range p.pending {
+			p.pendingUUID[f.UUID] = i
+		}
+	}
+}
+
+// owner returns the client of the analyzer owning the flow, the next
+// analyzers on the ring being used if not reachable
+func (p *FlowClientPool) owner(f *flow.Flow) *FlowClient {
+	for _, host := range p.ring.GetN(f.TrackingID, p.ring.Len()) {
+		if fc, found := p.shardClients[host]; found {
+			return fc
+		}
+	}
+	return p.flowClients[rand.Intn(len(p.flowClients))]
+}
+
+// sendFlows sends the flows to their owner, to a random analyzer if the
+// flows are not shared.
+func (p *FlowClientPool) sendFlows(flows []*flow.Flow) {
+	if p.ring.Len() == 0 {
+		p.flowClients[rand.Intn(len(p.flowClients))].SendFlows(flows)
+		return
+	}
+
+	shards := make(map[*FlowClient][]*flow.Flow)
+	for _, f := range flows {
+		fc := p.owner(f)
+		shards[fc] = append(shards[fc], f)
+	}
+
+	for fc, flows := range shards {
+		fc.SendFlows(flows)
+	}
+}
+
+// SendFlows sends flows to the analyzers
 func (p *FlowClientPool) SendFlows(flows []*flow.Flow) {
 	p.RLock()
-	defer p.RUnlock()
+	if len(p.flowClients) > 0 {
+		p.sendFlows(flows)
+		p.RUnlock()
+		return
+	}
+	p.RUnlock()
+
+	p.Lock()
+	defer p.Unlock()
 
 	if len(p.flowClients) == 0 {
+		p.keep(flows)
 		return
 	}
 
-	fc := p.flowClients[rand.Intn(len(p.flowClients))]
-	fc.SendFlows(flows)
+	p.sendFlows(flows)
+}
+
+// setMembers updates the analyzers sharing the flows, a client being
+// created for each of them.
+func (p *FlowClientPool) setMembers(members []etcd.EtcdMember, replicas int) {
+	p.Lock()
+	defer p.Unlock()
+
+	current := p.members
+	p.members = make(map[string]etcd.EtcdMember)
+
+	var hosts []string
+	for _, member := range members {
+		p.members[member.Host] = member
+		hosts = append(hosts, member.Host)
+
+		if previous, found := current[member.Host]; found && previous == member {
+			continue
+		}
+
+		if fc, found := p.shardClients[member.Host]; found {
+			fc.close()
+			delete(p.shardClients, member.Host)
+		}
+
+		fc, err := NewFlowClient(member.Addr, member.Port)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to create flow client for analyzer %s: %s", member.Host, err.Error())
+			continue
+		}
+		p.shardClients[member.Host] = fc
+	}
+
+	for host, fc := range p.shardClients {
+		if _, found := p.members[host]; !found {
+			fc.close()
+			delete(p.shardClients, host)
+		}
+	}
+
+	p.ring = common.NewHashRing(replicas)
+	p.ring.SetMembers(hosts)
+}
+
+// OnWSJSONMessage handles the flow ring advertised by the analyzers
+func (p *FlowClientPool) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	switch msg.Type {
+	case FlowRingMsgType:
+		var ring FlowRingMsg
+		if err := msg.DecodeObj(&ring); err != nil {
+			logging.GetLogger().Errorf("Unable to decode flow ring from %s: %s", c.GetHost(), err.Error())
+			return
+		}
+		p.setMembers(ring.Members, ring.Replicas)
+	}
 }
 
 // Close all connections
@@ -256,15 +414,24 @@ func (p *FlowClientPool) Close() {
 	for _, fc := range p.flowClients {
 		fc.close()
 	}
+	for _, fc := range p.shardClients {
+		fc.close()
+	}
 }
 
 // NewFlowClientPool returns a new FlowClientPool using the websocket connections
 // to maintain the pool of client up to date according to the websocket connections
 // status.
-func NewFlowClientPool(pool shttp.WSSpeakerPool) *FlowClientPool {
+func NewFlowClientPool(pool shttp.WSJSONSpeakerPool) *FlowClientPool {
 	p := &FlowClientPool{
-		flowClients: make([]*FlowClient, 0),
+		flowClients:  make([]*FlowClient, 0),
+		pendingUUID:  make(map[string]int),
+		maxPending:   config.GetConfig().GetInt("agent.flow.buffer_size"),
+		ring:         common.NewHashRing(1),
+		members:      make(map[string]etcd.EtcdMember),
+		shardClients: make(map[string]*FlowClient),
 	}
 	pool.AddEventHandler(p)
+	pool.AddJSONMessageHandler(p, []string{FlowShardingNamespace})
 	return p
 }
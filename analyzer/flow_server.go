@@ -35,6 +35,7 @@ import (
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/correlation"
 	"github.com/skydive-project/skydive/flow/enhancers"
 	"github.com/skydive-project/skydive/flow/storage"
 	shttp "github.com/skydive-project/skydive/http"
@@ -63,6 +64,7 @@ type FlowServerWebSocketConn struct {
 // FlowServer describes a flow server with pipeline enhancers mechanism
 type FlowServer struct {
 	storage                storage.Storage
+	correlator             *correlation.Correlator
 	enhancerPipeline       *flow.EnhancerPipeline
 	enhancerPipelineConfig *flow.EnhancerPipelineConfig
 	conn                   FlowServerConn
@@ -186,6 +188,7 @@ func (s *FlowServer) Start() {
 				s.storeFlows(flowBuffer)
 				flowBuffer = flowBuffer[:0]
 			case f := <-s.ch:
+				s.correlator.Add(f)
 				flowBuffer = append(flowBuffer, f)
 				if len(flowBuffer) >= s.bulkInsert {
 					s.storeFlows(flowBuffer)
@@ -206,7 +209,7 @@ func (s *FlowServer) Stop() {
 }
 
 // NewFlowServer creates a new flow server listening at address/port, based on configuration
-func NewFlowServer(s *shttp.Server, g *graph.Graph, store storage.Storage, probe *probe.ProbeBundle) (*FlowServer, error) {
+func NewFlowServer(s *shttp.Server, g *graph.Graph, store storage.Storage, correlator *correlation.Correlator, probe *probe.ProbeBundle) (*FlowServer, error) {
 	cache := cache.New(time.Duration(600)*time.Second, time.Duration(600)*time.Second)
 	pipeline := flow.NewEnhancerPipeline(enhancers.NewGraphFlowEnhancer(g, cache))
 
@@ -239,6 +242,7 @@ func NewFlowServer(s *shttp.Server, g *graph.Graph, store storage.Storage, probe
 
 	return &FlowServer{
 		storage:                store,
+		correlator:             correlator,
 		enhancerPipeline:       pipeline,
 		enhancerPipelineConfig: flow.NewEnhancerPipelineConfig(),
 		bulkInsert:             bulk,
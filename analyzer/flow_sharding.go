@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"net"
+	"os"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// FlowShardingNamespace is the namespace used to advertise the flow owners
+const FlowShardingNamespace = "FlowSharding"
+
+// FlowRingMsgType is sent to the agents each time the analyzers sharing the
+// flows change
+const FlowRingMsgType = "FlowRing"
+
+// FlowRingMsg describes the analyzers the flows are shared between. Each flow
+// is owned by an analyzer according to a consistent hash of its TrackingID,
+// each analyzer being placed Replicas times on the ring.
+type FlowRingMsg struct {
+	Replicas int
+	Members  []etcd.EtcdMember
+}
+
+// FlowSharding registers the analyzer as a flow owner in ETCD and keeps the
+// agents up to date with the analyzers owning the flows.
+type FlowSharding struct {
+	shttp.DefaultWSSpeakerEventHandler
+	membership *etcd.EtcdMembership
+	pool       shttp.WSSpeakerPool
+	replicas   int
+}
+
+func (f *FlowSharding) ringMsg(members []etcd.EtcdMember) *shttp.WSJSONMessage {
+	return shttp.NewWSJSONMessage(FlowShardingNamespace, FlowRingMsgType, &FlowRingMsg{Replicas: f.replicas, Members: members})
+}
+
+// OnMembersChanged event, the new ring is sent to all the agents
+func (f *FlowSharding) OnMembersChanged(members []etcd.EtcdMember) {
+	logging.GetLogger().Infof("Flows now shared between %d analyzers", len(members))
+	f.pool.BroadcastMessage(f.ringMsg(members))
+}
+
+// OnConnected websocket event, the ring is sent to the new agent
+func (f *FlowSharding) OnConnected(c shttp.WSSpeaker) {
+	c.SendMessage(f.ringMsg(f.membership.Members()))
+}
+
+// Start registers the analyzer as a flow owner
+func (f *FlowSharding) Start() error {
+	return f.membership.Start()
+}
+
+// Stop unregisters the analyzer so that its flows get moved to the others
+func (f *FlowSharding) Stop() {
+	f.membership.Stop()
+}
+
+// advertisedAddr returns the address to be used by the agents to send flows
+// when listening on any address
+func advertisedAddr(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil && ip.IsUnspecified() {
+		if hostname, err := os.Hostname(); err == nil {
+			return hostname
+		}
+	}
+	return addr
+}
+
+// NewFlowShardingFromConfig returns a new flow sharding advertising the flow
+// server address to the agents of the pool
+func NewFlowShardingFromConfig(server *shttp.Server, pool shttp.WSSpeakerPool, etcdClient *etcd.EtcdClient) (*FlowSharding, error) {
+	addr, port := advertisedAddr(server.Addr), server.Port
+	if advertise := config.GetConfig().GetString("analyzer.flow_sharding.advertise"); advertise != "" {
+		sa, err := common.ServiceAddressFromString(advertise)
+		if err != nil {
+			return nil, err
+		}
+		addr, port = sa.Addr, sa.Port
+	}
+
+	f := &FlowSharding{
+		membership: etcd.NewEtcdMembershipFromConfig(addr, port, common.AnalyzerService, "flows", etcdClient),
+		pool:       pool,
+		replicas:   config.GetConfig().GetInt("analyzer.flow_sharding.replicas"),
+	}
+	f.membership.AddEventListener(f)
+	pool.AddEventHandler(f)
+
+	return f, nil
+}
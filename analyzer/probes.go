@@ -29,7 +29,11 @@ import (
 	"github.com/skydive-project/skydive/topology/graph"
 	"github.com/skydive-project/skydive/topology/probes/fabric"
 	"github.com/skydive-project/skydive/topology/probes/k8s"
+	"github.com/skydive-project/skydive/topology/probes/lldp"
+	"github.com/skydive-project/skydive/topology/probes/ovn"
 	"github.com/skydive-project/skydive/topology/probes/peering"
+	"github.com/skydive-project/skydive/topology/probes/static"
+	"github.com/skydive-project/skydive/topology/probes/tunnel"
 )
 
 // NewTopologyProbeBundleFromConfig creates a new topology server probes from configuration
@@ -37,7 +41,9 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph) (*probe.ProbeBundle, error
 	list := config.GetConfig().GetStringSlice("analyzer.topology.probes")
 	probes := map[string]probe.Probe{
 		"fabric":  fabric.NewFabricProbe(g),
+		"lldp":    lldp.NewSwitchProbe(g),
 		"peering": peering.NewPeeringProbe(g),
+		"tunnel":  tunnel.NewProbe(g),
 	}
 
 	for _, t := range list {
@@ -54,6 +60,17 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph) (*probe.ProbeBundle, error
 				return nil, err
 			}
 
+		case "ovn":
+			var err error
+			probes[t], err = ovn.NewProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize OVN probe: %s", err.Error())
+				return nil, err
+			}
+
+		case "static":
+			probes[t] = static.NewProbeFromConfig(g)
+
 		default:
 			logging.GetLogger().Errorf("unknown probe type: %s", t)
 		}
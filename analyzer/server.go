@@ -23,6 +23,7 @@
 package analyzer
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -36,11 +37,13 @@ import (
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/etcd"
 	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/correlation"
 	ondemand "github.com/skydive-project/skydive/flow/ondemand/client"
 	"github.com/skydive-project/skydive/flow/storage"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/oftrace"
 	"github.com/skydive-project/skydive/packet_injector"
 	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology"
@@ -56,11 +59,15 @@ type Server struct {
 	publisherWSServer   *shttp.WSJSONServer
 	replicationWSServer *shttp.WSJSONServer
 	subscriberWSServer  *shttp.WSJSONServer
+	agentEndpoint       *TopologyAgentEndpoint
+	publisherEndpoint   *TopologyPublisherEndpoint
 	replicationEndpoint *TopologyReplicationEndpoint
 	alertServer         *alert.AlertServer
 	onDemandClient      *ondemand.OnDemandProbeClient
 	metadataManager     *metadata.UserMetadataManager
 	flowServer          *FlowServer
+	flowSharding        *FlowSharding
+	revocationWatcher   api.StoppableWatcher
 	probeBundle         *probe.ProbeBundle
 	storage             storage.Storage
 	embeddedEtcd        *etcd.EmbeddedEtcd
@@ -82,13 +89,19 @@ func (s *Server) GetStatus() interface{} {
 		}
 	}
 
+	rejected := s.agentEndpoint.authorizer.Rejected()
+	for host, count := range s.publisherEndpoint.authorizer.Rejected() {
+		rejected[host] += count
+	}
+
 	return &types.AnalyzerStatus{
-		Agents:      s.agentWSServer.GetStatus(),
-		Peers:       peersStatus,
-		Publishers:  s.publisherWSServer.GetStatus(),
-		Subscribers: s.subscriberWSServer.GetStatus(),
-		Alerts:      types.ElectionStatus{IsMaster: s.alertServer.IsMaster()},
-		Captures:    types.ElectionStatus{IsMaster: s.onDemandClient.IsMaster()},
+		Agents:              s.agentWSServer.GetStatus(),
+		Peers:               peersStatus,
+		Publishers:          s.publisherWSServer.GetStatus(),
+		Subscribers:         s.subscriberWSServer.GetStatus(),
+		Alerts:              s.alertServer.Status(),
+		Captures:            s.onDemandClient.Status(),
+		RejectedGraphWrites: rejected,
 	}
 }
 
@@ -109,6 +122,11 @@ func (s *Server) Start() error {
 	s.alertServer.Start()
 	s.metadataManager.Start()
 	s.flowServer.Start()
+	if s.flowSharding != nil {
+		if err := s.flowSharding.Start(); err != nil {
+			return err
+		}
+	}
 	s.agentWSServer.Start()
 	s.publisherWSServer.Start()
 	s.replicationWSServer.Start()
@@ -126,6 +144,9 @@ func (s *Server) Start() error {
 // Stop the analyzer server
 func (s *Server) Stop() {
 	s.flowServer.Stop()
+	if s.flowSharding != nil {
+		s.flowSharding.Stop()
+	}
 	s.agentWSServer.Stop()
 	s.publisherWSServer.Stop()
 	s.replicationWSServer.Stop()
@@ -141,6 +162,9 @@ func (s *Server) Stop() {
 	s.onDemandClient.Stop()
 	s.alertServer.Stop()
 	s.metadataManager.Stop()
+	if s.revocationWatcher != nil {
+		s.revocationWatcher.Stop()
+	}
 	s.etcdClient.Stop()
 	s.wgServers.Wait()
 	if tr, ok := http.DefaultTransport.(interface {
@@ -174,13 +198,13 @@ func NewServerFromConfig() (*Server, error) {
 	authOptions := NewAnalyzerAuthenticationOpts()
 
 	agentWSServer := shttp.NewWSJSONServer(shttp.NewWSServer(hserver, "/ws/agent"))
-	_, err = NewTopologyAgentEndpoint(agentWSServer, authOptions, cached, g)
+	agentEndpoint, err := NewTopologyAgentEndpoint(agentWSServer, authOptions, cached, g)
 	if err != nil {
 		return nil, err
 	}
 
 	publisherWSServer := shttp.NewWSJSONServer(shttp.NewWSServer(hserver, "/ws/publisher"))
-	_, err = NewTopologyPublisherEndpoint(publisherWSServer, authOptions, g)
+	publisherEndpoint, err := NewTopologyPublisherEndpoint(publisherWSServer, authOptions, g)
 	if err != nil {
 		return nil, err
 	}
@@ -191,6 +215,11 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
+	// the analyzers tell each other when an agent connects so that the one
+	// it was connected to doesn't delete its graph
+	agentEndpoint.replicator = replicationEndpoint
+	replicationEndpoint.agents = agentEndpoint
+
 	subscriberWSServer := shttp.NewWSJSONServer(shttp.NewWSServer(hserver, "/ws/subscriber"))
 	topology.NewTopologySubscriberEndpoint(subscriberWSServer, authOptions, g)
 
@@ -227,6 +256,10 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
+	if _, err = api.RegisterAuditAPI(apiServer); err != nil {
+		return nil, err
+	}
+
 	captureAPIHandler, err := api.RegisterCaptureAPI(apiServer, g)
 	if err != nil {
 		return nil, err
@@ -242,25 +275,85 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
+	ca, err := api.NewCertificateAuthorityFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var revocationWatcher api.StoppableWatcher
+	if ca != nil {
+		if !config.IsTLSenabled() {
+			return nil, errors.New("TLS has to be enabled to enroll agents")
+		}
+
+		certificateAPIHandler, err := api.RegisterEnrollmentAPI(apiServer, ca)
+		if err != nil {
+			return nil, err
+		}
+		hserver.CertAuth = certificateAPIHandler
+
+		// the host IDs of the enrolled agents can't be used without their
+		// certificate
+		agentEndpoint.authorizer.enrolled = certificateAPIHandler
+		publisherEndpoint.authorizer.enrolled = certificateAPIHandler
+
+		// revoking a certificate disconnects the agent it was delivered to
+		revocationWatcher = certificateAPIHandler.Watch(func(cert *types.AgentCertificate) {
+			agentEndpoint.DisconnectHost(cert.Host)
+		})
+	}
+
 	onDemandClient := ondemand.NewOnDemandProbeClient(g, captureAPIHandler, agentWSServer, subscriberWSServer, etcdClient)
 
 	metadataManager := metadata.NewUserMetadataManager(g, metadataAPIHandler)
 
 	tableClient := flow.NewTableClient(agentWSServer)
 
+	var flowSharding *FlowSharding
+	if config.GetConfig().GetBool("analyzer.flow_sharding.enabled") {
+		if flowSharding, err = NewFlowShardingFromConfig(hserver, agentWSServer, etcdClient); err != nil {
+			return nil, err
+		}
+
+		// agents may be connected to other analyzers only, so the flow
+		// table lookups are scattered to the peers
+		tableClient.SetPeers(replicationEndpoint)
+		replicationWSServer.AddJSONMessageHandler(tableClient, []string{flow.Namespace})
+		replicationEndpoint.out.AddJSONMessageHandler(tableClient, []string{flow.Namespace})
+	}
+
 	storage, err := storage.NewStorageFromConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	flowServer, err := NewFlowServer(hserver, g, storage, probeBundle)
+	correlator := correlation.NewCorrelator(
+		time.Duration(config.GetConfig().GetInt("analyzer.flowtable_expire"))*time.Second,
+		time.Duration(config.GetConfig().GetInt("analyzer.flow_correlation.time_window"))*time.Second,
+		config.GetConfig().GetFloat64("analyzer.flow_correlation.bytes_tolerance"),
+	)
+
+	if flowSharding != nil {
+		// the translated halves of a connection may be owned by the peers
+		correlator.SetPeers(replicationEndpoint)
+		replicationWSServer.AddJSONMessageHandler(correlator, []string{flow.Namespace})
+		replicationEndpoint.out.AddJSONMessageHandler(correlator, []string{flow.Namespace})
+	}
+
+	flowServer, err := NewFlowServer(hserver, g, storage, correlator, probeBundle)
 	if err != nil {
 		return nil, err
 	}
 
+	ofTraceClient := oftrace.NewOfTraceClient(agentWSServer)
+
 	tr := traversal.NewGremlinTraversalParser()
 	tr.AddTraversalExtension(ge.NewMetricsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewFlowTraversalExtension(tableClient, storage))
+	tr.AddTraversalExtension(ge.NewOfTraceTraversalExtension(ofTraceClient))
+	tr.AddTraversalExtension(ge.NewOfRuleTraversalExtension())
+	tr.AddTraversalExtension(ge.NewRouteToTraversalExtension())
+	tr.AddTraversalExtension(ge.NewCorrelatedTraversalExtension(correlator))
 
 	alertServer := alert.NewAlertServer(alertAPIHandler, subscriberWSServer, g, tr, etcdClient)
 
@@ -272,6 +365,8 @@ func NewServerFromConfig() (*Server, error) {
 		publisherWSServer:   publisherWSServer,
 		replicationWSServer: replicationWSServer,
 		subscriberWSServer:  subscriberWSServer,
+		agentEndpoint:       agentEndpoint,
+		publisherEndpoint:   publisherEndpoint,
 		replicationEndpoint: replicationEndpoint,
 		probeBundle:         probeBundle,
 		embeddedEtcd:        embeddedEtcd,
@@ -280,12 +375,17 @@ func NewServerFromConfig() (*Server, error) {
 		metadataManager:     metadataManager,
 		storage:             storage,
 		flowServer:          flowServer,
+		flowSharding:        flowSharding,
+		revocationWatcher:   revocationWatcher,
 		alertServer:         alertServer,
 	}
 
 	api.RegisterTopologyAPI(hserver, g, tr)
-	api.RegisterPacketInjectorAPI(piClient, g, hserver)
-	api.RegisterPcapAPI(hserver, storage)
+	api.RegisterTopologyStreamAPI(hserver, g, tr)
+	api.RegisterTopologyPublishAPI(hserver, publisherEndpoint)
+	api.RegisterPacketInjectorAPI(piClient, g, apiServer)
+	api.RegisterOfTraceAPI(ofTraceClient, g, hserver)
+	api.RegisterPcapAPI(apiServer, storage)
 	api.RegisterConfigAPI(hserver)
 	api.RegisterStatusAPI(hserver, s)
 
@@ -23,30 +23,169 @@
 package analyzer
 
 import (
+	"net/http"
 	"sync"
+	"time"
 
+	api "github.com/skydive-project/skydive/api/server"
+	"github.com/skydive-project/skydive/config"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/topology/graph"
 )
 
+// agentSession tracks the messages of an agent session, and the deletion of
+// its graph once disconnected
+type agentSession struct {
+	session string
+	seq     int64
+	reaper  *time.Timer
+	// incremented at each disconnection to ignore cancelled reapers
+	generation int
+}
+
 // TopologyAgentEndpoint serves the graph for agents.
 type TopologyAgentEndpoint struct {
 	sync.RWMutex
 	shttp.DefaultWSSpeakerEventHandler
-	pool   shttp.WSJSONSpeakerPool
-	Graph  *graph.Graph
-	cached *graph.CachedBackend
-	wg     sync.WaitGroup
+	pool        shttp.WSJSONSpeakerPool
+	Graph       *graph.Graph
+	cached      *graph.CachedBackend
+	wg          sync.WaitGroup
+	sessions    map[string]*agentSession
+	deleteDelay time.Duration
+	replicator  *TopologyReplicationEndpoint
+	authorizer  *GraphWriteAuthorizer
+}
+
+// OnConnected called when an agent connected, cancels the deletion of its graph
+// and notifies the other analyzers that it is now connected here.
+func (t *TopologyAgentEndpoint) OnConnected(c shttp.WSSpeaker) {
+	host := c.GetHost()
+	t.cancelHostGraphDeletion(host)
+
+	if t.replicator != nil {
+		t.replicator.notifyPeers(shttp.NewWSJSONMessage(graph.Namespace, graph.HostConnectedMsgType, host))
+	}
 }
 
-// OnDisconnected called when an agent disconnected.
+// OnDisconnected called when an agent disconnected. Its graph is deleted
+// after a delay, giving the agent the opportunity to reconnect and resume
+// without removing and adding back its nodes.
 func (t *TopologyAgentEndpoint) OnDisconnected(c shttp.WSSpeaker) {
 	host := c.GetHost()
+
+	if t.deleteDelay == 0 {
+		t.deleteHostGraph(host, 0)
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	s := t.getSession(host)
+	if s.reaper != nil {
+		s.reaper.Stop()
+	}
+
+	s.generation++
+	generation := s.generation
+	s.reaper = time.AfterFunc(t.deleteDelay, func() {
+		t.deleteHostGraph(host, generation)
+	})
+}
+
+// deleteHostGraph deletes the graph of a host. When triggered by a reaper,
+// a generation is given to check the deletion wasn't cancelled meanwhile.
+func (t *TopologyAgentEndpoint) deleteHostGraph(host string, generation int) {
 	t.Graph.Lock()
+	defer t.Graph.Unlock()
+
+	t.Lock()
+	defer t.Unlock()
+
+	if generation != 0 {
+		if s, found := t.sessions[host]; !found || s.reaper == nil || s.generation != generation {
+			return
+		}
+	}
+	delete(t.sessions, host)
+
 	logging.GetLogger().Debugf("Authoritative client unregistered, delete resources %s", host)
 	t.Graph.DelHostGraph(host)
-	t.Graph.Unlock()
+}
+
+func (t *TopologyAgentEndpoint) cancelHostGraphDeletion(host string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if s, found := t.sessions[host]; found && s.reaper != nil {
+		s.reaper.Stop()
+		s.reaper = nil
+	}
+}
+
+// onHostConnectedElsewhere is called when an agent got connected to another
+// analyzer, its graph is then maintained by the other analyzer.
+func (t *TopologyAgentEndpoint) onHostConnectedElsewhere(host string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if s, found := t.sessions[host]; found {
+		if s.reaper != nil {
+			s.reaper.Stop()
+		}
+		delete(t.sessions, host)
+	}
+}
+
+func (t *TopologyAgentEndpoint) getSession(host string) *agentSession {
+	s, found := t.sessions[host]
+	if !found {
+		s = &agentSession{}
+		t.sessions[host] = s
+	}
+	return s
+}
+
+// checkSeq returns whether the message is the next one of the agent session.
+// A full graph message sets the sequence, others are dropped if they were
+// already received or if some are missing, the agent will then replay them
+// when resuming.
+func (t *TopologyAgentEndpoint) checkSeq(host string, msgType string, seq int64) bool {
+	// agent not numbering its messages
+	if seq == 0 {
+		return true
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	s := t.getSession(host)
+	switch msgType {
+	case graph.SyncMsgType, graph.HostSyncMsgType:
+	default:
+		if seq != s.seq+1 {
+			logging.GetLogger().Debugf("Drop message %d of %s, expected %d", seq, host, s.seq+1)
+			return false
+		}
+	}
+	s.seq = seq
+
+	return true
+}
+
+// journalReply returns the last message of the session received from the agent
+func (t *TopologyAgentEndpoint) journalReply(host string, session string) (int64, int) {
+	t.Lock()
+	defer t.Unlock()
+
+	s := t.getSession(host)
+	if s.session != session {
+		s.session, s.seq = session, 0
+		return 0, http.StatusNotFound
+	}
+	return s.seq, http.StatusOK
 }
 
 // OnWSJSONMessage is triggered when a message from the agent is received.
@@ -57,9 +196,25 @@ func (t *TopologyAgentEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WS
 		return
 	}
 
+	if msgType == graph.JournalRequestMsgType {
+		seq, status := t.journalReply(c.GetHost(), obj.(graph.JournalRequestMsg).Session)
+		c.SendMessage(msg.Reply(graph.JournalReplyMsg{Seq: seq}, graph.JournalReplyMsgType, status))
+		return
+	}
+
 	t.Graph.Lock()
 	defer t.Graph.Unlock()
 
+	if !t.checkSeq(c.GetHost(), msgType, msg.Seq) {
+		return
+	}
+
+	// agents can only modify their own host graph, rejected messages are
+	// still acknowledged by the sequence to not be replayed
+	if !t.authorizer.Authorize(t.Graph, api.NewGraphSender(c.GetHost(), c.GetHeaders()), msgType, obj) {
+		return
+	}
+
 	switch msgType {
 	case graph.HostGraphDeletedMsgType:
 		// HostGraphDeletedMsgType is handled specifically as we need to be sure to not use the
@@ -79,6 +234,9 @@ func (t *TopologyAgentEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WS
 				t.Graph.EdgeAdded(e)
 			}
 		}
+	case graph.HostSyncMsgType:
+		r := obj.(*graph.SyncMsg)
+		t.Graph.ReconcileHostGraph(c.GetHost(), r.Nodes, r.Edges)
 	case graph.NodeUpdatedMsgType:
 		t.Graph.NodeUpdated(obj.(*graph.Node))
 	case graph.NodeDeletedMsgType:
@@ -91,15 +249,37 @@ func (t *TopologyAgentEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WS
 		t.Graph.EdgeDeleted(obj.(*graph.Edge))
 	case graph.EdgeAddedMsgType:
 		t.Graph.EdgeAdded(obj.(*graph.Edge))
+	case graph.NodePartiallyUpdatedMsgType:
+		if err := t.Graph.NodePartiallyUpdated(obj.(*graph.PartiallyUpdatedMsg)); err == graph.ErrRevisionMismatch {
+			c.SendMessage(graph.NewElementRequestMsg(msgType, obj.(*graph.PartiallyUpdatedMsg).ID))
+		}
+	case graph.EdgePartiallyUpdatedMsgType:
+		if err := t.Graph.EdgePartiallyUpdated(obj.(*graph.PartiallyUpdatedMsg)); err == graph.ErrRevisionMismatch {
+			c.SendMessage(graph.NewElementRequestMsg(msgType, obj.(*graph.PartiallyUpdatedMsg).ID))
+		}
+	}
+}
+
+// DisconnectHost disconnects the agent of a host, used when its certificate
+// is revoked
+func (t *TopologyAgentEndpoint) DisconnectHost(host string) {
+	for _, c := range t.pool.GetSpeakers() {
+		if c.GetHost() == host {
+			logging.GetLogger().Infof("Disconnect agent %s", host)
+			c.Disconnect()
+		}
 	}
 }
 
 // NewTopologyAgentEndpoint returns a new server that handles messages from the agents
 func NewTopologyAgentEndpoint(pool shttp.WSJSONSpeakerPool, auth *shttp.AuthenticationOpts, cached *graph.CachedBackend, g *graph.Graph) (*TopologyAgentEndpoint, error) {
 	t := &TopologyAgentEndpoint{
-		Graph:  g,
-		pool:   pool,
-		cached: cached,
+		Graph:       g,
+		pool:        pool,
+		cached:      cached,
+		sessions:    make(map[string]*agentSession),
+		authorizer:  NewGraphWriteAuthorizer(nil),
+		deleteDelay: time.Duration(config.GetConfig().GetInt("analyzer.topology.agent_delete_delay")) * time.Second,
 	}
 
 	pool.AddEventHandler(t)
@@ -23,9 +23,13 @@
 package analyzer
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 
+	api "github.com/skydive-project/skydive/api/server"
+	"github.com/skydive-project/skydive/config"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/statics"
@@ -56,6 +60,7 @@ type TopologyPublisherEndpoint struct {
 	edgeSchema    gojsonschema.JSONLoader
 	wg            sync.WaitGroup
 	gremlinParser *traversal.GremlinTraversalParser
+	authorizer    *GraphWriteAuthorizer
 }
 
 // OnDisconnected called when a publisher got disconnected.
@@ -71,39 +76,53 @@ func (t *TopologyPublisherEndpoint) OnDisconnected(c shttp.WSSpeaker) {
 	t.Graph.Unlock()
 }
 
-// OnWSJSONMessage is triggered by message coming from a publisher.
-func (t *TopologyPublisherEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
-	msgType, obj, err := graph.UnmarshalWSMessage(msg)
+// validateElement checks a graph element against a JSON schema
+func validateElement(schema gojsonschema.JSONLoader, obj interface{}) error {
+	result, err := gojsonschema.Validate(schema, gojsonschema.NewGoLoader(obj))
 	if err != nil {
-		logging.GetLogger().Errorf("Graph: Unable to parse the event %v: %s", msg, err.Error())
-		return
+		return fmt.Errorf("Invalid message: %s", err.Error())
 	}
 
-	// We use JSON schema to validate the message
-	loader := gojsonschema.NewGoLoader(obj)
+	if !result.Valid() {
+		errs := make([]string, len(result.Errors()))
+		for i, desc := range result.Errors() {
+			errs[i] = desc.String()
+		}
+		return fmt.Errorf("Invalid message: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
 
-	var schema gojsonschema.JSONLoader
+// validate checks the graph elements of a message against the JSON schemas
+func (t *TopologyPublisherEndpoint) validate(msgType string, obj interface{}) error {
 	switch msgType {
 	case graph.NodeAddedMsgType, graph.NodeUpdatedMsgType, graph.NodeDeletedMsgType:
-		schema = t.nodeSchema
+		return validateElement(t.nodeSchema, obj)
 	case graph.EdgeAddedMsgType, graph.EdgeUpdatedMsgType, graph.EdgeDeletedMsgType:
-		schema = t.edgeSchema
-	}
-
-	if schema != nil {
-		if _, err := gojsonschema.Validate(t.edgeSchema, loader); err != nil {
-			logging.GetLogger().Errorf("Invalid message: %s", err.Error())
-			return
+		return validateElement(t.edgeSchema, obj)
+	case graph.SyncMsgType, graph.SyncReplyMsgType, graph.HostSyncMsgType:
+		r := obj.(*graph.SyncMsg)
+		for _, n := range r.Nodes {
+			if err := validateElement(t.nodeSchema, n); err != nil {
+				return err
+			}
+		}
+		for _, e := range r.Edges {
+			if err := validateElement(t.edgeSchema, e); err != nil {
+				return err
+			}
 		}
 	}
 
-	t.Graph.Lock()
-	defer t.Graph.Unlock()
+	return nil
+}
 
+// apply applies a graph message of the publisher identified by host, the
+// graph lock has to be held. ErrRevisionMismatch is returned when a partial
+// update can't be applied, the publisher having to send the whole element.
+func (t *TopologyPublisherEndpoint) apply(host string, msgType string, obj interface{}) error {
 	switch msgType {
-	case graph.SyncRequestMsgType:
-		reply := msg.Reply(t.Graph, graph.SyncReplyMsgType, http.StatusOK)
-		c.SendMessage(reply)
 	case graph.HostGraphDeletedMsgType:
 		// HostGraphDeletedMsgType is handled specifically as we need to be sure to not use the
 		// cache while deleting otherwise the delete mechanism is using the cache to walk throught
@@ -122,6 +141,10 @@ func (t *TopologyPublisherEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shtt
 				t.Graph.EdgeAdded(e)
 			}
 		}
+	case graph.HostSyncMsgType:
+		// the elements of the publisher not part of the sync are removed
+		r := obj.(*graph.SyncMsg)
+		t.Graph.ReconcileHostGraph(host, r.Nodes, r.Edges)
 	case graph.NodeUpdatedMsgType:
 		t.Graph.NodeUpdated(obj.(*graph.Node))
 	case graph.NodeDeletedMsgType:
@@ -134,7 +157,145 @@ func (t *TopologyPublisherEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shtt
 		t.Graph.EdgeDeleted(obj.(*graph.Edge))
 	case graph.EdgeAddedMsgType:
 		t.Graph.EdgeAdded(obj.(*graph.Edge))
+	case graph.NodePartiallyUpdatedMsgType:
+		if err := t.Graph.NodePartiallyUpdated(obj.(*graph.PartiallyUpdatedMsg)); err == graph.ErrRevisionMismatch {
+			return err
+		}
+	case graph.EdgePartiallyUpdatedMsgType:
+		if err := t.Graph.EdgePartiallyUpdated(obj.(*graph.PartiallyUpdatedMsg)); err == graph.ErrRevisionMismatch {
+			return err
+		}
 	}
+	return nil
+}
+
+// OnWSJSONMessage is triggered by message coming from a publisher.
+func (t *TopologyPublisherEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	msgType, obj, err := graph.UnmarshalWSMessage(msg)
+	if err != nil {
+		logging.GetLogger().Errorf("Graph: Unable to parse the event %v: %s", msg, err.Error())
+		return
+	}
+
+	if err := t.validate(msgType, obj); err != nil {
+		logging.GetLogger().Error(err)
+		return
+	}
+
+	t.Graph.Lock()
+	defer t.Graph.Unlock()
+
+	if msgType == graph.SyncRequestMsgType {
+		reply := msg.Reply(t.Graph, graph.SyncReplyMsgType, http.StatusOK)
+		c.SendMessage(reply)
+		return
+	}
+
+	// publishers can only modify their own host graph unless allowed to
+	// write cross-host
+	sender := api.NewGraphSender(c.GetHost(), c.GetHeaders())
+	if !t.authorizer.Authorize(t.Graph, sender, msgType, obj) {
+		return
+	}
+
+	if err := t.apply(sender.Host, msgType, obj); err == graph.ErrRevisionMismatch {
+		c.SendMessage(graph.NewElementRequestMsg(msgType, obj.(*graph.PartiallyUpdatedMsg).ID))
+	}
+}
+
+// Publish applies the graph messages of the publisher, as if sent through the
+// WebSocket endpoint. No message is applied if one of them is invalid or not
+// authorized.
+func (t *TopologyPublisherEndpoint) Publish(sender api.GraphSender, msgs []*shttp.WSJSONMessage) error {
+	msgTypes := make([]string, len(msgs))
+	objs := make([]interface{}, len(msgs))
+	for i, msg := range msgs {
+		msgType, obj, err := graph.UnmarshalWSMessage(msg)
+		if err != nil {
+			return fmt.Errorf("Unable to parse message %d: %s", i, err.Error())
+		}
+
+		switch msgType {
+		case graph.SyncRequestMsgType, graph.JournalRequestMsgType, graph.JournalReplyMsgType, graph.NodeRequestMsgType, graph.EdgeRequestMsgType:
+			return fmt.Errorf("Message %d: %s messages can't be published", i, msgType)
+		}
+
+		if err := t.validate(msgType, obj); err != nil {
+			return fmt.Errorf("Message %d: %s", i, err.Error())
+		}
+
+		msgTypes[i], objs[i] = msgType, obj
+	}
+
+	t.Graph.Lock()
+	defer t.Graph.Unlock()
+
+	for i := range msgs {
+		if !t.authorizer.Authorize(t.Graph, sender, msgTypes[i], objs[i]) {
+			return api.ErrGraphWriteNotAuthorized
+		}
+	}
+
+	if err := t.checkRevisions(msgTypes, objs); err != nil {
+		return err
+	}
+
+	for i := range msgs {
+		t.apply(sender.Host, msgTypes[i], objs[i])
+	}
+
+	return nil
+}
+
+// revision returns the revision of the element of a partial update, false if
+// the element is unknown
+func (t *TopologyPublisherEndpoint) revision(msgType string, id graph.Identifier) (revision int64, found bool) {
+	switch msgType {
+	case graph.NodePartiallyUpdatedMsgType:
+		if n := t.Graph.GetNode(id); n != nil {
+			revision, _ = n.GetFieldInt64("Revision")
+			return revision, true
+		}
+	case graph.EdgePartiallyUpdatedMsgType:
+		if e := t.Graph.GetEdge(id); e != nil {
+			revision, _ = e.GetFieldInt64("Revision")
+			return revision, true
+		}
+	}
+	return 0, false
+}
+
+// checkRevisions returns an error if a partial update of the messages doesn't
+// follow the revision of its element, so that the batch is not partially
+// applied. The graph lock has to be held.
+func (t *TopologyPublisherEndpoint) checkRevisions(msgTypes []string, objs []interface{}) error {
+	revisions := make(map[graph.Identifier]int64)
+	for i, obj := range objs {
+		switch msgTypes[i] {
+		case graph.NodeAddedMsgType, graph.NodeUpdatedMsgType:
+			n := obj.(*graph.Node)
+			revisions[n.ID], _ = n.GetFieldInt64("Revision")
+		case graph.EdgeAddedMsgType, graph.EdgeUpdatedMsgType:
+			e := obj.(*graph.Edge)
+			revisions[e.ID], _ = e.GetFieldInt64("Revision")
+		case graph.NodePartiallyUpdatedMsgType, graph.EdgePartiallyUpdatedMsgType:
+			p := obj.(*graph.PartiallyUpdatedMsg)
+
+			revision, found := revisions[p.ID]
+			if !found {
+				if revision, found = t.revision(msgTypes[i], p.ID); !found {
+					continue
+				}
+			}
+
+			if p.Revision != revision+1 {
+				return fmt.Errorf("Message %d: %s, the whole element has to be published", i, graph.ErrRevisionMismatch)
+			}
+			revisions[p.ID] = p.Revision
+		}
+	}
+
+	return nil
 }
 
 // NewTopologyPublisherEndpoint returns a new server for external publishers.
@@ -155,6 +316,7 @@ func NewTopologyPublisherEndpoint(pool shttp.WSJSONSpeakerPool, auth *shttp.Auth
 		nodeSchema:    gojsonschema.NewBytesLoader(nodeSchema),
 		edgeSchema:    gojsonschema.NewBytesLoader(edgeSchema),
 		gremlinParser: traversal.NewGremlinTraversalParser(),
+		authorizer:    NewGraphWriteAuthorizer(config.GetConfig().GetStringSlice("analyzer.topology.cross_host_publishers")),
 	}
 
 	pool.AddEventHandler(t)
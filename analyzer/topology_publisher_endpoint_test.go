@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	api "github.com/skydive-project/skydive/api/server"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+type fakeEnrolledHosts map[string]bool
+
+func (f fakeEnrolledHosts) Enrolled(host string) bool {
+	return f[host]
+}
+
+func newPublisherEndpoint(t *testing.T, crossHost ...string) *TopologyPublisherEndpoint {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint, err := NewTopologyPublisherEndpoint(shttp.NewWSJSONClientPool("publisher"), nil, graph.NewGraphFromConfig(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.authorizer = NewGraphWriteAuthorizer(crossHost)
+
+	return endpoint
+}
+
+// newMessage returns a graph message as received from a publisher
+func newMessage(t *testing.T, msgType string, obj interface{}) *shttp.WSJSONMessage {
+	var msg shttp.WSJSONMessage
+	if err := json.Unmarshal(shttp.NewWSJSONMessage(graph.Namespace, msgType, obj).Marshal(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+func nodeAdded(t *testing.T, id graph.Identifier, host string, m graph.Metadata) *shttp.WSJSONMessage {
+	return newMessage(t, graph.NodeAddedMsgType, graph.CreateNode(id, m, time.Now().UTC(), host))
+}
+
+func TestPublishAllOrNothing(t *testing.T) {
+	endpoint := newPublisherEndpoint(t)
+	g := endpoint.Graph
+	cmdb := api.GraphSender{Host: "cmdb"}
+
+	err := endpoint.Publish(cmdb, []*shttp.WSJSONMessage{
+		nodeAdded(t, "n1", "cmdb", graph.Metadata{"Type": "switch"}),
+		nodeAdded(t, "n2", "controller", graph.Metadata{"Type": "switch"}),
+	})
+	if err != api.ErrGraphWriteNotAuthorized {
+		t.Errorf("Batch modifying the graph of another host should be rejected, got %v", err)
+	}
+
+	err = endpoint.Publish(cmdb, []*shttp.WSJSONMessage{
+		nodeAdded(t, "n1", "cmdb", graph.Metadata{"Type": "switch"}),
+		nodeAdded(t, "n2", "cmdb", graph.Metadata{"Type": "netns"}),
+	})
+	if err == nil {
+		t.Errorf("Batch with an invalid node should be rejected")
+	}
+
+	if g.GetNode("n1") != nil {
+		t.Fatalf("No message of a rejected batch should be applied")
+	}
+
+	err = endpoint.Publish(cmdb, []*shttp.WSJSONMessage{
+		nodeAdded(t, "n1", "cmdb", graph.Metadata{"Type": "switch"}),
+		nodeAdded(t, "n2", "cmdb", graph.Metadata{"Type": "netns", "Path": "/var/run/netns/ns2"}),
+	})
+	if err != nil {
+		t.Fatalf("Batch should be accepted, got %s", err)
+	}
+
+	if g.GetNode("n1") == nil || g.GetNode("n2") == nil {
+		t.Errorf("All the messages of the batch should be applied")
+	}
+}
+
+func TestPublishHostSync(t *testing.T) {
+	endpoint := newPublisherEndpoint(t)
+	g := endpoint.Graph
+	cmdb := api.GraphSender{Host: "cmdb"}
+
+	now := time.Now().UTC()
+	n1 := graph.CreateNode("n1", graph.Metadata{"Type": "switch"}, now, "cmdb")
+	n2 := graph.CreateNode("n2", graph.Metadata{"Type": "switch"}, now, "cmdb")
+	e := graph.CreateEdge("e1", "n1", "n2", graph.Metadata{"RelationType": "layer2"}, now, "cmdb")
+
+	err := endpoint.Publish(cmdb, []*shttp.WSJSONMessage{
+		newMessage(t, graph.NodeAddedMsgType, n1),
+		newMessage(t, graph.NodeAddedMsgType, n2),
+		newMessage(t, graph.EdgeAddedMsgType, e),
+	})
+	if err != nil {
+		t.Fatalf("Batch should be accepted, got %s", err)
+	}
+
+	invalid := graph.CreateNode("n3", graph.Metadata{"Type": "ovsport"}, now, "cmdb")
+	err = endpoint.Publish(cmdb, []*shttp.WSJSONMessage{
+		newMessage(t, graph.HostSyncMsgType, &graph.SyncMsg{Nodes: []*graph.Node{n1, invalid}}),
+	})
+	if err == nil {
+		t.Errorf("Host sync with an invalid node should be rejected")
+	}
+
+	other := graph.CreateNode("n4", graph.Metadata{"Type": "switch"}, now, "controller")
+	err = endpoint.Publish(cmdb, []*shttp.WSJSONMessage{
+		newMessage(t, graph.HostSyncMsgType, &graph.SyncMsg{Nodes: []*graph.Node{n1, other}}),
+	})
+	if err != api.ErrGraphWriteNotAuthorized {
+		t.Errorf("Host sync with a node of another host should be rejected, got %v", err)
+	}
+
+	if g.GetNode("n2") == nil || g.GetEdge("e1") == nil {
+		t.Fatalf("Rejected host syncs should not remove any element")
+	}
+
+	err = endpoint.Publish(cmdb, []*shttp.WSJSONMessage{
+		newMessage(t, graph.HostSyncMsgType, &graph.SyncMsg{Nodes: []*graph.Node{n1}}),
+	})
+	if err != nil {
+		t.Fatalf("Host sync should be accepted, got %s", err)
+	}
+
+	if g.GetNode("n1") == nil {
+		t.Errorf("Node part of the host sync should be kept")
+	}
+
+	if g.GetNode("n2") != nil || g.GetEdge("e1") != nil {
+		t.Errorf("Elements not part of the host sync should be removed")
+	}
+}
+
+func TestPublishAuthorization(t *testing.T) {
+	endpoint := newPublisherEndpoint(t, "k8s")
+	endpoint.authorizer.enrolled = fakeEnrolledHosts{"agent1": true}
+	g := endpoint.Graph
+
+	msgs := []*shttp.WSJSONMessage{nodeAdded(t, "n1", "node1", graph.Metadata{"Type": "pod"})}
+
+	if err := endpoint.Publish(api.GraphSender{Host: "k8s"}, msgs); err != api.ErrGraphWriteNotAuthorized {
+		t.Errorf("Cross-host publisher not authenticated should be rejected, got %v", err)
+	}
+
+	if err := endpoint.Publish(api.GraphSender{Host: "k8s", User: "k8s"}, msgs); err != nil {
+		t.Errorf("Authenticated cross-host publisher should be accepted, got %s", err)
+	}
+
+	if g.GetNode("n1") == nil {
+		t.Errorf("Node of the cross-host publisher should be added")
+	}
+
+	msgs = []*shttp.WSJSONMessage{nodeAdded(t, "n2", "agent1", graph.Metadata{"Type": "host"})}
+
+	if err := endpoint.Publish(api.GraphSender{Host: "agent1"}, msgs); err != api.ErrGraphWriteNotAuthorized {
+		t.Errorf("Host ID of an enrolled host should not be used without certificate, got %v", err)
+	}
+
+	if err := endpoint.Publish(api.GraphSender{Host: "agent1", User: "agent1", Certified: true}, msgs); err != nil {
+		t.Errorf("Enrolled host should be accepted, got %s", err)
+	}
+
+	deleted := []*shttp.WSJSONMessage{newMessage(t, graph.HostGraphDeletedMsgType, "agent1")}
+
+	if err := endpoint.Publish(api.GraphSender{Host: "agent1"}, deleted); err != api.ErrGraphWriteNotAuthorized {
+		t.Errorf("Graph of an enrolled host should not be deleted without certificate, got %v", err)
+	}
+
+	if err := endpoint.Publish(api.GraphSender{Host: "agent2"}, deleted); err != api.ErrGraphWriteNotAuthorized {
+		t.Errorf("Graph of another host should not be deleted, got %v", err)
+	}
+
+	if g.GetNode("n2") == nil {
+		t.Fatalf("Node of the enrolled host should not be deleted")
+	}
+
+	if rejected := endpoint.authorizer.Rejected(); rejected["k8s"] != 1 || rejected["agent1"] != 2 || rejected["agent2"] != 1 {
+		t.Errorf("Wrong number of rejected messages: %v", rejected)
+	}
+}
@@ -66,6 +66,7 @@ type TopologyReplicationEndpoint struct {
 	candidates   []*TopologyReplicatorPeer
 	Graph        *graph.Graph
 	cached       *graph.CachedBackend
+	agents       *TopologyAgentEndpoint
 	replicateMsg atomic.Value
 	wg           sync.WaitGroup
 }
@@ -232,6 +233,10 @@ func (t *TopologyReplicationEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *sh
 	case graph.HostGraphDeletedMsgType:
 		logging.GetLogger().Debugf("Got %s message for host %s", graph.HostGraphDeletedMsgType, obj.(string))
 		t.Graph.DelHostGraph(obj.(string))
+	case graph.HostConnectedMsgType:
+		if t.agents != nil {
+			t.agents.onHostConnectedElsewhere(obj.(string))
+		}
 	case graph.SyncMsgType, graph.SyncReplyMsgType:
 		r := obj.(*graph.SyncMsg)
 		for _, n := range r.Nodes {
@@ -256,6 +261,19 @@ func (t *TopologyReplicationEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *sh
 		t.Graph.EdgeDeleted(obj.(*graph.Edge))
 	case graph.EdgeAddedMsgType:
 		t.Graph.EdgeAdded(obj.(*graph.Edge))
+	case graph.NodePartiallyUpdatedMsgType:
+		if err := t.Graph.NodePartiallyUpdated(obj.(*graph.PartiallyUpdatedMsg)); err == graph.ErrRevisionMismatch {
+			c.SendMessage(graph.NewElementRequestMsg(msgType, obj.(*graph.PartiallyUpdatedMsg).ID))
+		}
+	case graph.EdgePartiallyUpdatedMsgType:
+		if err := t.Graph.EdgePartiallyUpdated(obj.(*graph.PartiallyUpdatedMsg)); err == graph.ErrRevisionMismatch {
+			c.SendMessage(graph.NewElementRequestMsg(msgType, obj.(*graph.PartiallyUpdatedMsg).ID))
+		}
+	case graph.NodeRequestMsgType, graph.EdgeRequestMsgType:
+		// a peer missed partial updates of an element
+		if reply := graph.NewElementReplyMsg(t.Graph, msgType, obj.(graph.ElementRequestMsg)); reply != nil {
+			c.SendMessage(reply)
+		}
 	}
 }
 
@@ -273,6 +291,14 @@ func (t *TopologyReplicationEndpoint) OnNodeUpdated(n *graph.Node) {
 	}
 }
 
+// OnNodePartiallyUpdated graph node partially updated event. Implements the GraphPartialUpdateListener interface.
+func (t *TopologyReplicationEndpoint) OnNodePartiallyUpdated(n *graph.Node, ops []graph.PartialUpdate) {
+	if t.replicateMsg.Load() == true {
+		msg := shttp.NewWSJSONMessage(graph.Namespace, graph.NodePartiallyUpdatedMsgType, graph.NewPartiallyUpdatedMsg(n, ops))
+		t.notifyPeers(msg)
+	}
+}
+
 // OnNodeAdded graph node added event. Implements the GraphEventListener interface.
 func (t *TopologyReplicationEndpoint) OnNodeAdded(n *graph.Node) {
 	if t.replicateMsg.Load() == true {
@@ -297,6 +323,14 @@ func (t *TopologyReplicationEndpoint) OnEdgeUpdated(e *graph.Edge) {
 	}
 }
 
+// OnEdgePartiallyUpdated graph edge partially updated event. Implements the GraphPartialUpdateListener interface.
+func (t *TopologyReplicationEndpoint) OnEdgePartiallyUpdated(e *graph.Edge, ops []graph.PartialUpdate) {
+	if t.replicateMsg.Load() == true {
+		msg := shttp.NewWSJSONMessage(graph.Namespace, graph.EdgePartiallyUpdatedMsgType, graph.NewPartiallyUpdatedMsg(e, ops))
+		t.notifyPeers(msg)
+	}
+}
+
 // OnEdgeAdded graph edge added event. Implements the GraphEventListener interface.
 func (t *TopologyReplicationEndpoint) OnEdgeAdded(e *graph.Edge) {
 	if t.replicateMsg.Load() == true {
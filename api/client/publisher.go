@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// TopologyPublisher pushes nodes and edges to the analyzer through the
+// topology ingestion API, with the semantics of the publisher WebSocket
+// endpoint. The elements belong to the publisher, their Host being the
+// publisher ID, a publisher can't modify the elements of other hosts unless
+// allowed to write cross-host by the analyzer.
+type TopologyPublisher struct {
+	ID     string
+	client *shttp.RestClient
+}
+
+// NewNode returns a new node belonging to the publisher
+func (p *TopologyPublisher) NewNode(id graph.Identifier, m graph.Metadata) *graph.Node {
+	return graph.CreateNode(id, m, time.Now().UTC(), p.ID)
+}
+
+// NewEdge returns a new edge belonging to the publisher
+func (p *TopologyPublisher) NewEdge(id graph.Identifier, parent graph.Identifier, child graph.Identifier, m graph.Metadata) *graph.Edge {
+	return graph.CreateEdge(id, parent, child, m, time.Now().UTC(), p.ID)
+}
+
+// Publish sends graph messages to the analyzer, none of them being applied if
+// one is rejected
+func (p *TopologyPublisher) Publish(msgs ...*shttp.WSJSONMessage) error {
+	bulk := make(shttp.WSBulkMessage, len(msgs))
+	for i, msg := range msgs {
+		bulk[i] = json.RawMessage(msg.Marshal())
+	}
+
+	body, err := json.Marshal(bulk)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Host-ID", p.ID)
+
+	resp, err := p.client.Request("PATCH", "topology", bytes.NewReader(body), header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to publish topology, %s: %s", resp.Status, string(data))
+	}
+
+	return nil
+}
+
+func (p *TopologyPublisher) publish(msgType string, obj interface{}) error {
+	return p.Publish(shttp.NewWSJSONMessage(graph.Namespace, msgType, obj))
+}
+
+// AddNode adds a node
+func (p *TopologyPublisher) AddNode(n *graph.Node) error {
+	return p.publish(graph.NodeAddedMsgType, n)
+}
+
+// UpdateNode replaces a node
+func (p *TopologyPublisher) UpdateNode(n *graph.Node) error {
+	return p.publish(graph.NodeUpdatedMsgType, n)
+}
+
+// DeleteNode deletes a node
+func (p *TopologyPublisher) DeleteNode(n *graph.Node) error {
+	return p.publish(graph.NodeDeletedMsgType, n)
+}
+
+// AddEdge adds an edge
+func (p *TopologyPublisher) AddEdge(e *graph.Edge) error {
+	return p.publish(graph.EdgeAddedMsgType, e)
+}
+
+// UpdateEdge replaces an edge
+func (p *TopologyPublisher) UpdateEdge(e *graph.Edge) error {
+	return p.publish(graph.EdgeUpdatedMsgType, e)
+}
+
+// DeleteEdge deletes an edge
+func (p *TopologyPublisher) DeleteEdge(e *graph.Edge) error {
+	return p.publish(graph.EdgeDeletedMsgType, e)
+}
+
+// Sync makes the graph of the publisher match the given nodes and edges, the
+// elements previously published and not part of the sync are removed
+func (p *TopologyPublisher) Sync(nodes []*graph.Node, edges []*graph.Edge) error {
+	return p.publish(graph.HostSyncMsgType, &graph.SyncMsg{Nodes: nodes, Edges: edges})
+}
+
+// NewTopologyPublisher returns a new publisher identified by id
+func NewTopologyPublisher(id string, client *shttp.RestClient) *TopologyPublisher {
+	return &TopologyPublisher{
+		ID:     id,
+		client: client,
+	}
+}
+
+// NewTopologyPublisherFromConfig returns a new publisher identified by id
+// using the analyzer of the configuration
+func NewTopologyPublisherFromConfig(id string, authOptions *shttp.AuthenticationOpts) (*TopologyPublisher, error) {
+	client, err := NewRestClientFromConfig(authOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTopologyPublisher(id, client), nil
+}
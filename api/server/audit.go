@@ -0,0 +1,379 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const etcdAuditPath = "/audit"
+
+// AuditBackend describes an append-only storage of audit entries
+type AuditBackend interface {
+	Append(entry *types.AuditEntry) error
+	Entries() ([]*types.AuditEntry, error)
+}
+
+// FileAuditBackend stores audit entries in a file, one JSON entry per line
+type FileAuditBackend struct {
+	sync.Mutex
+	path string
+	file *os.File
+}
+
+// Append writes an entry at the end of the audit file
+func (f *FileAuditBackend) Append(entry *types.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// Entries reads all the entries of the audit file
+func (f *FileAuditBackend) Entries() ([]*types.AuditEntry, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*types.AuditEntry
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var entry types.AuditEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("Failed to read audit file %s: %s", f.path, err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// NewFileAuditBackend opens, or creates, an audit file. The file is only
+// opened in append mode.
+func NewFileAuditBackend(path string) (*FileAuditBackend, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open audit file %s: %s", path, err)
+	}
+
+	return &FileAuditBackend{path: path, file: file}, nil
+}
+
+// EtcdAuditBackend stores audit entries in etcd, under the /audit directory
+type EtcdAuditBackend struct {
+	kapi etcd.KeysAPI
+}
+
+// Append stores an entry using a key prefixed by the entry timestamp so that
+// keys are sorted chronologically. Existing keys are never overwritten.
+func (e *EtcdAuditBackend) Append(entry *types.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s/%020d-%s", etcdAuditPath, entry.Time.UnixNano(), entry.UUID)
+	_, err = e.kapi.Set(context.Background(), key, string(data), &etcd.SetOptions{PrevExist: etcd.PrevNoExist})
+	return err
+}
+
+// Entries returns all the entries stored in etcd
+func (e *EtcdAuditBackend) Entries() ([]*types.AuditEntry, error) {
+	resp, err := e.kapi.Get(context.Background(), etcdAuditPath, &etcd.GetOptions{Recursive: true, Sort: true})
+	if err != nil {
+		if etcd.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []*types.AuditEntry
+	for _, node := range resp.Node.Nodes {
+		var entry types.AuditEntry
+		if err := json.Unmarshal([]byte(node.Value), &entry); err != nil {
+			logging.GetLogger().Warningf("Invalid audit entry %s: %s", node.Key, err)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// NewEtcdAuditBackend returns a new etcd audit backend
+func NewEtcdAuditBackend(kapi etcd.KeysAPI) *EtcdAuditBackend {
+	return &EtcdAuditBackend{kapi: kapi}
+}
+
+// AuditLog records the operations modifying the state of the analyzer to all
+// its backends. Entries are read from the first backend.
+type AuditLog struct {
+	backends []AuditBackend
+}
+
+// AuditFilter describes the criteria used to select audit entries
+type AuditFilter struct {
+	User     string
+	Resource string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (f *AuditFilter) match(entry *types.AuditEntry) bool {
+	if f.User != "" && entry.User != f.User {
+		return false
+	}
+	if f.Resource != "" && entry.Resource != f.Resource {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Record adds an entry to the audit log. A nil audit log records nothing.
+func (a *AuditLog) Record(r *auth.AuthenticatedRequest, operation, resource, id string, payload interface{}, err error) {
+	if a == nil {
+		return
+	}
+
+	entry := types.NewAuditEntry()
+	entry.User = r.Username
+	entry.SourceIP = sourceIP(&r.Request)
+	entry.Operation = operation
+	entry.Resource = resource
+	entry.ResourceID = id
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			logging.GetLogger().Errorf("Failed to marshal audit payload of %s %s: %s", resource, id, err)
+		} else {
+			entry.Payload = data
+		}
+	}
+
+	for _, backend := range a.backends {
+		if err := backend.Append(entry); err != nil {
+			logging.GetLogger().Errorf("Failed to write audit entry %s: %s", entry.UUID, err)
+		}
+	}
+}
+
+// RecordResource adds an entry about a resource of the given handler to the
+// audit log. The payload of a sensitive resource is omitted and its
+// identifier replaced by a hash, so that the entries of the same resource
+// can still be correlated.
+func (a *AuditLog) RecordResource(r *auth.AuthenticatedRequest, operation string, handler ResourceHandler, id string, payload interface{}, err error) {
+	if a == nil {
+		return
+	}
+
+	if _, ok := handler.New().(types.SensitiveResource); ok {
+		if id != "" {
+			hash := sha256.Sum256([]byte(id))
+			id = "sha256:" + hex.EncodeToString(hash[:])
+		}
+		payload = nil
+	}
+
+	a.Record(r, operation, handler.Name(), id, payload, err)
+}
+
+// Entries returns the audit entries matching the filter, backends return
+// entries in chronological order
+func (a *AuditLog) Entries(filter *AuditFilter) ([]*types.AuditEntry, error) {
+	if len(a.backends) == 0 {
+		return nil, nil
+	}
+
+	entries, err := a.backends[0].Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*types.AuditEntry
+	for _, entry := range entries {
+		if filter.match(entry) {
+			result = append(result, entry)
+		}
+	}
+
+	// keep the most recent entries
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+
+	return result, nil
+}
+
+// NewAuditLogFromConfig creates an audit log using the backends defined in
+// the analyzer.audit section of the configuration
+func NewAuditLogFromConfig(kapi etcd.KeysAPI) (*AuditLog, error) {
+	a := &AuditLog{}
+
+	for _, name := range config.GetConfig().GetStringSlice("analyzer.audit.backends") {
+		switch name {
+		case "file":
+			backend, err := NewFileAuditBackend(config.GetConfig().GetString("analyzer.audit.file"))
+			if err != nil {
+				return nil, err
+			}
+			a.backends = append(a.backends, backend)
+		case "etcd":
+			a.backends = append(a.backends, NewEtcdAuditBackend(kapi))
+		default:
+			return nil, fmt.Errorf("Unknown audit backend: %s", name)
+		}
+	}
+
+	return a, nil
+}
+
+func parseAuditFilter(r *http.Request) (*AuditFilter, error) {
+	query := r.URL.Query()
+
+	filter := &AuditFilter{
+		User:     query.Get("user"),
+		Resource: query.Get("resource"),
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return nil, fmt.Errorf("Invalid since parameter: %s", err)
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return nil, fmt.Errorf("Invalid until parameter: %s", err)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, fmt.Errorf("Invalid limit parameter: %s", err)
+		}
+	}
+
+	return filter, nil
+}
+
+func (a *AuditLog) auditList(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	filter, err := parseAuditFilter(&r.Request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := a.Entries(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if entries == nil {
+		entries = []*types.AuditEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (a *AuditLog) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "AuditList",
+			Method:      "GET",
+			Path:        "/api/audit",
+			HandlerFunc: a.auditList,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterAuditAPI creates the audit log of the API server and registers the
+// audit endpoint
+func RegisterAuditAPI(apiServer *Server) (*AuditLog, error) {
+	audit, err := NewAuditLogFromConfig(apiServer.EtcdKeyAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	apiServer.Audit = audit
+	audit.registerEndpoints(apiServer.HTTPServer)
+
+	return audit, nil
+}
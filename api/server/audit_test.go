@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/api/types"
+)
+
+func TestAuditFileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := NewFileAuditBackend(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &AuditLog{backends: []AuditBackend{backend}}
+
+	r := &auth.AuthenticatedRequest{Request: *httptest.NewRequest("POST", "/api/capture", nil), Username: "alice"}
+	audit.Record(r, "create", "capture", "1", &types.Capture{GremlinQuery: "G.V()"}, nil)
+
+	r.Username = "bob"
+	audit.Record(r, "delete", "alert", "2", nil, errors.New("not found"))
+
+	entries, err := audit.Entries(&AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+
+	if e := entries[0]; e.User != "alice" || e.Operation != "create" || e.SourceIP != "192.0.2.1" || len(e.Payload) == 0 {
+		t.Errorf("Wrong first entry: %+v", e)
+	}
+	if e := entries[1]; e.Resource != "alert" || e.Error != "not found" {
+		t.Errorf("Wrong second entry: %+v", e)
+	}
+
+	if entries, _ = audit.Entries(&AuditFilter{User: "bob"}); len(entries) != 1 || entries[0].User != "bob" {
+		t.Errorf("Expected only the entry of bob, got %+v", entries)
+	}
+
+	if entries, _ = audit.Entries(&AuditFilter{Since: time.Now().Add(time.Hour)}); len(entries) != 0 {
+		t.Errorf("Expected no entry, got %+v", entries)
+	}
+
+	if entries, _ = audit.Entries(&AuditFilter{Limit: 1}); len(entries) != 1 || entries[0].User != "bob" {
+		t.Errorf("Expected the most recent entry, got %+v", entries)
+	}
+}
+
+func TestAuditSensitiveResource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := NewFileAuditBackend(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &AuditLog{backends: []AuditBackend{backend}}
+
+	handler := &EnrollmentTokenResourceHandler{}
+	token := handler.New().(*types.EnrollmentToken)
+
+	r := &auth.AuthenticatedRequest{Request: *httptest.NewRequest("POST", "/api/enrollmenttoken", nil), Username: "admin"}
+	audit.RecordResource(r, "create", handler, token.ID(), token, nil)
+	audit.RecordResource(r, "delete", handler, token.ID(), nil, nil)
+	audit.RecordResource(r, "create", &CaptureResourceHandler{}, "1", &types.Capture{GremlinQuery: "G.V()"}, nil)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token.UUID) {
+		t.Errorf("The enrollment token is disclosed by the audit log: %s", string(data))
+	}
+
+	entries, err := audit.Entries(&AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	// the entries of the same token can still be correlated
+	if e := entries[0]; e.Resource != "enrollmenttoken" || e.ResourceID == "" || e.ResourceID != entries[1].ResourceID || len(e.Payload) != 0 {
+		t.Errorf("Wrong token entry: %+v", e)
+	}
+
+	if e := entries[2]; e.Resource != "capture" || e.ResourceID != "1" || len(e.Payload) == 0 {
+		t.Errorf("Wrong capture entry: %+v", e)
+	}
+}
@@ -0,0 +1,373 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	etcd "github.com/coreos/etcd/client"
+	"github.com/nu7hatch/gouuid"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+)
+
+var (
+	// ErrInvalidEnrollmentToken is returned when the enrollment token doesn't
+	// exist, was already used or doesn't match the host
+	ErrInvalidEnrollmentToken = errors.New("Invalid enrollment token")
+	// ErrCertificateRevoked is returned when the client certificate was revoked
+	ErrCertificateRevoked = errors.New("Certificate revoked")
+)
+
+// EnrollmentTokenResourceHandler aims to creates and manage enrollment tokens
+type EnrollmentTokenResourceHandler struct {
+	ResourceHandler
+}
+
+// EnrollmentTokenAPIHandler aims to exposes the enrollment token API
+type EnrollmentTokenAPIHandler struct {
+	BasicAPIHandler
+}
+
+// New creates a new enrollment token
+func (e *EnrollmentTokenResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.EnrollmentToken{
+		UUID:       id.String(),
+		CreateTime: time.Now().UTC(),
+	}
+}
+
+// Name returns resource name "enrollmenttoken"
+func (e *EnrollmentTokenResourceHandler) Name() string {
+	return "enrollmenttoken"
+}
+
+// Create stores the enrollment token with a TTL so that the tokens not used
+// expire
+func (e *EnrollmentTokenAPIHandler) Create(resource types.Resource) error {
+	ttl := time.Duration(config.GetConfig().GetInt("analyzer.enrollment.token_ttl")) * time.Second
+
+	token := resource.(*types.EnrollmentToken)
+	token.ExpireTime = time.Now().UTC().Add(ttl)
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	etcdPath := fmt.Sprintf("/%s/%s", e.Name(), token.ID())
+	_, err = e.EtcdKeyAPI.Set(context.Background(), etcdPath, string(data), &etcd.SetOptions{TTL: ttl})
+	return err
+}
+
+// AgentCertificateResourceHandler aims to manage the certificates delivered
+// to the agents
+type AgentCertificateResourceHandler struct {
+	ResourceHandler
+}
+
+// AgentCertificateAPIHandler aims to exposes the agent certificate API,
+// deleting a certificate revokes it
+type AgentCertificateAPIHandler struct {
+	BasicAPIHandler
+	ca        *common.CertificateAuthority
+	hostsLock sync.RWMutex
+	hosts     map[string]string // host per certificate serial, empty when revoked
+	enrolled  map[string]int    // number of certificates not revoked per host
+}
+
+// New creates a new agent certificate
+func (a *AgentCertificateResourceHandler) New() types.Resource {
+	return &types.AgentCertificate{}
+}
+
+// Name returns resource name "agentcertificate"
+func (a *AgentCertificateResourceHandler) Name() string {
+	return "agentcertificate"
+}
+
+// Create rejects the certificates not delivered through the enrollment
+func (a *AgentCertificateAPIHandler) Create(resource types.Resource) error {
+	return errors.New("Agent certificates are only delivered through enrollment")
+}
+
+// AuthorizeCertificate returns the host the certificate was delivered to. An
+// empty host is returned for certificates not signed by the enrollment
+// authority.
+func (a *AgentCertificateAPIHandler) AuthorizeCertificate(cert *x509.Certificate) (string, error) {
+	if !a.ca.Signed(cert) {
+		return "", nil
+	}
+
+	serial := cert.SerialNumber.Text(16)
+
+	a.hostsLock.RLock()
+	host, known := a.hosts[serial]
+	a.hostsLock.RUnlock()
+
+	if !known {
+		var err error
+		if host, err = a.lookupHost(serial); err != nil {
+			return "", err
+		}
+	}
+
+	if host == "" || host != cert.Subject.CommonName {
+		return "", ErrCertificateRevoked
+	}
+
+	return host, nil
+}
+
+// lookupHost retrieves from etcd the host of a certificate not notified by
+// the watcher yet, typically delivered by another analyzer
+func (a *AgentCertificateAPIHandler) lookupHost(serial string) (string, error) {
+	var host string
+
+	etcdPath := fmt.Sprintf("/%s/%s", a.Name(), serial)
+	resp, err := a.EtcdKeyAPI.Get(context.Background(), etcdPath, nil)
+	if err == nil {
+		resource, err := a.Unmarshal([]byte(resp.Node.Value))
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to decode certificate %s: %s", serial, err)
+			return "", shttp.ErrCertificateLookupFailed
+		}
+		host = resource.(*types.AgentCertificate).Host
+	} else if !etcd.IsKeyNotFound(err) {
+		logging.GetLogger().Errorf("Unable to retrieve certificate %s: %s", serial, err)
+		return "", shttp.ErrCertificateLookupFailed
+	}
+
+	// the watcher notifications take precedence as they may be more recent
+	a.hostsLock.Lock()
+	if known, found := a.hosts[serial]; found {
+		host = known
+	} else {
+		a.setHost(serial, host)
+	}
+	a.hostsLock.Unlock()
+
+	return host, nil
+}
+
+// setHost records the host of a certificate, an empty host for a revoked
+// certificate. The hosts lock has to be held.
+func (a *AgentCertificateAPIHandler) setHost(serial string, host string) {
+	if previous := a.hosts[serial]; previous != "" {
+		if a.enrolled[previous]--; a.enrolled[previous] == 0 {
+			delete(a.enrolled, previous)
+		}
+	}
+
+	a.hosts[serial] = host
+	if host != "" {
+		a.enrolled[host]++
+	}
+}
+
+// Enrolled returns whether a certificate not revoked was delivered to the host
+func (a *AgentCertificateAPIHandler) Enrolled(host string) bool {
+	a.hostsLock.RLock()
+	defer a.hostsLock.RUnlock()
+
+	return a.enrolled[host] > 0
+}
+
+// Watch keeps the certificates in memory so that they are authorized without
+// reaching etcd. The revoked callback is called for each certificate revoked.
+func (a *AgentCertificateAPIHandler) Watch(revoked func(cert *types.AgentCertificate)) StoppableWatcher {
+	return a.AsyncWatch(func(action string, id string, resource types.Resource) {
+		cert := resource.(*types.AgentCertificate)
+
+		switch action {
+		case "delete", "expire":
+			a.hostsLock.Lock()
+			a.setHost(id, "")
+			a.hostsLock.Unlock()
+
+			revoked(cert)
+		default:
+			a.hostsLock.Lock()
+			a.setHost(id, cert.Host)
+			a.hostsLock.Unlock()
+		}
+	})
+}
+
+// enroll consumes the enrollment token and signs the certificate request of
+// the agent
+func (a *AgentCertificateAPIHandler) enroll(tokens *EnrollmentTokenAPIHandler, request *types.EnrollmentRequest) (*types.AgentCertificate, *types.EnrollmentReply, error) {
+	if request.Host == "" {
+		return nil, nil, errors.New("No host specified")
+	}
+
+	resource, ok := tokens.Get(request.Token)
+	if !ok {
+		return nil, nil, ErrInvalidEnrollmentToken
+	}
+
+	token := resource.(*types.EnrollmentToken)
+	if token.Host != "" && token.Host != request.Host {
+		return nil, nil, ErrInvalidEnrollmentToken
+	}
+
+	// the key TTL may not be elapsed yet
+	if !token.ExpireTime.IsZero() && time.Now().After(token.ExpireTime) {
+		return nil, nil, ErrInvalidEnrollmentToken
+	}
+
+	// an invalid request must not consume the token
+	csr, err := common.ParseCertificateRequest([]byte(request.CSR))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// tokens are one-time, only the first request consuming it succeeds
+	if err := tokens.Delete(request.Token); err != nil {
+		return nil, nil, ErrInvalidEnrollmentToken
+	}
+
+	validity := time.Duration(config.GetConfig().GetInt("analyzer.enrollment.validity")) * 24 * time.Hour
+	cert, certPEM, err := a.ca.SignCertificate(csr, request.Host, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	agentCert := &types.AgentCertificate{
+		UUID:       cert.SerialNumber.Text(16),
+		Host:       request.Host,
+		CreateTime: time.Now().UTC(),
+		NotAfter:   cert.NotAfter,
+	}
+
+	if err := a.BasicAPIHandler.Create(agentCert); err != nil {
+		return nil, nil, err
+	}
+
+	reply := &types.EnrollmentReply{
+		Certificate: string(certPEM),
+		CA:          string(a.ca.PEM()),
+	}
+
+	return agentCert, reply, nil
+}
+
+func (a *AgentCertificateAPIHandler) registerEnrollEndpoint(apiServer *Server, tokens *EnrollmentTokenAPIHandler) {
+	// agents are not authenticated yet when enrolling, the token is their
+	// only credential
+	apiServer.HTTPServer.Router.HandleFunc("/api/enroll", func(w http.ResponseWriter, r *http.Request) {
+		var request types.EnrollmentRequest
+		if err := common.JSONDecode(r.Body, &request); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		agentCert, reply, err := a.enroll(tokens, &request)
+
+		ar := &auth.AuthenticatedRequest{Request: *r, Username: request.Host}
+		if agentCert != nil {
+			apiServer.Audit.Record(ar, "create", a.Name(), agentCert.ID(), agentCert, nil)
+		} else {
+			apiServer.Audit.Record(ar, "create", a.Name(), "", nil, err)
+		}
+
+		if err != nil {
+			logging.GetLogger().Warningf("Enrollment of host %s refused: %s", request.Host, err)
+			if err == ErrInvalidEnrollmentToken {
+				writeError(w, http.StatusForbidden, err)
+			} else {
+				writeError(w, http.StatusBadRequest, err)
+			}
+			return
+		}
+
+		logging.GetLogger().Infof("Host %s enrolled with certificate %s", request.Host, agentCert.ID())
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(reply); err != nil {
+			logging.GetLogger().Criticalf("Failed to send enrollment reply: %s", err)
+		}
+	}).Methods("POST")
+}
+
+// RegisterEnrollmentAPI registers the enrollment token and the agent
+// certificate APIs along with the enrollment endpoint used by the agents
+func RegisterEnrollmentAPI(apiServer *Server, ca *common.CertificateAuthority) (*AgentCertificateAPIHandler, error) {
+	tokenAPIHandler := &EnrollmentTokenAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &EnrollmentTokenResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+	}
+	if err := apiServer.RegisterAPIHandler(tokenAPIHandler); err != nil {
+		return nil, err
+	}
+
+	certificateAPIHandler := &AgentCertificateAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &AgentCertificateResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+		ca:       ca,
+		hosts:    make(map[string]string),
+		enrolled: make(map[string]int),
+	}
+	if err := apiServer.RegisterAPIHandler(certificateAPIHandler); err != nil {
+		return nil, err
+	}
+
+	certificateAPIHandler.registerEnrollEndpoint(apiServer, tokenAPIHandler)
+
+	return certificateAPIHandler, nil
+}
+
+// NewCertificateAuthorityFromConfig loads the enrollment certificate
+// authority, nil is returned when enrollment is not configured
+func NewCertificateAuthorityFromConfig() (*common.CertificateAuthority, error) {
+	certPEM := config.GetConfig().GetString("analyzer.enrollment.ca_cert")
+	keyPEM := config.GetConfig().GetString("analyzer.enrollment.ca_key")
+	if certPEM == "" {
+		return nil, nil
+	}
+
+	if keyPEM == "" {
+		return nil, fmt.Errorf("analyzer.enrollment.ca_key has to be set along with analyzer.enrollment.ca_cert")
+	}
+
+	return common.LoadCertificateAuthority(certPEM, keyPEM)
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/api/types"
+	ge "github.com/skydive-project/skydive/gremlin/traversal"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/oftrace"
+	"github.com/skydive-project/skydive/packet_injector"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// OfTraceAPI exposes the OpenFlow trace API
+type OfTraceAPI struct {
+	Client *oftrace.OfTraceClient
+	Graph  *graph.Graph
+}
+
+// nodeAddress returns the first IP and the MAC of a node, if any
+func nodeAddress(node *graph.Node, ipField string) (ip string, mac string) {
+	if ips, _ := node.GetFieldStringList(ipField); len(ips) > 0 {
+		ip = ips[0]
+	}
+	mac, _ = node.GetFieldString("MAC")
+	return
+}
+
+// requestToParams converts a trace request to packet parameters. Unlike the
+// packet injector, the addresses are optional as the packet is not sent.
+func (o *OfTraceAPI) requestToParams(ppr *types.PacketParamsReq) (string, *packet_injector.PacketParams, error) {
+	o.Graph.RLock()
+	defer o.Graph.RUnlock()
+
+	srcNode := o.getNode(ppr.Src)
+	if srcNode == nil {
+		return "", nil, errors.New("Not able to find a source node")
+	}
+
+	if ppr.Type == "" {
+		ppr.Type = "icmp4"
+	}
+
+	ipField := "IPV4"
+	if ppr.Type == "icmp6" || ppr.Type == "tcp6" || ppr.Type == "udp6" {
+		ipField = "IPV6"
+	}
+
+	srcIP, srcMAC := nodeAddress(srcNode, ipField)
+	if ppr.SrcIP == "" {
+		ppr.SrcIP = srcIP
+	}
+	if ppr.SrcMAC == "" {
+		ppr.SrcMAC = srcMAC
+	}
+
+	if ppr.Dst != "" {
+		dstNode := o.getNode(ppr.Dst)
+		if dstNode == nil {
+			return "", nil, errors.New("Not able to find a dest node")
+		}
+
+		dstIP, dstMAC := nodeAddress(dstNode, ipField)
+		if ppr.DstIP == "" {
+			ppr.DstIP = dstIP
+		}
+		if ppr.DstMAC == "" {
+			ppr.DstMAC = dstMAC
+		}
+	}
+
+	pp := &packet_injector.PacketParams{
+		SrcNodeID: srcNode.ID,
+		SrcIP:     ppr.SrcIP,
+		SrcMAC:    ppr.SrcMAC,
+		SrcPort:   ppr.SrcPort,
+		DstIP:     ppr.DstIP,
+		DstMAC:    ppr.DstMAC,
+		DstPort:   ppr.DstPort,
+		Type:      ppr.Type,
+	}
+
+	return srcNode.Host(), pp, nil
+}
+
+func (o *OfTraceAPI) trace(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	decoder := json.NewDecoder(r.Body)
+	var ppr types.PacketParamsReq
+	if err := decoder.Decode(&ppr); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	host, pp, err := o.requestToParams(&ppr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	trace, err := o.Client.Trace(host, pp)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(trace); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (o *OfTraceAPI) getNode(gremlinQuery string) *graph.Node {
+	res, err := ge.TopologyGremlinQuery(o.Graph, gremlinQuery)
+	if err != nil {
+		return nil
+	}
+
+	if values := res.Values(); len(values) > 0 {
+		if node, ok := values[0].(*graph.Node); ok {
+			return node
+		}
+	}
+	return nil
+}
+
+func (o *OfTraceAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "OfTrace",
+			Method:      "POST",
+			Path:        "/api/oftrace",
+			HandlerFunc: o.trace,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterOfTraceAPI registers the OpenFlow trace resource in the API
+func RegisterOfTraceAPI(client *oftrace.OfTraceClient, g *graph.Graph, r *shttp.Server) {
+	o := &OfTraceAPI{
+		Client: client,
+		Graph:  g,
+	}
+
+	o.registerEndpoints(r)
+}
@@ -49,6 +49,7 @@ const (
 type PacketInjectorAPI struct {
 	PIClient *packet_injector.PacketInjectorClient
 	Graph    *graph.Graph
+	Audit    *AuditLog
 }
 
 func (pi *PacketInjectorAPI) normalizeIP(ip, ipFamily string) string {
@@ -167,12 +168,14 @@ func (pi *PacketInjectorAPI) injectPacket(w http.ResponseWriter, r *auth.Authent
 
 	host, pp, err := pi.requestToParams(&ppr)
 	if err != nil {
+		pi.Audit.Record(r, "create", "injectpacket", "", &ppr, err)
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	trackingID, err := pi.PIClient.InjectPacket(host, pp)
 	if err != nil {
+		pi.Audit.Record(r, "create", "injectpacket", "", &ppr, err)
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -181,6 +184,8 @@ func (pi *PacketInjectorAPI) injectPacket(w http.ResponseWriter, r *auth.Authent
 	w.WriteHeader(http.StatusOK)
 
 	ppr.TrackingID = trackingID
+	pi.Audit.Record(r, "create", "injectpacket", trackingID, &ppr, nil)
+
 	if err := json.NewEncoder(w).Encode(ppr); err != nil {
 		logging.GetLogger().Warningf("Error while writing response: %s", err)
 	}
@@ -217,11 +222,12 @@ func (pi *PacketInjectorAPI) registerEndpoints(r *shttp.Server) {
 }
 
 // RegisterPacketInjectorAPI registers a new packet injector resource in the API
-func RegisterPacketInjectorAPI(pic *packet_injector.PacketInjectorClient, g *graph.Graph, r *shttp.Server) {
+func RegisterPacketInjectorAPI(pic *packet_injector.PacketInjectorClient, g *graph.Graph, apiServer *Server) {
 	pia := &PacketInjectorAPI{
 		PIClient: pic,
 		Graph:    g,
+		Audit:    apiServer.Audit,
 	}
 
-	pia.registerEndpoints(r)
+	pia.registerEndpoints(apiServer.HTTPServer)
 }
@@ -23,6 +23,7 @@
 package server
 
 import (
+	"io"
 	"net/http"
 	"time"
 
@@ -37,6 +38,19 @@ import (
 // PcapAPI exposes the pcap injector API
 type PcapAPI struct {
 	Storage storage.Storage
+	Audit   *AuditLog
+}
+
+// countingReader counts the bytes read from the pcap upload
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += int64(n)
+	return n, err
 }
 
 func (p *PcapAPI) flowExpireUpdate(flows []*flow.Flow) {
@@ -56,8 +70,10 @@ func (p *PcapAPI) injectPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest
 	flowtable := flow.NewTable(updateHandler, expireHandler, flow.NewEnhancerPipeline(), "", flow.TableOpts{})
 	packetSeqChan, _ := flowtable.Start()
 
-	feeder, err := flow.NewPcapTableFeeder(r.Body, packetSeqChan, false, "")
+	body := &countingReader{ReadCloser: r.Body}
+	feeder, err := flow.NewPcapTableFeeder(body, packetSeqChan, false, "")
 	if err != nil {
+		p.Audit.Record(r, "create", "pcap", "", map[string]int64{"Size": body.count}, err)
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -68,6 +84,8 @@ func (p *PcapAPI) injectPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest
 	// stop/flush flowtable
 	flowtable.Stop()
 
+	p.Audit.Record(r, "create", "pcap", "", map[string]int64{"Size": body.count}, nil)
+
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 }
@@ -86,10 +104,11 @@ func (p *PcapAPI) registerEndpoints(r *shttp.Server) {
 }
 
 // RegisterPcapAPI registers a new pcap injector API
-func RegisterPcapAPI(r *shttp.Server, store storage.Storage) {
+func RegisterPcapAPI(apiServer *Server, store storage.Storage) {
 	p := &PcapAPI{
 		Storage: store,
+		Audit:   apiServer.Audit,
 	}
 
-	p.registerEndpoints(r)
+	p.registerEndpoints(apiServer.HTTPServer)
 }
@@ -45,6 +45,7 @@ type Server struct {
 	HTTPServer  *shttp.Server
 	EtcdKeyAPI  etcd.KeysAPI
 	ServiceType common.ServiceType
+	Audit       *AuditLog
 	handlers    map[string]Handler
 }
 
@@ -135,9 +136,11 @@ func (a *Server) RegisterAPIHandler(handler Handler) error {
 				}
 
 				if err := handler.Create(resource); err != nil {
+					a.Audit.RecordResource(r, "create", handler, resource.ID(), resource, err)
 					writeError(w, http.StatusBadRequest, err)
 					return
 				}
+				a.Audit.RecordResource(r, "create", handler, resource.ID(), resource, nil)
 
 				data, err := json.Marshal(&resource)
 				if err != nil {
@@ -163,10 +166,18 @@ func (a *Server) RegisterAPIHandler(handler Handler) error {
 					return
 				}
 
+				// keep a copy of the resource for the audit log
+				var resource interface{}
+				if res, ok := handler.Get(id); ok {
+					resource = res
+				}
+
 				if err := handler.Delete(id); err != nil {
+					a.Audit.RecordResource(r, "delete", handler, id, resource, err)
 					writeError(w, http.StatusBadRequest, err)
 					return
 				}
+				a.Audit.RecordResource(r, "delete", handler, id, resource, nil)
 
 				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 				w.WriteHeader(http.StatusOK)
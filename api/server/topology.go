@@ -29,9 +29,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/abbot/go-http-auth"
 	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
@@ -41,12 +44,43 @@ import (
 	"github.com/skydive-project/skydive/validator"
 )
 
+// ErrGraphWriteNotAuthorized is returned when a publisher modifies graph
+// elements it doesn't own
+var ErrGraphWriteNotAuthorized = errors.New("Graph write not authorized")
+
 // TopologyAPI exposes the topology query API
 type TopologyAPI struct {
 	graph         *graph.Graph
 	gremlinParser *traversal.GremlinTraversalParser
 }
 
+// GraphSender identifies the sender of graph messages
+type GraphSender struct {
+	Host      string // host the sender speaks for
+	User      string // authenticated user, empty if not authenticated
+	Certified bool   // whether the host is the one of the client certificate
+}
+
+// NewGraphSender returns the sender of a request authenticated by the HTTP
+// server, host being the host ID of the request
+func NewGraphSender(host string, headers http.Header) GraphSender {
+	if certHost := headers.Get(shttp.ClientCertificateHostHeader); certHost != "" {
+		return GraphSender{Host: certHost, User: certHost, Certified: true}
+	}
+	return GraphSender{Host: host, User: headers.Get(shttp.AuthenticatedUserHeader)}
+}
+
+// TopologyPublisher applies the graph messages of the external publishers
+type TopologyPublisher interface {
+	Publish(sender GraphSender, msgs []*shttp.WSJSONMessage) error
+}
+
+// TopologyPublishAPI exposes the topology ingestion API, mapping to the
+// semantics of the publisher WebSocket endpoint
+type TopologyPublishAPI struct {
+	publisher TopologyPublisher
+}
+
 func shortID(s graph.Identifier) graph.Identifier {
 	if len(s) > 8 {
 		return s[:8]
@@ -117,6 +151,89 @@ func (t *TopologyAPI) topologyIndex(w http.ResponseWriter, r *auth.Authenticated
 	}
 }
 
+// setQueryLimits overrides the given limits with the ones defined in values
+func setQueryLimits(limits *traversal.QueryLimits, values map[string]interface{}) {
+	if v, err := common.ToInt64(values["max_elements"]); err == nil {
+		limits.MaxElements = v
+	}
+	if v, err := common.ToInt64(values["max_results"]); err == nil {
+		limits.MaxResults = v
+	}
+	if v, err := common.ToInt64(values["timeout"]); err == nil {
+		limits.Timeout = time.Duration(v) * time.Second
+	}
+}
+
+// lookupQueryLimits returns the limits defined for name in the map of the
+// configuration at key. The name is looked up in the map instead of being
+// part of the key as user and group names may contain dots.
+func lookupQueryLimits(key, name string) (map[string]interface{}, bool) {
+	entries := config.GetConfig().GetStringMap(key)
+
+	entry, ok := entries[name]
+	if !ok {
+		// keys may have been lowercased by the configuration
+		for k, v := range entries {
+			if strings.EqualFold(k, name) {
+				entry, ok = v, true
+				break
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+
+	values := make(map[string]interface{})
+	switch entry := entry.(type) {
+	case map[string]interface{}:
+		values = entry
+	case map[interface{}]interface{}:
+		for k, v := range entry {
+			values[fmt.Sprintf("%v", k)] = v
+		}
+	}
+	return values, true
+}
+
+// getQueryLimits returns the limits applied to the Gremlin queries of a user.
+// The limits of the user take precedence over the ones of its first group
+// having limits defined, which take precedence over the default ones.
+func getQueryLimits(username string, groups []string) traversal.QueryLimits {
+	cfg := config.GetConfig()
+
+	limits := traversal.QueryLimits{
+		MaxElements: int64(cfg.GetInt("graph.query_limits.max_elements")),
+		MaxResults:  int64(cfg.GetInt("graph.query_limits.max_results")),
+		Timeout:     time.Duration(cfg.GetInt("graph.query_limits.timeout")) * time.Second,
+	}
+
+	if username != "" {
+		if values, ok := lookupQueryLimits("graph.query_limits.users", username); ok {
+			setQueryLimits(&limits, values)
+			return limits
+		}
+	}
+
+	for _, group := range groups {
+		if values, ok := lookupQueryLimits("graph.query_limits.groups", group); ok {
+			setQueryLimits(&limits, values)
+			break
+		}
+	}
+
+	return limits
+}
+
+// queryErrorStatus returns the HTTP status code corresponding to a query error
+func queryErrorStatus(err error) int {
+	switch err.(type) {
+	case *traversal.QueryLimitError:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
+
 func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
 	resource := types.TopologyParam{}
 
@@ -143,9 +260,11 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 		return
 	}
 
-	res, err := ts.Exec(t.graph, true)
+	// the query is cancelled if the client goes away
+	limits := getQueryLimits(r.Username, shttp.GetUserGroups(&r.Request))
+	res, err := ts.ExecWithLimits(r.Context(), t.graph, true, limits)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
+		writeError(w, queryErrorStatus(err), err)
 		return
 	}
 
@@ -216,3 +335,49 @@ func RegisterTopologyAPI(r *shttp.Server, g *graph.Graph, parser *traversal.Grem
 
 	t.registerEndpoints(r)
 }
+
+// topologyPatch applies the graph messages of the request body, the
+// publisher being identified by its host ID
+func (t *TopologyPublishAPI) topologyPatch(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	host := r.Header.Get("X-Host-ID")
+	if host == "" {
+		host = r.URL.Query().Get("x-host-id")
+	}
+	if host == "" {
+		writeError(w, http.StatusBadRequest, errors.New("No publisher ID specified, use the X-Host-ID header"))
+		return
+	}
+
+	var msgs []*shttp.WSJSONMessage
+	if err := common.JSONDecode(r.Body, &msgs); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := t.publisher.Publish(NewGraphSender(host, r.Header), msgs); err != nil {
+		if err == ErrGraphWriteNotAuthorized {
+			writeError(w, http.StatusForbidden, err)
+		} else {
+			writeError(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RegisterTopologyPublishAPI registers the topology ingestion API
+func RegisterTopologyPublishAPI(r *shttp.Server, publisher TopologyPublisher) {
+	t := &TopologyPublishAPI{
+		publisher: publisher,
+	}
+
+	r.RegisterRoutes([]shttp.Route{
+		{
+			Name:        "TopologiesPatch",
+			Method:      "PATCH",
+			Path:        "/api/topology",
+			HandlerFunc: t.topologyPatch,
+		},
+	})
+}
@@ -0,0 +1,458 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/filters"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+	"github.com/skydive-project/skydive/validator"
+)
+
+// Namespace and types of the messages exchanged on the query WebSocket endpoint
+const (
+	QueryStreamNamespace = "Query"
+	QueryRequestMsgType  = "QueryRequest"
+	QueryCancelMsgType   = "QueryCancel"
+	QueryPageMsgType     = "QueryPage"
+	QueryEndMsgType      = "QueryEnd"
+	QueryAddedMsgType    = "QueryAdded"
+	QueryUpdatedMsgType  = "QueryUpdated"
+	QueryDeletedMsgType  = "QueryDeleted"
+)
+
+const (
+	defaultQueryPageSize = 100
+	queryWriteWait       = 10 * time.Second
+	liveQueryBacklog     = 1000
+)
+
+var (
+	errInvalidCursor     = errors.New("Invalid cursor, From has to be positive and lower or equal to To")
+	errLiveQueryCursor   = errors.New("Live queries do not support cursors")
+	errLiveQueryType     = errors.New("Live queries are only supported on G.V() and G.E() queries filtered by Has steps, optionally followed by SubGraph")
+	errLiveQueryOverflow = errors.New("Live query aborted, the client does not consume the updates fast enough")
+)
+
+// TopologyStreamAPI exposes a WebSocket endpoint streaming the results of
+// Gremlin queries page by page. A query is executed once, its result being
+// sent page by page so that the client does not have to receive it at once.
+type TopologyStreamAPI struct {
+	graph         *graph.Graph
+	gremlinParser *traversal.GremlinTraversalParser
+}
+
+type queryStreamConn struct {
+	sync.Mutex
+	conn     *websocket.Conn
+	username string
+	groups   []string
+	queries  map[string]context.CancelFunc
+}
+
+// liveUpdate is a modification of the result of a live query, serialized
+// when the graph event is received
+type liveUpdate struct {
+	kind string
+	data []byte
+}
+
+// liveQuery maintains the result of a live query from the graph events and
+// queues the modifications to send to the client
+type liveQuery struct {
+	graph.DefaultGraphListener
+	graph      *graph.Graph
+	query      *traversal.IncrementalQuery
+	updates    chan liveUpdate
+	overflow   chan struct{}
+	overflowed bool
+}
+
+func (c *queryStreamConn) send(id string, kind string, status int, data []byte) error {
+	raw := json.RawMessage(data)
+	msg := shttp.WSJSONMessage{
+		Namespace: QueryStreamNamespace,
+		Type:      kind,
+		UUID:      id,
+		Obj:       &raw,
+		Status:    status,
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(queryWriteWait))
+	return c.conn.WriteMessage(websocket.TextMessage, msg.Marshal())
+}
+
+func (c *queryStreamConn) sendEnd(id string, next *filters.Range, err error) error {
+	status, end := http.StatusOK, types.QueryStreamEnd{Next: next}
+	if err != nil {
+		status, end.Error = queryErrorStatus(err), err.Error()
+	}
+
+	data, _ := json.Marshal(end)
+	return c.send(id, QueryEndMsgType, status, data)
+}
+
+func (c *queryStreamConn) removeQuery(id string) {
+	c.Lock()
+	if cancel, ok := c.queries[id]; ok {
+		cancel()
+		delete(c.queries, id)
+	}
+	c.Unlock()
+}
+
+// queue serializes a modification of the result, the graph lock being held.
+// If the client does not keep up, the query is aborted instead of blocking
+// the graph.
+func (l *liveQuery) queue(kind string, update types.QueryStreamUpdate) {
+	if l.overflowed || len(update.Values)+len(update.IDs) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(update)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to serialize live query update: %s", err)
+		return
+	}
+
+	select {
+	case l.updates <- liveUpdate{kind: kind, data: data}:
+	default:
+		l.overflowed = true
+		close(l.overflow)
+	}
+}
+
+func (l *liveQuery) onDelta(delta traversal.IncrementalDelta) {
+	if delta.Empty() {
+		return
+	}
+
+	var added, updated, deleted types.QueryStreamUpdate
+	for _, n := range delta.AddedNodes {
+		added.Values = append(added.Values, n)
+	}
+	for _, e := range delta.AddedEdges {
+		added.Values = append(added.Values, e)
+	}
+	for _, n := range delta.UpdatedNodes {
+		updated.Values = append(updated.Values, n)
+	}
+	for _, e := range delta.UpdatedEdges {
+		updated.Values = append(updated.Values, e)
+	}
+	for _, n := range delta.RemovedNodes {
+		deleted.IDs = append(deleted.IDs, string(n.ID))
+	}
+	for _, e := range delta.RemovedEdges {
+		deleted.IDs = append(deleted.IDs, string(e.ID))
+	}
+
+	l.queue(QueryAddedMsgType, added)
+	l.queue(QueryUpdatedMsgType, updated)
+	l.queue(QueryDeletedMsgType, deleted)
+}
+
+// OnNodeAdded event
+func (l *liveQuery) OnNodeAdded(n *graph.Node) {
+	l.onDelta(l.query.OnNodeAdded(l.graph, n))
+}
+
+// OnNodeUpdated event
+func (l *liveQuery) OnNodeUpdated(n *graph.Node) {
+	l.onDelta(l.query.OnNodeUpdated(l.graph, n))
+}
+
+// OnNodeDeleted event
+func (l *liveQuery) OnNodeDeleted(n *graph.Node) {
+	l.onDelta(l.query.OnNodeDeleted(l.graph, n))
+}
+
+// OnEdgeAdded event
+func (l *liveQuery) OnEdgeAdded(e *graph.Edge) {
+	l.onDelta(l.query.OnEdgeAdded(l.graph, e))
+}
+
+// OnEdgeUpdated event
+func (l *liveQuery) OnEdgeUpdated(e *graph.Edge) {
+	l.onDelta(l.query.OnEdgeUpdated(l.graph, e))
+}
+
+// OnEdgeDeleted event
+func (l *liveQuery) OnEdgeDeleted(e *graph.Edge) {
+	l.onDelta(l.query.OnEdgeDeleted(l.graph, e))
+}
+
+// sendPages sends the values page by page, each page being serialized while
+// holding the graph lock as nodes and edges may be modified concurrently
+func (t *TopologyStreamAPI) sendPages(ctx context.Context, c *queryStreamConn, id string, values []interface{}, offset int64, pageSize int64) error {
+	for from := int64(0); from < int64(len(values)); from += pageSize {
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+
+		to := from + pageSize
+		if to > int64(len(values)) {
+			to = int64(len(values))
+		}
+
+		t.graph.RLock()
+		data, err := json.Marshal(types.QueryStreamPage{Values: values[from:to], Range: filters.Range{From: offset + from, To: offset + to}})
+		t.graph.RUnlock()
+
+		if err != nil {
+			return err
+		}
+
+		if err := c.send(id, QueryPageMsgType, http.StatusOK, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// streamPages executes the query once and sends its result. With a cursor,
+// only the requested range of the result is computed, one more value being
+// requested to know whether a next page is available.
+func (t *TopologyStreamAPI) streamPages(ctx context.Context, c *queryStreamConn, id string, ts *traversal.GremlinTraversalSequence, req *types.QueryStreamRequest, pageSize int64, limits traversal.QueryLimits) (*filters.Range, error) {
+	if req.Cursor == nil {
+		res, err := ts.ExecWithLimits(ctx, t.graph, true, limits)
+		if err != nil {
+			return nil, err
+		}
+		return nil, t.sendPages(ctx, c, id, res.Values(), 0, pageSize)
+	}
+
+	cursor := *req.Cursor
+
+	// the value used to detect the next page is not part of the results
+	if limits.MaxResults > 0 {
+		limits.MaxResults++
+	}
+
+	res, err := ts.ExecPage(ctx, t.graph, true, limits, filters.Range{From: cursor.From, To: cursor.To + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	var next *filters.Range
+	values := res.Values()
+	if count := cursor.To - cursor.From; int64(len(values)) > count {
+		values = values[:count]
+		next = &filters.Range{From: cursor.To, To: cursor.To + pageSize}
+	}
+
+	return next, t.sendPages(ctx, c, id, values, cursor.From, pageSize)
+}
+
+// streamLive sends the result of a live query and then its modifications.
+// The result is computed and the graph listener registered at once so that
+// no modification is missed or reported twice.
+func (t *TopologyStreamAPI) streamLive(ctx context.Context, c *queryStreamConn, id string, ts *traversal.GremlinTraversalSequence, pageSize int64, limits traversal.QueryLimits) error {
+	q := traversal.NewIncrementalQuery(ts)
+	if q == nil {
+		return errLiveQueryType
+	}
+
+	l := &liveQuery{
+		graph:    t.graph,
+		query:    q,
+		updates:  make(chan liveUpdate, liveQueryBacklog),
+		overflow: make(chan struct{}),
+	}
+
+	t.graph.Lock()
+	q.Reset(t.graph)
+	values := q.Values()
+	t.graph.AddEventListener(l)
+	t.graph.Unlock()
+
+	defer func() {
+		t.graph.Lock()
+		t.graph.RemoveEventListener(l)
+		t.graph.Unlock()
+	}()
+
+	if limits.MaxResults > 0 && int64(len(values)) > limits.MaxResults {
+		return &traversal.QueryLimitError{Limit: "results", Value: limits.MaxResults}
+	}
+
+	if err := t.sendPages(ctx, c, id, values, 0, pageSize); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-l.overflow:
+			return errLiveQueryOverflow
+		case update := <-l.updates:
+			if err := c.send(id, update.kind, http.StatusOK, update.data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (t *TopologyStreamAPI) runQuery(ctx context.Context, c *queryStreamConn, id string, req *types.QueryStreamRequest) (*filters.Range, error) {
+	if cursor := req.Cursor; cursor != nil && (cursor.From < 0 || cursor.From > cursor.To) {
+		return nil, errInvalidCursor
+	}
+
+	if req.Live && req.Cursor != nil {
+		return nil, errLiveQueryCursor
+	}
+
+	ts, err := t.gremlinParser.Parse(strings.NewReader(req.GremlinQuery))
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultQueryPageSize
+	}
+
+	limits := getQueryLimits(c.username, c.groups)
+
+	if req.Live {
+		return nil, t.streamLive(ctx, c, id, ts, pageSize, limits)
+	}
+
+	return t.streamPages(ctx, c, id, ts, req, pageSize, limits)
+}
+
+func (t *TopologyStreamAPI) startQuery(ctx context.Context, wg *sync.WaitGroup, c *queryStreamConn, msg *shttp.WSJSONMessage) {
+	var req types.QueryStreamRequest
+	if msg.Obj == nil {
+		c.sendEnd(msg.UUID, nil, errors.New("Missing query request"))
+		return
+	}
+	if err := json.Unmarshal(*msg.Obj, &req); err != nil {
+		c.sendEnd(msg.UUID, nil, err)
+		return
+	}
+	if err := validator.Validate(req); err != nil {
+		c.sendEnd(msg.UUID, nil, err)
+		return
+	}
+
+	c.Lock()
+	if _, ok := c.queries[msg.UUID]; ok {
+		c.Unlock()
+		c.sendEnd(msg.UUID, nil, fmt.Errorf("A query with the identifier %s is already running", msg.UUID))
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.queries[msg.UUID] = cancel
+	c.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		next, err := t.runQuery(ctx, c, msg.UUID, &req)
+
+		// the client may reuse the identifier once the end is received
+		c.removeQuery(msg.UUID)
+		c.sendEnd(msg.UUID, next, err)
+	}()
+}
+
+func (t *TopologyStreamAPI) serveQueries(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	conn, err := websocket.Upgrade(w, &r.Request, nil, 1024, 1024)
+	if err != nil {
+		return
+	}
+
+	c := &queryStreamConn{
+		conn:     conn,
+		username: r.Username,
+		groups:   shttp.GetUserGroups(&r.Request),
+		queries:  make(map[string]context.CancelFunc),
+	}
+
+	// all the queries are cancelled once the client goes away
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		wg.Wait()
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg shttp.WSJSONMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			logging.GetLogger().Errorf("Unable to decode query message from %s: %s", r.RemoteAddr, err)
+			continue
+		}
+
+		if msg.Namespace != QueryStreamNamespace {
+			continue
+		}
+
+		switch msg.Type {
+		case QueryRequestMsgType:
+			t.startQuery(ctx, &wg, c, &msg)
+		case QueryCancelMsgType:
+			c.removeQuery(msg.UUID)
+		}
+	}
+}
+
+// RegisterTopologyStreamAPI registers the WebSocket endpoint used to stream
+// the results of Gremlin queries
+func RegisterTopologyStreamAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser) {
+	t := &TopologyStreamAPI{
+		gremlinParser: parser,
+		graph:         g,
+	}
+
+	r.HandleFunc("/ws/query", t.serveQueries)
+}
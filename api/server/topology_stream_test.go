@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/websocket"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/filters"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+func newQueryStreamClient(t *testing.T, g *graph.Graph) (*websocket.Conn, func()) {
+	api := &TopologyStreamAPI{
+		graph:         g,
+		gremlinParser: traversal.NewGremlinTraversalParser(),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.serveQueries(w, &auth.AuthenticatedRequest{Request: *r})
+	}))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func sendQueryMessage(t *testing.T, conn *websocket.Conn, kind string, v interface{}) {
+	msg := shttp.NewWSJSONMessage(QueryStreamNamespace, kind, v, "query")
+	if err := conn.WriteMessage(websocket.TextMessage, msg.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readQueryMessage(t *testing.T, conn *websocket.Conn, v interface{}) *shttp.WSJSONMessage {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var msg shttp.WSJSONMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(*msg.Obj, v); err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+func pageIDs(t *testing.T, page *types.QueryStreamPage) []string {
+	var ids []string
+	for _, value := range page.Values {
+		node, ok := value.(map[string]interface{})
+		if !ok {
+			t.Fatalf("Unexpected page value: %+v", value)
+		}
+		ids = append(ids, node["ID"].(string))
+	}
+	return ids
+}
+
+func TestTopologyStreamPages(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	var ids []string
+	for i := 0; i != 5; i++ {
+		n := g.NewNode(graph.GenID(), graph.Metadata{"Type": "intf"})
+		ids = append(ids, string(n.ID))
+	}
+	sort.Strings(ids)
+
+	conn, stop := newQueryStreamClient(t, g)
+	defer stop()
+
+	sendQueryMessage(t, conn, QueryRequestMsgType, types.QueryStreamRequest{GremlinQuery: "G.V()", PageSize: 2})
+
+	var received []string
+	for _, expected := range []int{2, 2, 1} {
+		var page types.QueryStreamPage
+		if msg := readQueryMessage(t, conn, &page); msg.Type != QueryPageMsgType {
+			t.Fatalf("Expected a page, got: %+v", msg)
+		}
+		if len(page.Values) != expected || page.Range.From != int64(len(received)) {
+			t.Fatalf("Unexpected page: %+v", page)
+		}
+		received = append(received, pageIDs(t, &page)...)
+	}
+
+	// the pages are disjoint and cover the whole result
+	if !reflect.DeepEqual(received, ids) {
+		t.Fatalf("Expected nodes %v, got %v", ids, received)
+	}
+
+	var end types.QueryStreamEnd
+	if msg := readQueryMessage(t, conn, &end); msg.Type != QueryEndMsgType || end.Next != nil || end.Error != "" {
+		t.Fatalf("Unexpected end of query: %+v, %+v", msg, end)
+	}
+
+	// a cursor returns the cursor of the next page
+	sendQueryMessage(t, conn, QueryRequestMsgType, types.QueryStreamRequest{GremlinQuery: "G.V()", PageSize: 2, Cursor: &filters.Range{From: 1, To: 3}})
+
+	var page types.QueryStreamPage
+	if readQueryMessage(t, conn, &page); !reflect.DeepEqual(pageIDs(t, &page), ids[1:3]) {
+		t.Fatalf("Unexpected page: %+v", page)
+	}
+
+	end = types.QueryStreamEnd{}
+	if readQueryMessage(t, conn, &end); end.Next == nil || end.Next.From != 3 || end.Next.To != 5 {
+		t.Fatalf("Unexpected end of query: %+v", end)
+	}
+
+	// the last cursor reaches the end of the result
+	sendQueryMessage(t, conn, QueryRequestMsgType, types.QueryStreamRequest{GremlinQuery: "G.V()", Cursor: end.Next})
+
+	page = types.QueryStreamPage{}
+	if readQueryMessage(t, conn, &page); !reflect.DeepEqual(pageIDs(t, &page), ids[3:5]) {
+		t.Fatalf("Unexpected page: %+v", page)
+	}
+
+	end = types.QueryStreamEnd{}
+	if readQueryMessage(t, conn, &end); end.Next != nil {
+		t.Fatalf("Unexpected end of query: %+v", end)
+	}
+
+	// a reversed cursor is rejected
+	sendQueryMessage(t, conn, QueryRequestMsgType, types.QueryStreamRequest{GremlinQuery: "G.V()", Cursor: &filters.Range{From: 3, To: 1}})
+
+	end = types.QueryStreamEnd{}
+	if msg := readQueryMessage(t, conn, &end); msg.Status != http.StatusBadRequest || end.Error == "" {
+		t.Fatalf("Unexpected end of query: %+v, %+v", msg, end)
+	}
+}
+
+func TestTopologyStreamLive(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "intf"})
+
+	conn, stop := newQueryStreamClient(t, g)
+	defer stop()
+
+	sendQueryMessage(t, conn, QueryRequestMsgType, types.QueryStreamRequest{GremlinQuery: "G.V().Has('Type', 'intf')", Live: true})
+
+	var page types.QueryStreamPage
+	if readQueryMessage(t, conn, &page); len(page.Values) != 1 {
+		t.Fatalf("Unexpected page: %+v", page)
+	}
+
+	g.Lock()
+	n := g.NewNode(graph.GenID(), graph.Metadata{"Type": "intf"})
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "host"})
+	g.Unlock()
+
+	var update types.QueryStreamUpdate
+	if msg := readQueryMessage(t, conn, &update); msg.Type != QueryAddedMsgType || len(update.Values) != 1 {
+		t.Fatalf("Unexpected update: %+v, %+v", msg, update)
+	}
+
+	g.Lock()
+	g.AddMetadata(n, "Name", "eth0")
+	g.Unlock()
+
+	update = types.QueryStreamUpdate{}
+	if msg := readQueryMessage(t, conn, &update); msg.Type != QueryUpdatedMsgType || len(update.Values) != 1 {
+		t.Fatalf("Unexpected update: %+v, %+v", msg, update)
+	}
+
+	g.Lock()
+	g.DelNode(n)
+	g.Unlock()
+
+	update = types.QueryStreamUpdate{}
+	if msg := readQueryMessage(t, conn, &update); msg.Type != QueryDeletedMsgType || len(update.IDs) != 1 || update.IDs[0] != string(n.ID) {
+		t.Fatalf("Unexpected update: %+v, %+v", msg, update)
+	}
+
+	sendQueryMessage(t, conn, QueryCancelMsgType, nil)
+
+	var end types.QueryStreamEnd
+	if msg := readQueryMessage(t, conn, &end); msg.Type != QueryEndMsgType || end.Error != "" {
+		t.Fatalf("Unexpected end of query: %+v, %+v", msg, end)
+	}
+
+	// only the queries evaluated incrementally can be live
+	sendQueryMessage(t, conn, QueryRequestMsgType, types.QueryStreamRequest{GremlinQuery: "G.V().Out()", Live: true})
+
+	end = types.QueryStreamEnd{}
+	if msg := readQueryMessage(t, conn, &end); msg.Type != QueryEndMsgType || !strings.HasPrefix(end.Error, "Live queries are only supported") {
+		t.Fatalf("Unexpected end of query: %+v, %+v", msg, end)
+	}
+}
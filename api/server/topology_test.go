@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// fakeTopologyPublisher records the published messages, the publication
+// itself being tested with the publisher endpoint of the analyzer
+type fakeTopologyPublisher struct {
+	sender GraphSender
+	msgs   []*shttp.WSJSONMessage
+	err    error
+}
+
+func (f *fakeTopologyPublisher) Publish(sender GraphSender, msgs []*shttp.WSJSONMessage) error {
+	f.sender, f.msgs = sender, msgs
+	return f.err
+}
+
+func patchTopology(api *TopologyPublishAPI, host string, nodes ...*graph.Node) int {
+	var msgs []string
+	for _, n := range nodes {
+		msgs = append(msgs, string(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeAddedMsgType, n).Marshal()))
+	}
+
+	r := httptest.NewRequest("PATCH", "/api/topology", strings.NewReader("["+strings.Join(msgs, ",")+"]"))
+	if host != "" {
+		r.Header.Set("X-Host-ID", host)
+	}
+
+	w := httptest.NewRecorder()
+	api.topologyPatch(w, &auth.AuthenticatedRequest{Request: *r})
+	return w.Code
+}
+
+func TestTopologyPatch(t *testing.T) {
+	publisher := &fakeTopologyPublisher{}
+	api := &TopologyPublishAPI{publisher: publisher}
+
+	now := time.Now().UTC()
+	n1 := graph.CreateNode("n1", graph.Metadata{"Name": "switch1"}, now, "cmdb")
+	n2 := graph.CreateNode("n2", graph.Metadata{"Name": "switch2"}, now, "cmdb")
+
+	if code := patchTopology(api, "", n1); code != http.StatusBadRequest {
+		t.Errorf("Patch without publisher ID should be rejected, got %d", code)
+	}
+
+	if code := patchTopology(api, "cmdb", n1, n2); code != http.StatusOK {
+		t.Fatalf("Patch should be accepted, got %d", code)
+	}
+
+	if publisher.sender.Host != "cmdb" || len(publisher.msgs) != 2 {
+		t.Fatalf("Messages should be published by cmdb, got %+v %v", publisher.sender, publisher.msgs)
+	}
+
+	if _, obj, err := graph.UnmarshalWSMessage(publisher.msgs[1]); err != nil || obj.(*graph.Node).ID != "n2" {
+		t.Errorf("Wrong message published: %v", publisher.msgs[1])
+	}
+
+	publisher.err = ErrGraphWriteNotAuthorized
+	if code := patchTopology(api, "controller", n1); code != http.StatusForbidden {
+		t.Errorf("Patch not authorized should be forbidden, got %d", code)
+	}
+}
+
+func TestNewGraphSender(t *testing.T) {
+	headers := http.Header{}
+	if sender := NewGraphSender("host1", headers); sender != (GraphSender{Host: "host1"}) {
+		t.Errorf("Sender not authenticated expected, got %+v", sender)
+	}
+
+	headers.Set(shttp.AuthenticatedUserHeader, "admin")
+	if sender := NewGraphSender("host1", headers); sender != (GraphSender{Host: "host1", User: "admin"}) {
+		t.Errorf("Sender authenticated by its user name expected, got %+v", sender)
+	}
+
+	headers.Set(shttp.ClientCertificateHostHeader, "host2")
+	headers.Set(shttp.AuthenticatedUserHeader, "host2")
+	if sender := NewGraphSender("host1", headers); sender != (GraphSender{Host: "host2", User: "host2", Certified: true}) {
+		t.Errorf("Sender authenticated by its certificate expected, got %+v", sender)
+	}
+}
+
+func TestGetQueryLimits(t *testing.T) {
+	cfg := config.GetConfig()
+	cfg.Set("graph.query_limits.users", map[string]interface{}{
+		"john.doe": map[interface{}]interface{}{"max_results": 10},
+	})
+	cfg.Set("graph.query_limits.groups", map[string]interface{}{
+		"ops.team": map[string]interface{}{"timeout": 5},
+	})
+	defer cfg.Set("graph.query_limits.users", nil)
+	defer cfg.Set("graph.query_limits.groups", nil)
+
+	limits := getQueryLimits("john.doe", []string{"ops.team"})
+	if limits.MaxResults != 10 || limits.Timeout != 60*time.Second {
+		t.Errorf("Expected the limits of the user, got: %+v", limits)
+	}
+
+	limits = getQueryLimits("jane", []string{"admins", "ops.team"})
+	if limits.MaxResults != 100000 || limits.Timeout != 5*time.Second {
+		t.Errorf("Expected the limits of the group, got: %+v", limits)
+	}
+
+	if limits = getQueryLimits("john", nil); limits.MaxResults != 100000 {
+		t.Errorf("Expected the default limits, got: %+v", limits)
+	}
+}
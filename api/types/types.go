@@ -23,9 +23,11 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/nu7hatch/gouuid"
+	"github.com/skydive-project/skydive/filters"
 	shttp "github.com/skydive-project/skydive/http"
 )
 
@@ -35,6 +37,13 @@ type Resource interface {
 	SetID(string)
 }
 
+// SensitiveResource is implemented by the resources whose identifier is a
+// secret, like the enrollment tokens, so that it is not disclosed
+type SensitiveResource interface {
+	Resource
+	Sensitive()
+}
+
 // Alert is a set of parameters, the Alert Action will Trigger according to its Expression.
 type Alert struct {
 	Resource
@@ -67,6 +76,30 @@ func NewAlert() *Alert {
 	}
 }
 
+// AuditEntry describes an operation modifying the state of the analyzer
+// through the API, as recorded in the audit log
+type AuditEntry struct {
+	UUID       string
+	Time       time.Time
+	User       string          `json:"User,omitempty"`
+	SourceIP   string          `json:"SourceIP,omitempty"`
+	Operation  string          // create, delete
+	Resource   string          // capture, alert, usermetadata, injectpacket, pcap
+	ResourceID string          `json:"ResourceID,omitempty"`
+	Payload    json.RawMessage `json:"Payload,omitempty"`
+	Error      string          `json:"Error,omitempty"`
+}
+
+// NewAuditEntry creates a new audit entry, only UUID and Time are set.
+func NewAuditEntry() *AuditEntry {
+	id, _ := uuid.NewV4()
+
+	return &AuditEntry{
+		UUID: id.String(),
+		Time: time.Now().UTC(),
+	}
+}
+
 // AnalyzerStatus describes the status of an analyzer
 type AnalyzerStatus struct {
 	Agents      map[string]shttp.WSConnStatus
@@ -75,6 +108,8 @@ type AnalyzerStatus struct {
 	Subscribers map[string]shttp.WSConnStatus
 	Alerts      ElectionStatus
 	Captures    ElectionStatus
+	// number of graph messages rejected per agent or publisher host
+	RejectedGraphWrites map[string]int64 `json:",omitempty"`
 }
 
 // Capture describes a capture API
@@ -115,9 +150,14 @@ func NewCapture(query string, bpfFilter string) *Capture {
 	}
 }
 
-// ElectionStatus describes the status of an election
+// ElectionStatus describes the status of an election. Master is the host of
+// the analyzer currently owning the service and State its runtime state:
+// the time since when the alerts are firing for the alerts, the capture
+// registered on each node for the captures.
 type ElectionStatus struct {
 	IsMaster bool
+	Master   string            `json:"Master,omitempty"`
+	State    map[string]string `json:"State,omitempty"`
 }
 
 // PacketParamsReq packet injector API parameters
@@ -148,6 +188,40 @@ type TopologyParam struct {
 	GremlinQuery string `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
 }
 
+// QueryStreamRequest describes a Gremlin query whose results are streamed
+// page by page over the query WebSocket endpoint. The optional cursor limits
+// the range of results returned, ordered by identifier, all of them are
+// returned otherwise. A live query stays open once all the pages have been
+// sent and reports the elements matching the query as they appear, change
+// or disappear.
+type QueryStreamRequest struct {
+	GremlinQuery string         `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
+	PageSize     int64          `json:"PageSize,omitempty"`
+	Cursor       *filters.Range `json:"Cursor,omitempty"`
+	Live         bool           `json:"Live,omitempty"`
+}
+
+// QueryStreamPage holds a page of results of a streamed query
+type QueryStreamPage struct {
+	Values []interface{}
+	Range  filters.Range
+}
+
+// QueryStreamEnd is sent once all the pages of a streamed query have been
+// sent. Next is the cursor of the following page when a cursor was given
+// and more results are available.
+type QueryStreamEnd struct {
+	Next  *filters.Range `json:",omitempty"`
+	Error string         `json:",omitempty"`
+}
+
+// QueryStreamUpdate holds the elements added to, updated in or deleted from
+// the result of a live query
+type QueryStreamUpdate struct {
+	Values []interface{} `json:",omitempty"`
+	IDs    []string      `json:",omitempty"`
+}
+
 // UserMetadata describes a user metadata
 type UserMetadata struct {
 	UUID         string
@@ -177,3 +251,61 @@ func NewUserMetadata(query string, key string, value string) *UserMetadata {
 		Value:        value,
 	}
 }
+
+// EnrollmentToken describes a one-time token allowing an agent to request a
+// client certificate. When Host is set, only this host can use the token.
+// The token is deleted once expired.
+type EnrollmentToken struct {
+	UUID        string
+	Host        string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	CreateTime  time.Time
+	ExpireTime  time.Time
+}
+
+// ID returns the enrollment token
+func (e *EnrollmentToken) ID() string {
+	return e.UUID
+}
+
+// SetID set the enrollment token
+func (e *EnrollmentToken) SetID(id string) {
+	e.UUID = id
+}
+
+// Sensitive marks the enrollment token as a secret
+func (e *EnrollmentToken) Sensitive() {
+}
+
+// AgentCertificate describes a client certificate delivered to an agent, the
+// certificate is revoked when deleted
+type AgentCertificate struct {
+	UUID       string // serial number of the certificate
+	Host       string
+	CreateTime time.Time
+	NotAfter   time.Time
+}
+
+// ID returns the serial number of the certificate
+func (c *AgentCertificate) ID() string {
+	return c.UUID
+}
+
+// SetID set the serial number of the certificate
+func (c *AgentCertificate) SetID(id string) {
+	c.UUID = id
+}
+
+// EnrollmentRequest is sent by an agent to get a client certificate
+type EnrollmentRequest struct {
+	Token string
+	Host  string
+	CSR   string
+}
+
+// EnrollmentReply holds the client certificate delivered to an agent along
+// with the certificate of the authority, both PEM encoded
+type EnrollmentReply struct {
+	Certificate string
+	CA          string
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditUser     string
+	auditResource string
+	auditSince    string
+	auditUntil    string
+	auditLimit    int
+)
+
+// AuditCmd skydive audit root command
+var AuditCmd = &cobra.Command{
+	Use:          "audit",
+	Short:        "Query the audit log",
+	Long:         "Query the audit log",
+	SilenceUsage: false,
+}
+
+// parseAuditTime accepts either a RFC3339 time or a duration relative to now
+func parseAuditTime(s string) (string, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d).UTC().Format(time.RFC3339), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(time.RFC3339), nil
+}
+
+// AuditList skydive audit list command
+var AuditList = &cobra.Command{
+	Use:   "list",
+	Short: "List audit entries",
+	Long:  "List audit entries",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+
+		query := url.Values{}
+		if auditUser != "" {
+			query.Set("user", auditUser)
+		}
+		if auditResource != "" {
+			query.Set("resource", auditResource)
+		}
+		if auditSince != "" {
+			since, err := parseAuditTime(auditSince)
+			if err != nil {
+				logging.GetLogger().Errorf("Invalid since value: %s", err)
+				os.Exit(1)
+			}
+			query.Set("since", since)
+		}
+		if auditUntil != "" {
+			until, err := parseAuditTime(auditUntil)
+			if err != nil {
+				logging.GetLogger().Errorf("Invalid until value: %s", err)
+				os.Exit(1)
+			}
+			query.Set("until", until)
+		}
+		if auditLimit > 0 {
+			query.Set("limit", strconv.Itoa(auditLimit))
+		}
+
+		path := "audit"
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+
+		resp, err := client.Request("GET", path, nil, nil)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			data, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to get audit entries, %s: %s", resp.Status, data)
+			os.Exit(1)
+		}
+
+		var entries []types.AuditEntry
+		if err := common.JSONDecode(resp.Body, &entries); err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+
+		printJSON(entries)
+	},
+}
+
+func init() {
+	AuditCmd.AddCommand(AuditList)
+
+	AuditList.Flags().StringVarP(&auditUser, "user", "", "", "only show operations of this user")
+	AuditList.Flags().StringVarP(&auditResource, "resource", "", "", "only show operations on this resource type (capture, alert, usermetadata, injectpacket, pcap)")
+	AuditList.Flags().StringVarP(&auditSince, "since", "", "", "only show operations since this time, RFC3339 or duration (ex: 1h)")
+	AuditList.Flags().StringVarP(&auditUntil, "until", "", "", "only show operations until this time, RFC3339 or duration (ex: 10m)")
+	AuditList.Flags().IntVarP(&auditLimit, "limit", "", 0, "maximum number of entries, the most recent ones are kept")
+}
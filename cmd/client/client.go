@@ -49,6 +49,7 @@ var ClientCmd = &cobra.Command{
 
 func RegisterClientCommands(cmd *cobra.Command) {
 	cmd.AddCommand(AlertCmd)
+	cmd.AddCommand(AuditCmd)
 	cmd.AddCommand(CaptureCmd)
 	cmd.AddCommand(PacketInjectorCmd)
 	cmd.AddCommand(PcapCmd)
@@ -56,6 +56,7 @@ func initCaptureTypes() {
 	CaptureTypes["ovsbridge"] = CaptureType{Allowed: []string{"ovssflow", "pcapsocket"}, Default: "ovssflow"}
 	CaptureTypes["ovsport"] = CaptureType{Allowed: []string{"ovsmirror"}, Default: "ovsmirror"}
 	CaptureTypes["dpdkport"] = CaptureType{Allowed: []string{"dpdk"}, Default: "dpdk"}
+	CaptureTypes["netns"] = CaptureType{Allowed: []string{"conntrack"}, Default: "conntrack"}
 
 	// anything else will be handled by gopacket
 	types := []string{
@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// HashRing implements a consistent hashing ring. Each member is placed
+// several times on the ring so that the keys are evenly spread and that
+// only the keys of a leaving member get moved.
+type HashRing struct {
+	sync.RWMutex
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]string
+	members  []string
+}
+
+func ringHash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// SetMembers replaces the members of the ring
+func (r *HashRing) SetMembers(members []string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.members = make([]string, len(members))
+	copy(r.members, members)
+	sort.Strings(r.members)
+
+	r.hashes = make([]uint32, 0, len(members)*r.replicas)
+	r.owners = make(map[uint32]string)
+	for _, member := range r.members {
+		for i := 0; i < r.replicas; i++ {
+			h := ringHash(strconv.Itoa(i) + member)
+			if _, found := r.owners[h]; found {
+				continue
+			}
+			r.owners[h] = member
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Members returns the sorted list of the members of the ring
+func (r *HashRing) Members() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.members
+}
+
+// Len returns the number of members of the ring
+func (r *HashRing) Len() int {
+	r.RLock()
+	defer r.RUnlock()
+
+	return len(r.members)
+}
+
+// Get returns the member owning the given key, an empty string if the ring
+// is empty
+func (r *HashRing) Get(key string) string {
+	if members := r.GetN(key, 1); len(members) > 0 {
+		return members[0]
+	}
+	return ""
+}
+
+// GetN returns up to n distinct members for the given key, the owner first
+// followed by the next members on the ring to be used as fallbacks.
+func (r *HashRing) GetN(key string, n int) []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.hashes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(r.members) {
+		n = len(r.members)
+	}
+
+	h := ringHash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+
+	members := make([]string, 0, n)
+	visited := make(map[string]bool)
+	for j := 0; j < len(r.hashes) && len(members) < n; j++ {
+		member := r.owners[r.hashes[(i+j)%len(r.hashes)]]
+		if !visited[member] {
+			visited[member] = true
+			members = append(members, member)
+		}
+	}
+
+	return members
+}
+
+// NewHashRing returns a new empty ring placing each member replicas times
+func NewHashRing(replicas int) *HashRing {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &HashRing{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	r := NewHashRing(64)
+	if owner := r.Get("key"); owner != "" {
+		t.Fatalf("empty ring should not own any key, got: %s", owner)
+	}
+
+	r.SetMembers([]string{"analyzer1", "analyzer2", "analyzer3"})
+
+	owners := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("flow-%d", i)
+		owners[key] = r.Get(key)
+		count[owners[key]]++
+	}
+
+	for _, member := range r.Members() {
+		if count[member] < 500 {
+			t.Errorf("keys not evenly spread: %v", count)
+		}
+	}
+
+	if members := r.GetN("flow-1", 5); len(members) != 3 || members[0] != owners["flow-1"] {
+		t.Errorf("expected the owner followed by the 2 other members, got: %v", members)
+	}
+
+	// only the keys of the leaving member have to move
+	r.SetMembers([]string{"analyzer1", "analyzer3"})
+	for key, owner := range owners {
+		if newOwner := r.Get(key); owner != "analyzer2" && newOwner != owner {
+			t.Errorf("key %s moved from %s to %s", key, owner, newOwner)
+		} else if newOwner == "analyzer2" {
+			t.Errorf("key %s still owned by the removed member", key)
+		}
+	}
+}
@@ -23,12 +23,28 @@
 package common
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"time"
 )
 
+// CertificateAuthority describes a certificate authority signing the client
+// certificates of the agents
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	key         crypto.Signer
+}
+
 // SetupTLSLoadCertificate creates a X509 certificate from file
 func SetupTLSLoadCertificate(certPEM string) *x509.CertPool {
 	rootPEM, err := ioutil.ReadFile(certPEM)
@@ -72,3 +88,191 @@ func SetupTLSServerConfig(certPEM string, keyPEM string) *tls.Config {
 
 	return cfgTLS
 }
+
+// SetupTLSLoadCertificates creates a X509 certificate pool from the given
+// files, the empty file names being skipped
+func SetupTLSLoadCertificates(certPEMs ...string) *x509.CertPool {
+	roots := x509.NewCertPool()
+	for _, certPEM := range certPEMs {
+		if certPEM == "" {
+			continue
+		}
+
+		rootPEM, err := ioutil.ReadFile(certPEM)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to open root certificate '%s' : %s", certPEM, err.Error()))
+		}
+		if !roots.AppendCertsFromPEM(rootPEM) {
+			panic(fmt.Sprintf("Failed to parse root certificate '%s'", rootPEM))
+		}
+	}
+	return roots
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodePrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// NewCertificateRequest generates a private key and a certificate request for
+// the given common name, both PEM encoded
+func NewCertificateRequest(commonName string) (csrPEM []byte, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if keyPEM, err = encodePrivateKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), keyPEM, nil
+}
+
+// PEM returns the PEM encoded certificate of the authority
+func (ca *CertificateAuthority) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate.Raw})
+}
+
+// Signed returns whether the certificate was signed by the authority
+func (ca *CertificateAuthority) Signed(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(ca.Certificate) == nil
+}
+
+// ParseCertificateRequest decodes a PEM encoded certificate request and
+// checks its signature
+func ParseCertificateRequest(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("No PEM encoded certificate request found")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+
+	return csr, nil
+}
+
+// SignCertificateRequest signs a PEM encoded certificate request. The common
+// name of the client certificate returned is the given one, whatever the
+// request contains.
+func (ca *CertificateAuthority) SignCertificateRequest(csrPEM []byte, commonName string, validity time.Duration) (*x509.Certificate, []byte, error) {
+	csr, err := ParseCertificateRequest(csrPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ca.SignCertificate(csr, commonName, validity)
+}
+
+// SignCertificate signs a certificate request already parsed
+func (ca *CertificateAuthority) SignCertificate(csr *x509.CertificateRequest, commonName string, validity time.Duration) (*x509.Certificate, []byte, error) {
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// NewCertificateAuthority generates a self-signed certificate authority
+func NewCertificateAuthority(commonName string, validity time.Duration) (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateAuthority{Certificate: cert, key: key}, nil
+}
+
+// LoadCertificateAuthority loads a certificate authority from the PEM encoded
+// certificate and private key files
+func LoadCertificateAuthority(certPEM string, keyPEM string) (*CertificateAuthority, error) {
+	pair, err := tls.LoadX509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read certificate authority '%s' '%s': %s", certPEM, keyPEM, err.Error())
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !cert.IsCA {
+		return nil, fmt.Errorf("Certificate '%s' is not a certificate authority", certPEM)
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported private key '%s'", keyPEM)
+	}
+
+	return &CertificateAuthority{Certificate: cert, key: key}, nil
+}
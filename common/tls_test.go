@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestCertificateAuthority(t *testing.T) {
+	ca, err := NewCertificateAuthority("skydive-ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, key, err := NewCertificateRequest("spoofed-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(key); block == nil {
+		t.Fatal("private key should be PEM encoded")
+	}
+
+	cert, certPEM, err := ca.SignCertificateRequest(csr, "host1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Subject.CommonName != "host1" {
+		t.Errorf("common name should be enforced by the authority, got: %s", cert.Subject.CommonName)
+	}
+
+	if !ca.Signed(cert) {
+		t.Error("certificate should be signed by the authority")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.PEM())
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate should be verified: %s", err)
+	}
+
+	if block, _ := pem.Decode(certPEM); block == nil || block.Type != "CERTIFICATE" {
+		t.Error("certificate should be PEM encoded")
+	}
+
+	other, err := NewCertificateAuthority("other-ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Signed(cert) {
+		t.Error("certificate should not be signed by another authority")
+	}
+
+	if _, _, err := ca.SignCertificateRequest([]byte("garbage"), "host1", time.Hour); err == nil {
+		t.Error("invalid certificate request should be rejected")
+	}
+}
@@ -51,17 +51,27 @@ func init() {
 	}
 
 	cfg = viper.New()
+	cfg.SetDefault("agent.flow.buffer_size", 10000)
 	cfg.SetDefault("agent.flow.probes", []string{"gopacket", "pcapsocket"})
 	cfg.SetDefault("agent.flow.pcapsocket.bind_address", "127.0.0.1")
 	cfg.SetDefault("agent.flow.pcapsocket.min_port", 8100)
 	cfg.SetDefault("agent.flow.pcapsocket.max_port", 8132)
 	cfg.SetDefault("agent.flow.stats_update", 1)
+	cfg.SetDefault("agent.enrollment.token", "")
+	cfg.SetDefault("agent.flow.conntrack.accounting", false)
+	cfg.SetDefault("agent.flow.conntrack.dump_interval", 30)
 	cfg.SetDefault("agent.listen", "127.0.0.1:8081")
+	cfg.SetDefault("agent.topology.journal_size", 10000)
 	cfg.SetDefault("agent.topology.probes", []string{"ovsdb"})
+	cfg.SetDefault("agent.topology.lldp.interfaces", []string{})
 	cfg.SetDefault("agent.topology.netlink.metrics_update", 30)
+	cfg.SetDefault("agent.topology.netfilter.tables", []string{"filter", "nat", "mangle"})
+	cfg.SetDefault("agent.topology.netfilter.update", 60)
 	cfg.SetDefault("agent.X509_servername", "")
 	cfg.SetDefault("agent.http.debug", false)
 
+	cfg.SetDefault("analyzer.audit.backends", []string{"etcd"})
+	cfg.SetDefault("analyzer.audit.file", "/var/log/skydive/audit.log")
 	cfg.SetDefault("analyzer.bandwidth_absolute_active", 1)
 	cfg.SetDefault("analyzer.bandwidth_absolute_alert", 1000)
 	cfg.SetDefault("analyzer.bandwidth_absolute_warning", 100)
@@ -71,16 +81,30 @@ func init() {
 	cfg.SetDefault("analyzer.bandwidth_source", "netlink")
 	cfg.SetDefault("analyzer.bandwidth_threshold", "relative")
 	cfg.SetDefault("analyzer.bandwidth_update_rate", 5)
+	cfg.SetDefault("analyzer.enrollment.ca_cert", "")
+	cfg.SetDefault("analyzer.enrollment.ca_key", "")
+	cfg.SetDefault("analyzer.enrollment.validity", 365)
+	cfg.SetDefault("analyzer.enrollment.token_ttl", 86400)
+	cfg.SetDefault("analyzer.flow_correlation.bytes_tolerance", 0.1)
+	cfg.SetDefault("analyzer.flow_correlation.time_window", 2)
+	cfg.SetDefault("analyzer.flow_sharding.enabled", false)
+	cfg.SetDefault("analyzer.flow_sharding.replicas", 64)
 	cfg.SetDefault("analyzer.flowtable_expire", 600)
 	cfg.SetDefault("analyzer.flowtable_update", 60)
 	cfg.SetDefault("analyzer.listen", "127.0.0.1:8082")
 	cfg.SetDefault("analyzer.storage.bulk_insert", 100)
 	cfg.SetDefault("analyzer.storage.bulk_insert_deadline", 5)
+	cfg.SetDefault("analyzer.topology.agent_delete_delay", 30)
+	cfg.SetDefault("analyzer.topology.cross_host_publishers", []string{})
 	cfg.SetDefault("analyzer.topology.probes", []string{})
+	cfg.SetDefault("analyzer.topology.static.files", []string{})
 	cfg.SetDefault("analyzer.ssh_enabled", false)
 
 	cfg.SetDefault("auth.type", "noauth")
 	cfg.SetDefault("auth.keystone.tenant", "admin")
+	cfg.SetDefault("auth.oidc.username_claim", "preferred_username")
+	cfg.SetDefault("auth.oidc.groups_claim", "groups")
+	cfg.SetDefault("auth.oidc.jwks_cache_ttl", 3600)
 
 	cfg.SetDefault("cache.expire", 300)
 	cfg.SetDefault("cache.cleanup", 30)
@@ -98,6 +122,10 @@ func init() {
 
 	cfg.SetDefault("graph.backend", "memory")
 	cfg.SetDefault("graph.gremlin", "ws://127.0.0.1:8182")
+	cfg.SetDefault("graph.query_limits.max_elements", 1000000)
+	cfg.SetDefault("graph.query_limits.max_results", 100000)
+	cfg.SetDefault("graph.query_limits.timeout", 60)
+	cfg.SetDefault("graph.query_cache_size", 500)
 
 	cfg.SetDefault("host_id", host)
 
@@ -114,8 +142,12 @@ func init() {
 
 	cfg.SetDefault("opencontrail.mpls_udp_port", 51234)
 	cfg.SetDefault("openstack.endpoint_type", "public")
+	cfg.SetDefault("ovn.northbound", "unix:///var/run/openvswitch/ovnnb_db.sock")
+	cfg.SetDefault("ovn.southbound", "unix:///var/run/openvswitch/ovnsb_db.sock")
 	cfg.SetDefault("ovs.ovsdb", "unix:///var/run/openvswitch/db.sock")
 	cfg.SetDefault("ovs.oflow.enable", false)
+	cfg.SetDefault("ovs.oflow.metrics_update", 30)
+	cfg.SetDefault("ovs.oflow.native", false)
 	cfg.SetDefault("sflow.port_min", 6345)
 	cfg.SetDefault("sflow.port_max", 6355)
 
@@ -126,6 +158,7 @@ func init() {
 	cfg.SetDefault("storage.elasticsearch.retry", 60)
 	cfg.SetDefault("storage.elasticsearch.bulk_maxdocs", 100)
 	cfg.SetDefault("storage.elasticsearch.bulk_maxdelay", 5)
+	cfg.SetDefault("storage.elasticsearch.inplace_metadata", []string{})
 	cfg.SetDefault("storage.orientdb.addr", "http://localhost:2480")
 	cfg.SetDefault("storage.orientdb.database", "Skydive")
 	cfg.SetDefault("storage.orientdb.username", "root")
@@ -136,6 +169,8 @@ func init() {
 	cfg.SetDefault("ws_bulk_maxmsgs", 100)
 	cfg.SetDefault("ws_bulk_maxdelay", 1)
 	cfg.SetDefault("ws_queue_size", 10000)
+	cfg.SetDefault("ws_format", "msgpack")
+	cfg.SetDefault("ws_compression", false)
 
 	replacer := strings.NewReplacer(".", "_", "-", "_")
 	cfg.SetEnvPrefix("SKYDIVE")
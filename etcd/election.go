@@ -47,6 +47,17 @@ type EtcdMasterElectionListener interface {
 	OnSwitchToSlave()
 }
 
+// MasterElector describes the election of the master of a service
+type MasterElector interface {
+	IsMaster() bool
+	GetMaster() string
+	TTL() time.Duration
+	Start()
+	StartAndWait()
+	Stop()
+	AddEventListener(listener EtcdMasterElectionListener)
+}
+
 // EtcdMasterElector describes an ETCD master elector
 type EtcdMasterElector struct {
 	sync.RWMutex
@@ -99,6 +110,16 @@ func (le *EtcdMasterElector) IsMaster() bool {
 	return le.master
 }
 
+// GetMaster returns the host of the current master, an empty string if
+// there is none
+func (le *EtcdMasterElector) GetMaster() string {
+	resp, err := le.EtcdKeyAPI.Get(context.Background(), le.path, nil)
+	if err != nil {
+		return ""
+	}
+	return resp.Node.Value
+}
+
 // start starts the election process and send something to the chan when the first
 // election is done
 func (le *EtcdMasterElector) start(first chan struct{}) {
@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package etcd
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// EtcdMember describes a running instance of a service
+type EtcdMember struct {
+	Host string
+	Addr string
+	Port int
+}
+
+// EtcdMembershipListener describes the membership changes listener
+type EtcdMembershipListener interface {
+	OnMembersChanged(members []EtcdMember)
+}
+
+// EtcdMembership registers the local instance of a service into ETCD, the key
+// being kept alive with a TTL, and keeps track of the other instances.
+type EtcdMembership struct {
+	sync.RWMutex
+	EtcdKeyAPI etcd.KeysAPI
+	Self       EtcdMember
+	path       string
+	members    map[string]EtcdMember
+	listeners  []EtcdMembershipListener
+	cancel     context.CancelFunc
+	state      int64
+	wg         sync.WaitGroup
+}
+
+func (m *EtcdMembership) register() error {
+	value, err := json.Marshal(m.Self)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.EtcdKeyAPI.Set(context.Background(), path.Join(m.path, m.Self.Host), string(value), &etcd.SetOptions{TTL: timeout})
+	return err
+}
+
+func (m *EtcdMembership) keepAlive(ctx context.Context) {
+	defer m.wg.Done()
+
+	tick := time.NewTicker(timeout / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := m.register(); err != nil {
+				logging.GetLogger().Errorf("Unable to refresh membership %s: %s", m.path, err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Members returns the current members sorted by host
+func (m *EtcdMembership) Members() []EtcdMember {
+	m.RLock()
+	defer m.RUnlock()
+
+	members := make([]EtcdMember, 0, len(m.members))
+	for _, member := range m.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Host < members[j].Host })
+
+	return members
+}
+
+func (m *EtcdMembership) notify() {
+	members := m.Members()
+	for _, listener := range m.listeners {
+		listener.OnMembersChanged(members)
+	}
+}
+
+// list retrieves all the members and returns the index to watch from
+func (m *EtcdMembership) list() (uint64, error) {
+	resp, err := m.EtcdKeyAPI.Get(context.Background(), m.path, &etcd.GetOptions{Recursive: true})
+	if err != nil {
+		if etcd.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	members := make(map[string]EtcdMember)
+	for _, node := range resp.Node.Nodes {
+		var member EtcdMember
+		if err := json.Unmarshal([]byte(node.Value), &member); err != nil {
+			logging.GetLogger().Errorf("Invalid member %s: %s", node.Key, err.Error())
+			continue
+		}
+		members[member.Host] = member
+	}
+
+	m.Lock()
+	m.members = members
+	m.Unlock()
+
+	return resp.Index, nil
+}
+
+// update applies a watch event and returns whether the members changed
+func (m *EtcdMembership) update(resp *etcd.Response) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	switch resp.Action {
+	case "expire", "delete", "compareAndDelete":
+		host := path.Base(resp.Node.Key)
+		if _, found := m.members[host]; found {
+			delete(m.members, host)
+			return true
+		}
+	default:
+		var member EtcdMember
+		if err := json.Unmarshal([]byte(resp.Node.Value), &member); err != nil {
+			logging.GetLogger().Errorf("Invalid member %s: %s", resp.Node.Key, err.Error())
+			return false
+		}
+		if current, found := m.members[member.Host]; !found || current != member {
+			m.members[member.Host] = member
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *EtcdMembership) watch(ctx context.Context) {
+	defer m.wg.Done()
+
+	for atomic.LoadInt64(&m.state) == common.RunningState {
+		index, err := m.list()
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to list the members of %s: %s", m.path, err.Error())
+
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		m.notify()
+
+		watcher := m.EtcdKeyAPI.Watcher(m.path, &etcd.WatcherOptions{AfterIndex: index, Recursive: true})
+		for atomic.LoadInt64(&m.state) == common.RunningState {
+			resp, err := watcher.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.GetLogger().Errorf("Error while watching etcd: %s", err.Error())
+					time.Sleep(1 * time.Second)
+				}
+				// list again as some events may have been missed
+				break
+			}
+
+			if m.update(resp) {
+				m.notify()
+			}
+		}
+	}
+}
+
+// Start registers the local instance and starts watching the members
+func (m *EtcdMembership) Start() error {
+	if err := m.register(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	atomic.StoreInt64(&m.state, common.RunningState)
+
+	m.wg.Add(2)
+	go m.keepAlive(ctx)
+	go m.watch(ctx)
+
+	return nil
+}
+
+// Stop watching and unregister the local instance
+func (m *EtcdMembership) Stop() {
+	if atomic.CompareAndSwapInt64(&m.state, common.RunningState, common.StoppingState) {
+		m.cancel()
+		m.wg.Wait()
+
+		m.EtcdKeyAPI.Delete(context.Background(), path.Join(m.path, m.Self.Host), nil)
+	}
+}
+
+// AddEventListener registers a new listener
+func (m *EtcdMembership) AddEventListener(listener EtcdMembershipListener) {
+	m.listeners = append(m.listeners, listener)
+}
+
+// NewEtcdMembership creates a new membership of the given service
+func NewEtcdMembership(self EtcdMember, serviceType common.ServiceType, key string, etcdClient *EtcdClient) *EtcdMembership {
+	return &EtcdMembership{
+		EtcdKeyAPI: etcdClient.KeysAPI,
+		Self:       self,
+		path:       "/members-" + serviceType.String() + "-" + key,
+		members:    make(map[string]EtcdMember),
+	}
+}
+
+// NewEtcdMembershipFromConfig creates a new membership of the given service
+// for the local host
+func NewEtcdMembershipFromConfig(addr string, port int, serviceType common.ServiceType, key string, etcdClient *EtcdClient) *EtcdMembership {
+	self := EtcdMember{
+		Host: config.GetConfig().GetString("host_id"),
+		Addr: addr,
+		Port: port,
+	}
+	return NewEtcdMembership(self, serviceType, key, etcdClient)
+}
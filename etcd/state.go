@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package etcd
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"sync"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// State describes the runtime state persisted by the master of a service
+type State interface {
+	Set(key string, value interface{}) error
+	Delete(key string)
+	List() (map[string]json.RawMessage, error)
+	Stop()
+}
+
+// EtcdState persists the runtime state of the master of a service so that a
+// new master can resume from it. The writes are applied in background, the
+// last write of a key superseding the ones not yet applied.
+type EtcdState struct {
+	sync.Mutex
+	EtcdKeyAPI etcd.KeysAPI
+	path       string
+	pending    map[string]*string
+	flush      chan struct{}
+	quit       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func (s *EtcdState) keyPath(key string) string {
+	return path.Join(s.path, url.PathEscape(key))
+}
+
+func (s *EtcdState) write(key string, value *string) {
+	s.Lock()
+	s.pending[key] = value
+	s.Unlock()
+
+	select {
+	case s.flush <- struct{}{}:
+	default:
+	}
+}
+
+// Set the value of a key, the value being JSON encoded
+func (s *EtcdState) Set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	v := string(data)
+	s.write(key, &v)
+	return nil
+}
+
+// Delete a key
+func (s *EtcdState) Delete(key string) {
+	s.write(key, nil)
+}
+
+// List returns all the keys with their JSON encoded value
+func (s *EtcdState) List() (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+
+	resp, err := s.EtcdKeyAPI.Get(context.Background(), s.path, &etcd.GetOptions{Recursive: true})
+	if err != nil {
+		if etcd.IsKeyNotFound(err) {
+			return values, nil
+		}
+		return nil, err
+	}
+
+	for _, node := range resp.Node.Nodes {
+		key, err := url.PathUnescape(path.Base(node.Key))
+		if err != nil {
+			continue
+		}
+		values[key] = json.RawMessage(node.Value)
+	}
+
+	return values, nil
+}
+
+func (s *EtcdState) apply() {
+	s.Lock()
+	pending := s.pending
+	s.pending = make(map[string]*string)
+	s.Unlock()
+
+	for key, value := range pending {
+		var err error
+		if value == nil {
+			_, err = s.EtcdKeyAPI.Delete(context.Background(), s.keyPath(key), nil)
+			if etcd.IsKeyNotFound(err) {
+				err = nil
+			}
+		} else {
+			_, err = s.EtcdKeyAPI.Set(context.Background(), s.keyPath(key), *value, nil)
+		}
+
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to write state %s of %s: %s", key, s.path, err.Error())
+		}
+	}
+}
+
+func (s *EtcdState) run() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.flush:
+			s.apply()
+		case <-s.quit:
+			s.apply()
+			return
+		}
+	}
+}
+
+// Stop applies the pending writes and stops
+func (s *EtcdState) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// NewEtcdState returns a new state of the given service
+func NewEtcdState(serviceType common.ServiceType, key string, etcdClient *EtcdClient) *EtcdState {
+	s := &EtcdState{
+		EtcdKeyAPI: etcdClient.KeysAPI,
+		path:       "/state-" + serviceType.String() + "-" + key,
+		pending:    make(map[string]*string),
+		flush:      make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
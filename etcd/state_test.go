@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package etcd
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// fakeKeysAPI is an in-memory etcd keys API, the writes can be blocked to
+// check that they do not block the callers
+type fakeKeysAPI struct {
+	etcd.KeysAPI
+	sync.Mutex
+	values  map[string]string
+	blocked chan struct{}
+}
+
+func (k *fakeKeysAPI) wait() {
+	if k.blocked != nil {
+		<-k.blocked
+	}
+}
+
+func (k *fakeKeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	dir := &etcd.Node{Key: key, Dir: true}
+	for path, value := range k.values {
+		if strings.HasPrefix(path, key+"/") {
+			dir.Nodes = append(dir.Nodes, &etcd.Node{Key: path, Value: value})
+		}
+	}
+
+	if len(dir.Nodes) == 0 {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound}
+	}
+	return &etcd.Response{Action: "get", Node: dir}, nil
+}
+
+func (k *fakeKeysAPI) Set(ctx context.Context, key, value string, opts *etcd.SetOptions) (*etcd.Response, error) {
+	k.wait()
+
+	k.Lock()
+	defer k.Unlock()
+
+	k.values[key] = value
+	return &etcd.Response{Action: "set", Node: &etcd.Node{Key: key, Value: value}}, nil
+}
+
+func (k *fakeKeysAPI) Delete(ctx context.Context, key string, opts *etcd.DeleteOptions) (*etcd.Response, error) {
+	k.wait()
+
+	k.Lock()
+	defer k.Unlock()
+
+	if _, found := k.values[key]; !found {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound}
+	}
+	delete(k.values, key)
+	return &etcd.Response{Action: "delete", Node: &etcd.Node{Key: key}}, nil
+}
+
+func TestEtcdStateAsyncWrites(t *testing.T) {
+	keys := &fakeKeysAPI{values: make(map[string]string), blocked: make(chan struct{})}
+	state := NewEtcdState(common.AnalyzerService, "test", &EtcdClient{KeysAPI: keys})
+
+	// the writes must not wait for etcd
+	done := make(chan struct{})
+	go func() {
+		state.Set("node/1", "capture-1")
+		state.Set("node/2", "capture-2")
+		state.Set("node/1", "capture-3")
+		state.Delete("node/2")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writes blocked by etcd")
+	}
+
+	close(keys.blocked)
+	state.Stop()
+
+	values, err := state.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != 1 || string(values["node/1"]) != `"capture-3"` {
+		t.Errorf("Expected the last write of each key to be applied, got: %v", values)
+	}
+}
+
+func TestEtcdStateEmpty(t *testing.T) {
+	keys := &fakeKeysAPI{values: make(map[string]string)}
+	state := NewEtcdState(common.AnalyzerService, "test", &EtcdClient{KeysAPI: keys})
+	defer state.Stop()
+
+	values, err := state.List()
+	if err != nil || len(values) != 0 {
+		t.Errorf("Expected an empty state, got: %v, %v", values, err)
+	}
+}
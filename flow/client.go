@@ -24,6 +24,7 @@ package flow
 
 import (
 	"encoding/json"
+	"net/http"
 
 	"github.com/golang/protobuf/proto"
 
@@ -34,9 +35,67 @@ import (
 	"github.com/skydive-project/skydive/topology"
 )
 
-// TableClient describes a mechanism to Query a flow table via flowSet in JSON
+// TableClient describes a mechanism to Query a flow table via flowSet in JSON.
+// When peers are set, the lookups of the agents not connected to the local
+// analyzer are scattered to the peers and their results gathered.
 type TableClient struct {
 	WSJSONServer *shttp.WSJSONServer
+	peers        TablePeers
+}
+
+// TablePeers describes the analyzers to which the flow lookups are scattered
+type TablePeers interface {
+	GetSpeakers() []shttp.WSSpeaker
+}
+
+// PeerTableQuery describes a lookup scattered to an analyzer peer. The peer
+// only looks up the flow tables of the agents connected to it, either the
+// ones of HostNodeTIDs or all of them except the Exclude ones.
+type PeerTableQuery struct {
+	HostNodeTIDs topology.HostNodeTIDMap `json:",omitempty"`
+	Exclude      []string                `json:",omitempty"`
+	SearchQuery  []byte
+}
+
+// PeerTableReply describes the reply of an analyzer peer to a PeerTableQuery
+type PeerTableReply struct {
+	FlowSet []byte
+}
+
+func newMergeContext(flowSearchQuery filters.SearchQuery) MergeContext {
+	// for sort order we assume that the SortOrder of a flowSearchQuery comes from
+	// an already validated entry.
+	return MergeContext{
+		Sort:      flowSearchQuery.Sort,
+		SortBy:    flowSearchQuery.SortBy,
+		SortOrder: common.SortOrder(flowSearchQuery.SortOrder),
+		Dedup:     flowSearchQuery.Dedup,
+		DedupBy:   flowSearchQuery.DedupBy,
+	}
+}
+
+// gather merges the n flow sets received from the channel. As an agent can be
+// connected to several analyzers, the flows already merged are skipped.
+func gather(ch chan *FlowSet, n int, context MergeContext) *FlowSet {
+	flowset := NewFlowSet()
+
+	seen := make(map[string]bool)
+	for i := 0; i != n; i++ {
+		fs := <-ch
+
+		flows := make([]*Flow, 0, len(fs.Flows))
+		for _, f := range fs.Flows {
+			if !seen[f.UUID] {
+				seen[f.UUID] = true
+				flows = append(flows, f)
+			}
+		}
+		fs.Flows = flows
+
+		flowset.Merge(fs, context)
+	}
+
+	return flowset
 }
 
 func (f *TableClient) lookupFlows(flowset chan *FlowSet, host string, flowSearchQuery filters.SearchQuery) {
@@ -58,13 +117,7 @@ func (f *TableClient) lookupFlows(flowset chan *FlowSet, host string, flowSearch
 	}
 
 	fs := NewFlowSet()
-	context := MergeContext{
-		Sort:      flowSearchQuery.Sort,
-		SortBy:    flowSearchQuery.SortBy,
-		SortOrder: common.SortOrder(flowSearchQuery.SortOrder),
-		Dedup:     flowSearchQuery.Dedup,
-		DedupBy:   flowSearchQuery.DedupBy,
-	}
+	context := newMergeContext(flowSearchQuery)
 	for _, b := range reply.Obj {
 		var fsr FlowSearchReply
 		if err := proto.Unmarshal(b, &fsr); err != nil {
@@ -76,62 +129,164 @@ func (f *TableClient) lookupFlows(flowset chan *FlowSet, host string, flowSearch
 	flowset <- fs
 }
 
-// LookupFlows query flow table based on a filter search query
-func (f *TableClient) LookupFlows(flowSearchQuery filters.SearchQuery) (*FlowSet, error) {
-	speakers := f.WSJSONServer.GetSpeakersByType(common.AgentService)
-	ch := make(chan *FlowSet, len(speakers))
+func (f *TableClient) lookupPeerFlows(flowset chan *FlowSet, peer *shttp.WSJSONSpeaker, query *PeerTableQuery) {
+	msg := shttp.NewWSJSONMessage(Namespace, "PeerTableQuery", query)
 
-	for _, c := range speakers {
-		go f.lookupFlows(ch, c.GetHost(), flowSearchQuery)
+	resp, err := peer.Request(msg, shttp.DefaultRequestTimeout)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to send message to analyzer %s: %s", peer.GetHost(), err.Error())
+		flowset <- NewFlowSet()
+		return
 	}
 
-	flowset := NewFlowSet()
+	var reply PeerTableReply
+	fs := NewFlowSet()
+	if resp.Status != http.StatusOK || resp.DecodeObj(&reply) != nil || proto.Unmarshal(reply.FlowSet, fs) != nil {
+		logging.GetLogger().Errorf("Error returned while reading PeerTableReply from: %s", peer.GetHost())
+		flowset <- NewFlowSet()
+		return
+	}
+	flowset <- fs
+}
 
-	// for sort order we assume that the SortOrder of a flowSearchQuery comes from
-	// an already validated entry.
-	context := MergeContext{
-		Sort:      flowSearchQuery.Sort,
-		SortBy:    flowSearchQuery.SortBy,
-		SortOrder: common.SortOrder(flowSearchQuery.SortOrder),
-		Dedup:     flowSearchQuery.Dedup,
-		DedupBy:   flowSearchQuery.DedupBy,
+func (f *TableClient) getPeers() (peers []*shttp.WSJSONSpeaker) {
+	if f.peers == nil {
+		return
 	}
-	for i := 0; i != len(speakers); i++ {
-		fs := <-ch
-		flowset.Merge(fs, context)
+
+	for _, speaker := range f.peers.GetSpeakers() {
+		if peer, ok := speaker.(*shttp.WSJSONSpeaker); ok {
+			peers = append(peers, peer)
+		}
 	}
+	return
+}
 
-	return flowset, nil
+// scatter sends the query to the analyzer peers and returns the number of
+// flow sets that will be sent to the channel
+func (f *TableClient) scatter(ch chan *FlowSet, peers []*shttp.WSJSONSpeaker, query *PeerTableQuery, flowSearchQuery filters.SearchQuery) int {
+	query.SearchQuery, _ = proto.Marshal(&flowSearchQuery)
+	for _, peer := range peers {
+		go f.lookupPeerFlows(ch, peer, query)
+	}
+	return len(peers)
 }
 
-// LookupFlowsByNodes query flow table based on multiple nodes
-func (f *TableClient) LookupFlowsByNodes(hnmap topology.HostNodeTIDMap, flowSearchQuery filters.SearchQuery) (*FlowSet, error) {
-	ch := make(chan *FlowSet, len(hnmap))
+// lookupLocalFlows queries the flow tables of the agents connected to the local
+// analyzer and returns the number of flow sets that will be sent to the channel
+func (f *TableClient) lookupLocalFlows(ch chan *FlowSet, exclude map[string]bool, flowSearchQuery filters.SearchQuery) (hosts []string) {
+	for _, c := range f.WSJSONServer.GetSpeakersByType(common.AgentService) {
+		if host := c.GetHost(); !exclude[host] {
+			hosts = append(hosts, host)
+			go f.lookupFlows(ch, host, flowSearchQuery)
+		}
+	}
+	return
+}
 
+func (f *TableClient) lookupFlowsByNodes(ch chan *FlowSet, hnmap topology.HostNodeTIDMap, flowSearchQuery filters.SearchQuery) int {
 	// We conserve the original filter to reuse it for each host
 	searchQuery := flowSearchQuery.Filter
 	for host, tids := range hnmap {
 		flowSearchQuery.Filter = filters.NewAndFilter(NewFilterForNodeTIDs(tids), searchQuery)
 		go f.lookupFlows(ch, host, flowSearchQuery)
 	}
+	return len(hnmap)
+}
 
-	flowset := NewFlowSet()
+// splitByConnection splits the map between the hosts connected to the local
+// analyzer and the others
+func (f *TableClient) splitByConnection(hnmap topology.HostNodeTIDMap) (local topology.HostNodeTIDMap, remote topology.HostNodeTIDMap) {
+	local, remote = make(topology.HostNodeTIDMap), make(topology.HostNodeTIDMap)
+	for host, tids := range hnmap {
+		if f.WSJSONServer.GetSpeakerByHost(host) != nil {
+			local[host] = tids
+		} else {
+			remote[host] = tids
+		}
+	}
+	return
+}
 
-	// for sort order we assume that the SortOrder of a flowSearchQuery comes from
-	// an already validated entry.
-	context := MergeContext{
-		Sort:      flowSearchQuery.Sort,
-		SortBy:    flowSearchQuery.SortBy,
-		SortOrder: common.SortOrder(flowSearchQuery.SortOrder),
-		Dedup:     flowSearchQuery.Dedup,
-		DedupBy:   flowSearchQuery.DedupBy,
+// LookupFlows query flow table based on a filter search query
+func (f *TableClient) LookupFlows(flowSearchQuery filters.SearchQuery) (*FlowSet, error) {
+	peers := f.getPeers()
+	ch := make(chan *FlowSet, len(f.WSJSONServer.GetSpeakersByType(common.AgentService))+len(peers))
+
+	hosts := f.lookupLocalFlows(ch, nil, flowSearchQuery)
+	n := len(hosts)
+	if len(peers) > 0 {
+		n += f.scatter(ch, peers, &PeerTableQuery{Exclude: hosts}, flowSearchQuery)
 	}
-	for i := 0; i != len(hnmap); i++ {
-		fs := <-ch
-		flowset.Merge(fs, context)
+
+	return gather(ch, n, newMergeContext(flowSearchQuery)), nil
+}
+
+// LookupFlowsByNodes query flow table based on multiple nodes
+func (f *TableClient) LookupFlowsByNodes(hnmap topology.HostNodeTIDMap, flowSearchQuery filters.SearchQuery) (*FlowSet, error) {
+	peers := f.getPeers()
+	ch := make(chan *FlowSet, len(hnmap)+len(peers))
+
+	local, remote := hnmap, topology.HostNodeTIDMap{}
+	if len(peers) > 0 {
+		local, remote = f.splitByConnection(hnmap)
+	}
+
+	n := f.lookupFlowsByNodes(ch, local, flowSearchQuery)
+	if len(remote) > 0 {
+		n += f.scatter(ch, peers, &PeerTableQuery{HostNodeTIDs: remote}, flowSearchQuery)
+	}
+
+	return gather(ch, n, newMergeContext(flowSearchQuery)), nil
+}
+
+func (f *TableClient) onPeerTableQuery(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	var query PeerTableQuery
+	var flowSearchQuery filters.SearchQuery
+	if err := msg.DecodeObj(&query); err != nil || proto.Unmarshal(query.SearchQuery, &flowSearchQuery) != nil {
+		logging.GetLogger().Errorf("Unable to decode peer table query from: %s", c.GetHost())
+		c.SendMessage(msg.Reply(nil, "PeerTableReply", http.StatusBadRequest))
+		return
 	}
 
-	return flowset, nil
+	ch := make(chan *FlowSet, len(f.WSJSONServer.GetSpeakersByType(common.AgentService)))
+
+	var n int
+	if len(query.HostNodeTIDs) > 0 {
+		local, _ := f.splitByConnection(query.HostNodeTIDs)
+		n = f.lookupFlowsByNodes(ch, local, flowSearchQuery)
+	} else {
+		exclude := make(map[string]bool)
+		for _, host := range query.Exclude {
+			exclude[host] = true
+		}
+		n = len(f.lookupLocalFlows(ch, exclude, flowSearchQuery))
+	}
+
+	data, err := proto.Marshal(gather(ch, n, newMergeContext(flowSearchQuery)))
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to encode peer table reply: %s", err.Error())
+		c.SendMessage(msg.Reply(nil, "PeerTableReply", http.StatusInternalServerError))
+		return
+	}
+
+	c.SendMessage(msg.Reply(&PeerTableReply{FlowSet: data}, "PeerTableReply", http.StatusOK))
+}
+
+// OnWSJSONMessage serves the lookups scattered by the analyzer peers using the
+// agents connected to the local analyzer only.
+func (f *TableClient) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	switch msg.Type {
+	case "PeerTableQuery":
+		// do not block the peer connection while waiting for the agents
+		go f.onPeerTableQuery(c, msg)
+	}
+}
+
+// SetPeers scatters the lookups of the agents not connected to the local
+// analyzer to the given peers
+func (f *TableClient) SetPeers(peers TablePeers) {
+	f.peers = peers
 }
 
 // NewTableClient creates a new table client based on websocket
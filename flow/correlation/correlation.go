@@ -0,0 +1,582 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package correlation
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const expireEvery = time.Minute
+
+type entry struct {
+	flow   *flow.Flow
+	seen   time.Time
+	key    string // tuple of the flow, empty if it has none
+	parent string // tuple of the parent the flow is the translation of
+}
+
+// PeerCorrelationQuery describes a correlation lookup scattered to an
+// analyzer peer, the peer correlates the flows of the FlowSet with the ones
+// it received from the agents.
+type PeerCorrelationQuery struct {
+	FlowSet []byte
+}
+
+// PeerCorrelationReply describes the reply of an analyzer peer to a
+// PeerCorrelationQuery
+type PeerCorrelationReply struct {
+	FlowSet []byte
+}
+
+// Correlator links the flows of a same connection captured at different
+// points even when address translations make their tuples differ. The
+// translations are learnt from the flows reported by the conntrack probe,
+// a flow being the translated version of its parent. When no translation is
+// known for a tuple, a translated tuple is guessed from the flows sharing an
+// endpoint with it, started at about the same time and having about the same
+// byte counts. As the flows are sharded by TrackingID, the translated halves
+// of a connection may be owned by other analyzers, the lookups are then
+// scattered to the peers.
+type Correlator struct {
+	sync.RWMutex
+	flows        map[string]*entry
+	tuples       map[string]map[string]*flow.Flow
+	endpoints    map[string][]string
+	byEndpoint   map[string]map[string]bool
+	translations map[string]map[string]int
+	children     map[string]map[string]bool
+	peers        flow.TablePeers
+	expire       time.Duration
+	window       int64
+	tolerance    float64
+	lastExpire   time.Time
+}
+
+// tuple returns a key identifying the connection of a flow whatever its
+// direction, along with its two endpoints
+func tuple(f *flow.Flow) (string, []string, bool) {
+	if f.Network == nil {
+		return "", nil, false
+	}
+
+	var protocol, portA, portB string
+	switch {
+	case f.Transport != nil:
+		protocol, portA, portB = f.Transport.Protocol.String(), f.Transport.A, f.Transport.B
+	case f.ICMP != nil:
+		protocol = f.Network.Protocol.String() + "/ICMP"
+		portA = strconv.FormatUint(uint64(f.ICMP.ID), 10)
+		portB = portA
+	default:
+		return "", nil, false
+	}
+
+	endpoints := []string{f.Network.A + ":" + portA, f.Network.B + ":" + portB}
+	sort.Strings(endpoints)
+
+	return protocol + " " + strings.Join(endpoints, " "), endpoints, true
+}
+
+// isTranslation returns whether a flow is the translated version of its
+// parent, as reported by the conntrack probe which doesn't report any link
+// layer
+func isTranslation(parent, child *flow.Flow) bool {
+	return parent.Link == nil && child.Link == nil && parent.NodeTID == child.NodeTID
+}
+
+func sharedEndpoints(a, b []string) int {
+	shared := 0
+	for _, ea := range a {
+		for _, eb := range b {
+			if ea == eb {
+				shared++
+			}
+		}
+	}
+	return shared
+}
+
+func (c *Correlator) similarBytes(a, b *flow.Flow) bool {
+	ba := a.Metric.ABBytes + a.Metric.BABytes
+	bb := b.Metric.ABBytes + b.Metric.BABytes
+
+	max, diff := ba, ba-bb
+	if bb > max {
+		max = bb
+	}
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return float64(diff) <= c.tolerance*float64(max)
+}
+
+func (c *Correlator) matches(a, b *flow.Flow) bool {
+	if a.NodeTID == b.NodeTID {
+		return false
+	}
+
+	diff := a.Start - b.Start
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff <= c.window && c.similarBytes(a, b)
+}
+
+// guess returns the tuple which is likely the translation of the given one,
+// none if there are several candidates. Only the tuples sharing an endpoint
+// with the given one are considered.
+func (c *Correlator) guess(key string, flows []*flow.Flow, endpoints []string) string {
+	protocol := strings.SplitN(key, " ", 2)[0]
+
+	nodes := make(map[string]bool)
+	for _, f := range flows {
+		nodes[f.NodeTID] = true
+	}
+
+	candidates := make(map[string]bool)
+	for _, ep := range endpoints {
+		for k := range c.byEndpoint[ep] {
+			candidates[k] = true
+		}
+	}
+
+	var candidate string
+	for k := range candidates {
+		if k == key || strings.SplitN(k, " ", 2)[0] != protocol || sharedEndpoints(endpoints, c.endpoints[k]) != 1 {
+			continue
+		}
+
+		// both tuples seen on the same capture point are distinct connections
+		distinct := false
+		for _, o := range c.tuples[k] {
+			if nodes[o.NodeTID] {
+				distinct = true
+				break
+			}
+		}
+		if distinct {
+			continue
+		}
+
+	match:
+		for _, f := range flows {
+			for _, o := range c.tuples[k] {
+				if c.matches(f, o) {
+					if candidate != "" {
+						return ""
+					}
+					candidate = k
+					break match
+				}
+			}
+		}
+	}
+
+	return candidate
+}
+
+func sortFlows(flows []*flow.Flow) {
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].Start != flows[j].Start {
+			return flows[i].Start < flows[j].Start
+		}
+		return flows[i].UUID < flows[j].UUID
+	})
+}
+
+// correlate returns the flows of the connections of the seeds, the seeds
+// included. The seeds may be unknown to the correlator, their versions are
+// preferred to the recorded ones. The read lock has to be held.
+func (c *Correlator) correlate(seeds []*flow.Flow) []*flow.Flow {
+	seeded := make(map[string]map[string]*flow.Flow)
+	endpoints := make(map[string][]string)
+
+	var keys, queue []string
+	var alone []*flow.Flow
+	visited := make(map[string]bool)
+	for _, f := range seeds {
+		key, eps, ok := tuple(f)
+		if !ok {
+			alone = append(alone, f)
+			continue
+		}
+
+		if seeded[key] == nil {
+			seeded[key] = make(map[string]*flow.Flow)
+		}
+		seeded[key][f.UUID] = f
+		endpoints[key] = eps
+
+		if !visited[key] {
+			visited[key] = true
+			queue = append(queue, key)
+		}
+	}
+	keys = append(keys, queue...)
+
+	flowsOf := func(key string) (fs []*flow.Flow) {
+		for _, f := range seeded[key] {
+			fs = append(fs, f)
+		}
+		for uuid, f := range c.tuples[key] {
+			if _, ok := seeded[key][uuid]; !ok {
+				fs = append(fs, f)
+			}
+		}
+		return
+	}
+
+	for ; len(queue) > 0; queue = queue[1:] {
+		k := queue[0]
+
+		var next []string
+		for t := range c.translations[k] {
+			next = append(next, t)
+		}
+		if len(next) == 0 {
+			eps, ok := c.endpoints[k]
+			if !ok {
+				eps = endpoints[k]
+			}
+			if t := c.guess(k, flowsOf(k), eps); t != "" {
+				next = append(next, t)
+			}
+		}
+
+		for _, t := range next {
+			if !visited[t] {
+				visited[t] = true
+				keys = append(keys, t)
+				queue = append(queue, t)
+			}
+		}
+	}
+
+	chain := alone
+	for _, k := range keys {
+		chain = append(chain, flowsOf(k)...)
+	}
+	sortFlows(chain)
+
+	return chain
+}
+
+// Correlated returns the flows of the connection of the given flow, the flow
+// included, ordered by start time, using the flows recorded locally only.
+// It returns nil if the flow is unknown.
+func (c *Correlator) Correlated(uuid string) []*flow.Flow {
+	c.RLock()
+	defer c.RUnlock()
+
+	e, ok := c.flows[uuid]
+	if !ok {
+		return nil
+	}
+
+	return c.correlate([]*flow.Flow{e.flow})
+}
+
+func (c *Correlator) lookupPeer(ch chan []*flow.Flow, peer *shttp.WSJSONSpeaker, query *PeerCorrelationQuery) {
+	msg := shttp.NewWSJSONMessage(flow.Namespace, "PeerCorrelationQuery", query)
+
+	resp, err := peer.Request(msg, shttp.DefaultRequestTimeout)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to send message to analyzer %s: %s", peer.GetHost(), err.Error())
+		ch <- nil
+		return
+	}
+
+	var reply PeerCorrelationReply
+	fs := flow.NewFlowSet()
+	if resp.Status != http.StatusOK || resp.DecodeObj(&reply) != nil || proto.Unmarshal(reply.FlowSet, fs) != nil {
+		logging.GetLogger().Errorf("Error returned while reading PeerCorrelationReply from: %s", peer.GetHost())
+		ch <- nil
+		return
+	}
+	ch <- fs.Flows
+}
+
+func (c *Correlator) getPeers() (peers []*shttp.WSJSONSpeaker) {
+	if c.peers == nil {
+		return
+	}
+
+	for _, speaker := range c.peers.GetSpeakers() {
+		if peer, ok := speaker.(*shttp.WSJSONSpeaker); ok {
+			peers = append(peers, peer)
+		}
+	}
+	return
+}
+
+// Lookup returns the flows of the connection of the given flow, the flow
+// included, ordered by start time. The flow may be unknown to the local
+// correlator. The lookup is scattered to the peers until no more flow is
+// correlated, a connection possibly being spread over several analyzers.
+func (c *Correlator) Lookup(f *flow.Flow) []*flow.Flow {
+	c.RLock()
+	chain := c.correlate([]*flow.Flow{f})
+	c.RUnlock()
+
+	peers := c.getPeers()
+	for len(peers) > 0 {
+		fs := &flow.FlowSet{Flows: chain}
+		data, err := proto.Marshal(fs)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to encode peer correlation query: %s", err.Error())
+			break
+		}
+
+		ch := make(chan []*flow.Flow, len(peers))
+		for _, peer := range peers {
+			go c.lookupPeer(ch, peer, &PeerCorrelationQuery{FlowSet: data})
+		}
+
+		seen := make(map[string]bool)
+		for _, fl := range chain {
+			seen[fl.UUID] = true
+		}
+
+		seeds := chain
+		for range peers {
+			for _, fl := range <-ch {
+				if !seen[fl.UUID] {
+					seen[fl.UUID] = true
+					seeds = append(seeds, fl)
+				}
+			}
+		}
+
+		if len(seeds) == len(chain) {
+			break
+		}
+
+		// the flows of the peers may be translated locally as well
+		c.RLock()
+		chain = c.correlate(seeds)
+		c.RUnlock()
+	}
+
+	return chain
+}
+
+func (c *Correlator) onPeerCorrelationQuery(s shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	var query PeerCorrelationQuery
+	fs := flow.NewFlowSet()
+	if err := msg.DecodeObj(&query); err != nil || proto.Unmarshal(query.FlowSet, fs) != nil {
+		logging.GetLogger().Errorf("Unable to decode peer correlation query from: %s", s.GetHost())
+		s.SendMessage(msg.Reply(nil, "PeerCorrelationReply", http.StatusBadRequest))
+		return
+	}
+
+	c.RLock()
+	fs.Flows = c.correlate(fs.Flows)
+	c.RUnlock()
+
+	data, err := proto.Marshal(fs)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to encode peer correlation reply: %s", err.Error())
+		s.SendMessage(msg.Reply(nil, "PeerCorrelationReply", http.StatusInternalServerError))
+		return
+	}
+
+	s.SendMessage(msg.Reply(&PeerCorrelationReply{FlowSet: data}, "PeerCorrelationReply", http.StatusOK))
+}
+
+// OnWSJSONMessage serves the correlation lookups scattered by the analyzer
+// peers using the flows recorded locally only.
+func (c *Correlator) OnWSJSONMessage(s shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	switch msg.Type {
+	case "PeerCorrelationQuery":
+		c.onPeerCorrelationQuery(s, msg)
+	}
+}
+
+// SetPeers scatters the correlation lookups to the given peers
+func (c *Correlator) SetPeers(peers flow.TablePeers) {
+	c.peers = peers
+}
+
+// link adds n to the number of flows linking the tuples a and b
+func (c *Correlator) link(a, b string, n int) {
+	if a == b {
+		return
+	}
+
+	for _, t := range [][2]string{{a, b}, {b, a}} {
+		m := c.translations[t[0]]
+		if m == nil {
+			m = make(map[string]int)
+			c.translations[t[0]] = m
+		}
+		if m[t[1]] += n; m[t[1]] <= 0 {
+			delete(m, t[1])
+		}
+		if len(m) == 0 {
+			delete(c.translations, t[0])
+		}
+	}
+}
+
+// linkParent links the tuple of a flow to the one of its parent when the
+// flow is its translated version
+func (c *Correlator) linkParent(e *entry) {
+	parent, ok := c.flows[e.flow.ParentUUID]
+	if !ok || e.key == "" || parent.key == "" || !isTranslation(parent.flow, e.flow) {
+		return
+	}
+	e.parent = parent.key
+	c.link(e.key, e.parent, 1)
+}
+
+func (c *Correlator) unlinkParent(e *entry) {
+	if e.parent != "" {
+		c.link(e.key, e.parent, -1)
+		e.parent = ""
+	}
+}
+
+func (c *Correlator) remove(uuid string) {
+	e, ok := c.flows[uuid]
+	if !ok {
+		return
+	}
+
+	c.unlinkParent(e)
+	for child := range c.children[uuid] {
+		if ce, ok := c.flows[child]; ok {
+			c.unlinkParent(ce)
+		}
+	}
+
+	if parent := e.flow.ParentUUID; parent != "" {
+		if delete(c.children[parent], uuid); len(c.children[parent]) == 0 {
+			delete(c.children, parent)
+		}
+	}
+
+	if e.key != "" {
+		if delete(c.tuples[e.key], uuid); len(c.tuples[e.key]) == 0 {
+			for _, ep := range c.endpoints[e.key] {
+				if delete(c.byEndpoint[ep], e.key); len(c.byEndpoint[ep]) == 0 {
+					delete(c.byEndpoint, ep)
+				}
+			}
+			delete(c.tuples, e.key)
+			delete(c.endpoints, e.key)
+		}
+	}
+
+	delete(c.flows, uuid)
+}
+
+func (c *Correlator) add(f *flow.Flow, now time.Time) {
+	c.remove(f.UUID)
+
+	e := &entry{flow: f, seen: now}
+	if key, endpoints, ok := tuple(f); ok {
+		e.key = key
+		if c.tuples[key] == nil {
+			c.tuples[key] = make(map[string]*flow.Flow)
+			c.endpoints[key] = endpoints
+			for _, ep := range endpoints {
+				if c.byEndpoint[ep] == nil {
+					c.byEndpoint[ep] = make(map[string]bool)
+				}
+				c.byEndpoint[ep][key] = true
+			}
+		}
+		c.tuples[key][f.UUID] = f
+	}
+	c.flows[f.UUID] = e
+
+	if parent := f.ParentUUID; parent != "" {
+		if c.children[parent] == nil {
+			c.children[parent] = make(map[string]bool)
+		}
+		c.children[parent][f.UUID] = true
+		c.linkParent(e)
+	}
+
+	// the translated versions may have been recorded before their parent
+	for child := range c.children[f.UUID] {
+		if ce, ok := c.flows[child]; ok {
+			c.linkParent(ce)
+		}
+	}
+}
+
+func (c *Correlator) expireAt(now time.Time) {
+	for uuid, e := range c.flows {
+		if now.Sub(e.seen) > c.expire {
+			c.remove(uuid)
+		}
+	}
+	c.lastExpire = now
+}
+
+// Add records the last version of flows
+func (c *Correlator) Add(flows ...*flow.Flow) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for _, f := range flows {
+		c.add(f, now)
+	}
+
+	if now.Sub(c.lastExpire) > expireEvery {
+		c.expireAt(now)
+	}
+}
+
+// NewCorrelator returns a new correlator keeping the flows during expire.
+// Flows of guessed translations have to be started within window and their
+// byte counts may differ of the tolerance ratio.
+func NewCorrelator(expire, window time.Duration, tolerance float64) *Correlator {
+	return &Correlator{
+		flows:        make(map[string]*entry),
+		tuples:       make(map[string]map[string]*flow.Flow),
+		endpoints:    make(map[string][]string),
+		byEndpoint:   make(map[string]map[string]bool),
+		translations: make(map[string]map[string]int),
+		children:     make(map[string]map[string]bool),
+		expire:       expire,
+		window:       int64(window / time.Millisecond),
+		tolerance:    tolerance,
+		lastExpire:   time.Now(),
+	}
+}
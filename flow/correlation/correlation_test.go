@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package correlation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+func newFlow(uuid, tid string, link bool, a, portA, b, portB string, start, bytes int64) *flow.Flow {
+	f := flow.NewFlow()
+	f.UUID = uuid
+	f.NodeTID = tid
+	f.Start = start
+	f.Last = start
+	if link {
+		f.Link = &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET, A: "00:00:00:00:00:01", B: "00:00:00:00:00:02"}
+	}
+	f.Network = &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: a, B: b}
+	f.Transport = &flow.FlowLayer{Protocol: flow.FlowProtocol_TCPPORT, A: portA, B: portB}
+	f.Metric = &flow.FlowMetric{ABBytes: bytes / 2, BABytes: bytes - bytes/2}
+	return f
+}
+
+func uuids(flows []*flow.Flow) map[string]bool {
+	m := make(map[string]bool)
+	for _, f := range flows {
+		m[f.UUID] = true
+	}
+	return m
+}
+
+func newTestCorrelator() *Correlator {
+	return NewCorrelator(time.Minute, time.Second, 0.1)
+}
+
+func TestCorrelatedConntrack(t *testing.T) {
+	c := newTestCorrelator()
+
+	pod := newFlow("pod", "veth", true, "10.0.0.1", "5000", "172.16.0.1", "80", 1000, 1000)
+	orig := newFlow("orig", "ns", false, "10.0.0.1", "5000", "172.16.0.1", "80", 1000, 1000)
+	nat := newFlow("nat", "ns", false, "192.168.1.10", "6000", "10.0.0.2", "8080", 1000, 1000)
+	nat.ParentUUID = orig.UUID
+	// the reply direction seen first on the uplink
+	uplink := newFlow("uplink", "eth0", true, "10.0.0.2", "8080", "192.168.1.10", "6000", 9000, 5000)
+	other := newFlow("other", "eth0", true, "192.168.1.10", "6001", "10.0.0.2", "8080", 1000, 1000)
+
+	c.Add(pod, orig, nat, uplink, other)
+
+	chain := c.Correlated("pod")
+	if len(chain) != 4 {
+		t.Fatalf("Expected 4 flows, got: %v", uuids(chain))
+	}
+	for _, uuid := range []string{"pod", "orig", "nat", "uplink"} {
+		if !uuids(chain)[uuid] {
+			t.Errorf("Flow %s should be correlated: %v", uuid, uuids(chain))
+		}
+	}
+	if chain[len(chain)-1].UUID != "uplink" {
+		t.Errorf("Flows should be ordered by start time: %v", chain)
+	}
+
+	if chain = c.Correlated("uplink"); len(chain) != 4 {
+		t.Errorf("Correlation should work from both sides, got: %v", uuids(chain))
+	}
+
+	if chain = c.Correlated("unknown"); chain != nil {
+		t.Errorf("Unknown flow should not be correlated, got: %v", uuids(chain))
+	}
+}
+
+func TestCorrelatedGuess(t *testing.T) {
+	c := newTestCorrelator()
+
+	pod := newFlow("pod", "veth", true, "10.0.0.1", "5000", "172.16.0.1", "80", 1000, 1000)
+	uplink := newFlow("uplink", "eth0", true, "192.168.1.10", "6000", "172.16.0.1", "80", 1500, 1050)
+	late := newFlow("late", "eth0", true, "192.168.1.10", "6001", "172.16.0.1", "80", 5000, 1000)
+	bigger := newFlow("bigger", "eth0", true, "192.168.1.10", "6002", "172.16.0.1", "80", 1200, 2000)
+
+	c.Add(pod, uplink, late, bigger)
+
+	chain := c.Correlated("pod")
+	if len(chain) != 2 || !uuids(chain)["uplink"] {
+		t.Fatalf("Expected pod and uplink flows, got: %v", uuids(chain))
+	}
+
+	// a second candidate makes the guess ambiguous
+	c.Add(newFlow("ambiguous", "eth0", true, "192.168.1.10", "6003", "172.16.0.1", "80", 1100, 1000))
+
+	if chain = c.Correlated("pod"); len(chain) != 1 {
+		t.Errorf("Ambiguous guess should not correlate, got: %v", uuids(chain))
+	}
+}
+
+func TestCorrelatorExpire(t *testing.T) {
+	c := newTestCorrelator()
+
+	c.Add(newFlow("pod", "veth", true, "10.0.0.1", "5000", "172.16.0.1", "80", 1000, 1000))
+	c.expireAt(time.Now().Add(2 * time.Minute))
+
+	if chain := c.Correlated("pod"); chain != nil {
+		t.Errorf("Flow should have expired, got: %v", uuids(chain))
+	}
+}
+
+func TestCorrelatorIndex(t *testing.T) {
+	c := newTestCorrelator()
+
+	orig := newFlow("orig", "ns", false, "10.0.0.1", "5000", "172.16.0.1", "80", 1000, 1000)
+	nat := newFlow("nat", "ns", false, "192.168.1.10", "6000", "10.0.0.2", "8080", 1000, 1000)
+	nat.ParentUUID = orig.UUID
+
+	// the translated version recorded before its parent
+	c.Add(nat)
+	c.Add(orig)
+
+	if chain := c.Correlated("orig"); len(chain) != 2 {
+		t.Fatalf("Expected orig and nat flows, got: %v", uuids(chain))
+	}
+
+	// updating the parent keeps a single link
+	c.Add(orig)
+	if n := c.translations[c.flows["orig"].key][c.flows["nat"].key]; n != 1 {
+		t.Errorf("Expected a single translation link, got: %d", n)
+	}
+
+	c.flows["orig"].seen = time.Now().Add(-2 * time.Minute)
+	c.expireAt(time.Now())
+
+	if len(c.translations) != 0 || len(c.tuples) != 1 || len(c.byEndpoint) != 2 {
+		t.Errorf("Expired flow should be removed from the index: %v %v %v", c.translations, c.tuples, c.byEndpoint)
+	}
+	if chain := c.Correlated("nat"); len(chain) != 1 {
+		t.Errorf("Expected nat flow only, got: %v", uuids(chain))
+	}
+}
+
+func TestCorrelateSeeds(t *testing.T) {
+	c := newTestCorrelator()
+
+	orig := newFlow("orig", "ns", false, "10.0.0.1", "5000", "172.16.0.1", "80", 1000, 1000)
+	nat := newFlow("nat", "ns", false, "192.168.1.10", "6000", "10.0.0.2", "8080", 1000, 1000)
+	nat.ParentUUID = orig.UUID
+	c.Add(orig, nat)
+
+	// flows owned by a peer analyzer, unknown to the local correlator
+	pod := newFlow("pod", "veth", true, "10.0.0.1", "5000", "172.16.0.1", "80", 1000, 1000)
+	alone := newFlow("alone", "veth", true, "10.0.0.3", "5000", "172.16.0.3", "80", 1000, 1000)
+
+	chain := c.correlate([]*flow.Flow{pod})
+	if len(chain) != 3 || !uuids(chain)["nat"] {
+		t.Errorf("Expected pod, orig and nat flows, got: %v", uuids(chain))
+	}
+
+	if chain = c.correlate([]*flow.Flow{alone}); len(chain) != 1 || chain[0] != alone {
+		t.Errorf("Expected alone flow only, got: %v", uuids(chain))
+	}
+}
@@ -24,6 +24,7 @@ package flow
 
 import (
 	"encoding/binary"
+	"errors"
 	"runtime"
 
 	"github.com/google/gopacket"
@@ -37,6 +38,13 @@ var LayerTypeInGRE = gopacket.RegisterLayerType(55555, gopacket.LayerTypeMetadat
 // Try to find if the next layer is IPv4, or IPv6. If it fails, it considers it is Ethernet.
 var layerTypeInMplsEthOrIP = gopacket.RegisterLayerType(55556, gopacket.LayerTypeMetadata{Name: "LayerTypeInMplsEthOrIp", Decoder: gopacket.DecodeFunc(decodeInMplsEthOrIPLayer)})
 
+// LayerTypeVXLANGPE is the layer type of the VXLAN Generic Protocol Extension header
+var LayerTypeVXLANGPE = gopacket.RegisterLayerType(55557, gopacket.LayerTypeMetadata{Name: "VXLANGPE", Decoder: gopacket.DecodeFunc(decodeVXLANGPE)})
+
+// LayerTypeNSH is the layer type of the Network Service Header, its decoder
+// is set in init as NSH can encapsulate NSH
+var LayerTypeNSH = gopacket.RegisterLayerType(55558, gopacket.LayerTypeMetadata{Name: "NSH"})
+
 var layerTypeICMPv4 = gopacket.OverrideLayerType(19, gopacket.LayerTypeMetadata{Name: "ICMPv4", Decoder: gopacket.DecodeFunc(decodeICMPv4)})
 var layerTypeICMPv6 = gopacket.OverrideLayerType(57, gopacket.LayerTypeMetadata{Name: "ICMPv6", Decoder: gopacket.DecodeFunc(decodeICMPv6)})
 
@@ -57,6 +65,143 @@ func (m inGRELayer) LayerPayload() []byte {
 	return m.payload
 }
 
+// EncapProtocol is the next protocol field shared by VXLAN-GPE and NSH headers
+type EncapProtocol uint8
+
+// Next protocols defined by draft-ietf-nvo3-vxlan-gpe and RFC 8300
+const (
+	EncapProtocolIPv4     EncapProtocol = 1
+	EncapProtocolIPv6     EncapProtocol = 2
+	EncapProtocolEthernet EncapProtocol = 3
+	EncapProtocolNSH      EncapProtocol = 4
+	EncapProtocolMPLS     EncapProtocol = 5
+)
+
+// EthernetTypeNSH is the ethertype used to carry NSH directly over Ethernet or Geneve
+const EthernetTypeNSH layers.EthernetType = 0x894F
+
+var errEncapHeaderTooShort = errors.New("encapsulation header too short")
+
+// LayerType returns the layer type of the encapsulated protocol
+func (e EncapProtocol) LayerType() gopacket.LayerType {
+	switch e {
+	case EncapProtocolIPv4:
+		return layers.LayerTypeIPv4
+	case EncapProtocolIPv6:
+		return layers.LayerTypeIPv6
+	case EncapProtocolEthernet:
+		return layers.LayerTypeEthernet
+	case EncapProtocolNSH:
+		return LayerTypeNSH
+	case EncapProtocolMPLS:
+		return layers.LayerTypeMPLS
+	}
+	return gopacket.LayerTypePayload
+}
+
+// VXLANGPE is a VXLAN Generic Protocol Extension header
+type VXLANGPE struct {
+	layers.BaseLayer
+	Flags        uint8
+	Version      uint8
+	NextProtocol EncapProtocol
+	VNI          uint32
+}
+
+// LayerType returns LayerTypeVXLANGPE
+func (v *VXLANGPE) LayerType() gopacket.LayerType {
+	return LayerTypeVXLANGPE
+}
+
+// DecodeFromBytes decodes the 8 bytes VXLAN-GPE header
+func (v *VXLANGPE) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		df.SetTruncated()
+		return errEncapHeaderTooShort
+	}
+	v.Flags = data[0]
+	v.Version = (data[0] >> 4) & 0x3
+	v.NextProtocol = EncapProtocol(data[3])
+	v.VNI = binary.BigEndian.Uint32(data[4:8]) >> 8
+	v.BaseLayer = layers.BaseLayer{Contents: data[:8], Payload: data[8:]}
+	return nil
+}
+
+// NextLayerType returns the layer type of the encapsulated packet
+func (v *VXLANGPE) NextLayerType() gopacket.LayerType {
+	return v.NextProtocol.LayerType()
+}
+
+func decodeVXLANGPE(data []byte, p gopacket.PacketBuilder) error {
+	gpe := &VXLANGPE{}
+	if err := gpe.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(gpe)
+	return p.NextDecoder(gpe.NextLayerType())
+}
+
+// NSH is a Network Service Header as defined by RFC 8300
+type NSH struct {
+	layers.BaseLayer
+	Version      uint8
+	OAM          bool
+	TTL          uint8
+	Length       uint8
+	MDType       uint8
+	NextProtocol EncapProtocol
+	SPI          uint32
+	SI           uint8
+	Context      []byte
+}
+
+// LayerType returns LayerTypeNSH
+func (n *NSH) LayerType() gopacket.LayerType {
+	return LayerTypeNSH
+}
+
+// DecodeFromBytes decodes the base, service path and context headers
+func (n *NSH) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		df.SetTruncated()
+		return errEncapHeaderTooShort
+	}
+	header := binary.BigEndian.Uint32(data[0:4])
+	n.Version = uint8(header >> 30)
+	n.OAM = header&(1<<29) != 0
+	n.TTL = uint8(header>>22) & 0x3f
+	n.Length = uint8(header>>16) & 0x3f
+	n.MDType = uint8(header>>8) & 0xf
+	n.NextProtocol = EncapProtocol(header)
+
+	length := int(n.Length) * 4
+	if length < 8 || len(data) < length {
+		df.SetTruncated()
+		return errEncapHeaderTooShort
+	}
+
+	path := binary.BigEndian.Uint32(data[4:8])
+	n.SPI = path >> 8
+	n.SI = uint8(path)
+	n.Context = data[8:length]
+	n.BaseLayer = layers.BaseLayer{Contents: data[:length], Payload: data[length:]}
+	return nil
+}
+
+// NextLayerType returns the layer type of the encapsulated packet
+func (n *NSH) NextLayerType() gopacket.LayerType {
+	return n.NextProtocol.LayerType()
+}
+
+func decodeNSH(data []byte, p gopacket.PacketBuilder) error {
+	nsh := &NSH{}
+	if err := nsh.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(nsh)
+	return p.NextDecoder(nsh.NextLayerType())
+}
+
 // ICMPv4 aims to store ICMP metadata and aims to be used for the flow hash key
 type ICMPv4 struct {
 	layers.ICMPv4
@@ -223,6 +368,13 @@ func init() {
 	// to decode it as Ethernet.
 	layers.MPLSPayloadDecoder = layerTypeInMplsEthOrIP
 
+	gopacket.OverrideLayerType(int(LayerTypeNSH), gopacket.LayerTypeMetadata{Name: "NSH", Decoder: gopacket.DecodeFunc(decodeNSH)})
+
+	// IANA assigned port for VXLAN-GPE, NSH can also be carried by Ethernet
+	// or Geneve using its own ethertype
+	layers.RegisterUDPPortLayerType(layers.UDPPort(4790), LayerTypeVXLANGPE)
+	layers.EthernetTypeMetadata[EthernetTypeNSH] = layers.EnumMetadata{DecodeWith: LayerTypeNSH, Name: "NSH", LayerType: LayerTypeNSH}
+
 	// linux uses the port 8472 as default port used for vxlan protocol
 	if runtime.GOOS == "linux" {
 		layers.RegisterUDPPortLayerType(layers.UDPPort(8472), layers.LayerTypeVXLAN)
@@ -266,6 +266,9 @@ func networkID(p *gopacket.Packet) int64 {
 		if layer.LayerType() == layers.LayerTypeGeneve {
 			return int64(layer.(*layers.Geneve).VNI)
 		}
+		if layer.LayerType() == LayerTypeVXLANGPE {
+			return int64(layer.(*VXLANGPE).VNI)
+		}
 	}
 	return id
 }
@@ -394,6 +397,8 @@ func (f *Flow) InitFromGoPacket(key string, now int64, packet *gopacket.Packet,
 		f.newTransportLayer(packet, opts.TCPMetric)
 	}
 
+	f.newEncapLayer(packet)
+
 	// need to have as most variable filled as possible to get correct UUID
 	f.UpdateUUID(key, uuids.L2ID, uuids.L3ID)
 }
@@ -426,6 +431,32 @@ func (f *Flow) newLinkLayer(packet *gopacket.Packet, length int64) {
 	f.updateMetricsWithLinkLayer(packet, length)
 }
 
+func (f *Flow) newEncapLayer(packet *gopacket.Packet) {
+	var encap *EncapLayer
+	for _, layer := range (*packet).Layers() {
+		switch l := layer.(type) {
+		case *layers.VXLAN:
+			encap = &EncapLayer{Type: "VXLAN", VNI: int64(l.VNI)}
+		case *layers.Geneve:
+			encap = &EncapLayer{Type: "Geneve", VNI: int64(l.VNI)}
+			for _, opt := range l.Options {
+				encap.Options = append(encap.Options, &GeneveOption{Class: int64(opt.Class), Type: int64(opt.Type), Data: opt.Data})
+			}
+		case *VXLANGPE:
+			encap = &EncapLayer{Type: "VXLANGPE", VNI: int64(l.VNI)}
+		case *NSH:
+			// NSH is usually carried by another tunnel, keep its VNI
+			if encap == nil {
+				encap = &EncapLayer{}
+			}
+			encap.Type = "NSH"
+			encap.SPI = int64(l.SPI)
+			encap.SI = int64(l.SI)
+		}
+	}
+	f.Encap = encap
+}
+
 func getLinkLayerLength(packet *layers.Ethernet) int64 {
 	if packet.Length > 0 { // LLC
 		return 14 + int64(packet.Length)
@@ -720,15 +751,17 @@ func PacketSeqFromGoPacket(packet *gopacket.Packet, outerLength int64, t int64,
 		innerLength += len(layer.LayerContents())
 
 		switch layer.LayerType() {
-		case layers.LayerTypeGRE:
-			// If the next layer type is MPLS, we don't
+		case layers.LayerTypeGRE, layers.LayerTypeGeneve, LayerTypeVXLANGPE:
+			// If the next layer type is MPLS or NSH, we don't
 			// creates the tunneling packet at this level, but at the next one.
-			if i < len(packetLayers)-2 && packetLayers[i+1].LayerType() == layers.LayerTypeMPLS {
-				continue
+			if i < len(packetLayers)-2 {
+				if next := packetLayers[i+1].LayerType(); next == layers.LayerTypeMPLS || next == LayerTypeNSH {
+					continue
+				}
 			}
 			fallthrough
 			// We don't split on vlan layers.LayerTypeDot1Q
-		case layers.LayerTypeVXLAN, layers.LayerTypeMPLS, layers.LayerTypeGeneve:
+		case layers.LayerTypeVXLAN, layers.LayerTypeMPLS, LayerTypeNSH:
 			p := gopacket.NewPacket(packetData[start:start+innerLength], topLayer.LayerType(), gopacket.NoCopy)
 			ps.Packets = append(ps.Packets, Packet{gopacket: &p, length: topLayerLength})
 
@@ -813,6 +846,38 @@ func (f *FlowLayer) GetFieldInt64(field string) (int64, error) {
 	return 0, common.ErrFieldNotFound
 }
 
+// GetStringField returns the value of an encapsulation field
+func (e *EncapLayer) GetStringField(field string) (string, error) {
+	if e == nil {
+		return "", common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "Type":
+		return e.Type, nil
+	default:
+		return "", common.ErrFieldNotFound
+	}
+}
+
+// GetFieldInt64 returns the value of an encapsulation field
+func (e *EncapLayer) GetFieldInt64(field string) (int64, error) {
+	if e == nil {
+		return 0, common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "VNI":
+		return e.VNI, nil
+	case "SPI":
+		return e.SPI, nil
+	case "SI":
+		return e.SI, nil
+	default:
+		return 0, common.ErrFieldNotFound
+	}
+}
+
 // GetStringField returns the value of a ICMP field
 func (i *ICMPLayer) GetStringField(field string) (string, error) {
 	if i == nil {
@@ -947,6 +1012,8 @@ func (f *Flow) GetFieldString(field string) (string, error) {
 		return f.Network.GetStringField(fields[1])
 	case "ICMP":
 		return f.ICMP.GetStringField(fields[1])
+	case "Encap":
+		return f.Encap.GetStringField(fields[1])
 	case "Transport":
 		return f.Transport.GetStringField(fields[1])
 	case "UDPPORT", "TCPPORT", "SCTPPORT":
@@ -992,6 +1059,8 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		return f.Network.GetFieldInt64(fields[1])
 	case "ICMP":
 		return f.ICMP.GetFieldInt64(fields[1])
+	case "Encap":
+		return f.Encap.GetFieldInt64(fields[1])
 	case "Transport":
 		return f.Transport.GetFieldInt64(fields[1])
 	case "RawPacketsCaptured":
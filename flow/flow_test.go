@@ -23,6 +23,7 @@
 package flow
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -164,6 +165,26 @@ func compareFlowLayer(expected, tested *FlowLayer) bool {
 	return expected.Protocol == tested.Protocol && expected.A == tested.A && expected.B == tested.B && expected.ID == tested.ID
 }
 
+func compareEncapLayer(expected, tested *EncapLayer) bool {
+	if tested == nil {
+		return false
+	}
+
+	if expected.Type != tested.Type || expected.VNI != tested.VNI || expected.SPI != tested.SPI || expected.SI != tested.SI {
+		return false
+	}
+
+	if len(expected.Options) != len(tested.Options) {
+		return false
+	}
+	for i, opt := range expected.Options {
+		if opt.Class != tested.Options[i].Class || opt.Type != tested.Options[i].Type || !bytes.Equal(opt.Data, tested.Options[i].Data) {
+			return false
+		}
+	}
+	return true
+}
+
 func compareFlowMetric(expected, tested *FlowMetric) bool {
 	if tested == nil {
 		return false
@@ -195,6 +216,9 @@ func compareFlow(expected, tested *Flow) bool {
 	if expected.Transport != nil && !compareFlowLayer(expected.Transport, tested.Transport) {
 		return false
 	}
+	if expected.Encap != nil && !compareEncapLayer(expected.Encap, tested.Encap) {
+		return false
+	}
 	if expected.Metric != nil && !compareFlowMetric(expected.Metric, tested.Metric) {
 		return false
 	}
@@ -787,6 +811,120 @@ func TestGREMPLS(t *testing.T) {
 	validatePCAP(t, "pcaptraces/gre-mpls-icmpv4.pcap", layers.LinkTypeEthernet, nil, expected)
 }
 
+func TestGeneveOptions(t *testing.T) {
+	expected := []*Flow{
+		{
+			LayersPath:  "Ethernet/IPv4/UDP/Geneve",
+			Application: "Geneve",
+			Network: &FlowLayer{
+				Protocol: FlowProtocol_IPV4,
+				A:        "192.168.1.1",
+				B:        "192.168.1.2",
+				ID:       42,
+			},
+			Encap: &EncapLayer{
+				Type: "Geneve",
+				VNI:  42,
+				Options: []*GeneveOption{
+					{Class: 0x0102, Type: 0x80, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+				},
+			},
+			Metric: &FlowMetric{
+				ABPackets: 1,
+				ABBytes:   118,
+				BAPackets: 1,
+				BABytes:   118,
+			},
+		},
+		{
+			LayersPath:  "Ethernet/IPv4/ICMPv4",
+			Application: "ICMPv4",
+			Network: &FlowLayer{
+				Protocol: FlowProtocol_IPV4,
+				A:        "10.0.0.1",
+				B:        "10.0.0.2",
+			},
+			Metric: &FlowMetric{
+				ABPackets: 1,
+				ABBytes:   60,
+				BAPackets: 1,
+				BABytes:   60,
+			},
+		},
+	}
+
+	validatePCAP(t, "pcaptraces/geneve-options-icmpv4.pcap", layers.LinkTypeEthernet, nil, expected)
+}
+
+func TestVXLANGPE(t *testing.T) {
+	expected := []*Flow{
+		{
+			LayersPath:  "Ethernet/IPv4/UDP/VXLANGPE",
+			Application: "VXLANGPE",
+			Network: &FlowLayer{
+				Protocol: FlowProtocol_IPV4,
+				A:        "192.168.1.1",
+				B:        "192.168.1.2",
+				ID:       100,
+			},
+			Encap: &EncapLayer{
+				Type: "VXLANGPE",
+				VNI:  100,
+			},
+		},
+		{
+			LayersPath:  "IPv4/ICMPv4",
+			Application: "ICMPv4",
+			Network: &FlowLayer{
+				Protocol: FlowProtocol_IPV4,
+				A:        "10.0.0.1",
+				B:        "10.0.0.2",
+			},
+			Metric: &FlowMetric{
+				ABPackets: 1,
+				ABBytes:   46,
+				BAPackets: 1,
+				BABytes:   46,
+			},
+		},
+	}
+
+	validatePCAP(t, "pcaptraces/vxlan-gpe-icmpv4.pcap", layers.LinkTypeEthernet, nil, expected)
+}
+
+func TestVXLANGPENSH(t *testing.T) {
+	flows := flowsFromPCAP(t, "pcaptraces/vxlan-gpe-nsh-icmpv4.pcap", layers.LinkTypeEthernet, nil)
+	if len(flows) != 2 {
+		t.Fatalf("Expected 2 flows, got %d", len(flows))
+	}
+
+	outer, inner := flows[0], flows[1]
+	if outer.ParentUUID != "" {
+		outer, inner = inner, outer
+	}
+
+	if outer.LayersPath != "Ethernet/IPv4/UDP/VXLANGPE/NSH" {
+		t.Errorf("Wrong outer layers path: %s", outer.LayersPath)
+	}
+	if inner.LayersPath != "Ethernet/IPv4/ICMPv4" {
+		t.Errorf("Wrong inner layers path: %s", inner.LayersPath)
+	}
+	if inner.ParentUUID != outer.UUID {
+		t.Errorf("Inner flow should be linked to the outer one, got %s", inner.ParentUUID)
+	}
+
+	expected := &EncapLayer{Type: "NSH", VNI: 200, SPI: 0x123, SI: 254}
+	if !compareEncapLayer(expected, outer.Encap) {
+		t.Errorf("Wrong encapsulation layer, expected %+v, got %+v", expected, outer.Encap)
+	}
+	if spi, err := outer.GetFieldInt64("Encap.SPI"); err != nil || spi != 0x123 {
+		t.Errorf("Wrong SPI field: %d, %v", spi, err)
+	}
+	if inner.Metric.ABPackets != 1 || inner.Metric.BAPackets != 1 {
+		t.Errorf("Wrong inner metric: %+v", inner.Metric)
+	}
+}
+
 func TestFlowSimpleSynFin(t *testing.T) {
 	flows := flowsFromPCAP(t, "pcaptraces/simple-tcpv4.pcap", layers.LinkTypeEthernet, nil)
 	// In test pcap SYNs happen at 2017-03-21 10:58:23.768977 +0200 IST
@@ -43,16 +43,17 @@ import (
 // OnDemandProbeClient describes an ondemand probe client based on a websocket
 type OnDemandProbeClient struct {
 	sync.RWMutex
-	*etcd.EtcdMasterElector
+	etcd.MasterElector
 	graph.DefaultGraphListener
 	graph            *graph.Graph
-	captureHandler   *api.CaptureAPIHandler
+	captureHandler   api.Handler
 	agentPool        shttp.WSJSONSpeakerPool
 	subscriberPool   shttp.WSJSONSpeakerPool
 	captures         map[string]*types.Capture
 	watcher          api.StoppableWatcher
 	registeredNodes  map[string]string
 	deletedNodeCache *cache.Cache
+	state            etcd.State
 }
 
 type nodeProbe struct {
@@ -77,6 +78,7 @@ func (o *OnDemandProbeClient) OnWSJSONMessage(c shttp.WSSpeaker, m *shttp.WSJSON
 			o.Lock()
 			delete(o.registeredNodes, query.NodeID)
 			o.Unlock()
+			o.state.Delete(query.NodeID)
 		} else {
 			logging.GetLogger().Debugf("Capture start request succeeded %v", m)
 		}
@@ -87,6 +89,7 @@ func (o *OnDemandProbeClient) OnWSJSONMessage(c shttp.WSSpeaker, m *shttp.WSJSON
 			o.Lock()
 			delete(o.registeredNodes, query.NodeID)
 			o.Unlock()
+			o.state.Delete(query.NodeID)
 		} else {
 			logging.GetLogger().Debugf("Capture stop request failed %v", m)
 		}
@@ -160,6 +163,7 @@ func (o *OnDemandProbeClient) registerProbe(np nodeProbe) bool {
 	o.Lock()
 	o.registeredNodes[np.id] = cq.Capture.ID()
 	o.Unlock()
+	o.state.Set(np.id, cq.Capture.ID())
 
 	return true
 }
@@ -316,8 +320,84 @@ func (o *OnDemandProbeClient) onCaptureDeleted(capture *types.Capture) {
 	o.unregisterCapture(capture)
 }
 
+// loadRegistrations restores the registrations made by the previous master
+// so that the captures are not registered again. The registrations of the
+// captures deleted in the meantime are unregistered.
+func (o *OnDemandProbeClient) loadRegistrations() {
+	values, err := o.state.List()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to restore the capture registrations: %s", err.Error())
+		return
+	}
+
+	registeredNodes := make(map[string]string)
+	for nodeID, value := range values {
+		var captureID string
+		if err := json.Unmarshal(value, &captureID); err != nil {
+			logging.GetLogger().Errorf("Invalid capture registration for node %s: %s", nodeID, err.Error())
+			continue
+		}
+		registeredNodes[nodeID] = captureID
+	}
+
+	captures := make(map[string]bool)
+	for _, resource := range o.captureHandler.Index() {
+		captures[resource.ID()] = true
+	}
+
+	o.Lock()
+	o.registeredNodes = registeredNodes
+	o.Unlock()
+
+	logging.GetLogger().Infof("Resuming with %d capture registrations", len(registeredNodes))
+
+	o.graph.RLock()
+	defer o.graph.RUnlock()
+
+	for nodeID, captureID := range registeredNodes {
+		if captures[captureID] {
+			continue
+		}
+
+		if node := o.graph.GetNode(graph.Identifier(nodeID)); node != nil && node.Metadata()["Capture"] != nil {
+			logging.GetLogger().Debugf("Unregister deleted capture for node %s: %s", nodeID, captureID)
+			go o.unregisterProbe(node, &types.Capture{UUID: captureID})
+		} else {
+			o.Lock()
+			delete(o.registeredNodes, nodeID)
+			o.Unlock()
+			o.state.Delete(nodeID)
+		}
+	}
+}
+
+// Status returns the master of the captures and the capture registered on
+// each node
+func (o *OnDemandProbeClient) Status() types.ElectionStatus {
+	status := types.ElectionStatus{
+		IsMaster: o.IsMaster(),
+		Master:   o.GetMaster(),
+	}
+
+	values, err := o.state.List()
+	if err != nil {
+		return status
+	}
+
+	status.State = make(map[string]string)
+	for nodeID, value := range values {
+		var captureID string
+		if err := json.Unmarshal(value, &captureID); err == nil {
+			status.State[nodeID] = captureID
+		}
+	}
+
+	return status
+}
+
 // OnStartAsMaster event
 func (o *OnDemandProbeClient) OnStartAsMaster() {
+	o.loadRegistrations()
 }
 
 // OnStartAsSlave event
@@ -326,6 +406,8 @@ func (o *OnDemandProbeClient) OnStartAsSlave() {
 
 // OnSwitchToMaster event
 func (o *OnDemandProbeClient) OnSwitchToMaster() {
+	o.loadRegistrations()
+
 	// try to delete recently added capture to handle case where the api got a delete but wasn't yet master
 	for _, item := range o.deletedNodeCache.Items() {
 		capture := item.Object.(*types.Capture)
@@ -357,7 +439,7 @@ func (o *OnDemandProbeClient) onAPIWatcherEvent(action string, id string, resour
 
 // Start the probe
 func (o *OnDemandProbeClient) Start() {
-	o.EtcdMasterElector.StartAndWait()
+	o.MasterElector.StartAndWait()
 
 	o.watcher = o.captureHandler.AsyncWatch(o.onAPIWatcherEvent)
 	o.graph.AddEventListener(o)
@@ -366,28 +448,27 @@ func (o *OnDemandProbeClient) Start() {
 // Stop the probe
 func (o *OnDemandProbeClient) Stop() {
 	o.watcher.Stop()
-	o.EtcdMasterElector.Stop()
+	o.MasterElector.Stop()
+	o.state.Stop()
 }
 
-// NewOnDemandProbeClient creates a new ondemand probe client based on Capture API, graph and websocket
-func NewOnDemandProbeClient(g *graph.Graph, ch *api.CaptureAPIHandler, agentPool shttp.WSJSONSpeakerPool, subscriberPool shttp.WSJSONSpeakerPool, etcdClient *etcd.EtcdClient) *OnDemandProbeClient {
+func newOnDemandProbeClient(g *graph.Graph, ch api.Handler, agentPool shttp.WSJSONSpeakerPool, subscriberPool shttp.WSJSONSpeakerPool, elector etcd.MasterElector, state etcd.State) *OnDemandProbeClient {
 	resources := ch.Index()
 	captures := make(map[string]*types.Capture)
 	for _, resource := range resources {
 		captures[resource.ID()] = resource.(*types.Capture)
 	}
 
-	elector := etcd.NewEtcdMasterElectorFromConfig(common.AnalyzerService, "ondemand-client", etcdClient)
-
 	o := &OnDemandProbeClient{
-		EtcdMasterElector: elector,
-		graph:             g,
-		captureHandler:    ch,
-		agentPool:         agentPool,
-		subscriberPool:    subscriberPool,
-		captures:          captures,
-		registeredNodes:   make(map[string]string),
-		deletedNodeCache:  cache.New(elector.TTL()*2, elector.TTL()*2),
+		MasterElector:    elector,
+		graph:            g,
+		captureHandler:   ch,
+		agentPool:        agentPool,
+		subscriberPool:   subscriberPool,
+		captures:         captures,
+		registeredNodes:  make(map[string]string),
+		deletedNodeCache: cache.New(elector.TTL()*2, elector.TTL()*2),
+		state:            state,
 	}
 
 	elector.AddEventListener(o)
@@ -395,3 +476,11 @@ func NewOnDemandProbeClient(g *graph.Graph, ch *api.CaptureAPIHandler, agentPool
 
 	return o
 }
+
+// NewOnDemandProbeClient creates a new ondemand probe client based on Capture API, graph and websocket
+func NewOnDemandProbeClient(g *graph.Graph, ch *api.CaptureAPIHandler, agentPool shttp.WSJSONSpeakerPool, subscriberPool shttp.WSJSONSpeakerPool, etcdClient *etcd.EtcdClient) *OnDemandProbeClient {
+	elector := etcd.NewEtcdMasterElectorFromConfig(common.AnalyzerService, "ondemand-client", etcdClient)
+	state := etcd.NewEtcdState(common.AnalyzerService, "ondemand-client", etcdClient)
+
+	return newOnDemandProbeClient(g, ch, agentPool, subscriberPool, elector, state)
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/skydive-project/skydive/api/server"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/etcd"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// fakeElector lets the test decide when the client becomes the master
+type fakeElector struct {
+	sync.RWMutex
+	master    bool
+	listeners []etcd.EtcdMasterElectionListener
+}
+
+func (e *fakeElector) IsMaster() bool {
+	e.RLock()
+	defer e.RUnlock()
+	return e.master
+}
+
+func (e *fakeElector) GetMaster() string {
+	return ""
+}
+
+func (e *fakeElector) TTL() time.Duration {
+	return time.Second
+}
+
+func (e *fakeElector) Start() {
+}
+
+func (e *fakeElector) StartAndWait() {
+}
+
+func (e *fakeElector) Stop() {
+}
+
+func (e *fakeElector) AddEventListener(listener etcd.EtcdMasterElectionListener) {
+	e.listeners = append(e.listeners, listener)
+}
+
+func (e *fakeElector) startAsMaster() {
+	e.Lock()
+	e.master = true
+	e.Unlock()
+
+	for _, listener := range e.listeners {
+		listener.OnStartAsMaster()
+	}
+}
+
+func (e *fakeElector) switchToMaster() {
+	e.Lock()
+	e.master = true
+	e.Unlock()
+
+	for _, listener := range e.listeners {
+		listener.OnSwitchToMaster()
+	}
+}
+
+// fakeState is an in-memory state shared by the successive masters
+type fakeState struct {
+	sync.Mutex
+	values map[string]json.RawMessage
+}
+
+func (s *fakeState) Set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.values[key] = json.RawMessage(data)
+	s.Unlock()
+	return nil
+}
+
+func (s *fakeState) Delete(key string) {
+	s.Lock()
+	delete(s.values, key)
+	s.Unlock()
+}
+
+func (s *fakeState) List() (map[string]json.RawMessage, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	values := make(map[string]json.RawMessage, len(s.values))
+	for key, value := range s.values {
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (s *fakeState) Stop() {
+}
+
+func (s *fakeState) get(key string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	value, found := s.values[key]
+	return string(value), found
+}
+
+// fakePool counts the messages sent to the agents by type
+type fakePool struct {
+	shttp.WSJSONSpeakerPool
+	sync.Mutex
+	sent map[string]int
+}
+
+func (p *fakePool) AddJSONMessageHandler(h shttp.WSSpeakerJSONMessageHandler, namespaces []string) {
+}
+
+func (p *fakePool) BroadcastMessage(m shttp.WSMessage) {
+}
+
+func (p *fakePool) SendMessageTo(m shttp.WSMessage, host string) error {
+	p.Lock()
+	p.sent[m.(*shttp.WSJSONMessage).Type]++
+	p.Unlock()
+	return nil
+}
+
+func (p *fakePool) count(kind string) int {
+	p.Lock()
+	defer p.Unlock()
+	return p.sent[kind]
+}
+
+// fakeCaptureHandler returns the captures defined by the test
+type fakeCaptureHandler struct {
+	api.Handler
+	captures map[string]types.Resource
+}
+
+func (h *fakeCaptureHandler) Index() map[string]types.Resource {
+	return h.captures
+}
+
+func newTestClient(g *graph.Graph, handler api.Handler, state etcd.State) (*OnDemandProbeClient, *fakeElector, *fakePool) {
+	elector := &fakeElector{}
+	agents := &fakePool{sent: make(map[string]int)}
+	return newOnDemandProbeClient(g, handler, agents, &fakePool{sent: make(map[string]int)}, elector, state), elector, agents
+}
+
+func newTestGraph(t *testing.T) *graph.Graph {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph.NewGraphFromConfig(b)
+}
+
+func TestOnDemandHandover(t *testing.T) {
+	g := newTestGraph(t)
+
+	g.Lock()
+	node := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "Type": "device"}, "host1")
+	g.Unlock()
+
+	capture := &types.Capture{UUID: "capture1", GremlinQuery: `G.V().Has("Name", "eth0")`}
+	handler := &fakeCaptureHandler{captures: map[string]types.Resource{capture.UUID: capture}}
+	state := &fakeState{values: make(map[string]json.RawMessage)}
+
+	master, elector, agents := newTestClient(g, handler, state)
+	elector.startAsMaster()
+	master.onCaptureAdded(capture)
+
+	err := common.Retry(func() error {
+		if value, _ := state.get(string(node.ID)); value != `"capture1"` {
+			return fmt.Errorf("Registration not persisted: %s", value)
+		}
+		return nil
+	}, 50, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if agents.count("CaptureStart") != 1 {
+		t.Fatalf("Expected one capture request, got %d", agents.count("CaptureStart"))
+	}
+
+	// the new master gets the captures from the API when switching, the
+	// registrations of the previous master have to be restored before
+	next, elector, agents := newTestClient(g, handler, state)
+	elector.switchToMaster()
+
+	// the registrations are done in background
+	time.Sleep(500 * time.Millisecond)
+
+	if n := agents.count("CaptureStart"); n != 0 {
+		t.Errorf("Capture registered again by the new master, %d requests", n)
+	}
+
+	next.RLock()
+	captureID := next.registeredNodes[string(node.ID)]
+	next.RUnlock()
+
+	if captureID != "capture1" {
+		t.Errorf("Expected the registration to be restored, got: %s", captureID)
+	}
+}
+
+func TestOnDemandHandoverDeletedCapture(t *testing.T) {
+	g := newTestGraph(t)
+
+	g.Lock()
+	node := g.NewNode(graph.GenID(), graph.Metadata{
+		"Name":    "eth0",
+		"Type":    "device",
+		"Capture": map[string]interface{}{"ID": "capture1"},
+	}, "host1")
+	g.Unlock()
+
+	// the capture was deleted while there was no master
+	handler := &fakeCaptureHandler{captures: map[string]types.Resource{}}
+	state := &fakeState{values: make(map[string]json.RawMessage)}
+	state.Set(string(node.ID), "capture1")
+	state.Set("unknown", "capture1")
+
+	_, elector, agents := newTestClient(g, handler, state)
+	elector.switchToMaster()
+
+	err := common.Retry(func() error {
+		if agents.count("CaptureStop") != 1 {
+			return fmt.Errorf("Expected one stop request, got %d", agents.count("CaptureStop"))
+		}
+		return nil
+	}, 50, 100*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, found := state.get("unknown"); found {
+		t.Errorf("Expected the registration of a missing node to be removed")
+	}
+}
@@ -462,6 +462,28 @@ func (h *TPacket) WritePacketData(pkt []byte) error {
 	return err
 }
 
+// AddMulticastMembership makes the interface the socket is bound to deliver
+// the frames sent to the given link layer multicast address
+func (h *TPacket) AddMulticastMembership(ifaceName string, addr net.HardwareAddr) error {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("InterfaceByName: %v", err)
+	}
+
+	var mreq C.struct_packet_mreq
+	mreq.mr_ifindex = C.int(iface.Index)
+	mreq.mr_type = C.PACKET_MR_MULTICAST
+	mreq.mr_alen = C.ushort(len(addr))
+	for i, b := range addr {
+		mreq.mr_address[i] = C.uchar(b)
+	}
+
+	if _, err := C.setsockopt(h.fd, C.SOL_PACKET, C.PACKET_ADD_MEMBERSHIP, unsafe.Pointer(&mreq), C.socklen_t(unsafe.Sizeof(mreq))); err != nil {
+		return fmt.Errorf("setsockopt packet_add_membership: %v", err)
+	}
+	return nil
+}
+
 // SetBPF attaches a BPF filter to the underlying socket
 func (h *TPacket) SetBPF(filter []bpf.RawInstruction) error {
 	var p unix.SockFprog
@@ -0,0 +1,735 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// constants coming from include/uapi/linux/netfilter/nfnetlink.h and
+// include/uapi/linux/netfilter/nfnetlink_conntrack.h
+const (
+	nfnlSubsysCtnetlink = 1
+
+	ipctnlMsgCtNew    = 0
+	ipctnlMsgCtGet    = 1
+	ipctnlMsgCtDelete = 2
+
+	nfnlgrpConntrackNew     = 1
+	nfnlgrpConntrackUpdate  = 2
+	nfnlgrpConntrackDestroy = 3
+
+	ctaTupleOrig     = 1
+	ctaTupleReply    = 2
+	ctaStatus        = 3
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+	ctaID            = 12
+	ctaTimestamp     = 20
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPV4Src = 1
+	ctaIPV4Dst = 2
+	ctaIPV6Src = 3
+	ctaIPV6Dst = 4
+
+	ctaProtoNum        = 1
+	ctaProtoSrcPort    = 2
+	ctaProtoDstPort    = 3
+	ctaProtoICMPID     = 4
+	ctaProtoICMPType   = 5
+	ctaProtoICMPCode   = 6
+	ctaProtoICMPV6ID   = 7
+	ctaProtoICMPV6Type = 8
+	ctaProtoICMPV6Code = 9
+
+	ctaCountersPackets   = 1
+	ctaCountersBytes     = 2
+	ctaCounters32Packets = 3
+	ctaCounters32Bytes   = 4
+
+	ctaTimestampStart = 1
+
+	nlaTypeMask = 0x3fff
+
+	conntrackRecvTimeout = time.Second
+	conntrackRecvBuffer  = 4 * 1024 * 1024
+)
+
+var errConntrackMessage = errors.New("malformed conntrack message")
+
+// conntrackTuple describes one direction of a conntrack entry
+type conntrackTuple struct {
+	SrcIP    net.IP
+	DstIP    net.IP
+	Protocol uint8
+	SrcPort  uint16
+	DstPort  uint16
+	ICMPID   uint16
+	ICMPType uint8
+	ICMPCode uint8
+}
+
+// conntrackCounters holds the accounting of one direction, only reported
+// when nf_conntrack_acct is enabled
+type conntrackCounters struct {
+	Packets int64
+	Bytes   int64
+}
+
+// conntrackEntry is the decoded form of a ctnetlink message
+type conntrackEntry struct {
+	ID            uint32
+	Status        uint32
+	Start         int64
+	Orig          conntrackTuple
+	Reply         conntrackTuple
+	OrigCounters  conntrackCounters
+	ReplyCounters conntrackCounters
+}
+
+// conntrackState keeps what has to be stable between two events of a same
+// conntrack entry so that the flow UUIDs do not change
+type conntrackState struct {
+	start int64
+}
+
+// ConntrackProbe reports the connections tracked by the kernel of a network
+// namespace as flows
+type ConntrackProbe struct {
+	probeNodeTID string
+	fd           int
+	flowTable    *flow.Table
+	entries      map[string]*conntrackState
+	seen         map[string]bool
+	dumpInterval time.Duration
+	state        int64
+}
+
+// ConntrackProbesHandler describes a conntrack flow probe in the graph
+type ConntrackProbesHandler struct {
+	graph        *graph.Graph
+	probes       map[graph.Identifier]*ConntrackProbe
+	probesLock   sync.RWMutex
+	fpta         *FlowProbeTableAllocator
+	wg           sync.WaitGroup
+	accounting   bool
+	dumpInterval time.Duration
+}
+
+func parseNfAttrs(b []byte) (map[uint16][]byte, error) {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofRtAttr {
+		l := int(nl.NativeEndian().Uint16(b[0:2]))
+		t := nl.NativeEndian().Uint16(b[2:4])
+		if l < syscall.SizeofRtAttr || l > len(b) {
+			return nil, errConntrackMessage
+		}
+		attrs[t&nlaTypeMask] = b[syscall.SizeofRtAttr:l]
+
+		l = (l + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return attrs, nil
+}
+
+func parseConntrackTuple(b []byte, tuple *conntrackTuple) error {
+	attrs, err := parseNfAttrs(b)
+	if err != nil {
+		return err
+	}
+
+	if data, ok := attrs[ctaTupleIP]; ok {
+		ips, err := parseNfAttrs(data)
+		if err != nil {
+			return err
+		}
+		for t, v := range ips {
+			switch t {
+			case ctaIPV4Src, ctaIPV6Src:
+				tuple.SrcIP = net.IP(v)
+			case ctaIPV4Dst, ctaIPV6Dst:
+				tuple.DstIP = net.IP(v)
+			}
+		}
+	}
+
+	if data, ok := attrs[ctaTupleProto]; ok {
+		protos, err := parseNfAttrs(data)
+		if err != nil {
+			return err
+		}
+		for t, v := range protos {
+			switch t {
+			case ctaProtoNum, ctaProtoICMPType, ctaProtoICMPCode, ctaProtoICMPV6Type, ctaProtoICMPV6Code:
+				if len(v) < 1 {
+					return errConntrackMessage
+				}
+			case ctaProtoSrcPort, ctaProtoDstPort, ctaProtoICMPID, ctaProtoICMPV6ID:
+				if len(v) < 2 {
+					return errConntrackMessage
+				}
+			}
+
+			switch t {
+			case ctaProtoNum:
+				tuple.Protocol = v[0]
+			case ctaProtoSrcPort:
+				tuple.SrcPort = binary.BigEndian.Uint16(v)
+			case ctaProtoDstPort:
+				tuple.DstPort = binary.BigEndian.Uint16(v)
+			case ctaProtoICMPID, ctaProtoICMPV6ID:
+				tuple.ICMPID = binary.BigEndian.Uint16(v)
+			case ctaProtoICMPType, ctaProtoICMPV6Type:
+				tuple.ICMPType = v[0]
+			case ctaProtoICMPCode, ctaProtoICMPV6Code:
+				tuple.ICMPCode = v[0]
+			}
+		}
+	}
+
+	if tuple.SrcIP == nil || tuple.DstIP == nil {
+		return errConntrackMessage
+	}
+
+	return nil
+}
+
+func parseConntrackCounters(b []byte, counters *conntrackCounters) error {
+	attrs, err := parseNfAttrs(b)
+	if err != nil {
+		return err
+	}
+
+	for t, v := range attrs {
+		switch t {
+		case ctaCountersPackets, ctaCountersBytes:
+			if len(v) < 8 {
+				return errConntrackMessage
+			}
+			value := int64(binary.BigEndian.Uint64(v))
+			if t == ctaCountersPackets {
+				counters.Packets = value
+			} else {
+				counters.Bytes = value
+			}
+		case ctaCounters32Packets, ctaCounters32Bytes:
+			if len(v) < 4 {
+				return errConntrackMessage
+			}
+			value := int64(binary.BigEndian.Uint32(v))
+			if t == ctaCounters32Packets {
+				counters.Packets = value
+			} else {
+				counters.Bytes = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseConntrackEntry decodes the payload, nfgenmsg header included, of a
+// ctnetlink message
+func parseConntrackEntry(b []byte) (*conntrackEntry, error) {
+	if len(b) < nl.SizeofNfgenmsg {
+		return nil, errConntrackMessage
+	}
+
+	attrs, err := parseNfAttrs(b[nl.SizeofNfgenmsg:])
+	if err != nil {
+		return nil, err
+	}
+
+	orig, ok := attrs[ctaTupleOrig]
+	if !ok {
+		return nil, errConntrackMessage
+	}
+
+	reply, ok := attrs[ctaTupleReply]
+	if !ok {
+		return nil, errConntrackMessage
+	}
+
+	entry := &conntrackEntry{}
+	if err = parseConntrackTuple(orig, &entry.Orig); err != nil {
+		return nil, err
+	}
+	if err = parseConntrackTuple(reply, &entry.Reply); err != nil {
+		return nil, err
+	}
+
+	if v, ok := attrs[ctaID]; ok && len(v) >= 4 {
+		entry.ID = binary.BigEndian.Uint32(v)
+	}
+
+	if v, ok := attrs[ctaStatus]; ok && len(v) >= 4 {
+		entry.Status = binary.BigEndian.Uint32(v)
+	}
+
+	if v, ok := attrs[ctaCountersOrig]; ok {
+		if err = parseConntrackCounters(v, &entry.OrigCounters); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := attrs[ctaCountersReply]; ok {
+		if err = parseConntrackCounters(v, &entry.ReplyCounters); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := attrs[ctaTimestamp]; ok {
+		ts, err := parseNfAttrs(v)
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := ts[ctaTimestampStart]; ok && len(start) >= 8 {
+			entry.Start = int64(binary.BigEndian.Uint64(start)) / int64(time.Millisecond)
+		}
+	}
+
+	return entry, nil
+}
+
+// key returns a string identifying the conntrack entry, the kernel ID being
+// not reported by all the kernels
+func (e *conntrackEntry) key() string {
+	return fmt.Sprintf("%d-%s", e.ID, e.Orig.String())
+}
+
+// nat returns the tuple of the original direction once translated, nil if
+// the connection is not translated
+func (e *conntrackEntry) nat() *conntrackTuple {
+	nat := &conntrackTuple{
+		SrcIP:    e.Reply.DstIP,
+		DstIP:    e.Reply.SrcIP,
+		Protocol: e.Reply.Protocol,
+		SrcPort:  e.Reply.DstPort,
+		DstPort:  e.Reply.SrcPort,
+		ICMPID:   e.Reply.ICMPID,
+		ICMPType: e.Orig.ICMPType,
+		ICMPCode: e.Orig.ICMPCode,
+	}
+
+	if nat.String() == e.Orig.String() {
+		return nil
+	}
+	return nat
+}
+
+func (t *conntrackTuple) String() string {
+	return fmt.Sprintf("%d %s:%d %s:%d %d", t.Protocol, t.SrcIP, t.SrcPort, t.DstIP, t.DstPort, t.ICMPID)
+}
+
+func (p *ConntrackProbe) newFlow(key string, tuple *conntrackTuple, entry *conntrackEntry, start, now int64, parentUUID string) *flow.Flow {
+	f := flow.NewFlow()
+	f.Init(start, p.probeNodeTID, flow.FlowUUIDs{ParentUUID: parentUUID})
+	f.Last = now
+
+	ipv4 := tuple.SrcIP.To4() != nil
+	if ipv4 {
+		f.Network = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_IPV4,
+			A:        tuple.SrcIP.To4().String(),
+			B:        tuple.DstIP.To4().String(),
+		}
+		f.LayersPath = "IPv4"
+	} else {
+		f.Network = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_IPV6,
+			A:        tuple.SrcIP.String(),
+			B:        tuple.DstIP.String(),
+		}
+		f.LayersPath = "IPv6"
+	}
+
+	switch tuple.Protocol {
+	case syscall.IPPROTO_TCP:
+		f.Transport = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_TCPPORT,
+			A:        strconv.FormatUint(uint64(tuple.SrcPort), 10),
+			B:        strconv.FormatUint(uint64(tuple.DstPort), 10),
+		}
+		f.LayersPath += "/TCP"
+	case syscall.IPPROTO_UDP:
+		f.Transport = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_UDPPORT,
+			A:        strconv.FormatUint(uint64(tuple.SrcPort), 10),
+			B:        strconv.FormatUint(uint64(tuple.DstPort), 10),
+		}
+		f.LayersPath += "/UDP"
+	case syscall.IPPROTO_SCTP:
+		f.Transport = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_SCTPPORT,
+			A:        strconv.FormatUint(uint64(tuple.SrcPort), 10),
+			B:        strconv.FormatUint(uint64(tuple.DstPort), 10),
+		}
+		f.LayersPath += "/SCTP"
+	case syscall.IPPROTO_ICMP:
+		f.ICMP = &flow.ICMPLayer{
+			Type: flow.ICMPV4TypeToFlowICMPType(tuple.ICMPType),
+			Code: uint32(tuple.ICMPCode),
+			ID:   uint32(tuple.ICMPID),
+		}
+		f.LayersPath += "/ICMPv4"
+	case syscall.IPPROTO_ICMPV6:
+		f.ICMP = &flow.ICMPLayer{
+			Type: flow.ICMPV6TypeToFlowICMPType(tuple.ICMPType),
+			Code: uint32(tuple.ICMPCode),
+			ID:   uint32(tuple.ICMPID),
+		}
+		f.LayersPath += "/ICMPv6"
+	}
+
+	appLayers := strings.Split(f.LayersPath, "/")
+	f.Application = appLayers[len(appLayers)-1]
+
+	f.Metric = &flow.FlowMetric{
+		ABPackets: entry.OrigCounters.Packets,
+		ABBytes:   entry.OrigCounters.Bytes,
+		BAPackets: entry.ReplyCounters.Packets,
+		BABytes:   entry.ReplyCounters.Bytes,
+		Start:     start,
+		Last:      now,
+	}
+
+	f.UpdateUUID(key, 0, 0)
+
+	return f
+}
+
+// flowsFromEntry returns the flow of the original tuple and, for translated
+// connections, the flow of the translated tuple having the first one as parent
+func (p *ConntrackProbe) flowsFromEntry(entry *conntrackEntry, msgType uint16, now int64) []*flow.Flow {
+	key := entry.key()
+
+	state, ok := p.entries[key]
+	if !ok {
+		start := now
+		if entry.Start != 0 {
+			start = entry.Start
+		}
+		state = &conntrackState{start: start}
+		p.entries[key] = state
+	}
+
+	if p.seen != nil {
+		p.seen[key] = true
+	}
+
+	if msgType == ipctnlMsgCtDelete {
+		delete(p.entries, key)
+	}
+
+	orig := p.newFlow(key, &entry.Orig, entry, state.start, now, "")
+	flows := []*flow.Flow{orig}
+
+	if nat := entry.nat(); nat != nil {
+		flows = append(flows, p.newFlow(key+"-nat", nat, entry, state.start, now, orig.UUID))
+	}
+
+	return flows
+}
+
+// handleMessage returns the flows to be updated after a ctnetlink message
+func (p *ConntrackProbe) handleMessage(msg *syscall.NetlinkMessage, now int64) []*flow.Flow {
+	switch msg.Header.Type {
+	case syscall.NLMSG_DONE:
+		p.sweep()
+		return nil
+	case syscall.NLMSG_ERROR:
+		if len(msg.Data) >= 4 {
+			if errno := int32(nl.NativeEndian().Uint32(msg.Data[0:4])); errno != 0 {
+				logging.GetLogger().Errorf("Conntrack netlink error on %s: %s", p.probeNodeTID, syscall.Errno(-errno))
+			}
+		}
+		return nil
+	}
+
+	if msg.Header.Type>>8 != nfnlSubsysCtnetlink {
+		return nil
+	}
+
+	msgType := msg.Header.Type & 0xff
+	if msgType != ipctnlMsgCtNew && msgType != ipctnlMsgCtDelete {
+		return nil
+	}
+
+	entry, err := parseConntrackEntry(msg.Data)
+	if err != nil {
+		logging.GetLogger().Debugf("Unable to parse conntrack message on %s: %s", p.probeNodeTID, err)
+		return nil
+	}
+
+	return p.flowsFromEntry(entry, msgType, now)
+}
+
+// sweep forgets the entries not reported by the dump that just completed,
+// their destroy events having been lost
+func (p *ConntrackProbe) sweep() {
+	if p.seen == nil {
+		return
+	}
+
+	for key := range p.entries {
+		if !p.seen[key] {
+			delete(p.entries, key)
+		}
+	}
+	p.seen = nil
+}
+
+// dump requests all the current conntrack entries, the replies being read
+// along with the events. As the events are only sent when the state of a
+// connection changes, the entries are dumped periodically to refresh the
+// counters of the established connections.
+func (p *ConntrackProbe) dump() error {
+	p.seen = make(map[string]bool)
+
+	req := nl.NewNetlinkRequest((nfnlSubsysCtnetlink<<8)|ipctnlMsgCtGet, syscall.NLM_F_DUMP)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: syscall.AF_UNSPEC, Version: nl.NFNETLINK_V0})
+
+	return syscall.Sendto(p.fd, req.Serialize(), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+func (p *ConntrackProbe) run() {
+	_, flowChan := p.flowTable.Start()
+	defer p.flowTable.Stop()
+
+	if err := p.dump(); err != nil {
+		logging.GetLogger().Errorf("Unable to dump conntrack entries of %s: %s", p.probeNodeTID, err)
+	}
+	lastDump := time.Now()
+
+	buf := make([]byte, 65536)
+	for atomic.LoadInt64(&p.state) == common.RunningState {
+		if p.dumpInterval > 0 && time.Since(lastDump) >= p.dumpInterval {
+			if err := p.dump(); err != nil {
+				logging.GetLogger().Errorf("Unable to dump conntrack entries of %s: %s", p.probeNodeTID, err)
+			}
+			lastDump = time.Now()
+		}
+
+		n, _, err := syscall.Recvfrom(p.fd, buf, 0)
+		if err != nil {
+			switch err {
+			case syscall.EAGAIN, syscall.EINTR:
+			case syscall.ENOBUFS:
+				logging.GetLogger().Warningf("Conntrack events lost on %s, resynchronizing", p.probeNodeTID)
+				if err := p.dump(); err != nil {
+					logging.GetLogger().Errorf("Unable to dump conntrack entries of %s: %s", p.probeNodeTID, err)
+				}
+				lastDump = time.Now()
+			default:
+				logging.GetLogger().Errorf("Failed to receive conntrack events on %s: %s", p.probeNodeTID, err)
+				return
+			}
+			continue
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			logging.GetLogger().Errorf("Failed to parse conntrack events on %s: %s", p.probeNodeTID, err)
+			continue
+		}
+
+		now := common.UnixMillis(time.Now())
+		for i := range msgs {
+			for _, f := range p.handleMessage(&msgs[i], now) {
+				flowChan <- f
+			}
+		}
+	}
+}
+
+func (p *ConntrackProbe) stop() {
+	atomic.StoreInt64(&p.state, common.StoppingState)
+}
+
+func openConntrackSocket(nsPath string, accounting bool) (int, error) {
+	if nsPath != "" {
+		context, err := common.NewNetNsContext(nsPath)
+		if err != nil {
+			return -1, err
+		}
+		defer context.Close()
+	}
+
+	// accounting is per namespace and has to be enabled to get the counters,
+	// it is only changed when explicitly requested by the configuration
+	if accounting {
+		logging.GetLogger().Infof("Enabling conntrack accounting in %s", nsPath)
+		if err := ioutil.WriteFile("/proc/sys/net/netfilter/nf_conntrack_acct", []byte("1"), 0644); err != nil {
+			logging.GetLogger().Warningf("Unable to enable conntrack accounting in %s: %s", nsPath, err)
+		}
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return -1, err
+	}
+
+	groups := uint32(1<<(nfnlgrpConntrackNew-1) | 1<<(nfnlgrpConntrackUpdate-1) | 1<<(nfnlgrpConntrackDestroy-1))
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	// a busy host generates lots of events, make room for them
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, conntrackRecvBuffer)
+
+	tv := syscall.NsecToTimeval(int64(conntrackRecvTimeout))
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+// RegisterProbe registers a conntrack probe on a netns node
+func (p *ConntrackProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capture, e FlowProbeEventHandler) error {
+	p.probesLock.RLock()
+	_, ok := p.probes[n.ID]
+	p.probesLock.RUnlock()
+	if ok {
+		return nil
+	}
+
+	tid, _ := n.GetFieldString("TID")
+	if tid == "" {
+		return fmt.Errorf("No tid for node %s", n.ID)
+	}
+
+	nsPath, _ := n.GetFieldString("Path")
+	if nsPath == "" {
+		return fmt.Errorf("No namespace path for node %s", n.ID)
+	}
+
+	fd, err := openConntrackSocket(nsPath, p.accounting)
+	if err != nil {
+		return fmt.Errorf("Unable to subscribe to conntrack events of %s: %s", nsPath, err)
+	}
+
+	ft := p.fpta.Alloc(tid, flow.TableOpts{})
+
+	probe := &ConntrackProbe{
+		probeNodeTID: tid,
+		fd:           fd,
+		flowTable:    ft,
+		entries:      make(map[string]*conntrackState),
+		dumpInterval: p.dumpInterval,
+		state:        common.RunningState,
+	}
+
+	p.probesLock.Lock()
+	p.probes[n.ID] = probe
+	p.probesLock.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		e.OnStarted()
+
+		probe.run()
+
+		syscall.Close(fd)
+		p.fpta.Release(ft)
+
+		e.OnStopped()
+	}()
+
+	return nil
+}
+
+func (p *ConntrackProbesHandler) unregisterProbe(id graph.Identifier) {
+	if probe, ok := p.probes[id]; ok {
+		logging.GetLogger().Debugf("Terminating conntrack capture on %s", id)
+		probe.stop()
+		delete(p.probes, id)
+	}
+}
+
+// UnregisterProbe unregisters the conntrack probe of a netns node
+func (p *ConntrackProbesHandler) UnregisterProbe(n *graph.Node, e FlowProbeEventHandler) error {
+	p.probesLock.Lock()
+	defer p.probesLock.Unlock()
+
+	p.unregisterProbe(n.ID)
+
+	return nil
+}
+
+// Start the probe
+func (p *ConntrackProbesHandler) Start() {
+}
+
+// Stop the probe
+func (p *ConntrackProbesHandler) Stop() {
+	p.probesLock.Lock()
+	for id := range p.probes {
+		p.unregisterProbe(id)
+	}
+	p.probesLock.Unlock()
+
+	p.wg.Wait()
+}
+
+// NewConntrackProbesHandler creates a new conntrack flow probe
+func NewConntrackProbesHandler(g *graph.Graph, fpta *FlowProbeTableAllocator) (*ConntrackProbesHandler, error) {
+	return &ConntrackProbesHandler{
+		graph:        g,
+		probes:       make(map[graph.Identifier]*ConntrackProbe),
+		fpta:         fpta,
+		accounting:   config.GetConfig().GetBool("agent.flow.conntrack.accounting"),
+		dumpInterval: time.Duration(config.GetConfig().GetInt("agent.flow.conntrack.dump_interval")) * time.Second,
+	}, nil
+}
@@ -0,0 +1,248 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+func nfAttr(t uint16, value []byte) []byte {
+	l := syscall.SizeofRtAttr + len(value)
+	b := make([]byte, (l+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	nl.NativeEndian().PutUint16(b[0:2], uint16(l))
+	nl.NativeEndian().PutUint16(b[2:4], t)
+	copy(b[syscall.SizeofRtAttr:], value)
+	return b
+}
+
+func nfNested(t uint16, attrs ...[]byte) []byte {
+	var value []byte
+	for _, attr := range attrs {
+		value = append(value, attr...)
+	}
+	return nfAttr(t|nl.NLA_F_NESTED, value)
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func tcpTuple(t uint16, src, dst string, sport, dport uint16) []byte {
+	return nfNested(t,
+		nfNested(ctaTupleIP,
+			nfAttr(ctaIPV4Src, net.ParseIP(src).To4()),
+			nfAttr(ctaIPV4Dst, net.ParseIP(dst).To4()),
+		),
+		nfNested(ctaTupleProto,
+			nfAttr(ctaProtoNum, []byte{syscall.IPPROTO_TCP}),
+			nfAttr(ctaProtoSrcPort, be16(sport)),
+			nfAttr(ctaProtoDstPort, be16(dport)),
+		),
+	)
+}
+
+func conntrackMessage(msgType uint16, attrs ...[]byte) *syscall.NetlinkMessage {
+	data := []byte{syscall.AF_INET, nl.NFNETLINK_V0, 0, 0}
+	for _, attr := range attrs {
+		data = append(data, attr...)
+	}
+
+	return &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: nfnlSubsysCtnetlink<<8 | msgType},
+		Data:   data,
+	}
+}
+
+func counters(t uint16, packets, bytes uint64) []byte {
+	return nfNested(t,
+		nfAttr(ctaCountersPackets, be64(packets)),
+		nfAttr(ctaCountersBytes, be64(bytes)),
+	)
+}
+
+func newTestConntrackProbe() *ConntrackProbe {
+	return &ConntrackProbe{
+		probeNodeTID: "probe-tid",
+		entries:      make(map[string]*conntrackState),
+	}
+}
+
+func TestConntrackFlow(t *testing.T) {
+	p := newTestConntrackProbe()
+
+	msg := conntrackMessage(ipctnlMsgCtNew,
+		tcpTuple(ctaTupleOrig, "192.168.0.1", "192.168.0.2", 40000, 80),
+		tcpTuple(ctaTupleReply, "192.168.0.2", "192.168.0.1", 80, 40000),
+		nfAttr(ctaID, be32(42)),
+		counters(ctaCountersOrig, 3, 180),
+		counters(ctaCountersReply, 2, 120),
+	)
+
+	flows := p.handleMessage(msg, 1000)
+	if len(flows) != 1 {
+		t.Fatalf("Expected one flow, got: %v", flows)
+	}
+
+	f := flows[0]
+	if f.LayersPath != "IPv4/TCP" || f.Application != "TCP" {
+		t.Errorf("Wrong layers path: %s", f.LayersPath)
+	}
+	if f.Network.A != "192.168.0.1" || f.Network.B != "192.168.0.2" {
+		t.Errorf("Wrong network layer: %+v", f.Network)
+	}
+	if f.Transport.Protocol != flow.FlowProtocol_TCPPORT || f.Transport.A != "40000" || f.Transport.B != "80" {
+		t.Errorf("Wrong transport layer: %+v", f.Transport)
+	}
+	if f.Metric.ABPackets != 3 || f.Metric.ABBytes != 180 || f.Metric.BAPackets != 2 || f.Metric.BABytes != 120 {
+		t.Errorf("Wrong metric: %+v", f.Metric)
+	}
+	if f.NodeTID != "probe-tid" || f.Start != 1000 {
+		t.Errorf("Wrong flow: %+v", f)
+	}
+
+	// the UUID has to stay the same during the whole connection
+	msg = conntrackMessage(ipctnlMsgCtNew,
+		tcpTuple(ctaTupleOrig, "192.168.0.1", "192.168.0.2", 40000, 80),
+		tcpTuple(ctaTupleReply, "192.168.0.2", "192.168.0.1", 80, 40000),
+		nfAttr(ctaID, be32(42)),
+		counters(ctaCountersOrig, 10, 1000),
+		counters(ctaCountersReply, 8, 2000),
+	)
+
+	flows = p.handleMessage(msg, 2000)
+	if len(flows) != 1 || flows[0].UUID != f.UUID {
+		t.Fatalf("Expected the same flow, got: %v", flows)
+	}
+	if flows[0].Start != 1000 || flows[0].Last != 2000 || flows[0].Metric.BABytes != 2000 {
+		t.Errorf("Wrong updated flow: %+v", flows[0])
+	}
+
+	msg.Header.Type = nfnlSubsysCtnetlink<<8 | ipctnlMsgCtDelete
+	if flows = p.handleMessage(msg, 3000); len(flows) != 1 || flows[0].UUID != f.UUID {
+		t.Fatalf("Expected the same flow, got: %v", flows)
+	}
+	if len(p.entries) != 0 {
+		t.Errorf("Destroyed entry should be forgotten: %v", p.entries)
+	}
+}
+
+func TestConntrackNATFlow(t *testing.T) {
+	p := newTestConntrackProbe()
+
+	// 10.0.0.1:5000 -> 172.16.0.1:80 DNAT'ed to 10.0.0.2:8080 and SNAT'ed to 10.0.0.254:6000
+	msg := conntrackMessage(ipctnlMsgCtNew,
+		tcpTuple(ctaTupleOrig, "10.0.0.1", "172.16.0.1", 5000, 80),
+		tcpTuple(ctaTupleReply, "10.0.0.2", "10.0.0.254", 8080, 6000),
+		nfAttr(ctaID, be32(1)),
+	)
+
+	flows := p.handleMessage(msg, 1000)
+	if len(flows) != 2 {
+		t.Fatalf("Expected two flows, got: %v", flows)
+	}
+
+	orig, nat := flows[0], flows[1]
+	if orig.Network.A != "10.0.0.1" || orig.Network.B != "172.16.0.1" || orig.Transport.B != "80" {
+		t.Errorf("Wrong original flow: %+v", orig)
+	}
+	if nat.Network.A != "10.0.0.254" || nat.Network.B != "10.0.0.2" || nat.Transport.A != "6000" || nat.Transport.B != "8080" {
+		t.Errorf("Wrong translated flow: %+v", nat)
+	}
+	if orig.ParentUUID != "" || nat.ParentUUID != orig.UUID {
+		t.Errorf("Translated flow should have the original one as parent: %s, %s", orig.ParentUUID, nat.ParentUUID)
+	}
+	if orig.UUID == nat.UUID {
+		t.Error("Original and translated flows should have different UUIDs")
+	}
+}
+
+func TestConntrackMalformedMessage(t *testing.T) {
+	p := newTestConntrackProbe()
+
+	msg := conntrackMessage(ipctnlMsgCtNew,
+		tcpTuple(ctaTupleOrig, "10.0.0.1", "172.16.0.1", 5000, 80),
+	)
+	if flows := p.handleMessage(msg, 1000); len(flows) != 0 {
+		t.Errorf("No flow expected without reply tuple, got: %v", flows)
+	}
+
+	msg.Data = msg.Data[:len(msg.Data)-3]
+	if flows := p.handleMessage(msg, 1000); len(flows) != 0 {
+		t.Errorf("No flow expected for a truncated message, got: %v", flows)
+	}
+}
+
+func TestConntrackDumpSweep(t *testing.T) {
+	p := newTestConntrackProbe()
+
+	entry := func(id uint32, sport uint16) *syscall.NetlinkMessage {
+		return conntrackMessage(ipctnlMsgCtNew,
+			tcpTuple(ctaTupleOrig, "192.168.0.1", "192.168.0.2", sport, 80),
+			tcpTuple(ctaTupleReply, "192.168.0.2", "192.168.0.1", 80, sport),
+			nfAttr(ctaID, be32(id)),
+		)
+	}
+
+	p.handleMessage(entry(1, 40001), 1000)
+	p.handleMessage(entry(2, 40002), 1000)
+
+	// the destroy event of the second entry has been lost
+	p.seen = make(map[string]bool)
+	p.handleMessage(entry(1, 40001), 2000)
+	p.handleMessage(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.NLMSG_DONE}}, 2000)
+
+	if len(p.entries) != 1 {
+		t.Fatalf("Entries not dumped should be forgotten: %v", p.entries)
+	}
+	if p.seen != nil {
+		t.Error("The dump should be completed")
+	}
+
+	// the dumped entry keeps its start time
+	if flows := p.handleMessage(entry(1, 40001), 3000); len(flows) != 1 || flows[0].Start != 1000 {
+		t.Errorf("Wrong flow after dump: %v", flows)
+	}
+}
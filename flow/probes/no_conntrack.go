@@ -0,0 +1,57 @@
+// +build !linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// ConntrackProbesHandler describes a conntrack flow probe in the graph
+type ConntrackProbesHandler struct {
+}
+
+// RegisterProbe registers a conntrack probe on a netns node
+func (p *ConntrackProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capture, e FlowProbeEventHandler) error {
+	return nil
+}
+
+// UnregisterProbe unregisters the conntrack probe of a netns node
+func (p *ConntrackProbesHandler) UnregisterProbe(n *graph.Node, e FlowProbeEventHandler) error {
+	return nil
+}
+
+// Start the probe
+func (p *ConntrackProbesHandler) Start() {
+}
+
+// Stop the probe
+func (p *ConntrackProbesHandler) Stop() {
+}
+
+// NewConntrackProbesHandler creates a new conntrack flow probe
+func NewConntrackProbesHandler(g *graph.Graph, fpta *FlowProbeTableAllocator) (*ConntrackProbesHandler, error) {
+	return nil, ErrProbeNotCompiled
+}
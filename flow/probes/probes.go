@@ -60,7 +60,7 @@ func (a *FlowProbeTableAllocator) Alloc(nodeTID string, opts flow.TableOpts) *fl
 }
 
 func NewFlowProbeBundle(tb *probe.ProbeBundle, g *graph.Graph, fta *flow.TableAllocator, fcpool *analyzer.FlowClientPool) *probe.ProbeBundle {
-	list := []string{"pcapsocket", "ovssflow", "sflow", "gopacket", "dpdk", "ebpf", "ovsmirror"}
+	list := []string{"pcapsocket", "ovssflow", "sflow", "gopacket", "dpdk", "ebpf", "ovsmirror", "conntrack"}
 	logging.GetLogger().Infof("Flow probes: %v", list)
 
 	var captureTypes []string
@@ -103,6 +103,10 @@ func NewFlowProbeBundle(tb *probe.ProbeBundle, g *graph.Graph, fta *flow.TableAl
 			if fp, err = NewEBPFProbesHandler(g, fpta); err == nil {
 				captureTypes = []string{"ebpf"}
 			}
+		case "conntrack":
+			if fp, err = NewConntrackProbesHandler(g, fpta); err == nil {
+				captureTypes = []string{"conntrack"}
+			}
 		default:
 			err = fmt.Errorf("unknown probe type %s", t)
 		}
@@ -234,6 +234,12 @@ func (fs *FlowSet) mergeSortedFlows(left, right []*Flow, context MergeContext) (
 
 // Slice returns a slice of a FlowSet
 func (fs *FlowSet) Slice(from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to < from {
+		to = from
+	}
 	if from > len(fs.Flows) {
 		from = len(fs.Flows)
 	}
@@ -25,10 +25,13 @@ package elasticsearch
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/google/gopacket/layers"
 	"github.com/mattbaird/elastigo/lib"
+	"golang.org/x/net/context"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/filters"
@@ -219,16 +222,44 @@ func (c *ElasticSearchStorage) requestFromQuery(fsq filters.SearchQuery) (map[st
 	return request, nil
 }
 
-func (c *ElasticSearchStorage) sendRequest(docType string, request map[string]interface{}) (elastigo.SearchResult, error) {
+type searchResult struct {
+	out elastigo.SearchResult
+	err error
+}
+
+// sendRequest sends the search request, the search is abandoned as soon as
+// the context is done.
+func (c *ElasticSearchStorage) sendRequest(ctx context.Context, docType string, request map[string]interface{}) (elastigo.SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	// let ElasticSearch stop the search on its side as well
+	if deadline, ok := ctx.Deadline(); ok {
+		request["timeout"] = fmt.Sprintf("%dms", time.Until(deadline)/time.Millisecond)
+	}
+
 	q, err := json.Marshal(request)
 	if err != nil {
 		return elastigo.SearchResult{}, err
 	}
-	return c.client.Search(docType, string(q))
+
+	ch := make(chan searchResult, 1)
+	go func() {
+		out, err := c.client.Search(docType, string(q))
+		ch <- searchResult{out: out, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.out, r.err
+	case <-ctx.Done():
+		return elastigo.SearchResult{}, ctx.Err()
+	}
 }
 
 // SearchRawPackets searches flow raw packets matching filters in the database
-func (c *ElasticSearchStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
+func (c *ElasticSearchStorage) SearchRawPackets(ctx context.Context, fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
 	if !c.client.Started() {
 		return nil, errors.New("ElasticSearchStorage is not yet started")
 	}
@@ -272,7 +303,7 @@ func (c *ElasticSearchStorage) SearchRawPackets(fsq filters.SearchQuery, packetF
 		}
 	}
 
-	out, err := c.sendRequest("rawpacket", request)
+	out, err := c.sendRequest(ctx, "rawpacket", request)
 	if err != nil {
 		return nil, err
 	}
@@ -311,7 +342,7 @@ func (c *ElasticSearchStorage) SearchRawPackets(fsq filters.SearchQuery, packetF
 }
 
 // SearchMetrics searches flow metrics matching filters in the database
-func (c *ElasticSearchStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+func (c *ElasticSearchStorage) SearchMetrics(ctx context.Context, fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
 	if !c.client.Started() {
 		return nil, errors.New("ElasticSearchStorage is not yet started")
 	}
@@ -353,7 +384,7 @@ func (c *ElasticSearchStorage) SearchMetrics(fsq filters.SearchQuery, metricFilt
 		}
 	}
 
-	out, err := c.sendRequest("metric", request)
+	out, err := c.sendRequest(ctx, "metric", request)
 	if err != nil {
 		return nil, err
 	}
@@ -374,7 +405,7 @@ func (c *ElasticSearchStorage) SearchMetrics(fsq filters.SearchQuery, metricFilt
 }
 
 // SearchFlows search flow matching filters in the database
-func (c *ElasticSearchStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+func (c *ElasticSearchStorage) SearchFlows(ctx context.Context, fsq filters.SearchQuery) (*flow.FlowSet, error) {
 	if !c.client.Started() {
 		return nil, errors.New("ElasticSearchStorage is not yet started")
 	}
@@ -405,7 +436,7 @@ func (c *ElasticSearchStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowS
 		}
 	}
 
-	out, err := c.sendRequest("flow", request)
+	out, err := c.sendRequest(ctx, "flow", request)
 	if err != nil {
 		return nil, err
 	}
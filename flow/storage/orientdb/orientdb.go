@@ -36,6 +36,7 @@ import (
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/logging"
 	orient "github.com/skydive-project/skydive/storage/orientdb"
+	"golang.org/x/net/context"
 )
 
 // OrientDBStorage describes a OrientDB database client
@@ -139,6 +140,29 @@ func flowToDocument(flow *flow.Flow) orient.Document {
 		}
 	}
 
+	if flow.Encap != nil {
+		encapDoc := orient.Document{
+			"Type": flow.Encap.Type,
+			"VNI":  flow.Encap.VNI,
+			"SPI":  flow.Encap.SPI,
+			"SI":   flow.Encap.SI,
+		}
+
+		if len(flow.Encap.Options) > 0 {
+			var options []interface{}
+			for _, opt := range flow.Encap.Options {
+				options = append(options, orient.Document{
+					"Class": opt.Class,
+					"Type":  opt.Type,
+					"Data":  opt.Data,
+				})
+			}
+			encapDoc["Options"] = options
+		}
+
+		flowDoc["Encap"] = encapDoc
+	}
+
 	if flow.Transport != nil {
 		flowDoc["Transport"] = orient.Document{
 			"Protocol": flow.Transport.Protocol.String(),
@@ -232,9 +256,13 @@ func (c *OrientDBStorage) StoreFlows(flows []*flow.Flow) error {
 }
 
 // SearchFlows search flow matching filters in the database
-func (c *OrientDBStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+func (c *OrientDBStorage) SearchFlows(ctx context.Context, fsq filters.SearchQuery) (*flow.FlowSet, error) {
 	flowset := flow.NewFlowSet()
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	err := c.client.Query("Flow", &fsq, &flowset.Flows)
 	if err != nil {
 		return nil, err
@@ -250,7 +278,7 @@ func (c *OrientDBStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, e
 }
 
 // SearchMetrics searches flow raw packets matching filters in the database
-func (c *OrientDBStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
+func (c *OrientDBStorage) SearchRawPackets(ctx context.Context, fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
 	filter := fsq.Filter
 	sql := "SELECT LinkType, Timestamp, Index, Data, Flow.UUID FROM FlowRawPacket"
 
@@ -275,6 +303,10 @@ func (c *OrientDBStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	docs, err := c.client.Search(sql)
 	if err != nil {
 		return nil, err
@@ -302,7 +334,7 @@ func (c *OrientDBStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter
 }
 
 // SearchMetrics searches flow metrics matching filters in the database
-func (c *OrientDBStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+func (c *OrientDBStorage) SearchMetrics(ctx context.Context, fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
 	filter := fsq.Filter
 	sql := "SELECT ABBytes, ABPackets, BABytes, BAPackets, Start, Last, Flow.UUID FROM FlowMetric"
 	sql += " WHERE " + orient.FilterToExpression(metricFilter, nil)
@@ -317,6 +349,10 @@ func (c *OrientDBStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *f
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	docs, err := c.client.Search(sql)
 	if err != nil {
 		return nil, err
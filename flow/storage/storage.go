@@ -26,6 +26,8 @@ import (
 	"errors"
 	"fmt"
 
+	"golang.org/x/net/context"
+
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
@@ -44,9 +46,9 @@ var (
 type Storage interface {
 	Start()
 	StoreFlows(flows []*flow.Flow) error
-	SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error)
-	SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error)
-	SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error)
+	SearchFlows(ctx context.Context, fsq filters.SearchQuery) (*flow.FlowSet, error)
+	SearchMetrics(ctx context.Context, fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error)
+	SearchRawPackets(ctx context.Context, fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error)
 	Stop()
 }
 
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"errors"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/correlation"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// CorrelatedTraversalExtension describes a new extension returning the
+// flows of a same connection across the capture points
+type CorrelatedTraversalExtension struct {
+	CorrelatedToken traversal.Token
+	Correlator      *correlation.Correlator
+}
+
+// CorrelatedGremlinTraversalStep returns the end-to-end chain of the flows
+// of the previous step
+type CorrelatedGremlinTraversalStep struct {
+	context    traversal.GremlinTraversalContext
+	correlator *correlation.Correlator
+}
+
+// NewCorrelatedTraversalExtension returns a new graph traversal extension
+func NewCorrelatedTraversalExtension(correlator *correlation.Correlator) *CorrelatedTraversalExtension {
+	return &CorrelatedTraversalExtension{
+		CorrelatedToken: traversalCorrelatedToken,
+		Correlator:      correlator,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *CorrelatedTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "CORRELATED":
+		return e.CorrelatedToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse correlated step
+func (e *CorrelatedTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.CorrelatedToken:
+		return &CorrelatedGremlinTraversalStep{context: p, correlator: e.Correlator}, nil
+	}
+	return nil, nil
+}
+
+// Correlated returns the flows of the connections of the flows, including
+// the flows themselves, looking them up on the analyzer peers as well
+func (f *FlowTraversalStep) Correlated(correlator *correlation.Correlator) *FlowTraversalStep {
+	if f.error != nil {
+		return f
+	}
+
+	flowset := flow.NewFlowSet()
+
+	seen := make(map[string]bool)
+	for _, fl := range f.flowset.Flows {
+		if seen[fl.UUID] {
+			continue
+		}
+
+		// the version of the flow returned by the previous step is preferred
+		for _, c := range correlator.Lookup(fl) {
+			if !seen[c.UUID] {
+				seen[c.UUID] = true
+				flowset.Flows = append(flowset.Flows, c)
+			}
+		}
+	}
+
+	return &FlowTraversalStep{GraphTraversal: f.GraphTraversal, Storage: f.Storage, flowset: flowset}
+}
+
+// Exec executes the correlated step
+func (s *CorrelatedGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	fs, ok := last.(*FlowTraversalStep)
+	if !ok {
+		return nil, traversal.ErrExecutionError
+	}
+
+	if s.correlator == nil {
+		return nil, errors.New("Flow correlation is only available on analyzers")
+	}
+
+	return fs.Correlated(s.correlator), nil
+}
+
+// Reduce correlated step
+func (s *CorrelatedGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context correlated step
+func (s *CorrelatedGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
@@ -65,6 +65,7 @@ type FlowGremlinTraversalStep struct {
 	hasParams          []interface{}
 	metricsNextStep    bool
 	rawpacketsNextStep bool
+	hasNextStep        bool
 	dedup              bool
 	dedupBy            string
 	sort               bool
@@ -460,6 +461,37 @@ func (f *FlowTraversalStep) Sort(keys ...interface{}) *FlowTraversalStep {
 	return &FlowTraversalStep{GraphTraversal: f.GraphTraversal, Storage: f.Storage, flowset: f.flowset}
 }
 
+// Range step, keeps the flows between the two given indexes
+func (f *FlowTraversalStep) Range(s ...interface{}) *FlowTraversalStep {
+	if f.error != nil {
+		return f
+	}
+
+	if len(s) != 2 {
+		return &FlowTraversalStep{error: errors.New("2 parameters must be provided to 'range'")}
+	}
+
+	from, ok := s[0].(int64)
+	if !ok {
+		return &FlowTraversalStep{error: fmt.Errorf("%v is not an integer", s[0])}
+	}
+	to, ok := s[1].(int64)
+	if !ok {
+		return &FlowTraversalStep{error: fmt.Errorf("%v is not an integer", s[1])}
+	}
+
+	if from < 0 || to < 0 {
+		return &FlowTraversalStep{error: errors.New("'range' bounds must be positive")}
+	}
+
+	if to < from {
+		to = from
+	}
+
+	f.flowset.Slice(int(from), int(to))
+	return &FlowTraversalStep{GraphTraversal: f.GraphTraversal, Storage: f.Storage, flowset: f.flowset, flowSearchQuery: f.flowSearchQuery}
+}
+
 // Sum aggregates integer values mapped by 'key' cross flows
 func (f *FlowTraversalStep) Sum(keys ...interface{}) *traversal.GraphTraversalValue {
 	if f.error != nil {
@@ -581,8 +613,8 @@ func (f *FlowTraversalStep) FlowMetrics() *MetricsTraversalStep {
 		f.flowSearchQuery.SortOrder = string(common.SortAscending)
 
 		var err error
-		if flowMetrics, err = f.Storage.SearchMetrics(f.flowSearchQuery, metricFilter); err != nil {
-			return NewMetricsTraversalStep(nil, nil, f.error)
+		if flowMetrics, err = f.Storage.SearchMetrics(f.GraphTraversal.QueryContext(), f.flowSearchQuery, metricFilter); err != nil {
+			return NewMetricsTraversalStep(nil, nil, searchError(f.GraphTraversal, err))
 		}
 	} else {
 		flowMetrics = make(map[string][]common.Metric, len(f.flowset.Flows))
@@ -660,8 +692,8 @@ func (f *FlowTraversalStep) RawPackets() *RawPacketsTraversalStep {
 		f.flowSearchQuery.SortOrder = string(common.SortAscending)
 
 		var err error
-		if rawPackets, err = f.Storage.SearchRawPackets(f.flowSearchQuery, rawPacketsFilter); err != nil {
-			return &RawPacketsTraversalStep{error: err}
+		if rawPackets, err = f.Storage.SearchRawPackets(f.GraphTraversal.QueryContext(), f.flowSearchQuery, rawPacketsFilter); err != nil {
+			return &RawPacketsTraversalStep{error: searchError(f.GraphTraversal, err)}
 		}
 	} else {
 		for _, fl := range f.flowset.Flows {
@@ -804,7 +836,16 @@ func (e *FlowTraversalExtension) ParseStep(t traversal.Token, p traversal.Gremli
 	return nil, nil
 }
 
-func (s *FlowGremlinTraversalStep) makeSearchQuery() (fsq filters.SearchQuery, err error) {
+// pageRange returns the range of the flows returned by the step, for a page
+// the range of the page within the range of the query
+func (s *FlowGremlinTraversalStep) pageRange(page *filters.Range) *traversal.GraphTraversalRange {
+	if page != nil {
+		return s.context.StepContext.PaginationRange.Page(*page)
+	}
+	return s.context.StepContext.PaginationRange
+}
+
+func (s *FlowGremlinTraversalStep) makeSearchQuery(page *filters.Range) (fsq filters.SearchQuery, err error) {
 	var paramsFilter *filters.Filter
 
 	if len(s.hasParams) > 0 {
@@ -814,10 +855,10 @@ func (s *FlowGremlinTraversalStep) makeSearchQuery() (fsq filters.SearchQuery, e
 	}
 
 	var interval *filters.Range
-	if s.context.StepContext.PaginationRange != nil {
+	if r := s.pageRange(page); r != nil {
 		// not using the From parameter as the pagination will be applied after
 		// flow request.
-		interval = &filters.Range{From: 0, To: s.context.StepContext.PaginationRange[1]}
+		interval = &filters.Range{From: 0, To: r[1]}
 	}
 
 	fsq = filters.SearchQuery{
@@ -830,9 +871,39 @@ func (s *FlowGremlinTraversalStep) makeSearchQuery() (fsq filters.SearchQuery, e
 		SortOrder:       string(s.sortOrder),
 	}
 
+	// the pages have to be taken from flows returned in a stable order
+	if page != nil && !s.sort {
+		fsq.Sort = true
+		fsq.SortBy = "UUID"
+		fsq.SortOrder = string(common.SortAscending)
+	}
+
 	return
 }
 
+// limitSearchQuery caps the number of flows requested to the result limit of
+// the query, one more flow is requested so that reaching the limit is detected.
+// The cap is only applied when the flows are the result of the query, steps
+// like Count or Sum need all the flows to return an exact value.
+func (s *FlowGremlinTraversalStep) limitSearchQuery(gt *traversal.GraphTraversal, fsq *filters.SearchQuery) {
+	if s.hasNextStep {
+		return
+	}
+
+	if max := gt.QueryLimits().MaxResults; max > 0 && fsq.PaginationRange == nil {
+		fsq.PaginationRange = &filters.Range{To: max + 1}
+	}
+}
+
+// searchError returns the query limit error when the search was aborted
+// because of the query context
+func searchError(gt *traversal.GraphTraversal, err error) error {
+	if qerr := gt.Visit(0); qerr != nil {
+		return qerr
+	}
+	return err
+}
+
 func captureAllowedNodes(nodes []*graph.Node) []*graph.Node {
 	var allowed []*graph.Node
 	for _, n := range nodes {
@@ -863,12 +934,22 @@ func (s *FlowGremlinTraversalStep) addTimeFilter(fsq *filters.SearchQuery, timeC
 
 // Exec flow step
 func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	return s.exec(last, nil)
+}
+
+// ExecPage returns a page of the flows, ordered by UUID unless the flows
+// are explicitly sorted
+func (s *FlowGremlinTraversalStep) ExecPage(last traversal.GraphTraversalStep, page filters.Range) (traversal.GraphTraversalStep, error) {
+	return s.exec(last, &page)
+}
+
+func (s *FlowGremlinTraversalStep) exec(last traversal.GraphTraversalStep, page *filters.Range) (traversal.GraphTraversalStep, error) {
 	var graphTraversal *traversal.GraphTraversal
 	var err error
 	var context graph.GraphContext
 	var nodes []*graph.Node
 
-	flowSearchQuery, err := s.makeSearchQuery()
+	flowSearchQuery, err := s.makeSearchQuery(page)
 	if err != nil {
 		return nil, err
 	}
@@ -907,6 +988,9 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 		return nil, traversal.ErrExecutionError
 	}
 
+	s.limitSearchQuery(graphTraversal, &flowSearchQuery)
+
+	r := s.pageRange(page)
 	if context.TimeSlice != nil {
 		if s.Storage == nil {
 			return nil, storage.ErrNoStorageConfigured
@@ -933,8 +1017,15 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery}, nil
 		}
 
-		if flowset, err = s.Storage.SearchFlows(flowSearchQuery); err != nil {
-			return nil, err
+		// the storage returns the requested range by itself, unless the
+		// flows are deduplicated once returned
+		if r != nil && !flowSearchQuery.Dedup {
+			flowSearchQuery.PaginationRange = &filters.Range{From: r[0], To: r[1]}
+			r = nil
+		}
+
+		if flowset, err = s.Storage.SearchFlows(graphTraversal.QueryContext(), flowSearchQuery); err != nil {
+			return nil, searchError(graphTraversal, err)
 		}
 	} else {
 		if len(nodes) != 0 {
@@ -952,7 +1043,11 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 		return nil, err
 	}
 
-	if r := s.context.StepContext.PaginationRange; r != nil {
+	if err = graphTraversal.Visit(len(flowset.Flows)); err != nil {
+		return nil, err
+	}
+
+	if r != nil {
 		flowset.Slice(int(r[0]), int(r[1]))
 	}
 
@@ -1001,6 +1096,8 @@ func (s *FlowGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) t
 		return s
 	}
 
+	s.hasNextStep = true
+
 	return next
 }
 
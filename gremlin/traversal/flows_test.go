@@ -28,6 +28,7 @@ import (
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
 )
 
 func TestFlowMetricsAggregates(t *testing.T) {
@@ -119,3 +120,45 @@ func TestFlowMetricsAggregates(t *testing.T) {
 		t.Errorf("Metrics mismatch, expected: \n\n%s\n\ngot: \n\n%s", string(e), string(g))
 	}
 }
+
+func TestFlowStepResultLimitOnlyWhenLast(t *testing.T) {
+	last := &FlowGremlinTraversalStep{}
+	last.Reduce(&traversal.GremlinTraversalStepHas{GremlinTraversalContext: traversal.GremlinTraversalContext{Params: []interface{}{"Application", "TCP"}}})
+	last.Reduce(&traversal.GremlinTraversalStepDedup{})
+	if last.hasNextStep {
+		t.Error("Has and Dedup are reduced, the flow step should still be the last one")
+	}
+
+	counted := &FlowGremlinTraversalStep{}
+	if next := counted.Reduce(&traversal.GremlinTraversalStepCount{}); next == counted {
+		t.Fatal("Count should not be reduced into the flow step")
+	}
+	if !counted.hasNextStep {
+		t.Error("The flow step should be followed by the Count step")
+	}
+}
+
+func TestFlowStepRange(t *testing.T) {
+	newStep := func() *FlowTraversalStep {
+		flowset := flow.NewFlowSet()
+		for _, uuid := range []string{"aa", "bb", "cc"} {
+			flowset.Flows = append(flowset.Flows, &flow.Flow{UUID: uuid})
+		}
+		return &FlowTraversalStep{flowset: flowset}
+	}
+
+	for _, bounds := range [][]interface{}{{int64(-1), int64(2)}, {int64(0), int64(-2)}} {
+		if err := newStep().Range(bounds...).Error(); err == nil {
+			t.Errorf("Range%v should be rejected", bounds)
+		}
+	}
+
+	if values := newStep().Range(int64(1), int64(5)).Values(); len(values) != 2 || values[0].(*flow.Flow).UUID != "bb" {
+		t.Errorf("Unexpected flows: %+v", values)
+	}
+
+	fs := newStep().flowset
+	if fs.Slice(-1, 1); len(fs.Flows) != 1 {
+		t.Errorf("Negative bounds should be clamped, got: %+v", fs.Flows)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"errors"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// OfRuleTraversalExtension describes a new extension to look for OpenFlow
+// rules
+type OfRuleTraversalExtension struct {
+	StaleToken traversal.Token
+}
+
+// StaleGremlinTraversalStep filters the OpenFlow rules that did not match
+// any packet for a given period
+type StaleGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+}
+
+// NewOfRuleTraversalExtension returns a new graph traversal extension
+func NewOfRuleTraversalExtension() *OfRuleTraversalExtension {
+	return &OfRuleTraversalExtension{
+		StaleToken: traversalStaleToken,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *OfRuleTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "STALE":
+		return e.StaleToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse stale step
+func (e *OfRuleTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.StaleToken:
+		return &StaleGremlinTraversalStep{context: p}, nil
+	}
+	return nil, nil
+}
+
+// StaleRules returns the ofrule nodes that did not match any packet during
+// the given period in seconds, ending now or at the time of the graph
+// context. Rules added during the period are not considered as stale.
+func StaleRules(tv *traversal.GraphTraversalV, period int64) *traversal.GraphTraversalV {
+	if tv.Error() != nil {
+		return tv
+	}
+
+	gt := tv.GraphTraversal
+
+	now := int64(common.UnixMillis(time.Now()))
+	if gslice := gt.Graph.GetContext().TimeSlice; gslice != nil {
+		now = gslice.Last
+	}
+	from := now - period*1000
+
+	gt.RLock()
+	defer gt.RUnlock()
+
+	var nodes []*graph.Node
+	for _, n := range tv.GetNodes() {
+		if tp, _ := n.GetFieldString("Type"); tp != "ofrule" {
+			continue
+		}
+
+		if createdAt, _ := n.GetFieldInt64("CreatedAt"); createdAt > from {
+			continue
+		}
+
+		if lastHit, err := n.GetFieldInt64("LastHit"); err == nil && lastHit >= from {
+			continue
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return traversal.NewGraphTraversalV(gt, nodes)
+}
+
+// Exec executes the stale step
+func (s *StaleGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	tv, ok := last.(*traversal.GraphTraversalV)
+	if !ok {
+		return nil, traversal.ErrExecutionError
+	}
+
+	if len(s.context.Params) != 1 {
+		return nil, errors.New("Stale requires a period in seconds")
+	}
+
+	period, err := common.ToInt64(s.context.Params[0])
+	if err != nil || period <= 0 {
+		return nil, errors.New("Stale period should be a positive number of seconds")
+	}
+
+	return StaleRules(tv, period), nil
+}
+
+// Reduce stale step
+func (s *StaleGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context stale step
+func (s *StaleGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+func TestStaleRules(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	now := time.Now()
+	ms := func(d time.Duration) int64 {
+		return int64(common.UnixMillis(now.Add(-d)))
+	}
+
+	addRule := func(name string, createdAt int64, lastHit int64) {
+		m := map[string]interface{}{"Type": "ofrule", "Name": name}
+		if lastHit != 0 {
+			m["LastHit"] = lastHit
+		}
+
+		n := new(graph.Node)
+		if err := n.Decode(map[string]interface{}{"ID": name, "Metadata": m, "CreatedAt": createdAt}); err != nil {
+			t.Fatal(err)
+		}
+		g.AddNode(n)
+	}
+
+	g.Lock()
+	addRule("never-hit", ms(2*time.Hour), 0)
+	addRule("hit-recently", ms(2*time.Hour), ms(time.Minute))
+	addRule("hit-long-ago", ms(2*time.Hour), ms(90*time.Minute))
+	addRule("added-recently", ms(time.Minute), 0)
+	g.Unlock()
+
+	tr := traversal.NewGremlinTraversalParser()
+	tr.AddTraversalExtension(NewOfRuleTraversalExtension())
+
+	ts, err := tr.Parse(strings.NewReader(`G.V().Has('Type', 'ofrule').Stale(3600).Values('Name')`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ts.Exec(g, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, value := range res.Values() {
+		names = append(names, value.(string))
+	}
+	sort.Strings(names)
+
+	if len(names) != 2 || names[0] != "hit-long-ago" || names[1] != "never-hit" {
+		t.Errorf("Wrong stale rules: %v", names)
+	}
+
+}
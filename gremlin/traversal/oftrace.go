@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/oftrace"
+	"github.com/skydive-project/skydive/packet_injector"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// OfTraceTraversalExtension describes a new extension to trace packets
+// through the OpenFlow pipeline of a bridge
+type OfTraceTraversalExtension struct {
+	OfTraceToken traversal.Token
+	Client       *oftrace.OfTraceClient
+}
+
+// OfTraceGremlinTraversalStep traces packets from the OVS ports of the
+// previous step
+type OfTraceGremlinTraversalStep struct {
+	client  *oftrace.OfTraceClient
+	context traversal.GremlinTraversalContext
+}
+
+// OfTraceTraversalStep holds the traces of the OfTrace step
+type OfTraceTraversalStep struct {
+	GraphTraversal *traversal.GraphTraversal
+	traces         []*oftrace.Trace
+	error          error
+}
+
+// ofTraceValue is a trace along with the nodes of its path
+type ofTraceValue struct {
+	*oftrace.Trace
+	Path []*graph.Node
+}
+
+// NewOfTraceTraversalExtension returns a new graph traversal extension
+func NewOfTraceTraversalExtension(client *oftrace.OfTraceClient) *OfTraceTraversalExtension {
+	return &OfTraceTraversalExtension{
+		OfTraceToken: traversalOfTraceToken,
+		Client:       client,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *OfTraceTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "OFTRACE":
+		return e.OfTraceToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse oftrace step
+func (e *OfTraceTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.OfTraceToken:
+		return &OfTraceGremlinTraversalStep{client: e.Client, context: p}, nil
+	}
+	return nil, nil
+}
+
+// ofTraceParams returns the packet parameters defined by the key/value
+// parameters of the step, using the packet injector field names
+func ofTraceParams(params ...interface{}) (*packet_injector.PacketParams, error) {
+	if len(params)%2 != 0 {
+		return nil, fmt.Errorf("OfTrace requires an even number of parameters, got %d", len(params))
+	}
+
+	pp := &packet_injector.PacketParams{Type: "icmp4"}
+	for i := 0; i < len(params); i += 2 {
+		key, ok := params[i].(string)
+		if !ok {
+			return nil, errors.New("OfTrace keys should be of string type")
+		}
+
+		switch key {
+		case "SrcPort", "DstPort":
+			port, err := common.ToInt64(params[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("OfTrace %s should be an integer", key)
+			}
+			if key == "SrcPort" {
+				pp.SrcPort = port
+			} else {
+				pp.DstPort = port
+			}
+			continue
+		}
+
+		value, ok := params[i+1].(string)
+		if !ok {
+			return nil, fmt.Errorf("OfTrace %s should be a string", key)
+		}
+
+		switch key {
+		case "SrcIP":
+			pp.SrcIP = value
+		case "DstIP":
+			pp.DstIP = value
+		case "SrcMAC":
+			pp.SrcMAC = value
+		case "DstMAC":
+			pp.DstMAC = value
+		case "Type":
+			pp.Type = value
+		default:
+			return nil, fmt.Errorf("Unknown OfTrace parameter: %s", key)
+		}
+	}
+
+	return pp, nil
+}
+
+// Exec executes the oftrace step, a trace is requested to the agent of each
+// node of the previous step
+func (s *OfTraceGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	tv, ok := last.(*traversal.GraphTraversalV)
+	if !ok {
+		return nil, traversal.ErrExecutionError
+	}
+
+	pp, err := ofTraceParams(s.context.Params...)
+	if err != nil {
+		return nil, err
+	}
+
+	type source struct {
+		host   string
+		params packet_injector.PacketParams
+	}
+
+	gt := tv.GraphTraversal
+
+	var sources []source
+	gt.RLock()
+	for _, node := range tv.GetNodes() {
+		params := *pp
+		params.SrcNodeID = node.ID
+		if params.SrcMAC == "" {
+			params.SrcMAC, _ = node.GetFieldString("MAC")
+		}
+		sources = append(sources, source{host: node.Host(), params: params})
+	}
+	gt.RUnlock()
+
+	step := &OfTraceTraversalStep{GraphTraversal: gt}
+	for _, src := range sources {
+		if err := gt.Visit(0); err != nil {
+			return nil, err
+		}
+
+		trace, err := s.client.Trace(src.host, &src.params)
+		if err != nil {
+			return nil, err
+		}
+		step.traces = append(step.traces, trace)
+	}
+
+	return step, nil
+}
+
+// Reduce oftrace step
+func (s *OfTraceGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context oftrace step
+func (s *OfTraceGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+// Values returns the traces along with the nodes of their path, starting
+// with the source port followed by the matched rules and the output ports
+func (o *OfTraceTraversalStep) Values() []interface{} {
+	o.GraphTraversal.RLock()
+	defer o.GraphTraversal.RUnlock()
+
+	values := make([]interface{}, len(o.traces))
+	for i, trace := range o.traces {
+		value := &ofTraceValue{Trace: trace, Path: []*graph.Node{}}
+		for _, id := range trace.Path() {
+			if node := o.GraphTraversal.Graph.GetNode(id); node != nil {
+				value.Path = append(value.Path, node)
+			}
+		}
+		values[i] = value
+	}
+	return values
+}
+
+// MarshalJSON serialize in JSON
+func (o *OfTraceTraversalStep) MarshalJSON() ([]byte, error) {
+	values := o.Values()
+	o.GraphTraversal.RLock()
+	defer o.GraphTraversal.RUnlock()
+	return json.Marshal(values)
+}
+
+// Error returns traversal error
+func (o *OfTraceTraversalStep) Error() error {
+	return o.error
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+	"github.com/skydive-project/skydive/topology/routing"
+)
+
+// RouteToTraversalExtension describes a new extension to compute the L3
+// forwarding path of packets
+type RouteToTraversalExtension struct {
+	RouteToToken traversal.Token
+}
+
+// RouteToGremlinTraversalStep computes the forwarding path from the nodes
+// of the previous step to a destination address
+type RouteToGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+}
+
+// RouteToTraversalStep holds the routes of the RouteTo step
+type RouteToTraversalStep struct {
+	GraphTraversal *traversal.GraphTraversal
+	routes         []*routing.Route
+	error          error
+}
+
+// NewRouteToTraversalExtension returns a new graph traversal extension
+func NewRouteToTraversalExtension() *RouteToTraversalExtension {
+	return &RouteToTraversalExtension{
+		RouteToToken: traversalRouteToToken,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *RouteToTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "ROUTETO":
+		return e.RouteToToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse routeto step
+func (e *RouteToTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.RouteToToken:
+		return &RouteToGremlinTraversalStep{context: p}, nil
+	}
+	return nil, nil
+}
+
+// RouteTo returns the routes from the nodes to the destination address
+func RouteTo(tv *traversal.GraphTraversalV, dst net.IP) *RouteToTraversalStep {
+	gt := tv.GraphTraversal
+	if tv.Error() != nil {
+		return &RouteToTraversalStep{GraphTraversal: gt, error: tv.Error()}
+	}
+
+	gt.RLock()
+	defer gt.RUnlock()
+
+	resolver := routing.NewResolver(gt.Graph)
+
+	step := &RouteToTraversalStep{GraphTraversal: gt}
+	for _, n := range tv.GetNodes() {
+		if err := gt.Visit(1); err != nil {
+			return &RouteToTraversalStep{GraphTraversal: gt, error: err}
+		}
+
+		route, err := resolver.Resolve(n, dst)
+		if err != nil {
+			return &RouteToTraversalStep{GraphTraversal: gt, error: err}
+		}
+		step.routes = append(step.routes, route)
+	}
+
+	return step
+}
+
+// Exec executes the routeto step
+func (s *RouteToGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	tv, ok := last.(*traversal.GraphTraversalV)
+	if !ok {
+		return nil, traversal.ErrExecutionError
+	}
+
+	if len(s.context.Params) != 1 {
+		return nil, errors.New("RouteTo requires a destination address")
+	}
+
+	addr, ok := s.context.Params[0].(string)
+	if !ok {
+		return nil, errors.New("RouteTo destination should be a string")
+	}
+
+	dst := net.ParseIP(addr)
+	if dst == nil {
+		return nil, fmt.Errorf("RouteTo destination is not a valid IP address: %s", addr)
+	}
+
+	step := RouteTo(tv, dst)
+	if step.error != nil {
+		return nil, step.error
+	}
+	return step, nil
+}
+
+// Reduce routeto step
+func (s *RouteToGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context routeto step
+func (s *RouteToGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+// Values returns the routes, one per node of the previous step
+func (r *RouteToTraversalStep) Values() []interface{} {
+	values := make([]interface{}, len(r.routes))
+	for i, route := range r.routes {
+		values[i] = route
+	}
+	return values
+}
+
+// MarshalJSON serialize in JSON
+func (r *RouteToTraversalStep) MarshalJSON() ([]byte, error) {
+	values := r.Values()
+	r.GraphTraversal.RLock()
+	defer r.GraphTraversal.RUnlock()
+	return json.Marshal(values)
+}
+
+// Error returns traversal error
+func (r *RouteToTraversalStep) Error() error {
+	return r.error
+}
@@ -33,4 +33,8 @@ const (
 	traversalRawPacketsToken  traversal.Token = 1006
 	traversalBpfToken         traversal.Token = 1007
 	traversalMetricsToken     traversal.Token = 1008
+	traversalOfTraceToken     traversal.Token = 1009
+	traversalStaleToken       traversal.Token = 1010
+	traversalRouteToToken     traversal.Token = 1011
+	traversalCorrelatedToken  traversal.Token = 1012
 )
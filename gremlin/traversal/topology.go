@@ -33,7 +33,8 @@ import (
 	"github.com/skydive-project/skydive/topology/graph/traversal"
 )
 
-// InterfaceMetrics returns a Metrics step from interface metric metadata
+// InterfaceMetrics returns a Metrics step from interface metric metadata or
+// from OpenFlow rule metric metadata for ofrule nodes
 func InterfaceMetrics(tv *traversal.GraphTraversalV) *MetricsTraversalStep {
 	if tv.Error() != nil {
 		return &MetricsTraversalStep{error: tv.Error()}
@@ -62,15 +63,20 @@ nodeloop:
 			return nil
 		}
 
+		var lastMetric common.Metric = &topology.InterfaceMetric{}
+		switch tp, _ := n.GetFieldString("Type"); tp {
+		case "ofrule", "fwchain", "fwrule":
+			lastMetric = &topology.OfRuleMetric{}
+		}
+
 		// NOTE(safchain) mapstructure for now, need to be change once converted from json to
 		// protobuf
-		var lastMetric topology.InterfaceMetric
-		if err := mapstructure.WeakDecode(m, &lastMetric); err != nil {
+		if err := mapstructure.WeakDecode(m, lastMetric); err != nil {
 			return &MetricsTraversalStep{error: err}
 		}
 
-		if gslice == nil || (lastMetric.Start > gslice.Start && lastMetric.Last < gslice.Last) {
-			metrics[string(n.ID)] = append(metrics[string(n.ID)], &lastMetric)
+		if gslice == nil || (lastMetric.GetStart() > gslice.Start && lastMetric.GetLast() < gslice.Last) {
+			metrics[string(n.ID)] = append(metrics[string(n.ID)], lastMetric)
 		}
 	}
 
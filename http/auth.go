@@ -152,6 +152,8 @@ func NewAuthenticationBackendFromConfig() (AuthenticationBackend, error) {
 		return NewBasicAuthenticationBackendFromConfig()
 	case "keystone":
 		return NewKeystoneAuthenticationBackendFromConfig(), nil
+	case "oidc":
+		return NewOIDCAuthenticationBackendFromConfig()
 	default:
 		return NewNoAuthenticationBackend(), nil
 	}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/context"
+)
+
+// ClientCertificateHostHeader is set on the requests of the clients
+// authenticated with a client certificate signed by the enrollment authority,
+// its value being the host the certificate was delivered to.
+const ClientCertificateHostHeader = "X-Client-Certificate-Host"
+
+// AuthenticatedUserHeader is set on the requests of the authenticated clients,
+// its value being the user name or the host of the client certificate.
+const AuthenticatedUserHeader = "X-Authenticated-User"
+
+// ErrCertificateLookupFailed is returned by the certificate authorizers when
+// the state of a certificate could not be retrieved
+var ErrCertificateLookupFailed = errors.New("Certificate lookup failed")
+
+// CertificateAuthorizer authorizes the client certificates. It returns the
+// host the certificate was delivered to, an empty host for certificates
+// it doesn't manage, ErrCertificateLookupFailed if the state of the
+// certificate is unknown or an error if the certificate was revoked.
+type CertificateAuthorizer interface {
+	AuthorizeCertificate(cert *x509.Certificate) (string, error)
+}
+
+// certificateHost returns the host identified by the client certificate of
+// the request, if any
+func (s *Server) certificateHost(r *http.Request) (string, error) {
+	if s.CertAuth == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", nil
+	}
+	return s.CertAuth.AuthorizeCertificate(r.TLS.PeerCertificates[0])
+}
+
+// wrap authenticates the requests either with the client certificate when it
+// is managed by the certificate authorizer or with the authentication backend
+func (s *Server) wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	authWrapped := s.Auth.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		if r.Username != "" {
+			r.Header.Set(AuthenticatedUserHeader, r.Username)
+		}
+		wrapped(w, r)
+	})
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// only the server is allowed to set these headers
+		r.Header.Del(ClientCertificateHostHeader)
+		r.Header.Del(AuthenticatedUserHeader)
+
+		host, err := s.certificateHost(r)
+		if err == ErrCertificateLookupFailed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 Service Unavailable: " + err.Error() + "\n"))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 Forbidden: " + err.Error() + "\n"))
+			return
+		}
+
+		if host == "" {
+			authWrapped(w, r)
+			return
+		}
+
+		// a client certificate only allows to speak for its own host
+		id := r.Header.Get("X-Host-ID")
+		if id == "" {
+			id = r.URL.Query().Get("x-host-id")
+		}
+		if id != "" && id != host {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 Forbidden: certificate not delivered to host " + id + "\n"))
+			return
+		}
+		r.Header.Set("X-Host-ID", host)
+		r.Header.Set(ClientCertificateHostHeader, host)
+		r.Header.Set(AuthenticatedUserHeader, host)
+
+		setTLSHeader(w, r)
+		ar := &auth.AuthenticatedRequest{Request: *r, Username: host}
+		copyRequestVars(r, &ar.Request)
+		wrapped(w, ar)
+		context.Clear(&ar.Request)
+	}
+}
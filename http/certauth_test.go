@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+type fakeCertificateAuthorizer struct {
+	revoked map[string]bool
+	unknown map[string]bool
+}
+
+func (f *fakeCertificateAuthorizer) AuthorizeCertificate(cert *x509.Certificate) (string, error) {
+	if f.unknown[cert.Subject.CommonName] {
+		return "", ErrCertificateLookupFailed
+	}
+	if f.revoked[cert.Subject.CommonName] {
+		return "", errors.New("certificate revoked")
+	}
+	return cert.Subject.CommonName, nil
+}
+
+func newCertificateRequest(host string, cn string) *http.Request {
+	r := httptest.NewRequest("GET", "/ws/agent", nil)
+	r.Header.Set("X-Host-ID", host)
+	if cn != "" {
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}},
+		}
+	}
+	return r
+}
+
+func TestCertificateWrap(t *testing.T) {
+	s := &Server{
+		Auth: NewNoAuthenticationBackend(),
+		CertAuth: &fakeCertificateAuthorizer{
+			revoked: map[string]bool{"host2": true},
+			unknown: map[string]bool{"host4": true},
+		},
+	}
+
+	var username, certHost, user string
+	handler := s.wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		username, certHost = r.Username, r.Header.Get(ClientCertificateHostHeader)
+		user = r.Header.Get(AuthenticatedUserHeader)
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, newCertificateRequest("host1", "host1"))
+	if w.Code != http.StatusOK || username != "host1" || certHost != "host1" || user != "host1" {
+		t.Errorf("Request with a certificate of its own host should be accepted, got %d %s %s %s", w.Code, username, certHost, user)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, newCertificateRequest("host3", "host1"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Request with a certificate of another host should be rejected, got %d", w.Code)
+	}
+
+	r := newCertificateRequest("", "host1")
+	r.URL.RawQuery = "x-host-id=host3"
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Request with a certificate of another host should be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, newCertificateRequest("host2", "host2"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Request with a revoked certificate should be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, newCertificateRequest("host4", "host4"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Request with a certificate failing to be looked up should not be forbidden, got %d", w.Code)
+	}
+
+	r = newCertificateRequest("host1", "")
+	r.Header.Set(ClientCertificateHostHeader, "host1")
+	r.Header.Set(AuthenticatedUserHeader, "admin")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || certHost != "" || user != "" {
+		t.Errorf("Authentication headers should not be set by the client, got %s %s", certHost, user)
+	}
+}
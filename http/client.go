@@ -83,7 +83,13 @@ func (c *RestClient) Request(method, path string, body io.Reader, header http.He
 		}
 	}
 
-	url := c.url.ResolveReference(&url.URL{Path: path})
+	// path may contain a query string
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	url := c.url.ResolveReference(ref)
 	req, err := http.NewRequest(method, url.String(), body)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,281 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 for JWT signatures
+	_ "crypto/sha512" // register SHA-384/512 for JWT signatures
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtClockSkew is the tolerance applied when checking the time based claims
+const jwtClockSkew = time.Minute
+
+var (
+	// ErrMalformedToken error returned for tokens that are not valid JWS compact serializations
+	ErrMalformedToken = errors.New("Malformed token")
+	// ErrTokenExpired error returned when the token expiration time is in the past
+	ErrTokenExpired = errors.New("Token expired")
+	// ErrUnknownSigningKey error returned when no key matches the token key ID
+	ErrUnknownSigningKey = errors.New("Unknown token signing key")
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// JWTClaims holds the claims of a JSON Web Token
+type JWTClaims map[string]interface{}
+
+// jwt is a parsed, but not yet verified, JSON Web Token
+type jwt struct {
+	header    jwtHeader
+	claims    JWTClaims
+	signed    []byte
+	signature []byte
+}
+
+// JSONWebKey describes a public key as published in a JWKS document
+type JSONWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// JSONWebKeySet describes a JWKS document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func parseJWT(token string) (*jwt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	data, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	t := &jwt{}
+	if err := json.Unmarshal(data, &t.header); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if data, err = decodeSegment(parts[1]); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if err := json.Unmarshal(data, &t.claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if t.signature, err = decodeSegment(parts[2]); err != nil {
+		return nil, ErrMalformedToken
+	}
+	t.signed = []byte(parts[0] + "." + parts[1])
+
+	return t, nil
+}
+
+func (t *jwt) verifySignature(key crypto.PublicKey) error {
+	var hash crypto.Hash
+	switch t.header.Algorithm {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("Unsupported token signing algorithm: %s", t.header.Algorithm)
+	}
+
+	h := hash.New()
+	h.Write(t.signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch t.header.Algorithm[0] {
+		case 'R':
+			return rsa.VerifyPKCS1v15(k, hash, digest, t.signature)
+		case 'P':
+			return rsa.VerifyPSS(k, hash, digest, t.signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		if t.header.Algorithm[0] != 'E' {
+			break
+		}
+
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return errors.New("Invalid token signature")
+		}
+
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("Invalid token signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Signing key type doesn't match algorithm %s", t.header.Algorithm)
+}
+
+func (c JWTClaims) getTime(name string) (time.Time, bool) {
+	if v, ok := c[name].(float64); ok {
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
+
+// GetString returns the string value of the given claim
+func (c JWTClaims) GetString(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// GetStringList returns the value of the given claim as a list of strings,
+// a single string value being returned as a one element list
+func (c JWTClaims) GetStringList(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var l []string
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				l = append(l, s)
+			}
+		}
+		return l
+	}
+	return nil
+}
+
+func (c JWTClaims) validate(issuer, audience string, now time.Time) error {
+	if iss := c.GetString("iss"); iss != issuer {
+		return fmt.Errorf("Invalid token issuer: %s", iss)
+	}
+
+	if audience != "" {
+		found := false
+		for _, aud := range c.GetStringList("aud") {
+			if aud == audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Token not issued for audience %s", audience)
+		}
+	}
+
+	exp, ok := c.getTime("exp")
+	if !ok {
+		return errors.New("Token without expiration time")
+	}
+	if now.After(exp.Add(jwtClockSkew)) {
+		return ErrTokenExpired
+	}
+
+	if nbf, ok := c.getTime("nbf"); ok && now.Add(jwtClockSkew).Before(nbf) {
+		return errors.New("Token not valid yet")
+	}
+
+	return nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := decodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// PublicKey returns the crypto public key described by the JSON web key
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve: %s", k.Curve)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("Invalid EC key, point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported key type: %s", k.KeyType)
+}
@@ -0,0 +1,387 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/gorilla/context"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+type oidcContextKey int
+
+const (
+	groupsContextKey oidcContextKey = iota
+
+	// minimum delay between two JWKS downloads triggered by unknown key IDs
+	jwksMinRefreshInterval = 10 * time.Second
+)
+
+type oidcProviderConfig struct {
+	Issuer        string `json:"issuer"`
+	JWKSURI       string `json:"jwks_uri"`
+	TokenEndpoint string `json:"token_endpoint"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// OIDCAuthenticationBackend validates OpenID Connect/OAuth2 bearer tokens
+// (JWT) issued by an identity provider
+type OIDCAuthenticationBackend struct {
+	sync.RWMutex
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	Audience      string
+	UsernameClaim string
+	GroupsClaim   string
+	AllowedGroups []string
+	Scopes        []string
+	KeysTTL       time.Duration
+	client        *http.Client
+	provider      *oidcProviderConfig
+	keys          map[string]crypto.PublicKey
+	keysFetched   time.Time
+}
+
+func (b *OIDCAuthenticationBackend) getJSON(u string, v interface{}) error {
+	resp, err := b.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to get %s: returned code %d", u, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getProvider returns the provider configuration, retrieved using the OpenID
+// Connect discovery mechanism
+func (b *OIDCAuthenticationBackend) getProvider() (*oidcProviderConfig, error) {
+	b.RLock()
+	provider := b.provider
+	b.RUnlock()
+
+	if provider != nil {
+		return provider, nil
+	}
+
+	provider = &oidcProviderConfig{}
+	if err := b.getJSON(strings.TrimSuffix(b.Issuer, "/")+"/.well-known/openid-configuration", provider); err != nil {
+		return nil, err
+	}
+
+	if provider.Issuer != b.Issuer {
+		return nil, fmt.Errorf("Issuer mismatch, expected %s got %s", b.Issuer, provider.Issuer)
+	}
+
+	b.Lock()
+	b.provider = provider
+	b.Unlock()
+
+	return provider, nil
+}
+
+func (b *OIDCAuthenticationBackend) refreshKeys() error {
+	provider, err := b.getProvider()
+	if err != nil {
+		return err
+	}
+
+	var set JSONWebKeySet
+	if err := b.getJSON(provider.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+		if err != nil {
+			logging.GetLogger().Warningf("Ignoring signing key %s: %s", jwk.KeyID, err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	b.Lock()
+	b.keys = keys
+	b.keysFetched = time.Now()
+	b.Unlock()
+
+	return nil
+}
+
+// getKey returns the signing key for the given key ID. The keys are cached and
+// refreshed when expired or when an unknown key ID is requested as the
+// provider may have rotated its keys.
+func (b *OIDCAuthenticationBackend) getKey(kid string) (crypto.PublicKey, error) {
+	b.RLock()
+	key, found := b.keys[kid]
+	fetched := b.keysFetched
+	b.RUnlock()
+
+	age := time.Now().Sub(fetched)
+	if found && age < b.KeysTTL {
+		return key, nil
+	}
+
+	if !found && age < jwksMinRefreshInterval {
+		return nil, ErrUnknownSigningKey
+	}
+
+	if err := b.refreshKeys(); err != nil {
+		if found {
+			logging.GetLogger().Warningf("Failed to refresh signing keys, using cached ones: %s", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	b.RLock()
+	defer b.RUnlock()
+
+	if key, found = b.keys[kid]; !found {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+// ValidateToken checks the signature and the claims of a token and returns
+// the name and the groups of the user
+func (b *OIDCAuthenticationBackend) ValidateToken(token string) (string, []string, error) {
+	t, err := parseJWT(token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, err := b.getKey(t.header.KeyID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := t.verifySignature(key); err != nil {
+		return "", nil, err
+	}
+
+	audience := b.Audience
+	if audience == "" {
+		audience = b.ClientID
+	}
+
+	if err := t.claims.validate(b.Issuer, audience, time.Now()); err != nil {
+		return "", nil, err
+	}
+
+	username := t.claims.GetString(b.UsernameClaim)
+	if username == "" {
+		username = t.claims.GetString("sub")
+	}
+	if username == "" {
+		return "", nil, errors.New("No username found in token")
+	}
+
+	groups := t.claims.GetStringList(b.GroupsClaim)
+	if len(b.AllowedGroups) > 0 && !b.isAllowed(groups) {
+		return "", nil, fmt.Errorf("User %s is not member of an allowed group", username)
+	}
+
+	return username, groups, nil
+}
+
+func (b *OIDCAuthenticationBackend) isAllowed(groups []string) bool {
+	for _, group := range groups {
+		for _, allowed := range b.AllowedGroups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getBearerToken(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	if cookie, err := r.Cookie("authtok"); err == nil {
+		return cookie.Value
+	}
+
+	// browsers can not set headers on WebSocket upgrade requests
+	return r.URL.Query().Get("access_token")
+}
+
+// Authenticate uses the resource owner password credentials grant to retrieve a
+// token from the identity provider. The returned token is used as the
+// authentication cookie.
+func (b *OIDCAuthenticationBackend) Authenticate(username string, password string) (string, error) {
+	provider, err := b.getProvider()
+	if err != nil {
+		return "", err
+	}
+
+	values := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+		"scope":      {strings.Join(append([]string{"openid"}, b.Scopes...), " ")},
+	}
+
+	req, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(b.ClientID), url.QueryEscape(b.ClientSecret))
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tr oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logging.GetLogger().Noticef("OpenID Connect authentication error for %s: %s %s", username, tr.Error, tr.Description)
+		return "", ErrWrongCredentials
+	}
+
+	// the ID token is always a JWT, the access token may be opaque
+	token := tr.IDToken
+	if token == "" {
+		token = tr.AccessToken
+	}
+
+	if _, _, err := b.ValidateToken(token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Wrap an HTTP handler, requests are authenticated using the bearer token
+// found in the Authorization header, the authentication cookie or the
+// access_token query parameter
+func (b *OIDCAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setTLSHeader(w, r)
+
+		token := getBearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			unauthorized(w, r)
+			return
+		}
+
+		username, groups, err := b.ValidateToken(token)
+		if err != nil {
+			logging.GetLogger().Warningf("Failed to validate token: %s", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			unauthorized(w, r)
+			return
+		}
+
+		ar := &auth.AuthenticatedRequest{Request: *r, Username: username}
+		copyRequestVars(r, &ar.Request)
+		context.Set(&ar.Request, groupsContextKey, groups)
+		wrapped(w, ar)
+		context.Clear(&ar.Request)
+	}
+}
+
+// GetUserGroups returns the groups of the authenticated user, if known by the
+// authentication backend
+func GetUserGroups(r *http.Request) []string {
+	if groups, ok := context.Get(r, groupsContextKey).([]string); ok {
+		return groups
+	}
+	return nil
+}
+
+// NewOIDCAuthenticationBackend returns a new OpenID Connect authentication backend
+func NewOIDCAuthenticationBackend(issuer, clientID, clientSecret, audience string) *OIDCAuthenticationBackend {
+	return &OIDCAuthenticationBackend{
+		Issuer:        issuer,
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		Audience:      audience,
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		KeysTTL:       time.Hour,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		keys:          make(map[string]crypto.PublicKey),
+	}
+}
+
+// NewOIDCAuthenticationBackendFromConfig returns a new OpenID Connect authentication backend
+// using the auth.oidc section of the configuration
+func NewOIDCAuthenticationBackendFromConfig() (*OIDCAuthenticationBackend, error) {
+	cfg := config.GetConfig()
+
+	issuer := cfg.GetString("auth.oidc.issuer")
+	if issuer == "" {
+		return nil, errors.New("Configuration error: auth.oidc.issuer is required")
+	}
+
+	clientID := cfg.GetString("auth.oidc.client_id")
+	clientSecret := cfg.GetString("auth.oidc.client_secret")
+	audience := cfg.GetString("auth.oidc.audience")
+
+	b := NewOIDCAuthenticationBackend(issuer, clientID, clientSecret, audience)
+	b.UsernameClaim = cfg.GetString("auth.oidc.username_claim")
+	b.GroupsClaim = cfg.GetString("auth.oidc.groups_claim")
+	b.AllowedGroups = cfg.GetStringSlice("auth.oidc.allowed_groups")
+	b.Scopes = cfg.GetStringSlice("auth.oidc.scopes")
+	b.KeysTTL = time.Duration(cfg.GetInt("auth.oidc.jwks_cache_ttl")) * time.Second
+
+	return b, nil
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+// fakeIssuer is a minimal OpenID Connect provider
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+}
+
+func (f *fakeIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": f.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	h := crypto.SHA256.New()
+	h.Write([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (f *fakeIssuer) claims(username string, groups ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                f.URL,
+		"aud":                "skydive",
+		"sub":                "1234",
+		"preferred_username": username,
+		"groups":             groups,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIssuer{key: key, kid: "key1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":         f.URL,
+			"jwks_uri":       f.URL + "/keys",
+			"token_endpoint": f.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JSONWebKeySet{
+			Keys: []JSONWebKey{{
+				KeyType: "RSA",
+				KeyID:   f.kid,
+				Use:     "sig",
+				N:       base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+		if id != "skydive" || secret != "secret" || r.Form.Get("password") != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "opaque",
+			"id_token":     f.sign(t, f.claims(r.Form.Get("username"), "admins")),
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+
+	return f
+}
+
+func TestOIDCValidateToken(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	backend := NewOIDCAuthenticationBackend(issuer.URL, "skydive", "secret", "")
+
+	username, groups, err := backend.ValidateToken(issuer.sign(t, issuer.claims("alice", "admins")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alice" || len(groups) != 1 || groups[0] != "admins" {
+		t.Errorf("Wrong user mapping, got %s %v", username, groups)
+	}
+
+	claims := issuer.claims("alice")
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	if _, _, err := backend.ValidateToken(issuer.sign(t, claims)); err != ErrTokenExpired {
+		t.Errorf("Expired token should be rejected, got %v", err)
+	}
+
+	claims = issuer.claims("alice")
+	claims["aud"] = []string{"other"}
+	if _, _, err := backend.ValidateToken(issuer.sign(t, claims)); err == nil {
+		t.Error("Token for another audience should be rejected")
+	}
+
+	claims = issuer.claims("alice")
+	claims["iss"] = "https://evil.example.com"
+	if _, _, err := backend.ValidateToken(issuer.sign(t, claims)); err == nil {
+		t.Error("Token from another issuer should be rejected")
+	}
+
+	token := issuer.sign(t, issuer.claims("alice"))
+	if _, _, err := backend.ValidateToken(token[:len(token)-4] + "AAAA"); err == nil {
+		t.Error("Token with a wrong signature should be rejected")
+	}
+
+	backend.AllowedGroups = []string{"admins"}
+	if _, _, err := backend.ValidateToken(issuer.sign(t, issuer.claims("bob", "users"))); err == nil {
+		t.Error("User not in allowed groups should be rejected")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	backend := NewOIDCAuthenticationBackend(issuer.URL, "skydive", "secret", "")
+	if _, _, err := backend.ValidateToken(issuer.sign(t, issuer.claims("alice"))); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.key, issuer.kid = key, "key2"
+
+	// keys just fetched, unknown key ID must not trigger a new download
+	if _, _, err := backend.ValidateToken(issuer.sign(t, issuer.claims("alice"))); err != ErrUnknownSigningKey {
+		t.Errorf("Expected unknown signing key error, got %v", err)
+	}
+
+	backend.keysFetched = time.Now().Add(-jwksMinRefreshInterval)
+	if _, _, err := backend.ValidateToken(issuer.sign(t, issuer.claims("alice"))); err != nil {
+		t.Errorf("Rotated key should have been fetched: %s", err)
+	}
+}
+
+func TestOIDCWrap(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	backend := NewOIDCAuthenticationBackend(issuer.URL, "skydive", "secret", "")
+
+	var username string
+	var groups []string
+	handler := backend.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		username, groups = r.Username, GetUserGroups(&r.Request)
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Request without token should be rejected, got %d", w.Code)
+	}
+
+	token, err := backend.Authenticate("alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.Authenticate("alice", "wrong"); err != ErrWrongCredentials {
+		t.Errorf("Expected wrong credentials error, got %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || username != "alice" || len(groups) != 1 {
+		t.Errorf("Request with bearer token should be accepted, got %d %s %v", w.Code, username, groups)
+	}
+
+	r = httptest.NewRequest("GET", "/ws/subscriber", nil)
+	r.AddCookie(&http.Cookie{Name: "authtok", Value: token})
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Request with cookie should be accepted, got %d", w.Code)
+	}
+}
@@ -83,6 +83,7 @@ type Server struct {
 	Addr        string
 	Port        int
 	Auth        AuthenticationBackend
+	CertAuth    CertificateAuthorizer
 	lock        sync.Mutex
 	listener    net.Listener
 	CnxType     ConnectionType
@@ -102,7 +103,7 @@ func (s *Server) RegisterRoutes(routes []Route) {
 		r := s.Router.
 			Methods(route.Method).
 			Name(route.Name).
-			Handler(s.Auth.Wrap(route.HandlerFunc))
+			Handler(s.wrap(route.HandlerFunc))
 		switch p := route.Path.(type) {
 		case string:
 			r.Path(p)
@@ -126,8 +127,9 @@ func (s *Server) Listen() error {
 		certPEM := config.GetConfig().GetString("analyzer.X509_cert")
 		keyPEM := config.GetConfig().GetString("analyzer.X509_key")
 		agentCertPEM := config.GetConfig().GetString("agent.X509_cert")
+		enrollmentCAPEM := config.GetConfig().GetString("analyzer.enrollment.ca_cert")
 		tlsConfig := common.SetupTLSServerConfig(certPEM, keyPEM)
-		tlsConfig.ClientCAs = common.SetupTLSLoadCertificate(agentCertPEM)
+		tlsConfig.ClientCAs = common.SetupTLSLoadCertificates(agentCertPEM, enrollmentCAPEM)
 		s.listener = tls.NewListener(ln.(*net.TCPListener), tlsConfig)
 	}
 
@@ -225,6 +227,15 @@ func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
 func (s *Server) serveLogin(w http.ResponseWriter, r *http.Request) {
 	setTLSHeader(w, r)
 	if r.Method == "POST" {
+		// clients enrolled with a client certificate are already authenticated
+		if host, err := s.certificateHost(r); err != nil {
+			unauthorized(w, r)
+			return
+		} else if host != "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
 		r.ParseForm()
 		loginForm, passwordForm := r.Form["username"], r.Form["password"]
 		if len(loginForm) != 0 && len(passwordForm) != 0 {
@@ -255,7 +266,7 @@ func unauthorized(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) HandleFunc(path string, f auth.AuthenticatedHandlerFunc) {
-	s.Router.HandleFunc(path, s.Auth.Wrap(f))
+	s.Router.HandleFunc(path, s.wrap(f))
 }
 
 func (s *Server) loadExtraAssets(folder string) {
@@ -29,6 +29,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -51,6 +52,12 @@ type WSMessage interface {
 	Bytes() []byte
 }
 
+// WSMessageEncoder is the interface implemented by the messages that can be
+// encoded with another wire format than JSON.
+type WSMessageEncoder interface {
+	Encode(format WSFormat) ([]byte, error)
+}
+
 // WSRawMessage represents a raw message (array of bytes)
 type WSRawMessage []byte
 
@@ -68,6 +75,7 @@ type WSSpeaker interface {
 	GetServiceType() common.ServiceType
 	GetHeaders() http.Header
 	GetURL() *url.URL
+	GetFormat() WSFormat
 	IsConnected() bool
 	SendMessage(m WSMessage) error
 	Connect()
@@ -128,6 +136,8 @@ type WSConn struct {
 	pingTicker    *time.Ticker // only used by incoming connections
 	eventHandlers []WSSpeakerEventHandler
 	wsSpeaker     WSSpeaker // speaker owning the connection
+	format        WSFormat  // negotiated wire format
+	bulkMaxMsgs   int
 }
 
 // wsIncomingClient is only used internally to handle incoming client. It embeds a WSConn.
@@ -141,6 +151,7 @@ type WSClient struct {
 	*WSConn
 	Path       string
 	AuthClient *AuthenticationClient
+	formats    []WSFormat // formats advertised to the server
 }
 
 // WSSpeakerEventHandler is the interface to be implement by the client events listeners.
@@ -181,6 +192,13 @@ func (c *WSConn) GetURL() *url.URL {
 	return c.Url
 }
 
+// GetFormat returns the wire format negotiated for the connection.
+func (c *WSConn) GetFormat() WSFormat {
+	c.RLock()
+	defer c.RUnlock()
+	return c.format
+}
+
 // IsConnected returns the connection status.
 func (c *WSConn) IsConnected() bool {
 	return atomic.LoadInt32((*int32)(c.State)) == common.RunningState
@@ -197,12 +215,24 @@ func (c *WSConn) GetStatus() WSConnStatus {
 	return c.WSConnStatus
 }
 
-// SendMessage adds a message to sending queue.
+// SendMessage adds a message to sending queue. The message is encoded with
+// the negotiated format if it supports it.
 func (c *WSConn) SendMessage(m WSMessage) error {
 	if !c.IsConnected() {
 		return errors.New("Not connected")
 	}
 
+	if format := c.GetFormat(); format != JSONFormat {
+		if e, ok := m.(WSMessageEncoder); ok {
+			b, err := e.Encode(format)
+			if err != nil {
+				return err
+			}
+			c.send <- b
+			return nil
+		}
+	}
+
 	c.send <- m.Bytes()
 
 	return nil
@@ -237,7 +267,12 @@ func (c *WSConn) write(msg []byte) error {
 
 	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 
-	w, err := c.conn.NextWriter(websocket.TextMessage)
+	messageType := websocket.TextMessage
+	if c.GetFormat() != JSONFormat {
+		messageType = websocket.BinaryMessage
+	}
+
+	w, err := c.conn.NextWriter(messageType)
 	if err != nil {
 		return err
 	}
@@ -249,6 +284,34 @@ func (c *WSConn) write(msg []byte) error {
 	return w.Close()
 }
 
+// bulk gathers the messages already queued, up to bulkMaxMsgs, in a single
+// bulk message. Only used with the binary formats as old peers don't handle
+// bulk messages.
+func (c *WSConn) bulk(msg []byte) []byte {
+	msgs := [][]byte{msg}
+
+LOOP:
+	for len(msgs) < c.bulkMaxMsgs {
+		select {
+		case m := <-c.send:
+			msgs = append(msgs, m)
+		default:
+			break LOOP
+		}
+	}
+
+	if len(msgs) == 1 {
+		return msg
+	}
+
+	b, err := newMsgpackBulk(msgs)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while creating bulk message: %s", err)
+		return msg
+	}
+	return b
+}
+
 func (c *WSConn) start() {
 	c.wg.Add(1)
 	go c.run()
@@ -288,6 +351,9 @@ func (c *WSConn) run() {
 		for {
 			select {
 			case m := <-c.send:
+				if c.GetFormat() == MsgpackFormat {
+					m = c.bulk(m)
+				}
 				if err := c.write(m); err != nil {
 					logging.GetLogger().Errorf("Error while writing to the WebSocket: %s", err)
 				}
@@ -364,10 +430,12 @@ func newWSConn(host string, clientType common.ServiceType, url *url.URL, headers
 			Url:         url,
 			headers:     headers,
 		},
-		send:       make(chan []byte, queueSize),
-		read:       make(chan []byte, queueSize),
-		quit:       make(chan bool, 2),
-		pingTicker: &time.Ticker{},
+		send:        make(chan []byte, queueSize),
+		read:        make(chan []byte, queueSize),
+		quit:        make(chan bool, 2),
+		pingTicker:  &time.Ticker{},
+		format:      JSONFormat,
+		bulkMaxMsgs: config.GetConfig().GetInt("ws_bulk_maxmsgs"),
 	}
 	*c.State = common.StoppedState
 	c.running.Store(true)
@@ -391,6 +459,14 @@ func (c *WSClient) connect() {
 		"X-Websocket-Namespace": {WilcardNamespace},
 	}
 
+	if len(c.formats) > 0 {
+		formats := make([]string, len(c.formats))
+		for i, format := range c.formats {
+			formats[i] = string(format)
+		}
+		headers.Set(wsFormatHeader, strings.Join(formats, ", "))
+	}
+
 	if c.AuthClient != nil {
 		if err = c.AuthClient.Authenticate(); err != nil {
 			logging.GetLogger().Errorf("Unable to authenticate %s : %s", endpoint, err.Error())
@@ -401,12 +477,15 @@ func (c *WSClient) connect() {
 	setCookies(&headers, c.AuthClient)
 
 	d := websocket.Dialer{
-		Proxy:           http.ProxyFromEnvironment,
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		Proxy:             http.ProxyFromEnvironment,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: config.GetConfig().GetBool("ws_compression"),
 	}
 	d.TLSClientConfig = getTLSConfig(false)
-	c.conn, _, err = d.Dial(endpoint, headers)
+
+	var resp *http.Response
+	c.conn, resp, err = d.Dial(endpoint, headers)
 
 	if err != nil {
 		logging.GetLogger().Errorf("Unable to create a WebSocket connection %s : %s", endpoint, err.Error())
@@ -414,6 +493,14 @@ func (c *WSClient) connect() {
 	}
 	c.conn.SetPingHandler(nil)
 
+	// servers not aware of the negotiation don't answer, JSON is then used
+	c.Lock()
+	c.format = JSONFormat
+	if format := WSFormat(resp.Header.Get(wsFormatHeader)); format == MsgpackFormat {
+		c.format = format
+	}
+	c.Unlock()
+
 	atomic.StoreInt32((*int32)(c.State), common.RunningState)
 	defer atomic.StoreInt32((*int32)(c.State), common.StoppedState)
 
@@ -482,6 +569,7 @@ func newIncomingWSClient(conn *websocket.Conn, r *auth.AuthenticatedRequest) *ws
 	url := config.GetURL("http", svc.Addr, svc.Port, r.URL.Path+"?"+r.URL.RawQuery)
 	wsconn := newWSConn(host, clientType, url, r.Header, queueSize)
 	wsconn.conn = conn
+	wsconn.format = wsFormatFromRequest(&r.Request)
 
 	pingDelay := time.Duration(config.GetConfig().GetInt("ws_ping_delay")) * time.Second
 	pongTimeout := time.Duration(config.GetConfig().GetInt("ws_pong_timeout"))*time.Second + pingDelay
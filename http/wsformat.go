@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+
+	"github.com/skydive-project/skydive/config"
+)
+
+// WSFormat describes the wire format used by the speakers of a connection
+type WSFormat string
+
+// Wire formats, JSON is used when nothing is negotiated so that old peers
+// and the WebUI keep working
+const (
+	JSONFormat    WSFormat = "json"
+	MsgpackFormat WSFormat = "msgpack"
+)
+
+const wsFormatHeader = "X-Websocket-Format"
+
+// MsgpackMarshaler is the interface implemented by the message values that can
+// be serialized in MessagePack. Other values are embedded as JSON in MessagePack
+// messages.
+type MsgpackMarshaler interface {
+	MarshalMsgpack() ([]byte, error)
+}
+
+// wsMsgpackMessage is the MessagePack envelope of a WSJSONMessage. Format gives
+// the encoding of Obj.
+type wsMsgpackMessage struct {
+	Namespace string
+	Type      string
+	UUID      string
+	Status    int
+	Seq       int64
+	Format    WSFormat
+	Obj       []byte
+}
+
+var msgpackHandle = newMsgpackHandle()
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.SignedInteger = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+// MsgpackMarshal serializes the given value in MessagePack
+func MsgpackMarshal(v interface{}) (b []byte, err error) {
+	err = codec.NewEncoderBytes(&b, msgpackHandle).Encode(v)
+	return
+}
+
+// MsgpackUnmarshal deserializes MessagePack data. Maps are decoded as
+// map[string]interface{} and integers as int64.
+func MsgpackUnmarshal(b []byte, v interface{}) error {
+	return codec.NewDecoderBytes(b, msgpackHandle).Decode(v)
+}
+
+// newMsgpackBulk wraps already encoded messages into a single bulk message
+func newMsgpackBulk(msgs [][]byte) ([]byte, error) {
+	obj, err := MsgpackMarshal(msgs)
+	if err != nil {
+		return nil, err
+	}
+	return MsgpackMarshal(&wsMsgpackMessage{Type: BulkMsgType, Format: MsgpackFormat, Obj: obj})
+}
+
+// wsFormatsFromConfig returns the formats a client advertises, by order of
+// preference
+func wsFormatsFromConfig() []WSFormat {
+	if format := WSFormat(config.GetConfig().GetString("ws_format")); format == MsgpackFormat {
+		return []WSFormat{MsgpackFormat, JSONFormat}
+	}
+	return []WSFormat{JSONFormat}
+}
+
+// wsFormatFromRequest returns the first format requested by a client that
+// is supported
+func wsFormatFromRequest(r *http.Request) WSFormat {
+	for _, value := range r.Header[wsFormatHeader] {
+		for _, format := range strings.Split(value, ",") {
+			switch WSFormat(strings.TrimSpace(format)) {
+			case MsgpackFormat:
+				return MsgpackFormat
+			case JSONFormat:
+				return JSONFormat
+			}
+		}
+	}
+	return JSONFormat
+}
@@ -23,6 +23,7 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -54,7 +55,12 @@ type WSJSONMessage struct {
 	UUID      string `json:",omitempty"`
 	Obj       *json.RawMessage
 	Status    int
-	value     interface{}
+	// sequence number of the message in the stream of its sender, used to
+	// resume a stream after a disconnection
+	Seq   int64 `json:",omitempty"`
+	value interface{}
+	// value received in MessagePack, Obj is then nil
+	msgpackObj []byte
 }
 
 // Marshal serializes the WSJSONMessage into a JSON string.
@@ -74,6 +80,60 @@ func (g WSJSONMessage) Bytes() []byte {
 	return g.Marshal()
 }
 
+// Encode serializes the WSJSONMessage with the given wire format. With
+// MessagePack, only values implementing MsgpackMarshaler are natively encoded,
+// others are embedded as JSON so that their handlers don't have to care about
+// the wire format.
+func (g WSJSONMessage) Encode(format WSFormat) ([]byte, error) {
+	if format != MsgpackFormat {
+		return g.Marshal(), nil
+	}
+
+	m := &wsMsgpackMessage{
+		Namespace: g.Namespace,
+		Type:      g.Type,
+		UUID:      g.UUID,
+		Status:    g.Status,
+		Seq:       g.Seq,
+		Format:    JSONFormat,
+	}
+
+	var err error
+	switch v := g.value.(type) {
+	case MsgpackMarshaler:
+		m.Format = MsgpackFormat
+		m.Obj, err = v.MarshalMsgpack()
+	default:
+		switch {
+		case g.msgpackObj != nil:
+			m.Format = MsgpackFormat
+			m.Obj = g.msgpackObj
+		case g.Obj != nil:
+			m.Obj = []byte(*g.Obj)
+		default:
+			m.Obj, err = json.Marshal(g.value)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return MsgpackMarshal(m)
+}
+
+// DecodeObj deserializes the value of the message whatever the wire format
+// it was received with. JSON numbers are decoded as json.Number while
+// MessagePack ones are decoded as int64 or float64.
+func (g *WSJSONMessage) DecodeObj(v interface{}) error {
+	if g.msgpackObj != nil {
+		return MsgpackUnmarshal(g.msgpackObj, v)
+	}
+	if g.Obj == nil {
+		return errors.New("No value in message")
+	}
+	return common.JSONDecode(bytes.NewReader([]byte(*g.Obj)), v)
+}
+
 // Reply returns a reply message with the given value, type and status.
 // Basically it return a new WSJSONMessage with the correct Namespace and UUID.
 func (g *WSJSONMessage) Reply(v interface{}, kind string, status int) *WSJSONMessage {
@@ -259,10 +319,15 @@ func (s *WSJSONSpeaker) Request(m *WSJSONMessage, timeout time.Duration) (*WSJSO
 }
 
 // OnMessage checks that the WSMessage comes from a WSJSONSpeaker. It parses
-// the JSON message and then dispatch the message to the proper listeners according
-// to the namespace.
+// the message according to the wire format of the connection and then dispatch
+// the message to the proper listeners according to the namespace.
 func (s *WSJSONSpeaker) OnMessage(c WSSpeaker, m WSMessage) {
 	if c, ok := c.(*WSJSONSpeaker); ok {
+		if c.GetFormat() == MsgpackFormat {
+			s.onMsgpackMessage(c, m)
+			return
+		}
+
 		jm := &WSJSONMessage{}
 		if err := json.Unmarshal(m.Bytes(), jm); err != nil {
 			logging.GetLogger().Errorf("Error while decoding WSJSONMessage %s", err.Error())
@@ -271,7 +336,7 @@ func (s *WSJSONSpeaker) OnMessage(c WSSpeaker, m WSMessage) {
 
 		if jm.Type == BulkMsgType {
 			var bulkMessage WSBulkMessage
-			if err := json.Unmarshal([]byte(*jm.Obj), &bulkMessage); err != nil {
+			if err := json.Unmarshal([]byte(*jm.Obj), &bulkMessage); err == nil {
 				for _, jm := range bulkMessage {
 					s.OnMessage(c, WSRawMessage([]byte(jm)))
 				}
@@ -283,6 +348,43 @@ func (s *WSJSONSpeaker) OnMessage(c WSSpeaker, m WSMessage) {
 	}
 }
 
+func (s *WSJSONSpeaker) onMsgpackMessage(c *WSJSONSpeaker, m WSMessage) {
+	var mm wsMsgpackMessage
+	if err := MsgpackUnmarshal(m.Bytes(), &mm); err != nil {
+		logging.GetLogger().Errorf("Error while decoding MessagePack message %s", err.Error())
+		return
+	}
+
+	if mm.Type == BulkMsgType {
+		var bulkMessage [][]byte
+		if err := MsgpackUnmarshal(mm.Obj, &bulkMessage); err != nil {
+			logging.GetLogger().Errorf("Error while decoding MessagePack bulk message %s", err.Error())
+			return
+		}
+		for _, b := range bulkMessage {
+			s.onMsgpackMessage(c, WSRawMessage(b))
+		}
+		return
+	}
+
+	jm := &WSJSONMessage{
+		Namespace: mm.Namespace,
+		Type:      mm.Type,
+		UUID:      mm.UUID,
+		Status:    mm.Status,
+		Seq:       mm.Seq,
+	}
+
+	if mm.Format == MsgpackFormat {
+		jm.msgpackObj = mm.Obj
+	} else {
+		raw := json.RawMessage(mm.Obj)
+		jm.Obj = &raw
+	}
+
+	s.wsJSONSpeakerEventDispatcher.dispatchMessage(c, jm)
+}
+
 func newWSJSONSpeaker(c WSSpeaker) *WSJSONSpeaker {
 	s := &WSJSONSpeaker{
 		WSSpeaker:                    c,
@@ -297,7 +399,10 @@ func newWSJSONSpeaker(c WSSpeaker) *WSJSONSpeaker {
 	return s
 }
 
+// UpgradeToWSJSONSpeaker upgrades the client to a WSJSONSpeaker. The client
+// then advertises the wire formats it supports to the server.
 func (c *WSClient) UpgradeToWSJSONSpeaker() *WSJSONSpeaker {
+	c.formats = wsFormatsFromConfig()
 	js := newWSJSONSpeaker(c)
 	c.wsSpeaker = js
 	return js
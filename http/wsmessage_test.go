@@ -148,3 +148,155 @@ func TestWSMessageSubscription(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+type fakeMsgpackValue struct {
+	Value string
+}
+
+func (f *fakeMsgpackValue) MarshalMsgpack() ([]byte, error) {
+	return MsgpackMarshal(&struct{ Value string }{Value: f.Value})
+}
+
+type fakeWSMessageFormatHandler struct {
+	sync.RWMutex
+	DefaultWSSpeakerEventHandler
+	received map[string]string
+	formats  map[string]WSFormat
+}
+
+func (f *fakeWSMessageFormatHandler) OnWSJSONMessage(c WSSpeaker, m *WSJSONMessage) {
+	var obj map[string]interface{}
+	if err := m.DecodeObj(&obj); err != nil {
+		return
+	}
+
+	f.Lock()
+	f.received[m.Type], _ = obj["Value"].(string)
+	f.formats[c.GetHost()] = c.GetFormat()
+	f.Unlock()
+}
+
+func TestWSMessageFormat(t *testing.T) {
+	httpserver := NewServer("myhost", common.AnalyzerService, "localhost", 59998, NewNoAuthenticationBackend(), "")
+
+	go httpserver.ListenAndServe()
+	defer httpserver.Stop()
+
+	wsserver := NewWSJSONServer(NewWSServer(httpserver, "/wstest"))
+
+	serverHandler := &fakeWSMessageFormatHandler{received: make(map[string]string), formats: make(map[string]WSFormat)}
+	wsserver.AddJSONMessageHandler(serverHandler, []string{"NS"})
+
+	wsserver.Start()
+	defer wsserver.Stop()
+
+	wspool := NewWSJSONClientPool("TestWSMessageFormat")
+
+	msgpackClient := NewWSClient("msgpack", common.AgentService, config.GetURL("ws", "localhost", 59998, "/wstest"), nil, http.Header{}, 1000)
+	wspool.AddClient(msgpackClient)
+
+	// simulate a client not aware of the format negotiation
+	jsonClient := NewWSClient("json", common.AgentService, config.GetURL("ws", "localhost", 59998, "/wstest"), nil, http.Header{}, 1000)
+	wspool.AddClient(jsonClient)
+	jsonClient.formats = nil
+
+	msgpackClient.Connect()
+	defer msgpackClient.Disconnect()
+
+	jsonClient.Connect()
+	defer jsonClient.Disconnect()
+
+	err := common.Retry(func() error {
+		if !msgpackClient.IsConnected() || !jsonClient.IsConnected() {
+			return fmt.Errorf("Clients not connected")
+		}
+		return nil
+	}, 5, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format := msgpackClient.GetFormat(); format != MsgpackFormat {
+		t.Errorf("Expected msgpack format, got %s", format)
+	}
+	if format := jsonClient.GetFormat(); format != JSONFormat {
+		t.Errorf("Expected json format, got %s", format)
+	}
+
+	for _, c := range []*WSClient{msgpackClient, jsonClient} {
+		c.SendMessage(NewWSJSONMessage("NS", c.GetHost()+"-native", &fakeMsgpackValue{Value: "native"}))
+		c.SendMessage(NewWSJSONMessage("NS", c.GetHost()+"-embedded", map[string]string{"Value": "embedded"}))
+	}
+
+	err = common.Retry(func() error {
+		serverHandler.Lock()
+		defer serverHandler.Unlock()
+
+		if len(serverHandler.received) != 4 {
+			return fmt.Errorf("Server should have received 4 messages: %v", serverHandler.received)
+		}
+
+		for _, host := range []string{"msgpack", "json"} {
+			if value := serverHandler.received[host+"-native"]; value != "native" {
+				return fmt.Errorf("Wrong value received from %s: %s", host, value)
+			}
+			if value := serverHandler.received[host+"-embedded"]; value != "embedded" {
+				return fmt.Errorf("Wrong value received from %s: %s", host, value)
+			}
+		}
+
+		if serverHandler.formats["msgpack"] != MsgpackFormat || serverHandler.formats["json"] != JSONFormat {
+			return fmt.Errorf("Wrong formats negotiated by the server: %v", serverHandler.formats)
+		}
+
+		return nil
+	}, 5, time.Second)
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestWSMessageMsgpackBulk(t *testing.T) {
+	var msgs [][]byte
+	for _, kind := range []string{"A", "B"} {
+		b, err := NewWSJSONMessage("NS", kind, &fakeMsgpackValue{Value: kind}).Encode(MsgpackFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, b)
+	}
+
+	bulk, err := newMsgpackBulk(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m wsMsgpackMessage
+	if err := MsgpackUnmarshal(bulk, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != BulkMsgType {
+		t.Fatalf("Expected a bulk message, got %s", m.Type)
+	}
+
+	var bulkMessage [][]byte
+	if err := MsgpackUnmarshal(m.Obj, &bulkMessage); err != nil {
+		t.Fatal(err)
+	}
+	if len(bulkMessage) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(bulkMessage))
+	}
+
+	if err := MsgpackUnmarshal(bulkMessage[1], &m); err != nil {
+		t.Fatal(err)
+	}
+
+	var obj map[string]interface{}
+	if err := MsgpackUnmarshal(m.Obj, &obj); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != "B" || m.Format != MsgpackFormat || obj["Value"] != "B" {
+		t.Errorf("Wrong message decoded: %+v, %+v", m, obj)
+	}
+}
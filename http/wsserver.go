@@ -30,6 +30,7 @@ import (
 	"github.com/abbot/go-http-auth"
 	"github.com/gorilla/websocket"
 
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/logging"
 )
 
@@ -78,7 +79,25 @@ func (s *WSServer) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedReq
 		return
 	}
 
-	conn, err := websocket.Upgrade(w, &r.Request, nil, 1024, 1024)
+	// answer with the negotiated format only when requested, as old clients
+	// only speak JSON
+	var header http.Header
+	if _, ok := r.Header[wsFormatHeader]; ok {
+		header = http.Header{wsFormatHeader: {string(wsFormatFromRequest(&r.Request))}}
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: config.GetConfig().GetBool("ws_compression"),
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		},
+	}
+
+	conn, err := upgrader.Upgrade(w, &r.Request, header)
 	if err != nil {
 		return
 	}
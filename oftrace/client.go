@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package oftrace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/packet_injector"
+)
+
+// OfTraceReply is the reply of an agent to a trace request
+type OfTraceReply struct {
+	Trace *Trace
+	Error string
+}
+
+// OfTraceClient requests OpenFlow traces to the agents
+type OfTraceClient struct {
+	pool shttp.WSJSONSpeakerPool
+}
+
+// Trace asks the agent of the given host to trace a packet
+func (oc *OfTraceClient) Trace(host string, pp *packet_injector.PacketParams) (*Trace, error) {
+	msg := shttp.NewWSJSONMessage(Namespace, "OfTraceRequest", pp)
+
+	resp, err := oc.pool.Request(host, msg, shttp.DefaultRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to send message to agent %s: %s", host, err.Error())
+	}
+
+	var reply OfTraceReply
+	if err := json.Unmarshal([]byte(*resp.Obj), &reply); err != nil {
+		return nil, fmt.Errorf("Failed to parse response from %s: %s", host, err.Error())
+	}
+
+	if resp.Status != http.StatusOK {
+		return nil, errors.New(reply.Error)
+	}
+
+	return reply.Trace, nil
+}
+
+// NewOfTraceClient returns a new OpenFlow trace client
+func NewOfTraceClient(pool shttp.WSJSONSpeakerPool) *OfTraceClient {
+	return &OfTraceClient{pool: pool}
+}
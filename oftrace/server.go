@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package oftrace
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/skydive-project/skydive/common"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/packet_injector"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	// Namespace OpenFlow trace
+	Namespace = "OfTrace"
+)
+
+// OfTraceServer traces packets on the OpenFlow bridges of an agent
+type OfTraceServer struct {
+	Graph *graph.Graph
+}
+
+func (ots *OfTraceServer) trace(msg *shttp.WSJSONMessage) (*Trace, error) {
+	var params packet_injector.PacketParams
+	if err := common.JSONDecode(bytes.NewBuffer([]byte(*msg.Obj)), &params); err != nil {
+		return nil, fmt.Errorf("Unable to decode trace param message %v", msg)
+	}
+
+	trace, err := TracePacket(&params, ots.Graph)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to trace packet: %s", err.Error())
+	}
+
+	return trace, nil
+}
+
+// OnWSJSONMessage event, websocket OfTraceRequest message
+func (ots *OfTraceServer) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
+	switch msg.Type {
+	case "OfTraceRequest":
+		var reply *shttp.WSJSONMessage
+		trace, err := ots.trace(msg)
+		replyObj := &OfTraceReply{Trace: trace}
+		if err != nil {
+			logging.GetLogger().Error(err)
+
+			replyObj.Error = err.Error()
+			reply = msg.Reply(replyObj, "OfTraceResult", http.StatusBadRequest)
+		} else {
+			reply = msg.Reply(replyObj, "OfTraceResult", http.StatusOK)
+		}
+
+		c.SendMessage(reply)
+	}
+}
+
+// NewServer creates a new OpenFlow trace server based on websocket server
+func NewServer(graph *graph.Graph, pool shttp.WSJSONSpeakerPool) *OfTraceServer {
+	s := &OfTraceServer{
+		Graph: graph,
+	}
+	pool.AddJSONMessageHandler(s, []string{Namespace})
+	return s
+}
@@ -0,0 +1,416 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package oftrace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skydive-project/skydive/packet_injector"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+var (
+	bridgeRegexp = regexp.MustCompile(`^bridge\("(.+)"\)$`)
+	tableRegexp  = regexp.MustCompile(`^(\d+)\. (.*)$`)
+	ruleRegexp   = regexp.MustCompile(`^(?:(.*), )?priority (\d+)(?:, cookie (0x[0-9a-fA-F]+))?$`)
+	outputRegexp = regexp.MustCompile(`^output:(\d+)$`)
+)
+
+// TraceHop describes an OpenFlow table lookup done while tracing a packet
+type TraceHop struct {
+	Bridge   string
+	Table    int
+	Filters  string
+	Priority int
+	Cookie   string
+	Actions  []string
+	Matched  bool
+	RuleID   graph.Identifier `json:",omitempty"`
+}
+
+// TraceOutput describes a port a traced packet is sent to
+type TraceOutput struct {
+	Bridge string
+	OfPort int64
+	NodeID graph.Identifier `json:",omitempty"`
+}
+
+// Trace is the result of an OpenFlow pipeline trace, rules and ports are
+// mapped to the nodes of the graph when found.
+type Trace struct {
+	NodeID          graph.Identifier
+	Bridge          string
+	Flow            string
+	Hops            []*TraceHop
+	Outputs         []*TraceOutput
+	FinalFlow       string
+	Megaflow        string
+	DatapathActions string
+}
+
+// traceCommand runs ofproto/trace on a bridge, it can be overridden by tests
+var traceCommand = func(bridge string, flow string) ([]byte, error) {
+	/* #nosec */
+	command := exec.Command("ovs-appctl", "ofproto/trace", bridge, flow)
+	return command.CombinedOutput()
+}
+
+// Path returns the identifiers of the source node, of the matched rules and
+// of the output ports in the order they were traversed
+func (t *Trace) Path() []graph.Identifier {
+	path := []graph.Identifier{t.NodeID}
+	for _, hop := range t.Hops {
+		if hop.RuleID != "" {
+			path = append(path, hop.RuleID)
+		}
+	}
+	for _, output := range t.Outputs {
+		if output.NodeID != "" {
+			path = append(path, output.NodeID)
+		}
+	}
+	return path
+}
+
+// ParseTrace parses the output of ovs-appctl ofproto/trace
+func ParseTrace(output string) (*Trace, error) {
+	trace := &Trace{}
+
+	var bridge string
+	var hop *TraceHop
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "---") || strings.HasPrefix(line, "->") || strings.HasPrefix(line, ">>") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "Flow: "):
+			trace.Flow = strings.TrimPrefix(line, "Flow: ")
+		case strings.HasPrefix(line, "Final flow: "):
+			trace.FinalFlow = strings.TrimPrefix(line, "Final flow: ")
+		case strings.HasPrefix(line, "Megaflow: "):
+			trace.Megaflow = strings.TrimPrefix(line, "Megaflow: ")
+		case strings.HasPrefix(line, "Datapath actions: "):
+			trace.DatapathActions = strings.TrimPrefix(line, "Datapath actions: ")
+		default:
+			if m := bridgeRegexp.FindStringSubmatch(line); m != nil {
+				if bridge = m[1]; trace.Bridge == "" {
+					trace.Bridge = bridge
+				}
+				hop = nil
+				continue
+			}
+
+			if m := tableRegexp.FindStringSubmatch(line); m != nil {
+				table, _ := strconv.Atoi(m[1])
+				hop = &TraceHop{Bridge: bridge, Table: table, Cookie: "0x0"}
+				trace.Hops = append(trace.Hops, hop)
+
+				if r := ruleRegexp.FindStringSubmatch(m[2]); r != nil {
+					hop.Matched = true
+					hop.Filters = r[1]
+					hop.Priority, _ = strconv.Atoi(r[2])
+					if r[3] != "" {
+						hop.Cookie = strings.ToLower(r[3])
+					}
+				}
+				continue
+			}
+
+			if hop == nil {
+				continue
+			}
+
+			hop.Actions = append(hop.Actions, line)
+			if m := outputRegexp.FindStringSubmatch(line); m != nil {
+				ofport, _ := strconv.ParseInt(m[1], 10, 64)
+				trace.Outputs = append(trace.Outputs, &TraceOutput{Bridge: bridge, OfPort: ofport})
+			}
+		}
+	}
+
+	if trace.Bridge == "" {
+		return nil, errors.New("No bridge found in trace output")
+	}
+
+	return trace, nil
+}
+
+// traceIP returns the address of an IP parameter, with or without prefix
+// length, checking that it belongs to the expected family
+func traceIP(param string, ipv6 bool) (string, error) {
+	addr := param
+	if strings.Contains(param, "/") {
+		ip, _, err := net.ParseCIDR(param)
+		if err != nil {
+			return "", fmt.Errorf("Invalid IP address: %s", param)
+		}
+		addr = ip.String()
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil || (ip.To4() == nil) != ipv6 {
+		return "", fmt.Errorf("Invalid IP address: %s", param)
+	}
+	return ip.String(), nil
+}
+
+// traceFlow returns the ofproto/trace flow description of a packet
+// entering the switch through the given OpenFlow port. All the fields are
+// parsed so that no user value can add match fields to the description.
+func traceFlow(ofport int64, pp *packet_injector.PacketParams) (string, error) {
+	var proto, ipSrc, ipDst, l4 string
+	var ipv6 bool
+
+	switch pp.Type {
+	case "", "icmp4":
+		proto, ipSrc, ipDst, l4 = "icmp", "nw_src", "nw_dst", "icmp_type=8,icmp_code=0"
+	case "icmp6":
+		proto, ipSrc, ipDst, l4, ipv6 = "icmp6", "ipv6_src", "ipv6_dst", "icmpv6_type=128,icmpv6_code=0", true
+	case "tcp4", "udp4":
+		proto, ipSrc, ipDst = pp.Type[:3], "nw_src", "nw_dst"
+	case "tcp6", "udp6":
+		proto, ipSrc, ipDst, ipv6 = pp.Type, "ipv6_src", "ipv6_dst", true
+	default:
+		return "", fmt.Errorf("Unsupported packet type: %s", pp.Type)
+	}
+
+	fields := []string{proto, fmt.Sprintf("in_port=%d", ofport)}
+	if pp.SrcMAC != "" {
+		mac, err := net.ParseMAC(pp.SrcMAC)
+		if err != nil {
+			return "", fmt.Errorf("Invalid source MAC address: %s", pp.SrcMAC)
+		}
+		fields = append(fields, "dl_src="+mac.String())
+	}
+	if pp.DstMAC != "" {
+		mac, err := net.ParseMAC(pp.DstMAC)
+		if err != nil {
+			return "", fmt.Errorf("Invalid destination MAC address: %s", pp.DstMAC)
+		}
+		fields = append(fields, "dl_dst="+mac.String())
+	}
+	if pp.SrcIP != "" {
+		ip, err := traceIP(pp.SrcIP, ipv6)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, ipSrc+"="+ip)
+	}
+	if pp.DstIP != "" {
+		ip, err := traceIP(pp.DstIP, ipv6)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, ipDst+"="+ip)
+	}
+	if l4 != "" {
+		fields = append(fields, l4)
+	} else {
+		if pp.SrcPort < 0 || pp.SrcPort > 65535 || pp.DstPort < 0 || pp.DstPort > 65535 {
+			return "", fmt.Errorf("Invalid ports: %d, %d", pp.SrcPort, pp.DstPort)
+		}
+		if pp.SrcPort != 0 {
+			fields = append(fields, fmt.Sprintf("tp_src=%d", pp.SrcPort))
+		}
+		if pp.DstPort != 0 {
+			fields = append(fields, fmt.Sprintf("tp_dst=%d", pp.DstPort))
+		}
+	}
+
+	return strings.Join(fields, ","), nil
+}
+
+// sourcePort returns the bridge and the OpenFlow port of a node, being
+// either an ovsport node or one of its interfaces
+func sourcePort(g *graph.Graph, node *graph.Node) (*graph.Node, int64, error) {
+	port, ofport := node, int64(0)
+
+	if tp, _ := node.GetFieldString("Type"); tp == "ovsport" {
+		for _, intf := range g.LookupChildren(port, nil, topology.Layer2Metadata) {
+			if n, err := intf.GetFieldInt64("OfPort"); err == nil {
+				ofport = n
+				break
+			}
+		}
+	} else {
+		n, err := node.GetFieldInt64("OfPort")
+		if err != nil {
+			return nil, 0, errors.New("Source node is not an OVS port")
+		}
+		ofport = n
+
+		port = nil
+		if parents := g.LookupParents(node, graph.Metadata{"Type": "ovsport"}, topology.Layer2Metadata); len(parents) > 0 {
+			port = parents[0]
+		}
+	}
+
+	if port == nil || ofport == 0 {
+		return nil, 0, errors.New("Unable to find the OpenFlow port of the source node")
+	}
+
+	bridges := g.LookupParents(port, graph.Metadata{"Type": "ovsbridge"}, topology.OwnershipMetadata)
+	if len(bridges) == 0 {
+		return nil, 0, errors.New("Unable to find the bridge of the source node")
+	}
+
+	return bridges[0], ofport, nil
+}
+
+// normalizeFilters returns the match fields of a rule sorted, without the
+// priority that ovs-ofctl reports as part of the filters
+func normalizeFilters(filters string) string {
+	var fields []string
+	for _, field := range strings.Split(filters, ",") {
+		if field = strings.TrimSpace(field); field != "" && !strings.HasPrefix(field, "priority=") {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// lookupRule returns the ofrule node of a bridge matching a trace hop. If
+// the filters differ only by their formatting, a single rule with the same
+// table, priority and cookie is accepted.
+func lookupRule(g *graph.Graph, bridge *graph.Node, hop *TraceHop) *graph.Node {
+	var candidates []*graph.Node
+
+	filters := normalizeFilters(hop.Filters)
+	for _, rule := range g.LookupChildren(bridge, graph.Metadata{"Type": "ofrule"}, topology.OwnershipMetadata) {
+		table, _ := rule.GetFieldInt64("table")
+		priority, _ := rule.GetFieldInt64("priority")
+		cookie, _ := rule.GetFieldString("cookie")
+		if int(table) != hop.Table || int(priority) != hop.Priority || cookie != hop.Cookie {
+			continue
+		}
+
+		if f, _ := rule.GetFieldString("filters"); normalizeFilters(f) == filters {
+			return rule
+		}
+		candidates = append(candidates, rule)
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return nil
+}
+
+// lookupPort returns the interface node of a bridge having the given
+// OpenFlow port number
+func lookupPort(g *graph.Graph, bridge *graph.Node, ofport int64) *graph.Node {
+	for _, port := range g.LookupChildren(bridge, graph.Metadata{"Type": "ovsport"}, topology.OwnershipMetadata) {
+		for _, intf := range g.LookupChildren(port, nil, topology.Layer2Metadata) {
+			if n, err := intf.GetFieldInt64("OfPort"); err == nil && n == ofport {
+				return intf
+			}
+		}
+	}
+	return nil
+}
+
+// resolveTrace maps the hops and outputs of a trace to the graph nodes
+func resolveTrace(g *graph.Graph, trace *Trace) {
+	bridges := make(map[string]*graph.Node)
+	lookupBridge := func(name string) *graph.Node {
+		bridge, ok := bridges[name]
+		if !ok {
+			bridge = g.LookupFirstNode(graph.Metadata{"Type": "ovsbridge", "Name": name})
+			bridges[name] = bridge
+		}
+		return bridge
+	}
+
+	for _, hop := range trace.Hops {
+		if bridge := lookupBridge(hop.Bridge); bridge != nil && hop.Matched {
+			if rule := lookupRule(g, bridge, hop); rule != nil {
+				hop.RuleID = rule.ID
+			}
+		}
+	}
+
+	for _, output := range trace.Outputs {
+		if bridge := lookupBridge(output.Bridge); bridge != nil {
+			if port := lookupPort(g, bridge, output.OfPort); port != nil {
+				output.NodeID = port.ID
+			}
+		}
+	}
+}
+
+// TracePacket traces the OpenFlow pipeline crossed by a packet entering a
+// bridge through the source port of the packet parameters
+func TracePacket(pp *packet_injector.PacketParams, g *graph.Graph) (*Trace, error) {
+	g.RLock()
+
+	srcNode := g.GetNode(pp.SrcNodeID)
+	if srcNode == nil {
+		g.RUnlock()
+		return nil, errors.New("Unable to find source node")
+	}
+
+	bridge, ofport, err := sourcePort(g, srcNode)
+	if err != nil {
+		g.RUnlock()
+		return nil, err
+	}
+	bridgeName, _ := bridge.GetFieldString("Name")
+
+	g.RUnlock()
+
+	flow, err := traceFlow(ofport, pp)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := traceCommand(bridgeName, flow)
+	if err != nil {
+		return nil, fmt.Errorf("ofproto/trace failed on %s: %s: %s", bridgeName, err.Error(), strings.TrimSpace(string(output)))
+	}
+
+	trace, err := ParseTrace(string(output))
+	if err != nil {
+		return nil, err
+	}
+	trace.NodeID = pp.SrcNodeID
+
+	g.RLock()
+	resolveTrace(g, trace)
+	g.RUnlock()
+
+	return trace, nil
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package oftrace
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/packet_injector"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const traceOutput = `Flow: icmp,in_port=1,vlan_tci=0x0000,dl_src=00:00:00:00:00:00,dl_dst=00:00:00:00:00:00,nw_src=0.0.0.0,nw_dst=192.168.0.2,nw_tos=0,nw_ecn=0,nw_ttl=0,icmp_type=8,icmp_code=0
+
+bridge("br-test1")
+------------------
+ 0. in_port=1, priority 10
+    resubmit(,1)
+     1. icmp, priority 5, cookie 0x2a
+            output:2
+ 2. No match.
+    drop
+
+Final flow: unchanged
+Megaflow: recirc_id=0,eth,icmp,in_port=1,nw_frag=no
+Datapath actions: 3
+`
+
+func TestParseTrace(t *testing.T) {
+	trace, err := ParseTrace(traceOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trace.Bridge != "br-test1" || trace.DatapathActions != "3" || trace.FinalFlow != "unchanged" {
+		t.Errorf("Wrong trace summary: %+v", trace)
+	}
+
+	if len(trace.Hops) != 3 {
+		t.Fatalf("Expected 3 hops, got %d", len(trace.Hops))
+	}
+
+	hop := trace.Hops[1]
+	if !hop.Matched || hop.Table != 1 || hop.Filters != "icmp" || hop.Priority != 5 || hop.Cookie != "0x2a" || len(hop.Actions) != 1 {
+		t.Errorf("Wrong hop: %+v", hop)
+	}
+
+	if trace.Hops[2].Matched {
+		t.Errorf("Hop should not match any rule: %+v", trace.Hops[2])
+	}
+
+	if len(trace.Outputs) != 1 || trace.Outputs[0].OfPort != 2 || trace.Outputs[0].Bridge != "br-test1" {
+		t.Errorf("Wrong outputs: %+v", trace.Outputs)
+	}
+
+	if _, err := ParseTrace("ovs-appctl: br-test2: unknown bridge"); err == nil {
+		t.Error("Expected an error for an invalid trace")
+	}
+}
+
+func TestTracePacket(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	bridge := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge", "Name": "br-test1"})
+	newPort := func(name string, ofport int64) *graph.Node {
+		port := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsport", "Name": name})
+		intf := g.NewNode(graph.GenID(), graph.Metadata{"Type": "internal", "Name": name, "OfPort": ofport})
+		topology.AddOwnershipLink(g, bridge, port, nil)
+		topology.AddLayer2Link(g, port, intf, nil)
+		return port
+	}
+	newRule := func(table int, priority int, cookie string, filters string) *graph.Node {
+		rule := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ofrule", "table": table, "priority": priority, "cookie": cookie, "filters": filters})
+		topology.AddOwnershipLink(g, bridge, rule, nil)
+		return rule
+	}
+	src := newPort("intf1", 1)
+	newPort("intf2", 2)
+	rule0 := newRule(0, 10, "0x0", "priority=10,in_port=1")
+	newRule(0, 10, "0x0", "priority=10,in_port=2")
+	rule1 := newRule(1, 5, "0x2a", "priority=5,icmp")
+	g.Unlock()
+
+	traceCommand = func(bridge string, flow string) ([]byte, error) {
+		if bridge != "br-test1" || flow != "icmp,in_port=1,nw_dst=192.168.0.2,icmp_type=8,icmp_code=0" {
+			t.Errorf("Unexpected trace of %s on %s", flow, bridge)
+		}
+		return []byte(traceOutput), nil
+	}
+
+	trace, err := TracePacket(&packet_injector.PacketParams{SrcNodeID: src.ID, DstIP: "192.168.0.2/32", Type: "icmp4"}, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trace.Hops[0].RuleID != rule0.ID || trace.Hops[1].RuleID != rule1.ID || trace.Hops[2].RuleID != "" {
+		t.Errorf("Wrong rules matched: %+v", trace.Hops)
+	}
+
+	if trace.Outputs[0].NodeID == "" {
+		t.Error("Output port not found")
+	}
+
+	if path := trace.Path(); len(path) != 4 || path[0] != src.ID {
+		t.Errorf("Wrong path: %v", path)
+	}
+}
+
+func TestTraceFlow(t *testing.T) {
+	flow, err := traceFlow(1, &packet_injector.PacketParams{SrcMAC: "00:11:22:33:44:55", SrcIP: "10.0.0.1/24", DstIP: "10.0.0.2", DstPort: 80, Type: "tcp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flow != "tcp,in_port=1,dl_src=00:11:22:33:44:55,nw_src=10.0.0.1,nw_dst=10.0.0.2,tp_dst=80" {
+		t.Errorf("Wrong trace flow: %s", flow)
+	}
+
+	// values that could add match fields or actions are rejected
+	for _, pp := range []*packet_injector.PacketParams{
+		{SrcMAC: "00:11:22:33:44:55,actions=drop", Type: "icmp4"},
+		{DstMAC: "00:11:22:33:44:55,in_port=2", Type: "icmp4"},
+		{SrcIP: "10.0.0.1,nw_tos=4", Type: "icmp4"},
+		{DstIP: "10.0.0.2/32,tp_dst=22", Type: "udp4"},
+		{DstIP: "fe80::1", Type: "icmp4"},
+		{DstIP: "10.0.0.2", Type: "icmp6"},
+		{DstPort: 70000, Type: "tcp6"},
+	} {
+		if flow, err := traceFlow(1, pp); err == nil {
+			t.Errorf("Expected an error for %+v, got: %s", pp, flow)
+		}
+	}
+}
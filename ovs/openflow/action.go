@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package openflow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	instructionGotoTable     uint16 = 1
+	instructionWriteMetadata uint16 = 2
+	instructionWriteActions  uint16 = 3
+	instructionApplyActions  uint16 = 4
+	instructionClearActions  uint16 = 5
+	instructionMeter         uint16 = 6
+
+	actionOutput       uint16 = 0
+	actionCopyTTLOut   uint16 = 11
+	actionCopyTTLIn    uint16 = 12
+	actionSetMplsTTL   uint16 = 15
+	actionDecMplsTTL   uint16 = 16
+	actionPushVlan     uint16 = 17
+	actionPopVlan      uint16 = 18
+	actionPushMpls     uint16 = 19
+	actionPopMpls      uint16 = 20
+	actionSetQueue     uint16 = 21
+	actionGroup        uint16 = 22
+	actionSetNwTTL     uint16 = 23
+	actionDecNwTTL     uint16 = 24
+	actionSetField     uint16 = 25
+	actionPushPbb      uint16 = 26
+	actionPopPbb       uint16 = 27
+	actionExperimenter uint16 = 0xffff
+
+	experimenterNicira uint32 = 0x00002320
+
+	nxActionResubmit      uint16 = 1
+	nxActionResubmitTable uint16 = 14
+
+	nxPortInPort uint16 = 0xfff8
+)
+
+// parseInstructions decodes the instructions of a flow and returns them
+// in the ovs-ofctl actions syntax
+func parseInstructions(b []byte) (string, error) {
+	var actions []string
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return "", errTruncated
+		}
+
+		insType := binary.BigEndian.Uint16(b)
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || length > len(b) {
+			return "", errTruncated
+		}
+		ins := b[:length]
+
+		switch insType {
+		case instructionGotoTable:
+			if length < 8 {
+				return "", errTruncated
+			}
+			actions = append(actions, fmt.Sprintf("goto_table:%d", ins[4]))
+		case instructionWriteMetadata:
+			if length < 24 {
+				return "", errTruncated
+			}
+			metadata := fmt.Sprintf("write_metadata:0x%x", binary.BigEndian.Uint64(ins[8:]))
+			if mask := binary.BigEndian.Uint64(ins[16:]); mask != ^uint64(0) {
+				metadata += fmt.Sprintf("/0x%x", mask)
+			}
+			actions = append(actions, metadata)
+		case instructionWriteActions, instructionApplyActions:
+			if length < 8 {
+				return "", errTruncated
+			}
+			list, err := parseActions(ins[8:])
+			if err != nil {
+				return "", err
+			}
+			if insType == instructionApplyActions {
+				actions = append(actions, list...)
+			} else {
+				actions = append(actions, "write_actions("+strings.Join(list, ",")+")")
+			}
+		case instructionClearActions:
+			actions = append(actions, "clear_actions")
+		case instructionMeter:
+			if length < 8 {
+				return "", errTruncated
+			}
+			actions = append(actions, fmt.Sprintf("meter:%d", binary.BigEndian.Uint32(ins[4:])))
+		default:
+			actions = append(actions, fmt.Sprintf("instruction(%d)", insType))
+		}
+		b = b[length:]
+	}
+
+	if len(actions) == 0 {
+		return "drop", nil
+	}
+	return strings.Join(actions, ","), nil
+}
+
+// parseActions decodes an action list
+func parseActions(b []byte) ([]string, error) {
+	var actions []string
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errTruncated
+		}
+
+		actType := binary.BigEndian.Uint16(b)
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 8 || length > len(b) {
+			return nil, errTruncated
+		}
+
+		action, err := parseAction(actType, b[:length])
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+		b = b[length:]
+	}
+	return actions, nil
+}
+
+func parseAction(actType uint16, act []byte) (string, error) {
+	length := len(act)
+
+	switch actType {
+	case actionOutput:
+		if length < 16 {
+			return "", errTruncated
+		}
+		port := binary.BigEndian.Uint32(act[4:])
+		switch port {
+		case 0xfffffffd:
+			return fmt.Sprintf("CONTROLLER:%d", binary.BigEndian.Uint16(act[8:])), nil
+		case 0xfffffff8, 0xfffffffa, 0xfffffffb, 0xfffffffc, 0xfffffffe:
+			return formatPortNumber(port), nil
+		}
+		return "output:" + formatPortNumber(port), nil
+	case actionCopyTTLOut:
+		return "copy_ttl_out", nil
+	case actionCopyTTLIn:
+		return "copy_ttl_in", nil
+	case actionSetMplsTTL:
+		return fmt.Sprintf("set_mpls_ttl(%d)", act[4]), nil
+	case actionDecMplsTTL:
+		return "dec_mpls_ttl", nil
+	case actionPushVlan:
+		return fmt.Sprintf("push_vlan:0x%04x", binary.BigEndian.Uint16(act[4:])), nil
+	case actionPopVlan:
+		return "strip_vlan", nil
+	case actionPushMpls:
+		return fmt.Sprintf("push_mpls:0x%04x", binary.BigEndian.Uint16(act[4:])), nil
+	case actionPopMpls:
+		return fmt.Sprintf("pop_mpls:0x%04x", binary.BigEndian.Uint16(act[4:])), nil
+	case actionSetQueue:
+		return fmt.Sprintf("set_queue:%d", binary.BigEndian.Uint32(act[4:])), nil
+	case actionGroup:
+		return fmt.Sprintf("group:%d", binary.BigEndian.Uint32(act[4:])), nil
+	case actionSetNwTTL:
+		return fmt.Sprintf("mod_nw_ttl:%d", act[4]), nil
+	case actionDecNwTTL:
+		return "dec_ttl", nil
+	case actionSetField:
+		o, err := parseOXM(act[4:])
+		if err != nil {
+			return "", err
+		}
+		name, _ := o.name()
+		return fmt.Sprintf("set_field:%s->%s", o.formatValue(), name), nil
+	case actionPushPbb:
+		return fmt.Sprintf("push_pbb:0x%04x", binary.BigEndian.Uint16(act[4:])), nil
+	case actionPopPbb:
+		return "pop_pbb", nil
+	case actionExperimenter:
+		return parseExperimenterAction(act), nil
+	}
+	return fmt.Sprintf("action(%d)", actType), nil
+}
+
+// parseExperimenterAction decodes the Nicira resubmit actions, the other
+// experimenter actions are only named
+func parseExperimenterAction(act []byte) string {
+	experimenter := binary.BigEndian.Uint32(act[4:])
+	if experimenter != experimenterNicira || len(act) < 16 {
+		return fmt.Sprintf("experimenter(0x%x)", experimenter)
+	}
+
+	subtype := binary.BigEndian.Uint16(act[8:])
+	inPort := binary.BigEndian.Uint16(act[10:])
+
+	switch subtype {
+	case nxActionResubmit:
+		return fmt.Sprintf("resubmit:%d", inPort)
+	case nxActionResubmitTable:
+		port := ""
+		if inPort != nxPortInPort {
+			port = fmt.Sprintf("%d", inPort)
+		}
+		table := ""
+		if act[12] != tableAll {
+			table = fmt.Sprintf("%d", act[12])
+		}
+		return fmt.Sprintf("resubmit(%s,%s)", port, table)
+	}
+	return fmt.Sprintf("nx_action(%d)", subtype)
+}
@@ -0,0 +1,353 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package openflow
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	dialTimeout    = 5 * time.Second
+	monitorTimeout = 5 * time.Second
+	updateQueueLen = 1000
+)
+
+var (
+	// ErrClosed is returned when the connection to the switch is closed
+	ErrClosed = errors.New("OpenFlow connection closed")
+	// ErrMonitorNotSupported is returned when the negotiated version does
+	// not support flow monitoring
+	ErrMonitorNotSupported = errors.New("Flow monitoring requires OpenFlow 1.4")
+)
+
+type result struct {
+	body []byte
+	err  error
+}
+
+type request struct {
+	body []byte
+	done chan result
+}
+
+// Client is an OpenFlow connection to a switch, acting as a controller
+type Client struct {
+	sync.RWMutex
+	conn      net.Conn
+	version   uint8
+	xid       uint32
+	writeLock sync.Mutex
+	pending   map[uint32]*request
+	monitors  map[uint32]chan *FlowUpdate
+	err       error
+	closed    chan struct{}
+}
+
+// Dial connects to a switch. Addresses use the Open vSwitch syntax:
+// unix:<path>, tcp:<host>:<port> or ssl:<host>:<port>, the TLS
+// configuration being used for the latter.
+func Dial(address string, tlsConfig *tls.Config) (*Client, error) {
+	var conn net.Conn
+	var err error
+
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+		conn, err = net.DialTimeout("unix", path, dialTimeout)
+	case strings.HasPrefix(address, "tcp:"):
+		conn, err = net.DialTimeout("tcp", strings.TrimPrefix(address, "tcp:"), dialTimeout)
+	case strings.HasPrefix(address, "ssl:"):
+		if tlsConfig == nil {
+			return nil, fmt.Errorf("A TLS configuration is required to connect to %s", address)
+		}
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", strings.TrimPrefix(address, "ssl:"), tlsConfig)
+	default:
+		return nil, fmt.Errorf("Unsupported OpenFlow address: %s", address)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewClient negotiates the OpenFlow version over the given connection and
+// starts handling the messages of the switch
+func NewClient(conn net.Conn) (*Client, error) {
+	c := &Client{
+		conn:     conn,
+		pending:  make(map[uint32]*request),
+		monitors: make(map[uint32]chan *FlowUpdate),
+		closed:   make(chan struct{}),
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err := conn.Write(newHello(c.nextXid())); err != nil {
+		return nil, err
+	}
+
+	h, body, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Type != typeHello {
+		return nil, fmt.Errorf("Expected OpenFlow hello message, got type %d", h.Type)
+	}
+
+	if c.version, err = negotiateVersion(h, body); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	go c.run()
+
+	return c, nil
+}
+
+// Version returns the negotiated OpenFlow version
+func (c *Client) Version() uint8 {
+	return c.version
+}
+
+func (c *Client) nextXid() uint32 {
+	return atomic.AddUint32(&c.xid, 1)
+}
+
+func (c *Client) readMessage() (header, []byte, error) {
+	buf := make([]byte, headerLen)
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return header{}, nil, err
+	}
+
+	h := parseHeader(buf)
+	if h.Length < headerLen {
+		return h, nil, errTruncated
+	}
+
+	body := make([]byte, h.Length-headerLen)
+	if _, err := io.ReadFull(c.conn, body); err != nil {
+		return h, nil, err
+	}
+
+	return h, body, nil
+}
+
+func (c *Client) send(msg []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// run handles the messages of the switch until the connection is closed
+func (c *Client) run() {
+	var err error
+	for err == nil {
+		var h header
+		var body []byte
+		if h, body, err = c.readMessage(); err != nil {
+			break
+		}
+
+		switch h.Type {
+		case typeEchoRequest:
+			err = c.send(newMessage(c.version, typeEchoReply, h.Xid, body))
+		case typeError:
+			c.complete(h.Xid, nil, parseError(body))
+		case typeMultipartReply:
+			c.handleMultipartReply(h, body)
+		}
+	}
+
+	c.Lock()
+	if c.err = err; err == io.EOF {
+		c.err = ErrClosed
+	}
+	for xid, req := range c.pending {
+		req.done <- result{err: c.err}
+		delete(c.pending, xid)
+	}
+	for xid, updates := range c.monitors {
+		close(updates)
+		delete(c.monitors, xid)
+	}
+	c.Unlock()
+
+	close(c.closed)
+}
+
+func (c *Client) complete(xid uint32, body []byte, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if req, ok := c.pending[xid]; ok {
+		req.done <- result{body: body, err: err}
+		delete(c.pending, xid)
+	}
+}
+
+func (c *Client) handleMultipartReply(h header, body []byte) {
+	if len(body) < 8 {
+		return
+	}
+
+	mpType := binary.BigEndian.Uint16(body)
+	flags := binary.BigEndian.Uint16(body[2:])
+	body = body[8:]
+
+	if mpType == multipartFlowMonitor {
+		c.RLock()
+		updates, ok := c.monitors[h.Xid]
+		c.RUnlock()
+
+		if ok {
+			flowUpdates, err := parseFlowUpdates(body)
+			if err != nil {
+				c.complete(h.Xid, nil, err)
+				return
+			}
+			for _, update := range flowUpdates {
+				select {
+				case updates <- update:
+				case <-time.After(monitorTimeout):
+					// the consumer is too slow, close the connection so that
+					// the flows are dumped again after a reconnection
+					c.conn.Close()
+					return
+				}
+			}
+		}
+	}
+
+	c.Lock()
+	req, ok := c.pending[h.Xid]
+	if ok {
+		req.body = append(req.body, body...)
+	}
+	c.Unlock()
+
+	if ok && flags&multipartReplyMore == 0 {
+		c.complete(h.Xid, req.body, nil)
+	}
+}
+
+// request sends a message and waits for its reply
+func (c *Client) request(ctx context.Context, xid uint32, msg []byte) ([]byte, error) {
+	req := &request{done: make(chan result, 1)}
+
+	c.Lock()
+	if c.err != nil {
+		c.Unlock()
+		return nil, c.err
+	}
+	c.pending[xid] = req
+	c.Unlock()
+
+	if err := c.send(msg); err != nil {
+		c.complete(xid, nil, err)
+	}
+
+	select {
+	case res := <-req.done:
+		return res.body, res.err
+	case <-ctx.Done():
+		c.Lock()
+		delete(c.pending, xid)
+		c.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// FlowStats returns the flows of all the tables of the switch
+func (c *Client) FlowStats(ctx context.Context) ([]*Flow, error) {
+	xid := c.nextXid()
+	body, err := c.request(ctx, xid, newFlowStatsRequest(c.version, xid))
+	if err != nil {
+		return nil, err
+	}
+	return parseFlowStats(body)
+}
+
+// Monitor requests the switch to report the changes of its flows. The
+// returned channel is closed when the connection is closed.
+func (c *Client) Monitor(ctx context.Context) (<-chan *FlowUpdate, error) {
+	if c.version < Version14 {
+		return nil, ErrMonitorNotSupported
+	}
+
+	xid := c.nextXid()
+	updates := make(chan *FlowUpdate, updateQueueLen)
+
+	c.Lock()
+	c.monitors[xid] = updates
+	c.Unlock()
+
+	if _, err := c.request(ctx, xid, newFlowMonitorRequest(c.version, xid, xid)); err != nil {
+		c.Lock()
+		if _, ok := c.monitors[xid]; ok {
+			delete(c.monitors, xid)
+			close(updates)
+		}
+		c.Unlock()
+		return nil, err
+	}
+
+	return updates, nil
+}
+
+// Err returns the error that closed the connection
+func (c *Client) Err() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.err
+}
+
+// Closed returns a channel closed when the connection is closed
+func (c *Client) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Close closes the connection to the switch
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
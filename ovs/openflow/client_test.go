@@ -0,0 +1,318 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package openflow
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func oxmField(field uint8, value ...byte) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, oxmClassBasic)
+	b[2] = field << 1
+	b[3] = uint8(len(value))
+	return append(b, value...)
+}
+
+func oxmMatch(fields ...[]byte) []byte {
+	b := make([]byte, 4)
+	for _, field := range fields {
+		b = append(b, field...)
+	}
+	binary.BigEndian.PutUint16(b, matchTypeOXM)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func outputAction(port uint32) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint16(b, actionOutput)
+	binary.BigEndian.PutUint16(b[2:], 16)
+	binary.BigEndian.PutUint32(b[4:], port)
+	binary.BigEndian.PutUint16(b[8:], 0xffff)
+	return b
+}
+
+func resubmitAction(table uint8) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint16(b, actionExperimenter)
+	binary.BigEndian.PutUint16(b[2:], 16)
+	binary.BigEndian.PutUint32(b[4:], experimenterNicira)
+	binary.BigEndian.PutUint16(b[8:], nxActionResubmitTable)
+	binary.BigEndian.PutUint16(b[10:], nxPortInPort)
+	b[12] = table
+	return b
+}
+
+func applyActions(actions ...[]byte) []byte {
+	b := make([]byte, 8)
+	for _, action := range actions {
+		b = append(b, action...)
+	}
+	binary.BigEndian.PutUint16(b, instructionApplyActions)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+func flowStatsEntry(table uint8, priority uint16, cookie uint64, packets uint64, match []byte, instructions []byte) []byte {
+	b := make([]byte, 48)
+	b[2] = table
+	binary.BigEndian.PutUint32(b[4:], 12)
+	binary.BigEndian.PutUint16(b[12:], priority)
+	binary.BigEndian.PutUint64(b[24:], cookie)
+	binary.BigEndian.PutUint64(b[32:], packets)
+	binary.BigEndian.PutUint64(b[40:], packets*100)
+	b = append(append(b, match...), instructions...)
+	binary.BigEndian.PutUint16(b, uint16(len(b)))
+	return b
+}
+
+func flowUpdateEntry(event FlowUpdateEvent, table uint8, priority uint16, cookie uint64, match []byte, instructions []byte) []byte {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint16(b[2:], uint16(event))
+	b[4] = table
+	binary.BigEndian.PutUint16(b[10:], priority)
+	binary.BigEndian.PutUint64(b[16:], cookie)
+	b = append(append(b, match...), instructions...)
+	binary.BigEndian.PutUint16(b, uint16(len(b)))
+	return b
+}
+
+func multipartReply(version uint8, xid uint32, mpType uint16, more bool, body []byte) []byte {
+	mp := make([]byte, 8)
+	binary.BigEndian.PutUint16(mp, mpType)
+	if more {
+		binary.BigEndian.PutUint16(mp[2:], multipartReplyMore)
+	}
+	return newMessage(version, typeMultipartReply, xid, append(mp, body...))
+}
+
+// fakeSwitch answers the requests of a client with a fixed set of flows
+type fakeSwitch struct {
+	t       *testing.T
+	conn    net.Conn
+	version uint8
+	echoed  chan struct{}
+	monitor chan uint32
+	writes  chan []byte
+}
+
+// write queues a message as net.Pipe doesn't provide any buffering
+func (s *fakeSwitch) write(msg []byte) {
+	s.writes <- msg
+}
+
+func (s *fakeSwitch) writer() {
+	for msg := range s.writes {
+		if _, err := s.conn.Write(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeSwitch) read() (header, []byte, error) {
+	buf := make([]byte, headerLen)
+	if _, err := io.ReadFull(s.conn, buf); err != nil {
+		return header{}, nil, err
+	}
+	h := parseHeader(buf)
+	body := make([]byte, h.Length-headerLen)
+	if _, err := io.ReadFull(s.conn, body); err != nil {
+		return h, nil, err
+	}
+	return h, body, nil
+}
+
+func (s *fakeSwitch) run() {
+	if h, _, err := s.read(); err != nil || h.Type != typeHello {
+		s.t.Errorf("Expected hello message, got %+v (%v)", h, err)
+		return
+	}
+
+	hello := make([]byte, 8)
+	binary.BigEndian.PutUint16(hello, helloElemVersionBitmap)
+	binary.BigEndian.PutUint16(hello[2:], 8)
+	binary.BigEndian.PutUint32(hello[4:], 1<<s.version)
+	s.write(newMessage(s.version, typeHello, 1, hello))
+
+	s.write(newMessage(s.version, typeEchoRequest, 2, []byte("ping")))
+
+	for {
+		h, body, err := s.read()
+		if err != nil {
+			return
+		}
+
+		if h.Version != s.version {
+			s.t.Errorf("Expected version %d, got %d", s.version, h.Version)
+		}
+
+		switch h.Type {
+		case typeEchoReply:
+			if string(body) != "ping" {
+				s.t.Errorf("Wrong echo reply payload: %s", string(body))
+			}
+			close(s.echoed)
+		case typeMultipartRequest:
+			switch binary.BigEndian.Uint16(body) {
+			case multipartFlow:
+				flow1 := flowStatsEntry(0, 100, 0x1, 5,
+					oxmMatch(oxmField(oxmEthType, 0x08, 0x00), oxmField(oxmIPProto, 6), oxmField(11, 10, 0, 0, 1)),
+					applyActions(outputAction(2)))
+				flow2 := flowStatsEntry(1, DefaultPriority, 0, 0, oxmMatch(oxmField(0, 0, 0, 0, 3)), applyActions(resubmitAction(2)))
+				flow3 := flowStatsEntry(2, 0, 0, 0, oxmMatch(), nil)
+
+				s.write(multipartReply(s.version, h.Xid, multipartFlow, true, append(flow1, flow2...)))
+				s.write(multipartReply(s.version, h.Xid, multipartFlow, false, flow3))
+			case multipartFlowMonitor:
+				s.write(multipartReply(s.version, h.Xid, multipartFlowMonitor, false, nil))
+				s.monitor <- h.Xid
+			}
+		}
+	}
+}
+
+func newFakeSwitch(t *testing.T, version uint8) (*fakeSwitch, *Client) {
+	clientConn, switchConn := net.Pipe()
+
+	s := &fakeSwitch{
+		t:       t,
+		conn:    switchConn,
+		version: version,
+		echoed:  make(chan struct{}),
+		monitor: make(chan uint32, 1),
+		writes:  make(chan []byte, 10),
+	}
+	go s.writer()
+	go s.run()
+
+	client, err := NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, client
+}
+
+func TestFlowStats(t *testing.T) {
+	s, client := newFakeSwitch(t, Version13)
+	defer client.Close()
+
+	if client.Version() != Version13 {
+		t.Fatalf("Expected OpenFlow 1.3, got %d", client.Version())
+	}
+
+	select {
+	case <-s.echoed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Echo request not answered")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	flows, err := client.FlowStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Flow{
+		{Table: 0, Priority: 100, Cookie: 0x1, Match: "tcp,nw_src=10.0.0.1", Actions: "output:2", Packets: 5, Bytes: 500, Duration: 12 * time.Second},
+		{Table: 1, Priority: DefaultPriority, Match: "in_port=3", Actions: "resubmit(,2)", Duration: 12 * time.Second},
+		{Table: 2, Priority: 0, Match: "", Actions: "drop", Duration: 12 * time.Second},
+	}
+
+	if len(flows) != len(expected) {
+		t.Fatalf("Expected %d flows, got %d", len(expected), len(flows))
+	}
+
+	for i, flow := range flows {
+		if *flow != expected[i] {
+			t.Errorf("Expected flow %+v, got %+v", expected[i], *flow)
+		}
+	}
+
+	if _, err := client.Monitor(ctx); err != ErrMonitorNotSupported {
+		t.Errorf("Flow monitoring should not be supported with OpenFlow 1.3, got %v", err)
+	}
+}
+
+func TestFlowMonitor(t *testing.T) {
+	s, client := newFakeSwitch(t, Version14)
+
+	if client.Version() != Version14 {
+		t.Fatalf("Expected OpenFlow 1.4, got %d", client.Version())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates, err := client.Monitor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xid := <-s.monitor
+	added := flowUpdateEntry(FlowAdded, 3, 200, 0x2a, oxmMatch(oxmField(oxmEthType, 0x08, 0x06)), applyActions(outputAction(0xfffffffb)))
+	removed := flowUpdateEntry(FlowRemoved, 3, 200, 0x2a, oxmMatch(oxmField(oxmEthType, 0x08, 0x06)), nil)
+	s.write(multipartReply(Version14, xid, multipartFlowMonitor, true, append(added, removed...)))
+
+	expected := []FlowUpdate{
+		{Event: FlowAdded, Flow: &Flow{Table: 3, Priority: 200, Cookie: 0x2a, Match: "arp", Actions: "FLOOD"}},
+		{Event: FlowRemoved, Flow: &Flow{Table: 3, Priority: 200, Cookie: 0x2a, Match: "arp", Actions: "drop"}},
+	}
+
+	for _, e := range expected {
+		select {
+		case update := <-updates:
+			if update.Event != e.Event || *update.Flow != *e.Flow {
+				t.Errorf("Expected update %d %+v, got %d %+v", e.Event, *e.Flow, update.Event, *update.Flow)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Flow update not received")
+		}
+	}
+
+	client.Close()
+
+	select {
+	case _, ok := <-updates:
+		if ok {
+			t.Error("Update channel should be closed with the connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Update channel not closed")
+	}
+
+	if client.Err() == nil {
+		t.Error("Expected an error once the connection is closed")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package openflow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	oxmClassNXM0  uint16 = 0x0000
+	oxmClassNXM1  uint16 = 0x0001
+	oxmClassBasic uint16 = 0x8000
+
+	oxmInPort   uint8 = 0
+	oxmEthType  uint8 = 5
+	oxmVlanVID  uint8 = 6
+	oxmIPProto  uint8 = 10
+	oxmTunnelID uint8 = 38
+
+	// nxmTunID is the field of the Nicira tunnel id in the NXM_NX class
+	nxmTunID uint8 = 16
+
+	vidPresent uint16 = 0x1000
+)
+
+type oxmFormat int
+
+const (
+	fieldDecimal oxmFormat = iota
+	fieldHex
+	fieldMAC
+	fieldIP
+	fieldPort
+	fieldVlan
+)
+
+type oxmFieldDesc struct {
+	name   string
+	format oxmFormat
+}
+
+// basicFields describes the fields of the OpenFlow basic class using the
+// ovs-ofctl names
+var basicFields = map[uint8]oxmFieldDesc{
+	0:  {"in_port", fieldPort},
+	1:  {"in_phy_port", fieldPort},
+	2:  {"metadata", fieldHex},
+	3:  {"dl_dst", fieldMAC},
+	4:  {"dl_src", fieldMAC},
+	5:  {"dl_type", fieldHex},
+	6:  {"dl_vlan", fieldVlan},
+	7:  {"dl_vlan_pcp", fieldDecimal},
+	8:  {"ip_dscp", fieldDecimal},
+	9:  {"nw_ecn", fieldDecimal},
+	10: {"nw_proto", fieldDecimal},
+	11: {"nw_src", fieldIP},
+	12: {"nw_dst", fieldIP},
+	13: {"tp_src", fieldDecimal},
+	14: {"tp_dst", fieldDecimal},
+	15: {"tp_src", fieldDecimal},
+	16: {"tp_dst", fieldDecimal},
+	17: {"tp_src", fieldDecimal},
+	18: {"tp_dst", fieldDecimal},
+	19: {"icmp_type", fieldDecimal},
+	20: {"icmp_code", fieldDecimal},
+	21: {"arp_op", fieldDecimal},
+	22: {"arp_spa", fieldIP},
+	23: {"arp_tpa", fieldIP},
+	24: {"arp_sha", fieldMAC},
+	25: {"arp_tha", fieldMAC},
+	26: {"ipv6_src", fieldIP},
+	27: {"ipv6_dst", fieldIP},
+	28: {"ipv6_label", fieldHex},
+	29: {"icmpv6_type", fieldDecimal},
+	30: {"icmpv6_code", fieldDecimal},
+	31: {"nd_target", fieldIP},
+	32: {"nd_sll", fieldMAC},
+	33: {"nd_tll", fieldMAC},
+	34: {"mpls_label", fieldDecimal},
+	35: {"mpls_tc", fieldDecimal},
+	36: {"mpls_bos", fieldDecimal},
+	37: {"pbb_isid", fieldDecimal},
+	38: {"tun_id", fieldHex},
+	39: {"ipv6_exthdr", fieldHex},
+}
+
+// oxm is a field of a match or of a set_field action
+type oxm struct {
+	class   uint16
+	field   uint8
+	hasMask bool
+	length  int
+	value   []byte
+	mask    []byte
+}
+
+func parseOXM(b []byte) (*oxm, error) {
+	if len(b) < 4 {
+		return nil, errTruncated
+	}
+
+	h := binary.BigEndian.Uint32(b)
+	o := &oxm{
+		class:   uint16(h >> 16),
+		field:   uint8(h>>9) & 0x7f,
+		hasMask: h&0x100 != 0,
+		length:  int(h & 0xff),
+	}
+
+	if 4+o.length > len(b) {
+		return nil, errTruncated
+	}
+
+	payload := b[4 : 4+o.length]
+	if o.hasMask {
+		o.value, o.mask = payload[:o.length/2], payload[o.length/2:]
+	} else {
+		o.value = payload
+	}
+
+	return o, nil
+}
+
+func (o *oxm) name() (string, oxmFormat) {
+	switch o.class {
+	case oxmClassBasic:
+		if desc, ok := basicFields[o.field]; ok {
+			return desc.name, desc.format
+		}
+	case oxmClassNXM1:
+		if o.field < 16 {
+			return fmt.Sprintf("reg%d", o.field), fieldHex
+		}
+		if o.field == nxmTunID {
+			return "tun_id", fieldHex
+		}
+	}
+	return fmt.Sprintf("oxm_0x%04x_%d", o.class, o.field), fieldHex
+}
+
+func (o *oxm) formatValue() string {
+	_, format := o.name()
+
+	switch format {
+	case fieldMAC:
+		s := net.HardwareAddr(o.value).String()
+		if o.hasMask {
+			s += "/" + net.HardwareAddr(o.mask).String()
+		}
+		return s
+	case fieldIP:
+		return formatIP(o.value, o.mask)
+	case fieldVlan:
+		if len(o.value) == 2 && !o.hasMask {
+			return fmt.Sprintf("%d", binary.BigEndian.Uint16(o.value)&^vidPresent)
+		}
+	case fieldPort:
+		if len(o.value) == 4 && !o.hasMask {
+			return formatPortNumber(binary.BigEndian.Uint32(o.value))
+		}
+	case fieldDecimal:
+		if !o.hasMask && len(o.value) <= 8 {
+			var v uint64
+			for _, b := range o.value {
+				v = v<<8 | uint64(b)
+			}
+			return fmt.Sprintf("%d", v)
+		}
+	}
+
+	s := formatHex(o.value)
+	if o.hasMask {
+		s += "/" + formatHex(o.mask)
+	}
+	return s
+}
+
+// String returns the field in the ovs-ofctl match syntax
+func (o *oxm) String() string {
+	name, _ := o.name()
+	return name + "=" + o.formatValue()
+}
+
+// formatPortNumber returns the name of the reserved ports or the port number
+func formatPortNumber(port uint32) string {
+	switch port {
+	case 0xfffffff8:
+		return "IN_PORT"
+	case 0xfffffff9:
+		return "TABLE"
+	case 0xfffffffa:
+		return "NORMAL"
+	case 0xfffffffb:
+		return "FLOOD"
+	case 0xfffffffc:
+		return "ALL"
+	case 0xfffffffd:
+		return "CONTROLLER"
+	case 0xfffffffe:
+		return "LOCAL"
+	case 0xffffffff:
+		return "ANY"
+	}
+	return fmt.Sprintf("%d", port)
+}
@@ -0,0 +1,421 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package openflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// OpenFlow versions supported by the client
+const (
+	Version13 uint8 = 0x04
+	Version14 uint8 = 0x05
+)
+
+const (
+	headerLen = 8
+
+	typeHello            uint8 = 0
+	typeError            uint8 = 1
+	typeEchoRequest      uint8 = 2
+	typeEchoReply        uint8 = 3
+	typeMultipartRequest uint8 = 18
+	typeMultipartReply   uint8 = 19
+
+	helloElemVersionBitmap uint16 = 1
+
+	multipartFlow        uint16 = 1
+	multipartFlowMonitor uint16 = 16
+	multipartReplyMore   uint16 = 1
+
+	portAny  uint32 = 0xffffffff
+	groupAny uint32 = 0xffffffff
+	tableAll uint8  = 0xff
+
+	matchTypeOXM uint16 = 1
+
+	// flow monitor flags, changes are reported with full instructions
+	monitorAdd          uint16 = 1 << 1
+	monitorRemoved      uint16 = 1 << 2
+	monitorModify       uint16 = 1 << 3
+	monitorInstructions uint16 = 1 << 4
+	monitorNoAbbrev     uint16 = 1 << 5
+
+	// DefaultPriority is the priority of a flow when not specified
+	DefaultPriority uint16 = 0x8000
+)
+
+// FlowUpdateEvent is the kind of change reported by a flow monitor
+type FlowUpdateEvent uint16
+
+// Flow monitor events
+const (
+	FlowInitial  FlowUpdateEvent = 0
+	FlowAdded    FlowUpdateEvent = 1
+	FlowRemoved  FlowUpdateEvent = 2
+	FlowModified FlowUpdateEvent = 3
+)
+
+// Flow is a flow entry of a switch as reported by flow stats and flow
+// monitor messages. Match and actions use the ovs-ofctl syntax.
+type Flow struct {
+	Table    uint8
+	Priority uint16
+	Cookie   uint64
+	Match    string
+	Actions  string
+	Packets  uint64
+	Bytes    uint64
+	Duration time.Duration
+}
+
+// FlowUpdate is a change of a flow entry reported by a flow monitor
+type FlowUpdate struct {
+	Event FlowUpdateEvent
+	Flow  *Flow
+}
+
+// ErrorMessage is an OpenFlow error returned by the switch
+type ErrorMessage struct {
+	Type uint16
+	Code uint16
+}
+
+func (e *ErrorMessage) Error() string {
+	return fmt.Sprintf("OpenFlow error type %d code %d", e.Type, e.Code)
+}
+
+var errTruncated = errors.New("Truncated OpenFlow message")
+
+type header struct {
+	Version uint8
+	Type    uint8
+	Length  uint16
+	Xid     uint32
+}
+
+func parseHeader(b []byte) header {
+	return header{
+		Version: b[0],
+		Type:    b[1],
+		Length:  binary.BigEndian.Uint16(b[2:]),
+		Xid:     binary.BigEndian.Uint32(b[4:]),
+	}
+}
+
+func newMessage(version uint8, msgType uint8, xid uint32, body []byte) []byte {
+	msg := make([]byte, headerLen+len(body))
+	msg[0] = version
+	msg[1] = msgType
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	binary.BigEndian.PutUint32(msg[4:], xid)
+	copy(msg[headerLen:], body)
+	return msg
+}
+
+// newHello returns a hello message announcing the supported versions
+func newHello(xid uint32) []byte {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint16(body, helloElemVersionBitmap)
+	binary.BigEndian.PutUint16(body[2:], 8)
+	binary.BigEndian.PutUint32(body[4:], 1<<Version13|1<<Version14)
+	return newMessage(Version14, typeHello, xid, body)
+}
+
+// negotiateVersion returns the highest version supported by both sides
+// according to the hello message received
+func negotiateVersion(h header, body []byte) (uint8, error) {
+	version := h.Version
+	if version > Version14 {
+		version = Version14
+	}
+
+	for len(body) >= 4 {
+		elemType := binary.BigEndian.Uint16(body)
+		elemLen := int(binary.BigEndian.Uint16(body[2:]))
+		if elemLen < 4 || elemLen > len(body) {
+			break
+		}
+
+		if elemType == helloElemVersionBitmap && elemLen >= 8 {
+			bitmap := binary.BigEndian.Uint32(body[4:])
+			for version = Version14; version >= Version13; version-- {
+				if bitmap&(1<<version) != 0 {
+					break
+				}
+			}
+		}
+		body = body[(elemLen+7)/8*8:]
+	}
+
+	if version < Version13 {
+		return 0, fmt.Errorf("OpenFlow version 0x%02x not supported", h.Version)
+	}
+	return version, nil
+}
+
+func newMultipartRequest(version uint8, xid uint32, mpType uint16, body []byte) []byte {
+	mp := make([]byte, 8+len(body))
+	binary.BigEndian.PutUint16(mp, mpType)
+	copy(mp[8:], body)
+	return newMessage(version, typeMultipartRequest, xid, mp)
+}
+
+// emptyMatch returns an OXM match without any field, padded to 8 bytes
+func emptyMatch() []byte {
+	match := make([]byte, 8)
+	binary.BigEndian.PutUint16(match, matchTypeOXM)
+	binary.BigEndian.PutUint16(match[2:], 4)
+	return match
+}
+
+// newFlowStatsRequest requests the flows of all the tables
+func newFlowStatsRequest(version uint8, xid uint32) []byte {
+	body := make([]byte, 32)
+	body[0] = tableAll
+	binary.BigEndian.PutUint32(body[4:], portAny)
+	binary.BigEndian.PutUint32(body[8:], groupAny)
+	return newMultipartRequest(version, xid, multipartFlow, append(body, emptyMatch()...))
+}
+
+// newFlowMonitorRequest requests the changes of the flows of all the tables
+func newFlowMonitorRequest(version uint8, xid uint32, monitorID uint32) []byte {
+	body := make([]byte, 16)
+	binary.BigEndian.PutUint32(body, monitorID)
+	binary.BigEndian.PutUint32(body[4:], portAny)
+	binary.BigEndian.PutUint32(body[8:], groupAny)
+	binary.BigEndian.PutUint16(body[12:], monitorAdd|monitorRemoved|monitorModify|monitorInstructions|monitorNoAbbrev)
+	body[14] = tableAll
+	return newMultipartRequest(version, xid, multipartFlowMonitor, append(body, emptyMatch()...))
+}
+
+// parseMatch decodes an OXM match and returns its length including padding
+func parseMatch(b []byte) (string, int, error) {
+	if len(b) < 4 {
+		return "", 0, errTruncated
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	padded := (length + 7) / 8 * 8
+	if length < 4 || padded > len(b) {
+		return "", 0, errTruncated
+	}
+
+	var fields []string
+	var ethType uint16
+	var ipProto uint8
+	var hasEthType, hasIPProto bool
+
+	oxms := b[4:length]
+	for len(oxms) >= 4 {
+		oxm, err := parseOXM(oxms)
+		if err != nil {
+			return "", 0, err
+		}
+		oxms = oxms[4+oxm.length:]
+
+		if oxm.class == oxmClassBasic && !oxm.hasMask {
+			switch oxm.field {
+			case oxmEthType:
+				ethType, hasEthType = binary.BigEndian.Uint16(oxm.value), true
+				continue
+			case oxmIPProto:
+				ipProto, hasIPProto = oxm.value[0], true
+				continue
+			}
+		}
+		fields = append(fields, oxm.String())
+	}
+
+	var prefix []string
+	if hasEthType {
+		shorthand, protoUsed := protocolShorthand(ethType, ipProto, hasIPProto)
+		prefix = append(prefix, shorthand)
+		if hasIPProto && !protoUsed {
+			prefix = append(prefix, fmt.Sprintf("nw_proto=%d", ipProto))
+		}
+	} else if hasIPProto {
+		prefix = append(prefix, fmt.Sprintf("nw_proto=%d", ipProto))
+	}
+
+	return strings.Join(append(prefix, fields...), ","), padded, nil
+}
+
+// protocolShorthand returns the ovs-ofctl shorthand of an ethernet type and
+// IP protocol and whether the protocol is part of the shorthand
+func protocolShorthand(ethType uint16, ipProto uint8, hasIPProto bool) (string, bool) {
+	switch ethType {
+	case 0x0800:
+		if hasIPProto {
+			switch ipProto {
+			case 1:
+				return "icmp", true
+			case 6:
+				return "tcp", true
+			case 17:
+				return "udp", true
+			case 132:
+				return "sctp", true
+			}
+		}
+		return "ip", false
+	case 0x86dd:
+		if hasIPProto {
+			switch ipProto {
+			case 58:
+				return "icmp6", true
+			case 6:
+				return "tcp6", true
+			case 17:
+				return "udp6", true
+			case 132:
+				return "sctp6", true
+			}
+		}
+		return "ipv6", false
+	case 0x0806:
+		return "arp", false
+	case 0x8035:
+		return "rarp", false
+	case 0x8847:
+		return "mpls", false
+	case 0x8848:
+		return "mplsm", false
+	}
+	return fmt.Sprintf("dl_type=0x%04x", ethType), false
+}
+
+// parseFlowStats decodes the body of a flow stats reply
+func parseFlowStats(b []byte) ([]*Flow, error) {
+	var flows []*Flow
+	for len(b) > 0 {
+		if len(b) < 48 {
+			return nil, errTruncated
+		}
+
+		length := int(binary.BigEndian.Uint16(b))
+		if length < 48 || length > len(b) {
+			return nil, errTruncated
+		}
+
+		flow := &Flow{
+			Table:    b[2],
+			Duration: time.Duration(binary.BigEndian.Uint32(b[4:]))*time.Second + time.Duration(binary.BigEndian.Uint32(b[8:])),
+			Priority: binary.BigEndian.Uint16(b[12:]),
+			Cookie:   binary.BigEndian.Uint64(b[24:]),
+			Packets:  binary.BigEndian.Uint64(b[32:]),
+			Bytes:    binary.BigEndian.Uint64(b[40:]),
+		}
+
+		match, matchLen, err := parseMatch(b[48:length])
+		if err != nil {
+			return nil, err
+		}
+		flow.Match = match
+
+		if flow.Actions, err = parseInstructions(b[48+matchLen : length]); err != nil {
+			return nil, err
+		}
+
+		flows = append(flows, flow)
+		b = b[length:]
+	}
+	return flows, nil
+}
+
+// parseFlowUpdates decodes the body of a flow monitor reply, only the full
+// updates are returned
+func parseFlowUpdates(b []byte) ([]*FlowUpdate, error) {
+	var updates []*FlowUpdate
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errTruncated
+		}
+
+		length := int(binary.BigEndian.Uint16(b))
+		if length < 4 || length > len(b) {
+			return nil, errTruncated
+		}
+
+		event := FlowUpdateEvent(binary.BigEndian.Uint16(b[2:]))
+		switch event {
+		case FlowInitial, FlowAdded, FlowRemoved, FlowModified:
+			if length < 24 {
+				return nil, errTruncated
+			}
+
+			flow := &Flow{
+				Table:    b[4],
+				Priority: binary.BigEndian.Uint16(b[10:]),
+				Cookie:   binary.BigEndian.Uint64(b[16:]),
+			}
+
+			match, matchLen, err := parseMatch(b[24:length])
+			if err != nil {
+				return nil, err
+			}
+			flow.Match = match
+
+			if flow.Actions, err = parseInstructions(b[24+matchLen : length]); err != nil {
+				return nil, err
+			}
+
+			updates = append(updates, &FlowUpdate{Event: event, Flow: flow})
+		}
+		b = b[length:]
+	}
+	return updates, nil
+}
+
+// parseError decodes the body of an error message
+func parseError(b []byte) error {
+	if len(b) < 4 {
+		return errTruncated
+	}
+	return &ErrorMessage{Type: binary.BigEndian.Uint16(b), Code: binary.BigEndian.Uint16(b[2:])}
+}
+
+func formatHex(value []byte) string {
+	var v uint64
+	for _, b := range value {
+		v = v<<8 | uint64(b)
+	}
+	return fmt.Sprintf("0x%x", v)
+}
+
+func formatIP(value []byte, mask []byte) string {
+	s := net.IP(value).String()
+	if mask != nil {
+		if ones, bits := net.IPMask(mask).Size(); bits != 0 {
+			s += fmt.Sprintf("/%d", ones)
+		} else {
+			s += "/" + net.IP(mask).String()
+		}
+	}
+	return s
+}
@@ -24,6 +24,7 @@ package ovsdb
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"sync"
 	"sync/atomic"
@@ -53,21 +54,35 @@ type OvsMonitorHandler interface {
 	OnOvsPortUpdate(monitor *OvsMonitor, uuid string, row *libovsdb.RowUpdate)
 }
 
+// OvsTableMonitorHandler describes the handler of the rows of the tables
+// monitored with MonitorTable
+type OvsTableMonitorHandler interface {
+	OnOvsRowAdd(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate)
+	OnOvsRowDel(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate)
+	OnOvsRowUpdate(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate)
+}
+
 // OvsMonitor describes an OVS client Monitor
 type OvsMonitor struct {
 	sync.RWMutex
-	Protocol        string
-	Target          string
-	OvsClient       *OvsClient
-	MonitorHandlers []OvsMonitorHandler
-	bridgeCache     map[string]string
-	interfaceCache  map[string]string
-	portCache       map[string]string
-	columnsExcluded map[string][]string
-	columnsIncluded map[string][]string
-	ticker          *time.Ticker
-	done            chan struct{}
-}
+	Protocol             string
+	Target               string
+	Database             string
+	OvsClient            *OvsClient
+	MonitorHandlers      []OvsMonitorHandler
+	TableMonitorHandlers []OvsTableMonitorHandler
+	bridgeCache          map[string]string
+	interfaceCache       map[string]string
+	portCache            map[string]string
+	rowCache             map[string]map[string]bool
+	columnsExcluded      map[string][]string
+	columnsIncluded      map[string][]string
+	ticker               *time.Ticker
+	done                 chan struct{}
+}
+
+// OvsDatabase name of the Open vSwitch database, monitored by default
+const OvsDatabase = "Open_vSwitch"
 
 // ConnectionPollInterval poll OVS database every 4 seconds
 const ConnectionPollInterval time.Duration = 4 * time.Second
@@ -107,7 +122,7 @@ func (o *OvsClient) Exec(operations ...libovsdb.Operation) ([]libovsdb.Operation
 		return nil, errors.New("OVSDB client is not connected")
 	}
 
-	result, err := o.ovsdb.Transact("Open_vSwitch", operations...)
+	result, err := o.ovsdb.Transact(OvsDatabase, operations...)
 	if err != nil {
 		return nil, err
 	}
@@ -286,8 +301,45 @@ func (o *OvsMonitor) portUpdateHandler(updates *libovsdb.TableUpdate) {
 	}
 }
 
+func (o *OvsMonitor) rowUpdateHandler(table string, updates *libovsdb.TableUpdate) {
+	empty := libovsdb.Row{}
+
+	o.Lock()
+	defer o.Unlock()
+
+	cache, ok := o.rowCache[table]
+	if !ok {
+		return
+	}
+
+	for uuid, row := range updates.Rows {
+		if !reflect.DeepEqual(row.New, empty) {
+			if _, ok := cache[uuid]; ok {
+				for _, handler := range o.TableMonitorHandlers {
+					handler.OnOvsRowUpdate(o, table, uuid, &row)
+				}
+			} else {
+				cache[uuid] = true
+				for _, handler := range o.TableMonitorHandlers {
+					handler.OnOvsRowAdd(o, table, uuid, &row)
+				}
+			}
+		} else {
+			delete(cache, uuid)
+			for _, handler := range o.TableMonitorHandlers {
+				handler.OnOvsRowDel(o, table, uuid, &row)
+			}
+		}
+	}
+}
+
 func (o *OvsMonitor) updateHandler(updates *libovsdb.TableUpdates) {
 	for name, tableUpdate := range updates.Updates {
+		if o.Database != OvsDatabase {
+			o.rowUpdateHandler(name, &tableUpdate)
+			continue
+		}
+
 		switch name {
 		case "Interface":
 			o.interfaceUpdateHandler(&tableUpdate)
@@ -295,16 +347,22 @@ func (o *OvsMonitor) updateHandler(updates *libovsdb.TableUpdates) {
 			o.bridgeUpdateHandler(&tableUpdate)
 		case "Port":
 			o.portUpdateHandler(&tableUpdate)
+		default:
+			o.rowUpdateHandler(name, &tableUpdate)
 		}
 	}
 }
 
 func (o *OvsMonitor) setMonitorRequests(table string, r *map[string]libovsdb.MonitorRequest) error {
-	schema, ok := o.OvsClient.ovsdb.Schema["Open_vSwitch"]
+	schema, ok := o.OvsClient.ovsdb.Schema[o.Database]
 	if !ok {
 		return errors.New("invalid Database Schema")
 	}
 
+	if _, ok := schema.Tables[table]; !ok {
+		return fmt.Errorf("unknown table %s in database %s", table, o.Database)
+	}
+
 	selected := make(map[string]bool)
 
 	// include everything by default
@@ -373,6 +431,27 @@ func (o *OvsMonitor) AddMonitorHandler(handler OvsMonitorHandler) {
 	o.MonitorHandlers = append(o.MonitorHandlers, handler)
 }
 
+// AddTableMonitorHandler subscribe a new handler of the events of the tables
+// monitored with MonitorTable
+func (o *OvsMonitor) AddTableMonitorHandler(handler OvsTableMonitorHandler) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.TableMonitorHandlers = append(o.TableMonitorHandlers, handler)
+}
+
+// MonitorTable adds a table to the set of the monitored tables, its events
+// are delivered to the table monitor handlers. The Bridge, Interface and
+// Port tables of the Open_vSwitch database are always monitored.
+func (o *OvsMonitor) MonitorTable(table string) {
+	o.Lock()
+	defer o.Unlock()
+
+	if _, ok := o.rowCache[table]; !ok {
+		o.rowCache[table] = make(map[string]bool)
+	}
+}
+
 // ExcludeColumn excludes the given table/column to be monitored. All columns can be
 // excluded using "*" as column name.
 func (o *OvsMonitor) ExcludeColumn(table, column string) {
@@ -405,23 +484,25 @@ func (o *OvsMonitor) monitorOvsdb() error {
 	notifier := Notifier{monitor: o}
 	ovsdb.Register(notifier)
 
-	requests := make(map[string]libovsdb.MonitorRequest)
-	err = o.setMonitorRequests("Bridge", &requests)
-	if err != nil {
-		return err
+	var tables []string
+	if o.Database == OvsDatabase {
+		tables = []string{"Bridge", "Interface", "Port"}
 	}
 
-	err = o.setMonitorRequests("Interface", &requests)
-	if err != nil {
-		return err
+	o.RLock()
+	for table := range o.rowCache {
+		tables = append(tables, table)
 	}
+	o.RUnlock()
 
-	err = o.setMonitorRequests("Port", &requests)
-	if err != nil {
-		return err
+	requests := make(map[string]libovsdb.MonitorRequest)
+	for _, table := range tables {
+		if err = o.setMonitorRequests(table, &requests); err != nil {
+			return err
+		}
 	}
 
-	updates, err := ovsdb.Monitor("Open_vSwitch", "", requests)
+	updates, err := ovsdb.Monitor(o.Database, "", requests)
 	if err != nil {
 		return err
 	}
@@ -479,10 +560,12 @@ func NewOvsMonitor(protcol string, target string) *OvsMonitor {
 	return &OvsMonitor{
 		Protocol:        protcol,
 		Target:          target,
+		Database:        OvsDatabase,
 		OvsClient:       &OvsClient{ovsdb: nil, connected: 0},
 		bridgeCache:     make(map[string]string),
 		interfaceCache:  make(map[string]string),
 		portCache:       make(map[string]string),
+		rowCache:        make(map[string]map[string]bool),
 		columnsExcluded: make(map[string][]string),
 		columnsIncluded: make(map[string][]string),
 		ticker:          nil,
@@ -23,6 +23,7 @@
 package ovsdb
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/socketplane/libovsdb"
@@ -127,4 +128,48 @@ func TestBridgeDeleted(t *testing.T) {
 	}
 }
 
+type FakeTableHandler struct {
+	events []string
+}
+
+func (h *FakeTableHandler) OnOvsRowAdd(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	h.events = append(h.events, "add "+table+" "+uuid)
+}
+
+func (h *FakeTableHandler) OnOvsRowDel(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	h.events = append(h.events, "del "+table+" "+uuid)
+}
+
+func (h *FakeTableHandler) OnOvsRowUpdate(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	h.events = append(h.events, "update "+table+" "+uuid)
+}
+
+func TestTableMonitor(t *testing.T) {
+	monitor := NewOvsMonitor("tcp", "127.0.0.1:8888")
+	monitor.Database = "OVN_Northbound"
+	monitor.MonitorTable("Logical_Switch")
+
+	handler := &FakeTableHandler{}
+	monitor.AddTableMonitorHandler(handler)
+
+	row := libovsdb.Row{Fields: map[string]interface{}{"name": "ls1"}}
+	update := func(table string, rowUpdate libovsdb.RowUpdate) {
+		monitor.updateHandler(&libovsdb.TableUpdates{
+			Updates: map[string]libovsdb.TableUpdate{
+				table: {Rows: map[string]libovsdb.RowUpdate{"ls1-uuid": rowUpdate}},
+			},
+		})
+	}
+
+	update("Logical_Switch", libovsdb.RowUpdate{New: row})
+	update("Logical_Switch", libovsdb.RowUpdate{New: row})
+	update("Logical_Router", libovsdb.RowUpdate{New: row})
+	update("Logical_Switch", libovsdb.RowUpdate{Old: row})
+
+	expected := []string{"add Logical_Switch ls1-uuid", "update Logical_Switch ls1-uuid", "del Logical_Switch ls1-uuid"}
+	if !reflect.DeepEqual(handler.events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, handler.events)
+	}
+}
+
 /* TODO(safchain) Add UT for interface adding */
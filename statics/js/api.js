@@ -162,7 +162,7 @@ var apiMixin = {
       return ["ovsbridge", "device", "internal", "veth", "tun", "bridge", "dummy",
         "gre", "bond", "can", "hsr", "ifb", "macvlan", "macvtap", "vlan", "vxlan",
         "gretap", "ip6gretap", "geneve", "ipoib", "vcan", "ipip", "ipvlan", "lowpan",
-        "ip6tnl", "ip6gre", "sit", "dpdkport", "ovsport"];
+        "ip6tnl", "ip6gre", "sit", "dpdkport", "ovsport", "netns"];
     }
   }
 
@@ -143,6 +143,9 @@ Vue.component('capture-form', {
       options["dpdkport"] = [
         {"type": "dpdk", "desc": "DPDK based probe - experimental"}
       ];
+      options["netns"] = [
+        {"type": "conntrack", "desc": "Connections tracked by netfilter"}
+      ];
       return options[this.nodeType];
     },
 
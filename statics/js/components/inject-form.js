@@ -90,6 +90,8 @@ Vue.component('inject-form', {
         <input id="inject-interval" type="number" class="form-control input-sm" v-model="interval" min="0" />\
       </div>\
       <button type="submit" id="inject" class="btn btn-primary">Inject</button>\
+      <button type="button" id="oftrace" class="btn btn-default" @click="trace"\
+              title="Trace the packet through the OpenFlow rules of the source bridge">Trace</button>\
       <button type="button" class="btn btn-danger" @click="reset">Reset</button>\
     </form>\
   ',
@@ -113,6 +115,7 @@ Vue.component('inject-form', {
       dstMAC: "",
       mode: "random",
       payload: "",
+      tracedNodes: [],
     };
   },
 
@@ -131,6 +134,7 @@ Vue.component('inject-form', {
     if (this.node2) {
       this.highlightNode(this.node2, false);
     }
+    this.unhighlightTrace();
   },
 
   computed: {
@@ -227,6 +231,68 @@ Vue.component('inject-form', {
       this.srcMAC = this.dstMAC = "";
       this.mode = "random";
       this.payload = "";
+      this.unhighlightTrace();
+    },
+
+    unhighlightTrace: function() {
+      for (var i in this.tracedNodes) {
+        this.highlightNode(this.tracedNodes[i], false);
+      }
+      this.tracedNodes = [];
+    },
+
+    // tracePath returns the source port, the matched rules and the output
+    // ports of an OpenFlow trace in the order they were traversed
+    tracePath: function(trace) {
+      var path = [trace.NodeID];
+      var i;
+      for (i in trace.Hops || []) {
+        if (trace.Hops[i].RuleID) path.push(trace.Hops[i].RuleID);
+      }
+      for (i in trace.Outputs || []) {
+        if (trace.Outputs[i].NodeID) path.push(trace.Outputs[i].NodeID);
+      }
+      return path;
+    },
+
+    trace: function() {
+      var self = this;
+      if (!this.node1) {
+        this.$error({message: "Source interface must be selected"});
+        return;
+      }
+      var params = {
+        "Src": "G.V('" + this.node1 + "')",
+        "SrcPort": this.port1,
+        "DstPort": this.port2,
+        "SrcIP": this.srcIP,
+        "DstIP": this.dstIP,
+        "SrcMAC": this.srcMAC,
+        "DstMAC": this.dstMAC,
+        "Type": this.type,
+      };
+      if (this.node2) {
+        params.Dst = "G.V('" + this.node2 + "')";
+      }
+      $.ajax({
+        dataType: "json",
+        url: '/api/oftrace',
+        data: JSON.stringify(params),
+        contentType: "application/json; charset=utf-8",
+        method: 'POST',
+      })
+      .then(function(trace) {
+        self.unhighlightTrace();
+        // the source node stays highlighted as long as it is selected
+        self.tracedNodes = self.tracePath(trace).filter(function(id) { return id !== self.node1; });
+        for (var i in self.tracedNodes) {
+          self.highlightNode(self.tracedNodes[i], true);
+        }
+        self.$success({message: 'Datapath actions: ' + trace.DatapathActions});
+      })
+      .fail(function(e) {
+        self.$error({message: 'OpenFlow trace error: ' + e.responseText});
+      });
     },
 
     inject: function() {
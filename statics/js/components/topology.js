@@ -739,19 +739,64 @@ Graph.prototype = {
     return group;
   },
 
-  addNode: function(id, host, metadata) {
+  addNode: function(id, host, metadata, revision) {
     var node = new Node(id, host, metadata);
+    node.revision = revision || 0;
     this.nodes[id] = node;
 
     this.notifyHandlers('nodeAdded', node);
   },
 
-  updateNode: function(id, metadata) {
+  updateNode: function(id, metadata, revision) {
     this.nodes[id].metadata = metadata;
+    this.nodes[id].revision = revision || 0;
 
     this.notifyHandlers('nodeUpdated', this.nodes[id]);
   },
 
+  applyPartialUpdates: function(metadata, ops) {
+    ops.forEach(function(op) {
+      var path = op.Key.split(".");
+      var last = path.pop();
+      var m = metadata;
+      for (var i = 0; i < path.length; i++) {
+        if (typeof m[path[i]] !== "object" || m[path[i]] === null) {
+          if (op.Type === "del") {
+            return;
+          }
+          m[path[i]] = {};
+        }
+        m = m[path[i]];
+      }
+
+      if (op.Type === "set") {
+        m[last] = op.Value;
+      } else {
+        delete m[last];
+      }
+    });
+  },
+
+  // requestElement asks for the whole node or edge when partial updates
+  // were missed
+  requestElement: function(type, id) {
+    var msg = {"Namespace": "Graph", "Type": type, "Obj": {"ID": id}};
+    this.websocket.send(msg);
+  },
+
+  partiallyUpdateNode: function(id, revision, ops) {
+    var node = this.nodes[id];
+    if (node) {
+      if (revision !== node.revision + 1) {
+        this.requestElement("NodeRequest", id);
+        return;
+      }
+      this.applyPartialUpdates(node.metadata, ops);
+      node.revision = revision;
+      this.notifyHandlers('nodeUpdated', node);
+    }
+  },
+
   delNode: function(node) {
     for (var i in node.edges) {
       this.delEdge(this.edges[i]);
@@ -827,10 +872,11 @@ Graph.prototype = {
     this.notifyHandlers('parentSet', group);
   },
 
-  addEdge: function(id, host, metadata, source, target) {
+  addEdge: function(id, host, metadata, source, target, revision) {
     var self = this;
 
     var edge = new Edge(id, host, metadata, source, target);
+    edge.revision = revision || 0;
     this.edges[id] = edge;
 
     this.notifyHandlers('edgeAdded', edge);
@@ -867,9 +913,22 @@ Graph.prototype = {
     }
   },
 
-  updateEdge: function(id, metadata) {
+  updateEdge: function(id, metadata, revision) {
     if (id in this.edges) {
       this.edges[id].metadata = metadata;
+      this.edges[id].revision = revision || 0;
+    }
+  },
+
+  partiallyUpdateEdge: function(id, revision, ops) {
+    var edge = this.edges[id];
+    if (edge) {
+      if (revision !== edge.revision + 1) {
+        this.requestElement("EdgeRequest", id);
+        return;
+      }
+      this.applyPartialUpdates(edge.metadata, ops);
+      edge.revision = revision;
     }
   },
 
@@ -901,7 +960,7 @@ Graph.prototype = {
     var n, e, i;
     for (i in g.Nodes) {
       n = g.Nodes[i];
-      this.addNode(n.ID, n.Host, n.Metadata || {});
+      this.addNode(n.ID, n.Host, n.Metadata || {}, n.Revision);
     }
 
     // add first ownership link to respect the original order
@@ -911,7 +970,7 @@ Graph.prototype = {
         if (!this.nodes[e.Parent] || !this.nodes[e.Child])
           continue;
 
-        this.addEdge(e.ID, e.Host, e.Metadata || {}, this.nodes[e.Parent], this.nodes[e.Child]);
+        this.addEdge(e.ID, e.Host, e.Metadata || {}, this.nodes[e.Parent], this.nodes[e.Child], e.Revision);
       }
     }
 
@@ -921,7 +980,7 @@ Graph.prototype = {
         if (!this.nodes[e.Parent] || !this.nodes[e.Child])
           continue;
 
-        this.addEdge(e.ID, e.Host, e.Metadata || {}, this.nodes[e.Parent], this.nodes[e.Child]);
+        this.addEdge(e.ID, e.Host, e.Metadata || {}, this.nodes[e.Parent], this.nodes[e.Child], e.Revision);
       }
     }
   },
@@ -1007,13 +1066,17 @@ Graph.prototype = {
         break;
 
       case "NodeUpdated":
-        this.updateNode(msg.Obj.ID, msg.Obj.Metadata);
+        this.updateNode(msg.Obj.ID, msg.Obj.Metadata, msg.Obj.Revision);
+        break;
+
+      case "NodePartiallyUpdated":
+        this.partiallyUpdateNode(msg.Obj.ID, msg.Obj.Revision, msg.Obj.Ops);
         break;
 
       case "NodeAdded":
         node = this.nodes[msg.Obj.ID];
         if (!node) {
-          this.addNode(msg.Obj.ID, msg.Obj.Host, msg.Obj.Metadata || {});
+          this.addNode(msg.Obj.ID, msg.Obj.Host, msg.Obj.Metadata || {}, msg.Obj.Revision);
         }
         break;
 
@@ -1025,7 +1088,11 @@ Graph.prototype = {
         break;
 
       case "EdgeUpdated":
-        this.updateEdge(msg.Obj.ID, msg.Obj.Metadata);
+        this.updateEdge(msg.Obj.ID, msg.Obj.Metadata, msg.Obj.Revision);
+        break;
+
+      case "EdgePartiallyUpdated":
+        this.partiallyUpdateEdge(msg.Obj.ID, msg.Obj.Revision, msg.Obj.Ops);
         break;
 
       case "EdgeAdded":
@@ -1034,7 +1101,7 @@ Graph.prototype = {
           var parent = this.nodes[msg.Obj.Parent];
           var child = this.nodes[msg.Obj.Child];
 
-          this.addEdge(msg.Obj.ID, msg.Obj.Host, msg.Obj.Metadata || {}, parent, child);
+          this.addEdge(msg.Obj.ID, msg.Obj.Host, msg.Obj.Metadata || {}, parent, child, msg.Obj.Revision);
         }
         break;
 
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/oftrace"
 	"github.com/skydive-project/skydive/tests/helper"
 )
 
@@ -118,3 +119,49 @@ func TestDelRuleWithBridge(t *testing.T) {
 
 	RunTest(t, test)
 }
+
+func TestOfTrace(t *testing.T) {
+	test := &Test{
+		setupCmds: []helper.Cmd{
+			{"ovs-vsctl add-br br-test1", true},
+			{"ovs-vsctl add-port br-test1 intf1 -- set interface intf1 type=internal ofport_request=1", true},
+			{"ovs-vsctl add-port br-test1 intf2 -- set interface intf2 type=internal ofport_request=2", true},
+			{"ovs-ofctl del-flows br-test1", true},
+			{"ovs-ofctl add-flow br-test1 table=0,priority=10,in_port=1,actions=resubmit(,1)", true},
+			{"ovs-ofctl add-flow br-test1 table=1,priority=5,icmp,actions=output:2", true},
+		},
+
+		tearDownCmds: []helper.Cmd{
+			{"ovs-vsctl del-br br-test1", true},
+		},
+
+		checks: []CheckFunction{func(c *CheckContext) error {
+			if !c.time.IsZero() {
+				return nil
+			}
+
+			var traces []*oftrace.Trace
+			gremlin := `g.V().Has("Type", "ovsport", "Name", "intf1").OfTrace("DstIP", "192.168.0.2")`
+			if err := c.gh.QueryObject(gremlin, &traces); err != nil {
+				return err
+			}
+
+			if len(traces) != 1 {
+				return fmt.Errorf("Expected one trace, got %+v", traces)
+			}
+
+			trace := traces[0]
+			if len(trace.Hops) != 2 || trace.Hops[0].RuleID == "" || trace.Hops[1].RuleID == "" {
+				return fmt.Errorf("Expected two hops matching ofrule nodes, got %+v", trace.Hops)
+			}
+
+			if len(trace.Outputs) != 1 || trace.Outputs[0].OfPort != 2 || trace.Outputs[0].NodeID == "" {
+				return fmt.Errorf("Expected an output to intf2, got %+v", trace.Outputs)
+			}
+
+			return nil
+		}},
+	}
+
+	RunTest(t, test)
+}
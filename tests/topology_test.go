@@ -778,10 +778,11 @@ func TestQueryMetadata(t *testing.T) {
 				return err
 			}
 
+			// publishers can only write the nodes of their own host
 			n := new(graph.Node)
 			n.Decode(map[string]interface{}{
 				"ID":   "123",
-				"Host": "test",
+				"Host": hostname + "-cli",
 				"Metadata": map[string]interface{}{
 					"A": map[string]interface{}{
 						"B": map[string]interface{}{
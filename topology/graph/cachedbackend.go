@@ -187,6 +187,27 @@ func (c *CachedBackend) MetadataUpdated(i interface{}) bool {
 	return r
 }
 
+// MetadataPartiallyUpdated updates only the metadata operations in the
+// persistent backend if it supports it
+func (c *CachedBackend) MetadataPartiallyUpdated(i interface{}, ops []PartialUpdate) bool {
+	mode := c.cacheMode.Load()
+
+	r := false
+	if mode != CacheOnlyMode {
+		if b, ok := c.persistent.(GraphPartialBackend); ok {
+			r = b.MetadataPartiallyUpdated(i, ops)
+		} else {
+			r = c.persistent.MetadataUpdated(i)
+		}
+	}
+
+	if mode != PersistentOnlyMode {
+		r = c.memory.MetadataUpdated(i)
+	}
+
+	return r
+}
+
 // GetNodes returns a list of nodes with a time slice, matching metadata
 func (c *CachedBackend) GetNodes(t *common.TimeSlice, m GraphElementMatcher) []*Node {
 	mode := c.cacheMode.Load()
@@ -100,8 +100,9 @@ var ErrBadConfig = errors.New("elasticsearch : Config file is misconfigured, che
 // ElasticSearchBackend describes a presisent backend based on ElasticSearch
 type ElasticSearchBackend struct {
 	GraphBackend
-	client       elasticsearch.ElasticSearchClientInterface
-	prevRevision map[Identifier]int64
+	client          elasticsearch.ElasticSearchClientInterface
+	prevRevision    map[Identifier]int64
+	inPlaceMetadata map[string]bool
 }
 
 // TimedSearchQuery describes a search query within a time slice and metadata filters
@@ -345,6 +346,52 @@ func (b *ElasticSearchBackend) MetadataUpdated(i interface{}) bool {
 	return success
 }
 
+// MetadataPartiallyUpdated updates in place the current revision of a node
+// or an edge when only metadata listed in storage.elasticsearch.inplace_metadata
+// were set, otherwise a new revision is created.
+func (b *ElasticSearchBackend) MetadataPartiallyUpdated(i interface{}, ops []PartialUpdate) bool {
+	var kind string
+	var e *graphElement
+	switch i := i.(type) {
+	case *Node:
+		kind, e = "node", &i.graphElement
+	case *Edge:
+		kind, e = "edge", &i.graphElement
+	}
+
+	revision, ok := b.prevRevision[e.ID]
+	if !ok || !b.isInPlaceUpdate(ops) {
+		return b.MetadataUpdated(i)
+	}
+
+	metadata := make(map[string]interface{})
+	for _, op := range ops {
+		key := strings.SplitN(op.Key, ".", 2)[0]
+		metadata[key] = e.metadata[key]
+	}
+
+	id := string(e.ID) + "-" + strconv.FormatInt(revision, 10)
+	if err := b.client.BulkUpdateWithPartialDoc(kind, id, map[string]interface{}{"Metadata": metadata}); err != nil {
+		logging.GetLogger().Errorf("Error while updating %s %s: %s", kind, id, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (b *ElasticSearchBackend) isInPlaceUpdate(ops []PartialUpdate) bool {
+	if len(b.inPlaceMetadata) == 0 || len(ops) == 0 {
+		return false
+	}
+
+	for _, op := range ops {
+		if op.Type != PartialUpdateSet || !b.inPlaceMetadata[strings.SplitN(op.Key, ".", 2)[0]] {
+			return false
+		}
+	}
+	return true
+}
+
 // Query the database for a "node" or "edge"
 func (b *ElasticSearchBackend) Query(obj string, tsq *TimedSearchQuery) (sr elastigo.SearchResult, _ error) {
 	if tsq.TimeFilter == nil {
@@ -537,9 +584,15 @@ func newElasticSearchBackend(client elasticsearch.ElasticSearchClientInterface)
 		{"edge": []byte(graphElementMapping)},
 	})
 
+	inPlaceMetadata := make(map[string]bool)
+	for _, key := range config.GetConfig().GetStringSlice("storage.elasticsearch.inplace_metadata") {
+		inPlaceMetadata[key] = true
+	}
+
 	return &ElasticSearchBackend{
-		client:       client,
-		prevRevision: make(map[Identifier]int64),
+		client:          client,
+		prevRevision:    make(map[Identifier]int64),
+		inPlaceMetadata: inPlaceMetadata,
 	}, nil
 }
 
@@ -33,6 +33,7 @@ import (
 	elastigo "github.com/mattbaird/elastigo/lib"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/storage/elasticsearch"
 )
@@ -106,13 +107,27 @@ func (f *fakeElasticsearchClient) UpdateWithPartialDoc(obj string, id string, da
 }
 func (f *fakeElasticsearchClient) BulkUpdateWithPartialDoc(obj string, id string, data interface{}) error {
 	if revision, ok := f.revisions[id]; ok {
-		m := revision.(map[string]interface{})
-		for k, v := range data.(map[string]interface{}) {
-			m[k] = v
-		}
+		mergeDoc(revision.(map[string]interface{}), data.(map[string]interface{}))
 	}
 	return nil
 }
+
+// mergeDoc merges objects recursively as Elasticsearch does for partial documents
+func mergeDoc(doc map[string]interface{}, data map[string]interface{}) {
+	for k, v := range data {
+		if dm, ok := v.(map[string]interface{}); ok {
+			if m, ok := doc[k].(map[string]interface{}); ok {
+				mergeDoc(m, dm)
+				continue
+			}
+			if m, ok := doc[k].(Metadata); ok {
+				mergeDoc(m, dm)
+				continue
+			}
+		}
+		doc[k] = v
+	}
+}
 func (f *fakeElasticsearchClient) Get(obj string, id string) (elastigo.BaseResponse, error) {
 	return elastigo.BaseResponse{}, nil
 }
@@ -460,3 +475,45 @@ func TestElasticsearchForwarded(t *testing.T) {
 		t.Fatalf("Expected elasticsearch records not found: \nexpected: %v\ngot: %v", expected, client.getRevisions())
 	}
 }
+
+// test in place update of the current revision for partial updates
+func TestElasticsearchInPlace(t *testing.T) {
+	config.GetConfig().Set("storage.elasticsearch.inplace_metadata", []string{"Metric"})
+	defer config.GetConfig().Set("storage.elasticsearch.inplace_metadata", []string{})
+
+	g, client := newElasticsearchGraph(t)
+
+	node := g.newNode("aaa", Metadata{"MTU": 1500}, time.Unix(1, 0), "host1")
+	g.addMetadata(node, "Metric", map[string]interface{}{"RxBytes": 123}, time.Unix(2, 0))
+
+	expected := []interface{}{
+		map[string]interface{}{
+			"CreatedAt": int64(1000),
+			"Host":      "host1",
+			"ID":        "aaa",
+			"Metadata": Metadata{
+				"MTU":    1500,
+				"Metric": map[string]interface{}{"RxBytes": 123},
+			},
+			"Revision":  int64(1),
+			"UpdatedAt": int64(1000),
+		},
+	}
+
+	if !reflect.DeepEqual(client.getRevisions(), expected) {
+		t.Fatalf("Expected elasticsearch records not found: \nexpected: %v\ngot: %v", expected, client.getRevisions())
+	}
+
+	// other metadata still create a new revision
+	g.addMetadata(node, "MTU", 1510, time.Unix(3, 0))
+
+	revisions := client.getRevisions()
+	if len(revisions) != 2 {
+		t.Fatalf("Expected 2 elasticsearch records, got: %v", revisions)
+	}
+
+	last := revisions[1].(map[string]interface{})
+	if last["Revision"] != int64(3) || last["UpdatedAt"] != int64(3000) {
+		t.Errorf("Wrong last revision: %v", last)
+	}
+}
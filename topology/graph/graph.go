@@ -27,6 +27,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -35,6 +36,7 @@ import (
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
+	shttp "github.com/skydive-project/skydive/http"
 )
 
 const (
@@ -54,6 +56,16 @@ const (
 	edgeDeleted
 )
 
+var (
+	// ErrElementNotFound is returned when the element of an update is unknown
+	ErrElementNotFound = errors.New("Element not found")
+	// ErrRevisionMismatch is returned when a partial update doesn't follow
+	// the revision of the element, the updates in between were missed
+	ErrRevisionMismatch = errors.New("Revision mismatch")
+	// ErrBackendUpdate is returned when the backend failed to store an update
+	ErrBackendUpdate = errors.New("Unable to update the backend")
+)
+
 // Identifier graph ID
 type Identifier string
 
@@ -67,10 +79,36 @@ type GraphEventListener interface {
 	OnEdgeDeleted(e *Edge)
 }
 
+// GraphPartialUpdateListener can be implemented by a GraphEventListener willing
+// to be notified of the metadata operations of an update rather than of the
+// whole updated element.
+type GraphPartialUpdateListener interface {
+	OnNodePartiallyUpdated(n *Node, ops []PartialUpdate)
+	OnEdgePartiallyUpdated(e *Edge, ops []PartialUpdate)
+}
+
+// PartialUpdateType describes the kind of a metadata partial update
+type PartialUpdateType string
+
+// Partial update types
+const (
+	PartialUpdateSet PartialUpdateType = "set"
+	PartialUpdateDel PartialUpdateType = "del"
+)
+
+// PartialUpdate describes a set or a deletion of a metadata path, dot
+// separated, of a node or an edge
+type PartialUpdate struct {
+	Type  PartialUpdateType
+	Key   string
+	Value interface{} `json:",omitempty"`
+}
+
 type graphEvent struct {
 	kind     graphEventType
 	element  interface{}
 	listener GraphEventListener
+	ops      []PartialUpdate
 }
 
 // GraphElementMatcher defines an interface used to match an element
@@ -137,6 +175,12 @@ type GraphBackend interface {
 	WithContext(graph *Graph, context GraphContext) (*Graph, error)
 }
 
+// GraphPartialBackend can be implemented by a GraphBackend able to store only
+// the metadata operations of an update.
+type GraphPartialBackend interface {
+	MetadataPartiallyUpdated(e interface{}, ops []PartialUpdate) bool
+}
+
 // GraphContext describes within time slice
 type GraphContext struct {
 	TimeSlice *common.TimeSlice
@@ -231,13 +275,21 @@ func (g *GraphEventHandler) notifyEvent(ge graphEvent) {
 			case nodeAdded:
 				g.currentEventListener.OnNodeAdded(ge.element.(*Node))
 			case nodeUpdated:
-				g.currentEventListener.OnNodeUpdated(ge.element.(*Node))
+				if l, ok := g.currentEventListener.(GraphPartialUpdateListener); ok && ge.ops != nil {
+					l.OnNodePartiallyUpdated(ge.element.(*Node), ge.ops)
+				} else {
+					g.currentEventListener.OnNodeUpdated(ge.element.(*Node))
+				}
 			case nodeDeleted:
 				g.currentEventListener.OnNodeDeleted(ge.element.(*Node))
 			case edgeAdded:
 				g.currentEventListener.OnEdgeAdded(ge.element.(*Edge))
 			case edgeUpdated:
-				g.currentEventListener.OnEdgeUpdated(ge.element.(*Edge))
+				if l, ok := g.currentEventListener.(GraphPartialUpdateListener); ok && ge.ops != nil {
+					l.OnEdgePartiallyUpdated(ge.element.(*Edge), ge.ops)
+				} else {
+					g.currentEventListener.OnEdgeUpdated(ge.element.(*Edge))
+				}
 			case edgeDeleted:
 				g.currentEventListener.OnEdgeDeleted(ge.element.(*Edge))
 			}
@@ -520,11 +572,14 @@ func (e *graphElement) Decode(i interface{}) (err error) {
 	}
 
 	if revision, ok := objMap["Revision"]; ok {
-		if r, ok := revision.(json.Number); ok {
+		switch r := revision.(type) {
+		case json.Number:
 			if e.revision, err = r.Int64(); err != nil {
 				return errors.New("Wrong type for Revision")
 			}
-		} else {
+		case int64:
+			e.revision = r
+		default:
 			return errors.New("Wrong type for Revision")
 		}
 	}
@@ -548,12 +603,21 @@ func (n *Node) String() string {
 
 // MarshalJSON serialize in JSON
 func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.serialize())
+}
+
+// MarshalMsgpack serialize in MessagePack
+func (n *Node) MarshalMsgpack() ([]byte, error) {
+	return shttp.MsgpackMarshal(n.serialize())
+}
+
+func (n *Node) serialize() interface{} {
 	deletedAt := int64(0)
 	if !n.deletedAt.IsZero() {
 		deletedAt = common.UnixMillis(n.deletedAt)
 	}
 
-	return json.Marshal(&struct {
+	return &struct {
 		ID        Identifier
 		Metadata  Metadata `json:",omitempty"`
 		Host      string
@@ -569,7 +633,7 @@ func (n *Node) MarshalJSON() ([]byte, error) {
 		UpdatedAt: common.UnixMillis(n.updatedAt),
 		DeletedAt: deletedAt,
 		Revision:  n.revision,
-	})
+	}
 }
 
 // Decode deserialize the node
@@ -599,12 +663,21 @@ func (e *Edge) String() string {
 
 // MarshalJSON serialize in JSON
 func (e *Edge) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.serialize())
+}
+
+// MarshalMsgpack serialize in MessagePack
+func (e *Edge) MarshalMsgpack() ([]byte, error) {
+	return shttp.MsgpackMarshal(e.serialize())
+}
+
+func (e *Edge) serialize() interface{} {
 	deletedAt := int64(0)
 	if !e.deletedAt.IsZero() {
 		deletedAt = common.UnixMillis(e.deletedAt)
 	}
 
-	return json.Marshal(&struct {
+	return &struct {
 		ID        Identifier
 		Metadata  Metadata `json:",omitempty"`
 		Parent    Identifier
@@ -613,6 +686,7 @@ func (e *Edge) MarshalJSON() ([]byte, error) {
 		CreatedAt int64
 		UpdatedAt int64 `json:",omitempty"`
 		DeletedAt int64 `json:",omitempty"`
+		Revision  int64
 	}{
 		ID:        e.ID,
 		Metadata:  e.metadata,
@@ -622,7 +696,8 @@ func (e *Edge) MarshalJSON() ([]byte, error) {
 		CreatedAt: common.UnixMillis(e.createdAt),
 		UpdatedAt: common.UnixMillis(e.updatedAt),
 		DeletedAt: deletedAt,
-	})
+		Revision:  e.revision,
+	}
 }
 
 // Decode deserialize the current edge
@@ -687,6 +762,7 @@ func (g *Graph) EdgeUpdated(e *Edge) bool {
 	if edge := g.GetEdge(e.ID); edge != nil {
 		edge.metadata = e.metadata
 		edge.updatedAt = e.updatedAt
+		edge.revision = e.revision
 
 		if !g.backend.MetadataUpdated(edge) {
 			return false
@@ -698,6 +774,103 @@ func (g *Graph) EdgeUpdated(e *Edge) bool {
 	return false
 }
 
+// NodePartiallyUpdated applies the metadata operations of a remote update to
+// a node. The update is rejected with ErrRevisionMismatch if it doesn't follow
+// the revision of the node, the whole node has then to be requested.
+func (g *Graph) NodePartiallyUpdated(p *PartiallyUpdatedMsg) error {
+	node := g.GetNode(p.ID)
+	if node == nil {
+		return ErrElementNotFound
+	}
+
+	if err := node.applyPartialUpdates(p); err != nil {
+		return err
+	}
+
+	if !g.metadataUpdated(node, p.Ops) {
+		return ErrBackendUpdate
+	}
+
+	g.notifyEvent(graphEvent{kind: nodeUpdated, element: node, ops: p.Ops})
+	return nil
+}
+
+// EdgePartiallyUpdated applies the metadata operations of a remote update to
+// an edge, see NodePartiallyUpdated.
+func (g *Graph) EdgePartiallyUpdated(p *PartiallyUpdatedMsg) error {
+	edge := g.GetEdge(p.ID)
+	if edge == nil {
+		return ErrElementNotFound
+	}
+
+	if err := edge.applyPartialUpdates(p); err != nil {
+		return err
+	}
+
+	if !g.metadataUpdated(edge, p.Ops) {
+		return ErrBackendUpdate
+	}
+
+	g.notifyEvent(graphEvent{kind: edgeUpdated, element: edge, ops: p.Ops})
+	return nil
+}
+
+func (e *graphElement) applyPartialUpdates(p *PartiallyUpdatedMsg) error {
+	if p.Revision != e.revision+1 {
+		return ErrRevisionMismatch
+	}
+
+	if e.metadata == nil {
+		e.metadata = Metadata{}
+	}
+
+	for _, op := range p.Ops {
+		switch op.Type {
+		case PartialUpdateSet:
+			e.metadata.SetField(op.Key, op.Value)
+		case PartialUpdateDel:
+			common.DelField(e.metadata, op.Key)
+		}
+	}
+
+	e.updatedAt = time.Unix(0, p.UpdatedAt*int64(time.Millisecond))
+	e.revision = p.Revision
+
+	return nil
+}
+
+// metadataUpdated stores only the operations of the update if the backend
+// supports it
+func (g *Graph) metadataUpdated(i interface{}, ops []PartialUpdate) bool {
+	if b, ok := g.backend.(GraphPartialBackend); ok && ops != nil {
+		return b.MetadataPartiallyUpdated(i, ops)
+	}
+	return g.backend.MetadataUpdated(i)
+}
+
+// metadataDiff returns the operations needed to go from the old metadata
+// to the new ones, at the first level of keys. Keys containing a dot can't
+// be expressed as a path, nil is returned then.
+func metadataDiff(old, new Metadata) (ops []PartialUpdate) {
+	for k, v := range new {
+		if strings.Contains(k, ".") {
+			return nil
+		}
+		if o, ok := old[k]; !ok || !reflect.DeepEqual(o, v) {
+			ops = append(ops, PartialUpdate{Type: PartialUpdateSet, Key: k, Value: v})
+		}
+	}
+	for k := range old {
+		if strings.Contains(k, ".") {
+			return nil
+		}
+		if _, ok := new[k]; !ok {
+			ops = append(ops, PartialUpdate{Type: PartialUpdateDel, Key: k})
+		}
+	}
+	return
+}
+
 // SetMetadata associate metadata to an edge or node
 func (g *Graph) SetMetadata(i interface{}, m Metadata) bool {
 	var e *graphElement
@@ -716,11 +889,13 @@ func (g *Graph) SetMetadata(i interface{}, m Metadata) bool {
 		return false
 	}
 
+	ge.ops = metadataDiff(e.metadata, m)
+
 	e.metadata = m
 	e.updatedAt = time.Now().UTC()
 	e.revision++
 
-	if !g.backend.MetadataUpdated(i) {
+	if !g.metadataUpdated(i, ge.ops) {
 		return false
 	}
 
@@ -747,7 +922,8 @@ func (g *Graph) DelMetadata(i interface{}, k string) bool {
 	e.updatedAt = time.Now().UTC()
 	e.revision++
 
-	if !g.backend.MetadataUpdated(i) {
+	ge.ops = []PartialUpdate{{Type: PartialUpdateDel, Key: k}}
+	if !g.metadataUpdated(i, ge.ops) {
 		return false
 	}
 
@@ -784,7 +960,8 @@ func (g *Graph) addMetadata(i interface{}, k string, v interface{}, t time.Time)
 	e.updatedAt = t
 	e.revision++
 
-	if !g.backend.MetadataUpdated(i) {
+	ge.ops = []PartialUpdate{{Type: PartialUpdateSet, Key: k, Value: v}}
+	if !g.metadataUpdated(i, ge.ops) {
 		return false
 	}
 
@@ -1096,6 +1273,11 @@ func newNode(i Identifier, m Metadata, t time.Time, h string) *Node {
 	return n
 }
 
+// CreateNode returns a new node of the given host, not bound to a graph
+func CreateNode(i Identifier, m Metadata, t time.Time, h string) *Node {
+	return newNode(i, m, t, h)
+}
+
 func (g *Graph) newNode(i Identifier, m Metadata, t time.Time, h ...string) *Node {
 	hostname := g.host
 	if len(h) > 0 {
@@ -1117,9 +1299,15 @@ func (g *Graph) NewNode(i Identifier, m Metadata, h ...string) *Node {
 }
 
 func newEdge(i Identifier, p *Node, c *Node, m Metadata, t time.Time, h string) *Edge {
+	return CreateEdge(i, p.ID, c.ID, m, t, h)
+}
+
+// CreateEdge returns a new edge of the given host between the parent and the
+// child nodes, not bound to a graph
+func CreateEdge(i Identifier, p Identifier, c Identifier, m Metadata, t time.Time, h string) *Edge {
 	e := &Edge{
-		parent: p.ID,
-		child:  c.ID,
+		parent: p,
+		child:  c,
 		graphElement: graphElement{
 			ID:        i,
 			host:      h,
@@ -1212,6 +1400,46 @@ func (g *Graph) DelHostGraph(host string) {
 	}
 }
 
+// ReconcileHostGraph makes the graph of a host match the given nodes and
+// edges. Contrary to a DelHostGraph followed by a re-sync, only the elements
+// added, updated or removed since trigger events.
+func (g *Graph) ReconcileHostGraph(host string, nodes []*Node, edges []*Edge) {
+	nodeIDs := make(map[Identifier]bool)
+	for _, n := range nodes {
+		nodeIDs[n.ID] = true
+
+		if node := g.GetNode(n.ID); node == nil {
+			g.AddNode(n)
+		} else if node.revision != n.revision || !reflect.DeepEqual(node.metadata, n.metadata) {
+			g.NodeUpdated(n)
+		}
+	}
+
+	edgeIDs := make(map[Identifier]bool)
+	for _, e := range edges {
+		edgeIDs[e.ID] = true
+
+		if edge := g.GetEdge(e.ID); edge == nil {
+			g.AddEdge(e)
+		} else if !reflect.DeepEqual(edge.metadata, e.metadata) {
+			g.EdgeUpdated(e)
+		}
+	}
+
+	t := time.Now().UTC()
+	for _, e := range g.GetEdges(nil) {
+		if e.host == host && !edgeIDs[e.ID] {
+			g.delEdge(e, t)
+		}
+	}
+
+	for _, n := range g.GetNodes(nil) {
+		if n.host == host && !nodeIDs[n.ID] {
+			g.delNode(n, t)
+		}
+	}
+}
+
 // GetNodes returns a list of nodes
 func (g *Graph) GetNodes(m GraphElementMatcher) []*Node {
 	return g.backend.GetNodes(g.context.TimeSlice, m)
@@ -1237,8 +1465,7 @@ func (g *Graph) String() string {
 	return string(j)
 }
 
-// MarshalJSON serialize the graph in JSON
-func (g *Graph) MarshalJSON() ([]byte, error) {
+func (g *Graph) sortedElements() ([]*Node, []*Edge) {
 	nodes := make([]*Node, 0)
 	nodes = append(nodes, g.GetNodes(nil)...)
 	SortNodes(nodes, "CreatedAt", common.SortAscending)
@@ -1247,6 +1474,13 @@ func (g *Graph) MarshalJSON() ([]byte, error) {
 	edges = append(edges, g.GetEdges(nil)...)
 	SortEdges(edges, "CreatedAt", common.SortAscending)
 
+	return nodes, edges
+}
+
+// MarshalJSON serialize the graph in JSON
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	nodes, edges := g.sortedElements()
+
 	return json.Marshal(&struct {
 		Nodes []*Node
 		Edges []*Edge
@@ -1256,6 +1490,29 @@ func (g *Graph) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// MarshalMsgpack serialize the graph in MessagePack
+func (g *Graph) MarshalMsgpack() ([]byte, error) {
+	nodes, edges := g.sortedElements()
+
+	// MessagePack encoder doesn't know about unexported fields, serialize
+	// the elements as for JSON
+	msg := &struct {
+		Nodes []interface{}
+		Edges []interface{}
+	}{
+		Nodes: make([]interface{}, len(nodes)),
+		Edges: make([]interface{}, len(edges)),
+	}
+	for i, n := range nodes {
+		msg.Nodes[i] = n.serialize()
+	}
+	for i, e := range edges {
+		msg.Edges[i] = e.serialize()
+	}
+
+	return shttp.MsgpackMarshal(msg)
+}
+
 // WithContext select a graph within a context
 func (g *Graph) WithContext(c GraphContext) (*Graph, error) {
 	return g.backend.WithContext(g, c)
@@ -24,9 +24,14 @@ package graph
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
 )
 
 func newGraph(t *testing.T) *Graph {
@@ -415,6 +420,174 @@ func TestEvents(t *testing.T) {
 	}
 }
 
+type FakePartialListener struct {
+	DefaultGraphListener
+	lastNodeOps []PartialUpdate
+}
+
+func (c *FakePartialListener) OnNodePartiallyUpdated(n *Node, ops []PartialUpdate) {
+	c.lastNodeOps = ops
+}
+
+func (c *FakePartialListener) OnEdgePartiallyUpdated(e *Edge, ops []PartialUpdate) {
+}
+
+func TestPartialEvents(t *testing.T) {
+	g := newGraph(t)
+
+	l := &FakePartialListener{}
+	g.AddEventListener(l)
+
+	n := g.NewNode(GenID(), Metadata{"Name": "eth0", "MTU": 1500, "State": "UP"})
+
+	g.AddMetadata(n, "Metric.RxBytes", 123)
+	expected := []PartialUpdate{{Type: PartialUpdateSet, Key: "Metric.RxBytes", Value: 123}}
+	if !reflect.DeepEqual(l.lastNodeOps, expected) {
+		t.Errorf("Expected operations %v, got %v", expected, l.lastNodeOps)
+	}
+
+	g.DelMetadata(n, "State")
+	expected = []PartialUpdate{{Type: PartialUpdateDel, Key: "State"}}
+	if !reflect.DeepEqual(l.lastNodeOps, expected) {
+		t.Errorf("Expected operations %v, got %v", expected, l.lastNodeOps)
+	}
+
+	m := n.Metadata()
+	m["MTU"] = 9000
+	delete(m, "Metric")
+	g.SetMetadata(n, m)
+
+	sort.Slice(l.lastNodeOps, func(i, j int) bool { return l.lastNodeOps[i].Key < l.lastNodeOps[j].Key })
+	expected = []PartialUpdate{{Type: PartialUpdateSet, Key: "MTU", Value: 9000}, {Type: PartialUpdateDel, Key: "Metric"}}
+	if !reflect.DeepEqual(l.lastNodeOps, expected) {
+		t.Errorf("Expected operations %v, got %v", expected, l.lastNodeOps)
+	}
+}
+
+func TestNodePartiallyUpdated(t *testing.T) {
+	g := newGraph(t)
+
+	l := &FakeListener{}
+	g.AddEventListener(l)
+
+	n := g.NewNode(GenID(), Metadata{"Name": "eth0", "State": "UP"})
+	revision := n.revision
+
+	p := &PartiallyUpdatedMsg{
+		ID:        n.ID,
+		Revision:  revision + 1,
+		UpdatedAt: 2000,
+		Ops: []PartialUpdate{
+			{Type: PartialUpdateSet, Key: "Metric.RxBytes", Value: 123},
+			{Type: PartialUpdateDel, Key: "State"},
+		},
+	}
+
+	if err := g.NodePartiallyUpdated(p); err != nil {
+		t.Fatalf("Partial update not applied: %s", err)
+	}
+
+	// listeners not implementing partial updates get the whole node
+	if l.lastNodeUpdated == nil || l.lastNodeUpdated.ID != n.ID {
+		t.Error("Didn't get the notification")
+	}
+
+	expected := Metadata{"Name": "eth0", "Metric": map[string]interface{}{"RxBytes": 123}}
+	if !reflect.DeepEqual(n.Metadata(), expected) {
+		t.Errorf("Expected metadata %v, got %v", expected, n.Metadata())
+	}
+
+	if n.revision != revision+1 || common.UnixMillis(n.updatedAt) != 2000 {
+		t.Errorf("Wrong revision or update time: %s", n.String())
+	}
+
+	// an update was missed, the partial update is rejected
+	p.Revision = revision + 3
+	p.Ops = []PartialUpdate{{Type: PartialUpdateSet, Key: "State", Value: "DOWN"}}
+	if err := g.NodePartiallyUpdated(p); err != ErrRevisionMismatch {
+		t.Errorf("Expected a revision mismatch, got: %v", err)
+	}
+	if _, err := n.GetField("State"); err == nil || n.revision != revision+1 {
+		t.Errorf("Rejected update should not be applied: %s", n.String())
+	}
+
+	// the same update replayed is rejected as well
+	p.Revision = revision + 1
+	if err := g.NodePartiallyUpdated(p); err != ErrRevisionMismatch {
+		t.Errorf("Expected a revision mismatch, got: %v", err)
+	}
+
+	if err := g.NodePartiallyUpdated(&PartiallyUpdatedMsg{ID: "unknown"}); err != ErrElementNotFound {
+		t.Errorf("Partial update of an unknown node should fail, got: %v", err)
+	}
+}
+
+func TestReconcileHostGraph(t *testing.T) {
+	g := newGraph(t)
+
+	now := time.Now().UTC()
+	n1 := g.newNode("n1", Metadata{"Name": "eth0"}, now, "host1")
+	n2 := g.newNode("n2", Metadata{"Name": "eth1"}, now, "host1")
+	g.newNode("n3", Metadata{"Name": "eth0"}, now, "host2")
+	g.newEdge("e1", n1, n2, Metadata{"RelationType": "layer2"}, now, "host1")
+
+	var events []string
+	g.AddEventListener(&eventRecorder{events: &events})
+
+	// same n1, n2 removed, n4 added, host2 untouched
+	nodes := []*Node{
+		{graphElement: graphElement{ID: "n1", metadata: Metadata{"Name": "eth0"}, host: "host1", revision: n1.revision}},
+		{graphElement: graphElement{ID: "n4", metadata: Metadata{"Name": "eth2"}, host: "host1", revision: 1}},
+	}
+	g.ReconcileHostGraph("host1", nodes, nil)
+
+	expected := []string{"NodeAdded n4", "EdgeDeleted e1", "NodeDeleted n2"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+
+	if g.GetNode("n2") != nil || g.GetNode("n3") == nil || g.GetNode("n4") == nil {
+		t.Error("Host graph not reconciled")
+	}
+
+	// metadata changed
+	events = nil
+	nodes[0].metadata = Metadata{"Name": "eth0", "MTU": 1500}
+	g.ReconcileHostGraph("host1", nodes, nil)
+
+	if expected = []string{"NodeUpdated n1"}; !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+type eventRecorder struct {
+	events *[]string
+}
+
+func (r *eventRecorder) OnNodeUpdated(n *Node) {
+	*r.events = append(*r.events, "NodeUpdated "+string(n.ID))
+}
+
+func (r *eventRecorder) OnNodeAdded(n *Node) {
+	*r.events = append(*r.events, "NodeAdded "+string(n.ID))
+}
+
+func (r *eventRecorder) OnNodeDeleted(n *Node) {
+	*r.events = append(*r.events, "NodeDeleted "+string(n.ID))
+}
+
+func (r *eventRecorder) OnEdgeUpdated(e *Edge) {
+	*r.events = append(*r.events, "EdgeUpdated "+string(e.ID))
+}
+
+func (r *eventRecorder) OnEdgeAdded(e *Edge) {
+	*r.events = append(*r.events, "EdgeAdded "+string(e.ID))
+}
+
+func (r *eventRecorder) OnEdgeDeleted(e *Edge) {
+	*r.events = append(*r.events, "EdgeDeleted "+string(e.ID))
+}
+
 type FakeRecursiveListener1 struct {
 	DefaultGraphListener
 	graph *Graph
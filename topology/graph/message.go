@@ -23,7 +23,6 @@
 package graph
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 
@@ -43,12 +42,25 @@ const (
 	EdgeUpdatedMsgType      = "EdgeUpdated"
 	EdgeDeletedMsgType      = "EdgeDeleted"
 	EdgeAddedMsgType        = "EdgeAdded"
+
+	NodePartiallyUpdatedMsgType = "NodePartiallyUpdated"
+	EdgePartiallyUpdatedMsgType = "EdgePartiallyUpdated"
+	NodeRequestMsgType          = "NodeRequest"
+	EdgeRequestMsgType          = "EdgeRequest"
+
+	HostSyncMsgType       = "HostSync"
+	HostConnectedMsgType  = "HostConnected"
+	JournalRequestMsgType = "JournalRequest"
+	JournalReplyMsgType   = "JournalReply"
 )
 
 // Graph error message
 var (
-	ErrSyncRequestMalFormed = errors.New("SyncRequestMsg malformed")
-	ErrSyncMsgMalFormed     = errors.New("SyncMsg/SyncReplyMsg malformed")
+	ErrSyncRequestMalFormed    = errors.New("SyncRequestMsg malformed")
+	ErrSyncMsgMalFormed        = errors.New("SyncMsg/SyncReplyMsg malformed")
+	ErrPartialMsgMalFormed     = errors.New("PartiallyUpdatedMsg malformed")
+	ErrJournalMsgMalFormed     = errors.New("JournalRequestMsg/JournalReplyMsg malformed")
+	ErrElementRequestMalFormed = errors.New("ElementRequestMsg malformed")
 )
 
 // type SyncRequestMsg describes a graph synchro request message
@@ -63,10 +75,141 @@ type SyncMsg struct {
 	Edges []*Edge
 }
 
+// JournalRequestMsg is sent by an agent to its analyzer when connecting, to
+// know the last message of its session the analyzer got
+type JournalRequestMsg struct {
+	Session string
+}
+
+// JournalReplyMsg gives the sequence number of the last message of the agent
+// session the analyzer applied
+type JournalReplyMsg struct {
+	Seq int64
+}
+
+// PartiallyUpdatedMsg describes the metadata operations applied to a node or
+// an edge by an update, and the revision resulting of it
+type PartiallyUpdatedMsg struct {
+	ID        Identifier
+	Revision  int64
+	UpdatedAt int64
+	Ops       []PartialUpdate
+}
+
+// ElementRequestMsg is sent to the sender of a partial update that couldn't be
+// applied, some updates having been missed, to get the whole node or edge
+type ElementRequestMsg struct {
+	ID Identifier
+}
+
+// NewElementRequestMsg returns the message requesting the whole element of a
+// rejected partial update message
+func NewElementRequestMsg(partialMsgType string, id Identifier) *shttp.WSJSONMessage {
+	msgType := NodeRequestMsgType
+	if partialMsgType == EdgePartiallyUpdatedMsgType {
+		msgType = EdgeRequestMsgType
+	}
+	return shttp.NewWSJSONMessage(Namespace, msgType, ElementRequestMsg{ID: id})
+}
+
+// NewElementReplyMsg returns the message updating the whole element asked by
+// a request, nil if the element is unknown
+func NewElementReplyMsg(g *Graph, requestMsgType string, r ElementRequestMsg) *shttp.WSJSONMessage {
+	switch requestMsgType {
+	case NodeRequestMsgType:
+		if n := g.GetNode(r.ID); n != nil {
+			return shttp.NewWSJSONMessage(Namespace, NodeUpdatedMsgType, n)
+		}
+	case EdgeRequestMsgType:
+		if e := g.GetEdge(r.ID); e != nil {
+			return shttp.NewWSJSONMessage(Namespace, EdgeUpdatedMsgType, e)
+		}
+	}
+	return nil
+}
+
+// NewPartiallyUpdatedMsg returns the partial update message of a node or an edge
+func NewPartiallyUpdatedMsg(i interface{}, ops []PartialUpdate) *PartiallyUpdatedMsg {
+	var e *graphElement
+	switch i := i.(type) {
+	case *Node:
+		e = &i.graphElement
+	case *Edge:
+		e = &i.graphElement
+	}
+
+	return &PartiallyUpdatedMsg{
+		ID:        e.ID,
+		Revision:  e.revision,
+		UpdatedAt: common.UnixMillis(e.updatedAt),
+		Ops:       ops,
+	}
+}
+
+// MarshalMsgpack serialize in MessagePack
+func (p *PartiallyUpdatedMsg) MarshalMsgpack() ([]byte, error) {
+	return shttp.MsgpackMarshal(*p)
+}
+
+// Decode deserialize the partial update message
+func (p *PartiallyUpdatedMsg) Decode(i interface{}) (err error) {
+	objMap, ok := i.(map[string]interface{})
+	if !ok {
+		return ErrPartialMsgMalFormed
+	}
+
+	id, ok := objMap["ID"].(string)
+	if !ok {
+		return ErrPartialMsgMalFormed
+	}
+	p.ID = Identifier(id)
+
+	if p.Revision, err = decodeInt64(objMap["Revision"]); err != nil {
+		return err
+	}
+
+	if p.UpdatedAt, err = decodeInt64(objMap["UpdatedAt"]); err != nil {
+		return err
+	}
+
+	ops, ok := objMap["Ops"].([]interface{})
+	if !ok {
+		return ErrPartialMsgMalFormed
+	}
+
+	for _, o := range ops {
+		op, ok := o.(map[string]interface{})
+		if !ok {
+			return ErrPartialMsgMalFormed
+		}
+		decodeMap(op)
+
+		kind, _ := op["Type"].(string)
+		key, _ := op["Key"].(string)
+		if key == "" || (kind != string(PartialUpdateSet) && kind != string(PartialUpdateDel)) {
+			return ErrPartialMsgMalFormed
+		}
+
+		p.Ops = append(p.Ops, PartialUpdate{Type: PartialUpdateType(kind), Key: key, Value: op["Value"]})
+	}
+
+	return nil
+}
+
+func decodeInt64(i interface{}) (int64, error) {
+	switch v := i.(type) {
+	case json.Number:
+		return v.Int64()
+	case int64:
+		return v, nil
+	}
+	return 0, ErrPartialMsgMalFormed
+}
+
 // UnmarshalWSMessage deserialize the websocket message
 func UnmarshalWSMessage(msg *shttp.WSJSONMessage) (string, interface{}, error) {
 	var obj interface{}
-	if err := common.JSONDecode(bytes.NewReader([]byte(*msg.Obj)), &obj); err != nil {
+	if err := msg.DecodeObj(&obj); err != nil {
 		return "", msg, err
 	}
 
@@ -85,6 +228,8 @@ func UnmarshalWSMessage(msg *shttp.WSJSONMessage) (string, interface{}, error) {
 				return "", msg, err
 			}
 			syncRequest.TimeSlice = common.NewTimeSlice(i, i)
+		case int64:
+			syncRequest.TimeSlice = common.NewTimeSlice(v, v)
 		}
 
 		if s, ok := m["GremlinFilter"]; ok {
@@ -94,7 +239,30 @@ func UnmarshalWSMessage(msg *shttp.WSJSONMessage) (string, interface{}, error) {
 		}
 
 		return msg.Type, syncRequest, nil
-	case SyncMsgType, SyncReplyMsgType:
+	case JournalRequestMsgType:
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return "", msg, ErrJournalMsgMalFormed
+		}
+
+		session, ok := m["Session"].(string)
+		if !ok {
+			return "", msg, ErrJournalMsgMalFormed
+		}
+
+		return msg.Type, JournalRequestMsg{Session: session}, nil
+	case JournalReplyMsgType:
+		var reply JournalReplyMsg
+		if m, ok := obj.(map[string]interface{}); ok && m["Seq"] != nil {
+			seq, err := decodeInt64(m["Seq"])
+			if err != nil {
+				return "", msg, ErrJournalMsgMalFormed
+			}
+			reply.Seq = seq
+		}
+
+		return msg.Type, reply, nil
+	case SyncMsgType, SyncReplyMsgType, HostSyncMsgType:
 		result := &SyncMsg{}
 
 		els, ok := obj.(map[string]interface{})
@@ -136,7 +304,7 @@ func UnmarshalWSMessage(msg *shttp.WSJSONMessage) (string, interface{}, error) {
 		}
 
 		return msg.Type, result, nil
-	case HostGraphDeletedMsgType:
+	case HostGraphDeletedMsgType, HostConnectedMsgType:
 		return msg.Type, obj, nil
 	case NodeUpdatedMsgType, NodeDeletedMsgType, NodeAddedMsgType:
 		var node Node
@@ -152,6 +320,25 @@ func UnmarshalWSMessage(msg *shttp.WSJSONMessage) (string, interface{}, error) {
 		}
 
 		return msg.Type, &edge, nil
+	case NodePartiallyUpdatedMsgType, EdgePartiallyUpdatedMsgType:
+		var partial PartiallyUpdatedMsg
+		if err := partial.Decode(obj); err != nil {
+			return "", msg, err
+		}
+
+		return msg.Type, &partial, nil
+	case NodeRequestMsgType, EdgeRequestMsgType:
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return "", msg, ErrElementRequestMalFormed
+		}
+
+		id, ok := m["ID"].(string)
+		if !ok {
+			return "", msg, ErrElementRequestMalFormed
+		}
+
+		return msg.Type, ElementRequestMsg{ID: Identifier(id)}, nil
 	}
 
 	return "", msg, nil
@@ -25,8 +25,10 @@ package graph
 import (
 	"encoding/json"
 	"net/http"
+	"reflect"
 	"testing"
 
+	"github.com/skydive-project/skydive/common"
 	shttp "github.com/skydive-project/skydive/http"
 )
 
@@ -115,3 +117,185 @@ func TestHost(t *testing.T) {
 		t.Error("Should raise an error")
 	}
 }
+
+func TestMsgpackGraph(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(Identifier("aaa"), Metadata{"Name": "eth0", "MTU": 1500, "Metric": map[string]interface{}{"RxBytes": int64(123)}})
+	n2 := g.NewNode(Identifier("bbb"), Metadata{"Name": "eth1"})
+	g.NewEdge(Identifier("ccc"), n1, n2, Metadata{"RelationType": "layer2"})
+
+	b, err := g.MarshalMsgpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var obj map[string]interface{}
+	if err := shttp.MsgpackUnmarshal(b, &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, _ := obj["Nodes"].([]interface{})
+	edges, _ := obj["Edges"].([]interface{})
+	if len(nodes) != 2 || len(edges) != 1 {
+		t.Fatalf("Expected 2 nodes and 1 edge, got %v", obj)
+	}
+
+	// the nodes are not ordered
+	node := &Node{}
+	for _, obj := range nodes {
+		if err := node.Decode(obj); err != nil {
+			t.Fatal(err)
+		}
+		if node.ID == n1.ID {
+			break
+		}
+		node = &Node{}
+	}
+
+	if node.ID != n1.ID || node.Host() != n1.Host() || node.revision != n1.revision {
+		t.Errorf("Wrong node decoded: %s", node.String())
+	}
+
+	if mtu, _ := node.GetFieldInt64("MTU"); mtu != 1500 {
+		t.Errorf("Wrong MTU decoded: %s", node.String())
+	}
+
+	if rx, _ := node.GetFieldInt64("Metric.RxBytes"); rx != 123 {
+		t.Errorf("Wrong metric decoded: %s", node.String())
+	}
+
+	var edge Edge
+	if err := edge.Decode(edges[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if edge.GetParent() != n1.ID || edge.GetChild() != n2.ID {
+		t.Errorf("Wrong edge decoded: %s", edge.String())
+	}
+}
+
+func TestPartiallyUpdatedMsg(t *testing.T) {
+	g := newGraph(t)
+
+	n := g.NewNode(Identifier("aaa"), Metadata{"Name": "eth0"})
+	g.AddMetadata(n, "Metric.RxBytes", int64(123))
+
+	ops := []PartialUpdate{
+		{Type: PartialUpdateSet, Key: "Metric.RxBytes", Value: int64(123)},
+		{Type: PartialUpdateDel, Key: "State"},
+	}
+	partial := NewPartiallyUpdatedMsg(n, ops)
+
+	checkPartial := func(format string, p *PartiallyUpdatedMsg) {
+		if p.ID != n.ID || p.Revision != n.revision || p.UpdatedAt != common.UnixMillis(n.updatedAt) {
+			t.Errorf("Wrong partial update decoded from %s: %+v", format, p)
+		}
+
+		if !reflect.DeepEqual(p.Ops, ops) {
+			t.Errorf("Expected operations %v from %s, got %v", ops, format, p.Ops)
+		}
+	}
+
+	b, err := json.Marshal(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := json.RawMessage(b)
+	msgType, obj, err := UnmarshalWSMessage(&shttp.WSJSONMessage{
+		Namespace: Namespace,
+		Type:      NodePartiallyUpdatedMsgType,
+		Obj:       &raw,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := obj.(*PartiallyUpdatedMsg)
+	if msgType != NodePartiallyUpdatedMsgType || !ok {
+		t.Fatalf("Wrong message decoded: %s %v", msgType, obj)
+	}
+	checkPartial("JSON", p)
+
+	if b, err = partial.MarshalMsgpack(); err != nil {
+		t.Fatal(err)
+	}
+
+	var m interface{}
+	if err := shttp.MsgpackUnmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	p = &PartiallyUpdatedMsg{}
+	if err := p.Decode(m); err != nil {
+		t.Fatal(err)
+	}
+	checkPartial("MessagePack", p)
+}
+
+func TestJournalMsg(t *testing.T) {
+	decode := func(msgType string, obj string) (string, interface{}) {
+		raw := json.RawMessage(obj)
+		msgType, v, err := UnmarshalWSMessage(&shttp.WSJSONMessage{Namespace: Namespace, Type: msgType, Obj: &raw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msgType, v
+	}
+
+	if _, v := decode(JournalRequestMsgType, `{"Session": "abc"}`); v != (JournalRequestMsg{Session: "abc"}) {
+		t.Errorf("Wrong journal request decoded: %v", v)
+	}
+
+	if _, v := decode(JournalReplyMsgType, `{"Seq": 42}`); v != (JournalReplyMsg{Seq: 42}) {
+		t.Errorf("Wrong journal reply decoded: %v", v)
+	}
+
+	if _, v := decode(JournalReplyMsgType, `null`); v != (JournalReplyMsg{}) {
+		t.Errorf("Wrong journal reply decoded: %v", v)
+	}
+
+	msgType, v := decode(HostSyncMsgType, `{"Nodes": [{"ID": "aaa", "Host": "host1"}]}`)
+	if r, ok := v.(*SyncMsg); msgType != HostSyncMsgType || !ok || len(r.Nodes) != 1 {
+		t.Errorf("Wrong host sync decoded: %v", v)
+	}
+}
+
+func TestElementRequestMsg(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(Identifier("n1"), Metadata{"Name": "eth0"})
+	n2 := g.NewNode(Identifier("n2"), Metadata{"Name": "eth1"})
+	e := g.NewEdge(Identifier("e1"), n1, n2, Metadata{"RelationType": "layer2"})
+
+	request := NewElementRequestMsg(EdgePartiallyUpdatedMsgType, e.ID)
+	if request.Type != EdgeRequestMsgType {
+		t.Errorf("Expected an edge request, got: %s", request.Type)
+	}
+
+	b, err := json.Marshal(ElementRequestMsg{ID: e.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := json.RawMessage(b)
+	msgType, obj, err := UnmarshalWSMessage(&shttp.WSJSONMessage{Namespace: Namespace, Type: EdgeRequestMsgType, Obj: &raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, ok := obj.(ElementRequestMsg)
+	if msgType != EdgeRequestMsgType || !ok || r.ID != e.ID {
+		t.Fatalf("Wrong message decoded: %s %v", msgType, obj)
+	}
+
+	reply := NewElementReplyMsg(g, msgType, r)
+	if reply == nil || reply.Type != EdgeUpdatedMsgType {
+		t.Errorf("Expected the whole edge, got: %v", reply)
+	}
+
+	if reply := NewElementReplyMsg(g, NodeRequestMsgType, ElementRequestMsg{ID: "unknown"}); reply != nil {
+		t.Errorf("Unknown element should not be replied, got: %v", reply)
+	}
+}
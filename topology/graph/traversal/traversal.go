@@ -49,6 +49,7 @@ type GraphTraversalStep interface {
 // GraphStepContext a step within a context
 type GraphStepContext struct {
 	PaginationRange *GraphTraversalRange
+	OrderByID       bool // whether the step has to order its elements by identifier before applying the range
 }
 
 // Iterator on the range
@@ -68,6 +69,7 @@ type GraphTraversal struct {
 	error              error
 	currentStepContext GraphStepContext
 	lockGraph          bool
+	query              *queryState
 }
 
 // GraphTraversalV traversal steps on nodes
@@ -515,7 +517,7 @@ func (t *GraphTraversal) Context(s ...interface{}) *GraphTraversal {
 		return &GraphTraversal{error: err}
 	}
 
-	return &GraphTraversal{Graph: g}
+	return &GraphTraversal{Graph: g, query: t.query}
 }
 
 // V step : [node ID]
@@ -551,6 +553,14 @@ func (t *GraphTraversal) V(s ...interface{}) *GraphTraversalV {
 		nodes = t.Graph.GetNodes(matcher)
 	}
 
+	if err := t.Visit(len(nodes)); err != nil {
+		return &GraphTraversalV{error: err}
+	}
+
+	if t.currentStepContext.OrderByID {
+		nodes = nodesByID(nodes, t.currentStepContext.PaginationRange)
+	}
+
 	if t.currentStepContext.PaginationRange != nil {
 		var nodeRange []*graph.Node
 		it := t.currentStepContext.PaginationRange.Iterator()
@@ -564,6 +574,10 @@ func (t *GraphTraversal) V(s ...interface{}) *GraphTraversalV {
 		nodes = nodeRange
 	}
 
+	if err := t.Collect(len(nodes)); err != nil {
+		return &GraphTraversalV{error: err}
+	}
+
 	return &GraphTraversalV{GraphTraversal: t, nodes: nodes}
 }
 
@@ -717,6 +731,14 @@ func (t *GraphTraversal) E(s ...interface{}) *GraphTraversalE {
 		edges = t.Graph.GetEdges(matcher)
 	}
 
+	if err := t.Visit(len(edges)); err != nil {
+		return &GraphTraversalE{error: err}
+	}
+
+	if t.currentStepContext.OrderByID {
+		edges = edgesByID(edges, t.currentStepContext.PaginationRange)
+	}
+
 	if t.currentStepContext.PaginationRange != nil {
 		var edgeRange []*graph.Edge
 		it := t.currentStepContext.PaginationRange.Iterator()
@@ -730,6 +752,10 @@ func (t *GraphTraversal) E(s ...interface{}) *GraphTraversalE {
 		edges = edgeRange
 	}
 
+	if err := t.Collect(len(edges)); err != nil {
+		return &GraphTraversalE{error: err}
+	}
+
 	return &GraphTraversalE{GraphTraversal: t, edges: edges}
 }
 
@@ -844,6 +870,9 @@ nodeLoop:
 		if it.Done() {
 			break
 		}
+		if err := tv.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
 
 		skip := false
 		if len(keys) != 0 {
@@ -870,6 +899,9 @@ nodeLoop:
 		}
 
 		ntv.nodes = append(ntv.nodes, n)
+		if err := tv.GraphTraversal.Collect(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
 		if !skip {
 			visited[kvisited] = true
 		}
@@ -924,6 +956,10 @@ func (tv *GraphTraversalV) ShortestPathTo(m graph.Metadata, e graph.Metadata) *G
 
 	visited := make(map[graph.Identifier]bool)
 	for _, n := range tv.nodes {
+		if err := tv.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalShortestPath{error: err}
+		}
+
 		if _, ok := visited[n.ID]; !ok {
 			path := tv.GraphTraversal.Graph.LookupShortestPath(n, m, e)
 			if len(path) > 0 {
@@ -968,8 +1004,14 @@ func (tv *GraphTraversalV) Has(s ...interface{}) *GraphTraversalV {
 		if it.Done() {
 			break
 		}
+		if err := tv.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
 		if (filter == nil || filter.Eval(n)) && it.Next() {
 			ntv.nodes = append(ntv.nodes, n)
+			if err := tv.GraphTraversal.Collect(1); err != nil {
+				return &GraphTraversalV{error: err}
+			}
 		}
 	}
 
@@ -998,8 +1040,14 @@ func (tv *GraphTraversalV) HasNot(s string) *GraphTraversalV {
 		if it.Done() {
 			break
 		}
+		if err := tv.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
 		if (filter == nil || filter.Eval(n)) && it.Next() {
 			ntv.nodes = append(ntv.nodes, n)
+			if err := tv.GraphTraversal.Collect(1); err != nil {
+				return &GraphTraversalV{error: err}
+			}
 		}
 	}
 
@@ -1026,6 +1074,10 @@ func (tv *GraphTraversalV) Both(s ...interface{}) *GraphTraversalV {
 nodeloop:
 	for _, n := range tv.nodes {
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, nil) {
+			if err := tv.GraphTraversal.Visit(1); err != nil {
+				return &GraphTraversalV{error: err}
+			}
+
 			var nodes []*graph.Node
 			if e.GetChild() == n.ID {
 				nodes, _ = tv.GraphTraversal.Graph.GetEdgeNodes(e, metadata, nil)
@@ -1038,6 +1090,9 @@ nodeloop:
 					break nodeloop
 				} else if it.Next() {
 					ntv.nodes = append(ntv.nodes, node)
+					if err := tv.GraphTraversal.Collect(1); err != nil {
+						return &GraphTraversalV{error: err}
+					}
 				}
 			}
 		}
@@ -1108,10 +1163,16 @@ func (tv *GraphTraversalV) Out(s ...interface{}) *GraphTraversalV {
 nodeloop:
 	for _, n := range tv.nodes {
 		for _, child := range tv.GraphTraversal.Graph.LookupChildren(n, metadata, nil) {
+			if err := tv.GraphTraversal.Visit(1); err != nil {
+				return &GraphTraversalV{error: err}
+			}
 			if it.Done() {
 				break nodeloop
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, child)
+				if err := tv.GraphTraversal.Collect(1); err != nil {
+					return &GraphTraversalV{error: err}
+				}
 			}
 		}
 	}
@@ -1139,11 +1200,17 @@ func (tv *GraphTraversalV) OutE(s ...interface{}) *GraphTraversalE {
 nodeloop:
 	for _, n := range tv.nodes {
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, metadata) {
+			if err := tv.GraphTraversal.Visit(1); err != nil {
+				return &GraphTraversalE{error: err}
+			}
 			if e.GetParent() == n.ID {
 				if it.Done() {
 					break nodeloop
 				} else {
 					nte.edges = append(nte.edges, e)
+					if err := tv.GraphTraversal.Collect(1); err != nil {
+						return &GraphTraversalE{error: err}
+					}
 				}
 			}
 		}
@@ -1172,10 +1239,16 @@ func (tv *GraphTraversalV) BothE(s ...interface{}) *GraphTraversalE {
 nodeloop:
 	for _, n := range tv.nodes {
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, metadata) {
+			if err := tv.GraphTraversal.Visit(1); err != nil {
+				return &GraphTraversalE{error: err}
+			}
 			if it.Done() {
 				break nodeloop
 			} else if it.Next() {
 				nte.edges = append(nte.edges, e)
+				if err := tv.GraphTraversal.Collect(1); err != nil {
+					return &GraphTraversalE{error: err}
+				}
 			}
 		}
 	}
@@ -1203,10 +1276,16 @@ func (tv *GraphTraversalV) In(s ...interface{}) *GraphTraversalV {
 nodeloop:
 	for _, n := range tv.nodes {
 		for _, parent := range tv.GraphTraversal.Graph.LookupParents(n, metadata, nil) {
+			if err := tv.GraphTraversal.Visit(1); err != nil {
+				return &GraphTraversalV{error: err}
+			}
 			if it.Done() {
 				break nodeloop
 			} else {
 				ntv.nodes = append(ntv.nodes, parent)
+				if err := tv.GraphTraversal.Collect(1); err != nil {
+					return &GraphTraversalV{error: err}
+				}
 			}
 		}
 	}
@@ -1234,11 +1313,17 @@ func (tv *GraphTraversalV) InE(s ...interface{}) *GraphTraversalE {
 nodeloop:
 	for _, n := range tv.nodes {
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, metadata) {
+			if err := tv.GraphTraversal.Visit(1); err != nil {
+				return &GraphTraversalE{error: err}
+			}
 			if e.GetChild() == n.ID {
 				if it.Done() {
 					break nodeloop
 				} else if it.Next() {
 					nte.edges = append(nte.edges, e)
+					if err := tv.GraphTraversal.Collect(1); err != nil {
+						return &GraphTraversalE{error: err}
+					}
 				}
 			}
 		}
@@ -1271,6 +1356,10 @@ func (tv *GraphTraversalV) SubGraph(s ...interface{}) *GraphTraversal {
 	// then insert edges, ignore edge insert error since one of the linked node couldn't be part
 	// of the SubGraph
 	for _, n := range tv.nodes {
+		if err := tv.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversal{error: err}
+		}
+
 		edges := tv.GraphTraversal.Graph.GetNodeEdges(n, nil)
 		for _, e := range edges {
 			memory.EdgeAdded(e)
@@ -1279,7 +1368,9 @@ func (tv *GraphTraversalV) SubGraph(s ...interface{}) *GraphTraversal {
 
 	ng := graph.NewGraph(tv.GraphTraversal.Graph.GetHost(), memory)
 
-	return NewGraphTraversal(ng, tv.GraphTraversal.lockGraph)
+	gt := NewGraphTraversal(ng, tv.GraphTraversal.lockGraph)
+	gt.query = tv.GraphTraversal.query
+	return gt
 }
 
 // SubGraph step, node/edge out
@@ -1315,7 +1406,9 @@ func (tv *GraphTraversalShortestPath) SubGraph(s ...interface{}) *GraphTraversal
 
 	ng := graph.NewGraph(tv.GraphTraversal.Graph.GetHost(), memory)
 
-	return NewGraphTraversal(ng, tv.GraphTraversal.lockGraph)
+	gt := NewGraphTraversal(ng, tv.GraphTraversal.lockGraph)
+	gt.query = tv.GraphTraversal.query
+	return gt
 }
 
 // Count step
@@ -1386,6 +1479,10 @@ func (te *GraphTraversalE) Dedup(keys ...interface{}) *GraphTraversalE {
 	defer te.GraphTraversal.RUnlock()
 
 	for _, e := range te.edges {
+		if err := te.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalE{error: err}
+		}
+
 		kvisited = e.ID
 		if key != "" {
 			if v, ok := e.Metadata()[key]; ok {
@@ -1395,6 +1492,9 @@ func (te *GraphTraversalE) Dedup(keys ...interface{}) *GraphTraversalE {
 
 		if _, ok := visited[kvisited]; !ok {
 			ntv.edges = append(ntv.edges, e)
+			if err := te.GraphTraversal.Collect(1); err != nil {
+				return &GraphTraversalE{error: err}
+			}
 			visited[kvisited] = true
 		}
 	}
@@ -1435,8 +1535,14 @@ func (te *GraphTraversalE) Has(s ...interface{}) *GraphTraversalE {
 		if it.Done() {
 			break
 		}
+		if err := te.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalE{error: err}
+		}
 		if (filter == nil || filter.Eval(e)) && it.Next() {
 			nte.edges = append(nte.edges, e)
+			if err := te.GraphTraversal.Collect(1); err != nil {
+				return &GraphTraversalE{error: err}
+			}
 		}
 	}
 
@@ -1465,8 +1571,14 @@ func (te *GraphTraversalE) HasNot(s string) *GraphTraversalE {
 		if it.Done() {
 			break
 		}
+		if err := te.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalE{error: err}
+		}
 		if (filter == nil || filter.Eval(e)) && it.Next() {
 			nte.edges = append(nte.edges, e)
+			if err := te.GraphTraversal.Collect(1); err != nil {
+				return &GraphTraversalE{error: err}
+			}
 		}
 	}
 
@@ -1491,12 +1603,19 @@ func (te *GraphTraversalE) InV(s ...interface{}) *GraphTraversalV {
 	defer te.GraphTraversal.RUnlock()
 
 	for _, e := range te.edges {
+		if err := te.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
 		parents, _ := te.GraphTraversal.Graph.GetEdgeNodes(e, metadata, nil)
 		for _, parent := range parents {
 			if it.Done() {
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, parent)
+				if err := te.GraphTraversal.Collect(1); err != nil {
+					return &GraphTraversalV{error: err}
+				}
 			}
 		}
 	}
@@ -1522,12 +1641,19 @@ func (te *GraphTraversalE) OutV(s ...interface{}) *GraphTraversalV {
 	defer te.GraphTraversal.RUnlock()
 
 	for _, e := range te.edges {
+		if err := te.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
 		_, children := te.GraphTraversal.Graph.GetEdgeNodes(e, nil, metadata)
 		for _, child := range children {
 			if it.Done() {
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, child)
+				if err := te.GraphTraversal.Collect(1); err != nil {
+					return &GraphTraversalV{error: err}
+				}
 			}
 		}
 	}
@@ -1553,12 +1679,19 @@ func (te *GraphTraversalE) BothV(s ...interface{}) *GraphTraversalV {
 	defer te.GraphTraversal.RUnlock()
 
 	for _, e := range te.edges {
+		if err := te.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
 		parents, children := te.GraphTraversal.Graph.GetEdgeNodes(e, metadata, metadata)
 		for _, parent := range parents {
 			if it.Done() {
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, parent)
+				if err := te.GraphTraversal.Collect(1); err != nil {
+					return &GraphTraversalV{error: err}
+				}
 			}
 		}
 		for _, child := range children {
@@ -1566,6 +1699,9 @@ func (te *GraphTraversalE) BothV(s ...interface{}) *GraphTraversalV {
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, child)
+				if err := te.GraphTraversal.Collect(1); err != nil {
+					return &GraphTraversalV{error: err}
+				}
 			}
 		}
 	}
@@ -1588,6 +1724,10 @@ func (te *GraphTraversalE) SubGraph(s ...interface{}) *GraphTraversal {
 	}
 
 	for _, e := range te.edges {
+		if err := te.GraphTraversal.Visit(1); err != nil {
+			return &GraphTraversal{error: err}
+		}
+
 		parents, children := te.GraphTraversal.Graph.GetEdgeNodes(e, nil, nil)
 		for _, child := range children {
 			if !memory.NodeAdded(child) {
@@ -1608,7 +1748,9 @@ func (te *GraphTraversalE) SubGraph(s ...interface{}) *GraphTraversal {
 
 	ng := graph.NewGraph(te.GraphTraversal.Graph.GetHost(), memory)
 
-	return NewGraphTraversal(ng, te.GraphTraversal.lockGraph)
+	gt := NewGraphTraversal(ng, te.GraphTraversal.lockGraph)
+	gt.query = te.GraphTraversal.query
+	return gt
 }
 
 // NewGraphTraversalValue creates a new traversal value step
@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// maximum number of idle sequences kept for a single query
+const maxIdleSequences = 8
+
+type queryCacheEntry struct {
+	query     string
+	sequences []*GremlinTraversalSequence
+}
+
+// QueryCache caches the parsed traversal sequences of the Gremlin queries
+// evaluated over and over, for instance by the alerts. As the execution of a
+// sequence is not reentrant, a sequence is handed to a single user at a time
+// and returned to the cache once executed, a query being parsed again only
+// when all its cached sequences are in use.
+type QueryCache struct {
+	sync.Mutex
+	parser  *GremlinTraversalParser
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// Get returns a parsed sequence for the given query, the sequence has to be
+// given back using Put once executed
+func (c *QueryCache) Get(query string) (*GremlinTraversalSequence, error) {
+	c.Lock()
+	if element, ok := c.entries[query]; ok {
+		c.lru.MoveToFront(element)
+
+		entry := element.Value.(*queryCacheEntry)
+		if n := len(entry.sequences); n > 0 {
+			ts := entry.sequences[n-1]
+			entry.sequences = entry.sequences[:n-1]
+			c.Unlock()
+			return ts, nil
+		}
+	}
+	c.Unlock()
+
+	return c.parser.Parse(strings.NewReader(query))
+}
+
+// Put gives back a sequence previously returned by Get
+func (c *QueryCache) Put(query string, ts *GremlinTraversalSequence) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[query]
+	if !ok {
+		element = c.lru.PushFront(&queryCacheEntry{query: query})
+		c.entries[query] = element
+
+		if c.lru.Len() > c.size {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*queryCacheEntry).query)
+		}
+	}
+
+	if entry := element.Value.(*queryCacheEntry); len(entry.sequences) < maxIdleSequences {
+		entry.sequences = append(entry.sequences, ts)
+	}
+}
+
+// Exec executes the given query using a cached sequence
+func (c *QueryCache) Exec(query string, g *graph.Graph, lockGraph bool) (GraphTraversalStep, error) {
+	ts, err := c.Get(query)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Put(query, ts)
+
+	return ts.Exec(g, lockGraph)
+}
+
+// Len returns the number of queries in the cache
+func (c *QueryCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lru.Len()
+}
+
+// NewQueryCache returns a new cache of at most size queries parsed with the given parser
+func NewQueryCache(parser *GremlinTraversalParser, size int) *QueryCache {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &QueryCache{
+		parser:  parser,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
@@ -0,0 +1,379 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// IncrementalDelta holds the modifications of the result of an incremental
+// query caused by a graph event
+type IncrementalDelta struct {
+	AddedNodes   []*graph.Node
+	UpdatedNodes []*graph.Node
+	RemovedNodes []*graph.Node
+	AddedEdges   []*graph.Edge
+	UpdatedEdges []*graph.Edge
+	RemovedEdges []*graph.Edge
+}
+
+// Changed returns whether elements were added to or removed from the result
+func (d *IncrementalDelta) Changed() bool {
+	return len(d.AddedNodes)+len(d.RemovedNodes)+len(d.AddedEdges)+len(d.RemovedEdges) > 0
+}
+
+// Empty returns whether the result was not modified at all
+func (d *IncrementalDelta) Empty() bool {
+	return !d.Changed() && len(d.UpdatedNodes)+len(d.UpdatedEdges) == 0
+}
+
+// IncrementalQuery maintains the result of a query of the form G.V().Has(...)
+// or G.E().Has(...), optionally followed by a SubGraph step, by evaluating
+// only the elements touched by the graph events instead of executing the
+// whole query again. The graph lock has to be held by the caller of all the
+// methods taking a graph as parameter.
+type IncrementalQuery struct {
+	filter   *filters.Filter
+	edges    bool
+	subGraph bool
+	nodes    map[graph.Identifier]*graph.Node
+	links    map[graph.Identifier]*graph.Edge
+}
+
+func stepFilter(params []interface{}) (*filters.Filter, error) {
+	switch len(params) {
+	case 0:
+		return nil, nil
+	case 1:
+		k, ok := params[0].(string)
+		if !ok {
+			return nil, errors.New("Key must be a string")
+		}
+		return filters.NewNotFilter(filters.NewNullFilter(k)), nil
+	default:
+		return ParamsToFilter(params...)
+	}
+}
+
+// NewIncrementalQuery returns an incremental query for the given sequence,
+// or nil if the shape of the sequence is not supported
+func NewIncrementalQuery(ts *GremlinTraversalSequence) *IncrementalQuery {
+	if len(ts.steps) == 0 {
+		return nil
+	}
+
+	q := &IncrementalQuery{
+		nodes: make(map[graph.Identifier]*graph.Node),
+		links: make(map[graph.Identifier]*graph.Edge),
+	}
+
+	var andFilters []*filters.Filter
+	for i, step := range ts.steps {
+		var params []interface{}
+		switch s := step.(type) {
+		case *GremlinTraversalStepV, *GremlinTraversalStepE:
+			if i != 0 {
+				return nil
+			}
+			_, q.edges = s.(*GremlinTraversalStepE)
+
+			// a single parameter is a lookup by identifier
+			if params = s.Context().Params; len(params) == 1 {
+				return nil
+			}
+		case *GremlinTraversalStepHas:
+			if params = s.Params; len(params) == 0 {
+				return nil
+			}
+		case *GremlinTraversalStepHasKey:
+			params = s.Params
+		case *GremlinTraversalStepHasNot:
+			if len(s.Params) != 1 {
+				return nil
+			}
+			k, ok := s.Params[0].(string)
+			if !ok {
+				return nil
+			}
+			andFilters = append(andFilters, filters.NewNullFilter(k))
+			continue
+		case *GremlinTraversalStepSubGraph:
+			if i != len(ts.steps)-1 {
+				return nil
+			}
+			q.subGraph = true
+			continue
+		default:
+			return nil
+		}
+
+		if i == 0 && step.Context().StepContext.PaginationRange != nil {
+			return nil
+		}
+
+		filter, err := stepFilter(params)
+		if err != nil {
+			return nil
+		}
+		if filter != nil {
+			andFilters = append(andFilters, filter)
+		}
+	}
+
+	switch ts.steps[0].(type) {
+	case *GremlinTraversalStepV, *GremlinTraversalStepE:
+	default:
+		return nil
+	}
+
+	if len(andFilters) > 0 {
+		q.filter = filters.NewAndFilter(andFilters...)
+	}
+	return q
+}
+
+func (q *IncrementalQuery) match(e filters.Getter) bool {
+	return q.filter == nil || q.filter.Eval(e)
+}
+
+// SubGraph returns whether the query returns a graph
+func (q *IncrementalQuery) SubGraph() bool {
+	return q.subGraph
+}
+
+// Reset evaluates the whole query
+func (q *IncrementalQuery) Reset(g *graph.Graph) {
+	q.nodes = make(map[graph.Identifier]*graph.Node)
+	q.links = make(map[graph.Identifier]*graph.Edge)
+
+	var delta IncrementalDelta
+	if q.edges {
+		for _, e := range g.GetEdges(nil) {
+			if q.match(e) {
+				q.addEdge(g, e, &delta)
+			}
+		}
+	} else {
+		for _, n := range g.GetNodes(nil) {
+			if q.match(n) {
+				q.nodes[n.ID] = n
+			}
+		}
+
+		if q.subGraph {
+			for _, e := range g.GetEdges(nil) {
+				q.OnEdgeAdded(g, e)
+			}
+		}
+	}
+}
+
+func (q *IncrementalQuery) addEdge(g *graph.Graph, e *graph.Edge, delta *IncrementalDelta) {
+	q.links[e.ID] = e
+	delta.AddedEdges = append(delta.AddedEdges, e)
+
+	if !q.subGraph {
+		return
+	}
+
+	for _, id := range []graph.Identifier{e.GetParent(), e.GetChild()} {
+		if _, ok := q.nodes[id]; !ok {
+			if n := g.GetNode(id); n != nil {
+				q.nodes[id] = n
+				delta.AddedNodes = append(delta.AddedNodes, n)
+			}
+		}
+	}
+}
+
+func (q *IncrementalQuery) linked(id graph.Identifier) bool {
+	for _, e := range q.links {
+		if e.GetParent() == id || e.GetChild() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *IncrementalQuery) removeEdge(e *graph.Edge, delta *IncrementalDelta) {
+	delete(q.links, e.ID)
+	delta.RemovedEdges = append(delta.RemovedEdges, e)
+
+	// with an edge query, the nodes are part of the result only
+	// as long as one of their edges is
+	if !q.subGraph || !q.edges {
+		return
+	}
+
+	for _, id := range []graph.Identifier{e.GetParent(), e.GetChild()} {
+		if n, ok := q.nodes[id]; ok && !q.linked(id) {
+			delete(q.nodes, id)
+			delta.RemovedNodes = append(delta.RemovedNodes, n)
+		}
+	}
+}
+
+func (q *IncrementalQuery) removeNode(n *graph.Node, delta *IncrementalDelta) {
+	delete(q.nodes, n.ID)
+	delta.RemovedNodes = append(delta.RemovedNodes, n)
+
+	for _, e := range q.links {
+		if e.GetParent() == n.ID || e.GetChild() == n.ID {
+			delete(q.links, e.ID)
+			delta.RemovedEdges = append(delta.RemovedEdges, e)
+		}
+	}
+}
+
+func (q *IncrementalQuery) onNode(g *graph.Graph, n *graph.Node) (delta IncrementalDelta) {
+	_, in := q.nodes[n.ID]
+
+	if q.edges {
+		if in {
+			delta.UpdatedNodes = append(delta.UpdatedNodes, n)
+		}
+		return
+	}
+
+	switch match := q.match(n); {
+	case match && in:
+		delta.UpdatedNodes = append(delta.UpdatedNodes, n)
+	case match:
+		q.nodes[n.ID] = n
+		delta.AddedNodes = append(delta.AddedNodes, n)
+
+		if q.subGraph {
+			for _, e := range g.GetNodeEdges(n, nil) {
+				if _, ok := q.links[e.ID]; !ok && q.nodes[e.GetParent()] != nil && q.nodes[e.GetChild()] != nil {
+					q.links[e.ID] = e
+					delta.AddedEdges = append(delta.AddedEdges, e)
+				}
+			}
+		}
+	case in:
+		q.removeNode(n, &delta)
+	}
+
+	return
+}
+
+// OnNodeAdded updates the result according to the node added
+func (q *IncrementalQuery) OnNodeAdded(g *graph.Graph, n *graph.Node) IncrementalDelta {
+	return q.onNode(g, n)
+}
+
+// OnNodeUpdated updates the result according to the node updated
+func (q *IncrementalQuery) OnNodeUpdated(g *graph.Graph, n *graph.Node) IncrementalDelta {
+	return q.onNode(g, n)
+}
+
+// OnNodeDeleted updates the result according to the node deleted
+func (q *IncrementalQuery) OnNodeDeleted(g *graph.Graph, n *graph.Node) (delta IncrementalDelta) {
+	if _, in := q.nodes[n.ID]; in {
+		q.removeNode(n, &delta)
+	}
+	return
+}
+
+func (q *IncrementalQuery) onEdge(g *graph.Graph, e *graph.Edge) (delta IncrementalDelta) {
+	_, in := q.links[e.ID]
+
+	var match bool
+	if q.edges {
+		match = q.match(e)
+	} else if q.subGraph {
+		match = q.nodes[e.GetParent()] != nil && q.nodes[e.GetChild()] != nil
+	} else {
+		return
+	}
+
+	switch {
+	case match && in:
+		delta.UpdatedEdges = append(delta.UpdatedEdges, e)
+	case match:
+		q.addEdge(g, e, &delta)
+	case in:
+		q.removeEdge(e, &delta)
+	}
+
+	return
+}
+
+// OnEdgeAdded updates the result according to the edge added
+func (q *IncrementalQuery) OnEdgeAdded(g *graph.Graph, e *graph.Edge) IncrementalDelta {
+	return q.onEdge(g, e)
+}
+
+// OnEdgeUpdated updates the result according to the edge updated
+func (q *IncrementalQuery) OnEdgeUpdated(g *graph.Graph, e *graph.Edge) IncrementalDelta {
+	return q.onEdge(g, e)
+}
+
+// OnEdgeDeleted updates the result according to the edge deleted
+func (q *IncrementalQuery) OnEdgeDeleted(g *graph.Graph, e *graph.Edge) (delta IncrementalDelta) {
+	if _, in := q.links[e.ID]; in {
+		q.removeEdge(e, &delta)
+	}
+	return
+}
+
+// Nodes returns the nodes of the result sorted by identifier
+func (q *IncrementalQuery) Nodes() []*graph.Node {
+	nodes := make([]*graph.Node, 0, len(q.nodes))
+	for _, n := range q.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// Edges returns the edges of the result sorted by identifier
+func (q *IncrementalQuery) Edges() []*graph.Edge {
+	edges := make([]*graph.Edge, 0, len(q.links))
+	for _, e := range q.links {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].ID < edges[j].ID })
+	return edges
+}
+
+// Values returns the nodes or the edges matching the query, for a SubGraph
+// query the nodes are followed by the edges
+func (q *IncrementalQuery) Values() []interface{} {
+	var values []interface{}
+	if !q.edges || q.subGraph {
+		for _, n := range q.Nodes() {
+			values = append(values, n)
+		}
+	}
+	if q.edges || q.subGraph {
+		for _, e := range q.Edges() {
+			values = append(values, e)
+		}
+	}
+	return values
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrQueryCancelled error returned when the query context has been cancelled,
+// for instance when the client went away
+var ErrQueryCancelled = errors.New("Query cancelled")
+
+// QueryLimits defines the cost limits of a query, a zero value means no limit
+type QueryLimits struct {
+	MaxElements int64         // maximum number of nodes/edges visited
+	MaxResults  int64         // maximum number of values returned
+	Timeout     time.Duration // maximum duration of the execution
+}
+
+// QueryLimitError is returned when a query is aborted because it reached one
+// of its limits
+type QueryLimitError struct {
+	Limit string
+	Value interface{}
+}
+
+func (e *QueryLimitError) Error() string {
+	return fmt.Sprintf("Query aborted, %s limit of %v reached", e.Limit, e.Value)
+}
+
+// queryState holds the execution state of a query shared by all the steps
+type queryState struct {
+	ctx     context.Context
+	limits  QueryLimits
+	visited int64
+	final   bool  // whether the last step of the query is executed
+	results int64 // number of values collected by the last step
+}
+
+func (q *queryState) err() error {
+	switch q.ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return &QueryLimitError{Limit: "timeout", Value: q.limits.Timeout}
+	default:
+		return ErrQueryCancelled
+	}
+}
+
+func (q *queryState) visit(n int) error {
+	if q == nil {
+		return nil
+	}
+
+	if visited := atomic.AddInt64(&q.visited, int64(n)); q.limits.MaxElements > 0 && visited > q.limits.MaxElements {
+		return &QueryLimitError{Limit: "elements", Value: q.limits.MaxElements}
+	}
+
+	return q.err()
+}
+
+func (q *queryState) collect(n int) error {
+	if q == nil || !q.final {
+		return nil
+	}
+
+	if results := atomic.AddInt64(&q.results, int64(n)); q.limits.MaxResults > 0 && results > q.limits.MaxResults {
+		return &QueryLimitError{Limit: "results", Value: q.limits.MaxResults}
+	}
+
+	return nil
+}
+
+// Visit accounts for n elements visited by a step. An error is returned if
+// the query has to be aborted, steps have to stop as soon as possible to
+// release the graph lock.
+func (t *GraphTraversal) Visit(n int) error {
+	return t.query.visit(n)
+}
+
+// Collect accounts for n values added by a step to its result. When the step
+// is the last one of the query, an error is returned as soon as the results
+// limit is reached so that the result is not built any further.
+func (t *GraphTraversal) Collect(n int) error {
+	return t.query.collect(n)
+}
+
+// QueryContext returns the context of the query, extension steps should use
+// it for any blocking call
+func (t *GraphTraversal) QueryContext() context.Context {
+	if t.query == nil {
+		return context.Background()
+	}
+	return t.query.ctx
+}
+
+// QueryLimits returns the limits of the query
+func (t *GraphTraversal) QueryLimits() QueryLimits {
+	if t.query == nil {
+		return QueryLimits{}
+	}
+	return t.query.limits
+}
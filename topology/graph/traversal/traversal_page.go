@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// ErrInvalidPage error returned when the bounds of a page are not valid
+var ErrInvalidPage = errors.New("Invalid page, the bounds have to be positive and From lower or equal to To")
+
+// GremlinTraversalPagedStep is implemented by the steps able to return a
+// page of their result, ordered by identifier, without building the whole
+// result first. The step must not modify its own state as the sequence
+// may be executed again.
+type GremlinTraversalPagedStep interface {
+	ExecPage(last GraphTraversalStep, page filters.Range) (GraphTraversalStep, error)
+}
+
+// Page returns the range of a page of a result already restricted to r,
+// the bounds of the page being relative to the start of r
+func (r *GraphTraversalRange) Page(page filters.Range) *GraphTraversalRange {
+	from, to := page.From, page.To
+	if r != nil {
+		from, to = r[0]+from, r[0]+to
+		if to > r[1] {
+			to = r[1]
+		}
+		if from > to {
+			from = to
+		}
+	}
+	return &GraphTraversalRange{from, to}
+}
+
+// byKey is a max-heap of element indexes ordered by key
+type byKey struct {
+	keys    []string
+	indexes []int
+}
+
+func (h *byKey) Len() int {
+	return len(h.indexes)
+}
+
+func (h *byKey) Less(i, j int) bool {
+	return h.keys[h.indexes[i]] > h.keys[h.indexes[j]]
+}
+
+func (h *byKey) Swap(i, j int) {
+	h.indexes[i], h.indexes[j] = h.indexes[j], h.indexes[i]
+}
+
+func (h *byKey) Push(x interface{}) {
+	h.indexes = append(h.indexes, x.(int))
+}
+
+func (h *byKey) Pop() interface{} {
+	last := len(h.indexes) - 1
+	x := h.indexes[last]
+	h.indexes = h.indexes[:last]
+	return x
+}
+
+// firstByKey returns, in order, the indexes of the n elements having the
+// lowest keys. Only n elements are kept while scanning the keys so that a
+// page at the beginning of a large result does not require to sort it all.
+// A negative n returns the indexes of all the elements.
+func firstByKey(keys []string, n int64) []int {
+	if n < 0 || n > int64(len(keys)) {
+		n = int64(len(keys))
+	}
+
+	h := &byKey{keys: keys, indexes: make([]int, 0, n)}
+	for i := 0; n > 0 && i < len(keys); i++ {
+		if int64(h.Len()) < n {
+			heap.Push(h, i)
+		} else if keys[i] < keys[h.indexes[0]] {
+			h.indexes[0] = i
+			heap.Fix(h, 0)
+		}
+	}
+
+	sort.Slice(h.indexes, func(i, j int) bool { return keys[h.indexes[i]] < keys[h.indexes[j]] })
+	return h.indexes
+}
+
+func rangeEnd(r *GraphTraversalRange) int64 {
+	if r == nil {
+		return -1
+	}
+	return r[1]
+}
+
+// nodesByID returns the nodes ordered by identifier, up to the end of the range
+func nodesByID(nodes []*graph.Node, r *GraphTraversalRange) []*graph.Node {
+	keys := make([]string, len(nodes))
+	for i, n := range nodes {
+		keys[i] = string(n.ID)
+	}
+
+	indexes := firstByKey(keys, rangeEnd(r))
+	sorted := make([]*graph.Node, len(indexes))
+	for i, index := range indexes {
+		sorted[i] = nodes[index]
+	}
+	return sorted
+}
+
+// edgesByID returns the edges ordered by identifier, up to the end of the range
+func edgesByID(edges []*graph.Edge, r *GraphTraversalRange) []*graph.Edge {
+	keys := make([]string, len(edges))
+	for i, e := range edges {
+		keys[i] = string(e.ID)
+	}
+
+	indexes := firstByKey(keys, rangeEnd(r))
+	sorted := make([]*graph.Edge, len(indexes))
+	for i, index := range indexes {
+		sorted[i] = edges[index]
+	}
+	return sorted
+}
+
+// elementID returns the identifier of a node or an edge
+func elementID(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case *graph.Node:
+		return string(v.ID), true
+	case *graph.Edge:
+		return string(v.ID), true
+	}
+	return "", false
+}
+
+// slicePage returns the values of the page of a result built by a step
+// not supporting paging. Nodes and edges are ordered by identifier so that
+// consecutive pages do not overlap, other values are kept in their order.
+func slicePage(values []interface{}, page filters.Range) []interface{} {
+	keys := make([]string, len(values))
+	for i, value := range values {
+		id, ok := elementID(value)
+		if !ok {
+			keys = nil
+			break
+		}
+		keys[i] = id
+	}
+
+	if keys != nil {
+		indexes := firstByKey(keys, page.To)
+		sorted := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			sorted[i] = values[index]
+		}
+		values = sorted
+	}
+
+	from, to := page.From, page.To
+	if to > int64(len(values)) {
+		to = int64(len(values))
+	}
+	if from > to {
+		from = to
+	}
+	return values[from:to]
+}
+
+// ExecPage executes the sequence and returns the values of the given range
+// of its result. When the last step is a paged step, the page is computed
+// by the step itself, otherwise the result is built before being sliced.
+func (s *GremlinTraversalSequence) ExecPage(ctx context.Context, g *graph.Graph, lockGraph bool, limits QueryLimits, page filters.Range) (GraphTraversalStep, error) {
+	if page.From < 0 || page.To < page.From {
+		return nil, ErrInvalidPage
+	}
+
+	return s.exec(ctx, g, lockGraph, limits, &page)
+}
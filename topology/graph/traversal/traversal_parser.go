@@ -32,7 +32,10 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/topology/graph"
 )
 
@@ -41,6 +44,7 @@ type (
 	GremlinTraversalSequence struct {
 		GraphTraversal *GraphTraversal
 		steps          []GremlinTraversalStep
+		reduced        []GremlinTraversalStep
 		extensions     []GremlinTraversalExtension
 	}
 
@@ -241,6 +245,18 @@ func (s *GremlinTraversalStepV) Exec(last GraphTraversalStep) (GraphTraversalSte
 	return g.V(s.Params...), nil
 }
 
+// ExecPage returns a page of the V step result ordered by identifier
+func (s *GremlinTraversalStepV) ExecPage(last GraphTraversalStep, page filters.Range) (GraphTraversalStep, error) {
+	g, ok := last.(*GraphTraversal)
+	if !ok {
+		return nil, ErrExecutionError
+	}
+
+	g.currentStepContext = GraphStepContext{PaginationRange: s.StepContext.PaginationRange.Page(page), OrderByID: true}
+
+	return g.V(s.Params...), nil
+}
+
 // Reduce V step
 func (s *GremlinTraversalStepV) Reduce(next GremlinTraversalStep) GremlinTraversalStep {
 	if s.ReduceRange(next) {
@@ -267,6 +283,18 @@ func (s *GremlinTraversalStepE) Exec(last GraphTraversalStep) (GraphTraversalSte
 	return g.E(s.Params...), nil
 }
 
+// ExecPage returns a page of the E step result ordered by identifier
+func (s *GremlinTraversalStepE) ExecPage(last GraphTraversalStep, page filters.Range) (GraphTraversalStep, error) {
+	g, ok := last.(*GraphTraversal)
+	if !ok {
+		return nil, ErrExecutionError
+	}
+
+	g.currentStepContext = GraphStepContext{PaginationRange: s.StepContext.PaginationRange.Page(page), OrderByID: true}
+
+	return g.E(s.Params...), nil
+}
+
 // Reduce E step
 func (s *GremlinTraversalStepE) Reduce(next GremlinTraversalStep) GremlinTraversalStep {
 	if s.ReduceRange(next) {
@@ -773,31 +801,78 @@ func (s *GremlinTraversalStepSum) Reduce(next GremlinTraversalStep) GremlinTrave
 	return next
 }
 
+// reduce merges the steps into their previous step when possible and returns
+// the steps to execute. As the reduction modifies the state of the steps, it
+// is done only once so that a sequence can be executed several times.
+func (s *GremlinTraversalSequence) reduce() []GremlinTraversalStep {
+	if s.reduced != nil {
+		return s.reduced
+	}
+
+	for i := 0; i < len(s.steps); {
+		step := s.steps[i]
+
+		for i = i + 1; i < len(s.steps); i = i + 1 {
+			if next := step.Reduce(s.steps[i]); next != step {
+				break
+			}
+		}
+
+		s.reduced = append(s.reduced, step)
+	}
+
+	return s.reduced
+}
+
 // Exec sequence step
 func (s *GremlinTraversalSequence) Exec(g *graph.Graph, lockGraph bool) (GraphTraversalStep, error) {
-	var step GremlinTraversalStep
+	return s.ExecWithLimits(context.Background(), g, lockGraph, QueryLimits{})
+}
+
+// ExecWithLimits executes the sequence, the execution is aborted with an error
+// when the context is cancelled or when one of the limits is reached
+func (s *GremlinTraversalSequence) ExecWithLimits(ctx context.Context, g *graph.Graph, lockGraph bool, limits QueryLimits) (GraphTraversalStep, error) {
+	return s.exec(ctx, g, lockGraph, limits, nil)
+}
+
+func (s *GremlinTraversalSequence) exec(ctx context.Context, g *graph.Graph, lockGraph bool, limits QueryLimits, page *filters.Range) (GraphTraversalStep, error) {
 	var last GraphTraversalStep
 	var err error
 
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+	query := &queryState{ctx: ctx, limits: limits}
+
 	s.GraphTraversal = NewGraphTraversal(g, lockGraph)
+	s.GraphTraversal.query = query
 	last = s.GraphTraversal
 
-	for i := 0; i < len(s.steps); {
-		step = s.steps[i]
+	steps := s.reduce()
+	for i, step := range steps {
+		// only the values of the last step are accounted for in the results
+		query.final = i == len(steps)-1
 
-		for i = i + 1; i < len(s.steps); i = i + 1 {
-			if next := step.Reduce(s.steps[i]); next != step {
-				break
-			}
+		if paged, ok := step.(GremlinTraversalPagedStep); ok && page != nil && i == len(steps)-1 {
+			last, err = paged.ExecPage(last, *page)
+			page = nil
+		} else {
+			last, err = step.Exec(last)
 		}
 
-		if last, err = step.Exec(last); err != nil {
+		if err != nil {
 			return nil, err
 		}
 
 		if err := last.Error(); err != nil {
 			return nil, err
 		}
+
+		if err := query.err(); err != nil {
+			return nil, err
+		}
 	}
 
 	res, ok := last.(GraphTraversalStep)
@@ -805,6 +880,15 @@ func (s *GremlinTraversalSequence) Exec(g *graph.Graph, lockGraph bool) (GraphTr
 		return nil, ErrExecutionError
 	}
 
+	// the last step was not able to return the page by itself
+	if page != nil {
+		res = NewGraphTraversalValue(s.GraphTraversal, slicePage(res.Values(), *page))
+	}
+
+	if limits.MaxResults > 0 && int64(len(res.Values())) > limits.MaxResults {
+		return nil, &QueryLimitError{Limit: "results", Value: limits.MaxResults}
+	}
+
 	return res, nil
 }
 
@@ -23,9 +23,14 @@
 package traversal
 
 import (
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/topology/graph"
 )
 
@@ -650,3 +655,266 @@ func TestTraversalParser(t *testing.T) {
 		t.Fatalf("Should return 1 node, returned: %v", res.Values())
 	}
 }
+
+func TestTraversalQueryLimits(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	exec := func(ctx context.Context, query string, limits QueryLimits) (GraphTraversalStep, error) {
+		ts, err := NewGremlinTraversalParser().Parse(strings.NewReader(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts.ExecWithLimits(ctx, g, false, limits)
+	}
+
+	query := `G.V().Both().Both().Both()`
+	if _, err := exec(context.Background(), query, QueryLimits{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := exec(context.Background(), query, QueryLimits{MaxElements: 20})
+	if e, ok := err.(*QueryLimitError); !ok || e.Limit != "elements" {
+		t.Errorf("Expected elements limit error, got: %v", err)
+	}
+
+	if _, err = exec(context.Background(), `G.V()`, QueryLimits{MaxResults: 4}); err != nil {
+		t.Errorf("Results limit should not be reached, got: %v", err)
+	}
+
+	_, err = exec(context.Background(), `G.V()`, QueryLimits{MaxResults: 3})
+	if e, ok := err.(*QueryLimitError); !ok || e.Limit != "results" {
+		t.Errorf("Expected results limit error, got: %v", err)
+	}
+
+	// the values of the intermediate steps are not accounted for
+	if _, err = exec(context.Background(), `G.V().Count()`, QueryLimits{MaxResults: 1}); err != nil {
+		t.Errorf("Results limit should not be reached, got: %v", err)
+	}
+
+	_, err = exec(context.Background(), `G.V().Both()`, QueryLimits{MaxResults: 2})
+	if e, ok := err.(*QueryLimitError); !ok || e.Limit != "results" {
+		t.Errorf("Expected results limit error, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = exec(ctx, query, QueryLimits{}); err != ErrQueryCancelled {
+		t.Errorf("Expected cancelled query error, got: %v", err)
+	}
+}
+
+func TestTraversalExecPage(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	page := func(query string, from, to int64) []string {
+		ts, err := NewGremlinTraversalParser().Parse(strings.NewReader(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := ts.ExecPage(context.Background(), g, false, QueryLimits{}, filters.Range{From: from, To: to})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, value := range res.Values() {
+			id, _ := elementID(value)
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	for _, query := range []string{`G.V()`, `G.E()`, `G.V().Both().Dedup()`, `G.V().Range(1, 3)`} {
+		all := page(query, 0, 100)
+		if !sort.StringsAreSorted(all) {
+			t.Errorf("Values of %s are not ordered by identifier: %v", query, all)
+		}
+
+		// consecutive pages cover the whole result without overlapping
+		var pages []string
+		for from := int64(0); from < int64(len(all))+2; from += 2 {
+			pages = append(pages, page(query, from, from+2)...)
+		}
+		if !reflect.DeepEqual(pages, all) {
+			t.Errorf("Pages of %s are %v, expected %v", query, pages, all)
+		}
+	}
+
+	if all := page(`G.V().Range(1, 3)`, 0, 100); len(all) != 2 {
+		t.Errorf("The range of the query should be applied, got: %v", all)
+	}
+
+	ts, _ := NewGremlinTraversalParser().Parse(strings.NewReader(`G.V()`))
+	if _, err := ts.ExecPage(context.Background(), g, false, QueryLimits{}, filters.Range{From: 2, To: 1}); err != ErrInvalidPage {
+		t.Errorf("Expected invalid page error, got: %v", err)
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	g := newTransversalGraph(t)
+	cache := NewQueryCache(NewGremlinTraversalParser(), 1)
+
+	query := `G.V().Has('Type', 'intf')`
+	ts1, err := cache.Get(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sequence in use is not handed twice
+	ts2, _ := cache.Get(query)
+	if ts1 == ts2 {
+		t.Fatal("A sequence in use should not be returned by the cache")
+	}
+
+	cache.Put(query, ts1)
+	if ts, _ := cache.Get(query); ts != ts1 {
+		t.Fatal("The cached sequence should be returned")
+	}
+
+	res, err := cache.Exec(query, g, false)
+	if err != nil || len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 nodes, returned: %v, %v", res, err)
+	}
+
+	// only one query is kept
+	cache.Exec(`G.V()`, g, false)
+	if cache.Len() != 1 {
+		t.Fatalf("Expected 1 query in the cache, got %d", cache.Len())
+	}
+
+	if _, err := cache.Get(`G.V().Foo()`); err == nil {
+		t.Fatal("Invalid query should return an error")
+	}
+
+	// the reduced steps of a cached sequence give the same result on each run
+	for query, expected := range map[string]int{`G.V().Range(1, 3)`: 2, `G.V().Has('Type', 'intf').Limit(1)`: 1} {
+		for i := 0; i != 3; i++ {
+			res, err := cache.Exec(query, g, false)
+			if err != nil || len(res.Values()) != expected {
+				t.Fatalf("Cached %s should return %d nodes, returned: %v, %v", query, expected, res, err)
+			}
+		}
+	}
+}
+
+type incrementalQueryListener struct {
+	graph.DefaultGraphListener
+	g       *graph.Graph
+	queries []*IncrementalQuery
+}
+
+func (l *incrementalQueryListener) OnNodeAdded(n *graph.Node) {
+	for _, q := range l.queries {
+		q.OnNodeAdded(l.g, n)
+	}
+}
+
+func (l *incrementalQueryListener) OnNodeUpdated(n *graph.Node) {
+	for _, q := range l.queries {
+		q.OnNodeUpdated(l.g, n)
+	}
+}
+
+func (l *incrementalQueryListener) OnNodeDeleted(n *graph.Node) {
+	for _, q := range l.queries {
+		q.OnNodeDeleted(l.g, n)
+	}
+}
+
+func (l *incrementalQueryListener) OnEdgeAdded(e *graph.Edge) {
+	for _, q := range l.queries {
+		q.OnEdgeAdded(l.g, e)
+	}
+}
+
+func (l *incrementalQueryListener) OnEdgeUpdated(e *graph.Edge) {
+	for _, q := range l.queries {
+		q.OnEdgeUpdated(l.g, e)
+	}
+}
+
+func (l *incrementalQueryListener) OnEdgeDeleted(e *graph.Edge) {
+	for _, q := range l.queries {
+		q.OnEdgeDeleted(l.g, e)
+	}
+}
+
+func TestIncrementalQuery(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	for _, query := range []string{`G.V().Out()`, `G.V('123')`, `G.V().Has('Type', 'intf').Limit(1)`, `G.V().SubGraph().V()`} {
+		ts, _ := NewGremlinTraversalParser().Parse(strings.NewReader(query))
+		if NewIncrementalQuery(ts) != nil {
+			t.Errorf("Query %s should not be incremental", query)
+		}
+	}
+
+	queries := []string{
+		`G.V().Has('Type', 'intf')`,
+		`G.V().HasKey('IPV4').HasNot('Name')`,
+		`G.V().Has('Type', 'intf').SubGraph()`,
+		`G.E().Has('Direction', 'Left')`,
+		`G.E().Has('Direction', 'Left').SubGraph()`,
+	}
+
+	listener := &incrementalQueryListener{g: g}
+	var sequences []*GremlinTraversalSequence
+	for _, query := range queries {
+		ts, err := NewGremlinTraversalParser().Parse(strings.NewReader(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q := NewIncrementalQuery(ts)
+		if q == nil {
+			t.Fatalf("Query %s should be incremental", query)
+		}
+		q.Reset(g)
+
+		sequences = append(sequences, ts)
+		listener.queries = append(listener.queries, q)
+	}
+	g.AddEventListener(listener)
+
+	n5 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "intf", "IPV4": "10.0.0.5"})
+	n1 := g.LookupFirstNode(graph.Metadata{"Value": int64(1)})
+	n3 := g.LookupFirstNode(graph.Metadata{"Value": int64(3)})
+	g.Link(n5, n1, graph.Metadata{"Direction": "Left"})
+	g.AddMetadata(n3, "Type", "intf")
+	g.AddMetadata(n1, "Type", "host")
+	g.Link(n3, n5, graph.Metadata{"Name": "e6"})
+	g.DelNode(g.LookupFirstNode(graph.Metadata{"Value": int64(2)}))
+
+	ids := func(values []interface{}) (s []string) {
+		for _, value := range values {
+			switch v := value.(type) {
+			case *graph.Node:
+				s = append(s, "n"+string(v.ID))
+			case *graph.Edge:
+				s = append(s, "e"+string(v.ID))
+			case *graph.Graph:
+				for _, n := range v.GetNodes(nil) {
+					s = append(s, "n"+string(n.ID))
+				}
+				for _, e := range v.GetEdges(nil) {
+					s = append(s, "e"+string(e.ID))
+				}
+			}
+		}
+		sort.Strings(s)
+		return
+	}
+
+	for i, q := range listener.queries {
+		res, err := sequences[i].Exec(g, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, got := ids(res.Values()), ids(q.Values())
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query %s: expected %v, got %v", queries[i], expected, got)
+		}
+	}
+}
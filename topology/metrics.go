@@ -23,7 +23,10 @@
 package topology
 
 import (
+	"time"
+
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
 )
 
 // InterfaceMetric the interface packets counters
@@ -221,3 +224,114 @@ func (im *InterfaceMetric) IsZero() bool {
 		im.TxPackets +
 		im.TxWindowErrors) == 0
 }
+
+// OfRuleMetric the OpenFlow rule counters, also used for the firewall rules
+type OfRuleMetric struct {
+	Packets int64 `json:"Packets,omitempty"`
+	Bytes   int64 `json:"Bytes,omitempty"`
+	IdleAge int64 `json:"IdleAge,omitempty"`
+	Start   int64 `json:"Start,omitempty"`
+	Last    int64 `json:"Last,omitempty"`
+}
+
+// GetStart returns start time
+func (rm *OfRuleMetric) GetStart() int64 {
+	return rm.Start
+}
+
+// SetStart set start time
+func (rm *OfRuleMetric) SetStart(start int64) {
+	rm.Start = start
+}
+
+// GetLast returns last time
+func (rm *OfRuleMetric) GetLast() int64 {
+	return rm.Last
+}
+
+// SetLast set last time
+func (rm *OfRuleMetric) SetLast(last int64) {
+	rm.Last = last
+}
+
+// GetFieldInt64 returns field by name
+func (rm *OfRuleMetric) GetFieldInt64(field string) (int64, error) {
+	switch field {
+	case "Packets":
+		return rm.Packets, nil
+	case "Bytes":
+		return rm.Bytes, nil
+	case "IdleAge":
+		return rm.IdleAge, nil
+	}
+	return 0, common.ErrFieldNotFound
+}
+
+// Add sum two metrics and return a new Metrics object, the idle age being
+// the one of the most recently hit rule
+func (rm *OfRuleMetric) Add(m common.Metric) common.Metric {
+	om := m.(*OfRuleMetric)
+
+	idleAge := rm.IdleAge
+	if om.IdleAge < idleAge {
+		idleAge = om.IdleAge
+	}
+
+	return &OfRuleMetric{
+		Packets: rm.Packets + om.Packets,
+		Bytes:   rm.Bytes + om.Bytes,
+		IdleAge: idleAge,
+		Start:   rm.Start,
+		Last:    rm.Last,
+	}
+}
+
+// Sub substracts two metrics and return a new Metrics object, the idle age
+// being the current one
+func (rm *OfRuleMetric) Sub(m common.Metric) common.Metric {
+	om := m.(*OfRuleMetric)
+
+	return &OfRuleMetric{
+		Packets: rm.Packets - om.Packets,
+		Bytes:   rm.Bytes - om.Bytes,
+		IdleAge: rm.IdleAge,
+		Start:   rm.Start,
+		Last:    rm.Last,
+	}
+}
+
+// IsZero returns true if the counters are equal to zero
+func (rm *OfRuleMetric) IsZero() bool {
+	return rm.Packets+rm.Bytes == 0
+}
+
+// UpdateRuleMetric records the counters of a rule as the Metric of its node
+// and their increase since the previous update as its LastUpdateMetric,
+// lower counters meaning that they have been reset. It returns the increase,
+// nil on the first update, and false if the counters did not change in
+// which case nothing is recorded.
+func UpdateRuleMetric(tr *graph.MetadataTransaction, metric *OfRuleMetric, last, now time.Time) (*OfRuleMetric, bool) {
+	metric.Last = int64(common.UnixMillis(now))
+
+	var lastUpdateMetric *OfRuleMetric
+	if prevMetric, ok := tr.Metadata["Metric"].(*OfRuleMetric); ok {
+		lastUpdateMetric = metric.Sub(prevMetric).(*OfRuleMetric)
+
+		// nothing changed since last update
+		if lastUpdateMetric.IsZero() {
+			return nil, false
+		}
+
+		// counters have been reset
+		if lastUpdateMetric.Packets < 0 || lastUpdateMetric.Bytes < 0 {
+			lastUpdateMetric.Packets, lastUpdateMetric.Bytes = metric.Packets, metric.Bytes
+		}
+
+		lastUpdateMetric.Start = int64(common.UnixMillis(last))
+		lastUpdateMetric.Last = int64(common.UnixMillis(now))
+		tr.AddMetadata("LastUpdateMetric", lastUpdateMetric)
+	}
+
+	tr.AddMetadata("Metric", metric)
+	return lastUpdateMetric, true
+}
@@ -0,0 +1,106 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package lldp
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/bpf"
+
+	"github.com/skydive-project/skydive/flow/probes/afpacket"
+)
+
+// pollTimeout bounds the time a capture waits for a frame before checking
+// whether it was stopped
+const pollTimeout = time.Second
+
+var (
+	lldpMulticast = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+	cdpMulticast  = net.HardwareAddr{0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc}
+)
+
+// discoveryFilter only accepts the LLDP frames and the frames sent to the
+// CDP multicast address
+var discoveryFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 12, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x88cc, SkipTrue: 5},
+	bpf.LoadAbsolute{Off: 0, Size: 4},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x01000ccc, SkipTrue: 2},
+	bpf.LoadAbsolute{Off: 4, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0xcccc, SkipTrue: 1},
+	bpf.RetConstant{Val: 0},
+	bpf.RetConstant{Val: 0xffff},
+}
+
+type afpacketSource struct {
+	tpacket *afpacket.TPacket
+}
+
+// ReadFrame returns the next discovery frame, nil if none was received
+// before the poll timeout
+func (s *afpacketSource) ReadFrame() ([]byte, error) {
+	data, _, err := s.tpacket.ReadPacketData()
+	if err == afpacket.ErrTimeout || err == syscall.EINTR {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Close the capture socket
+func (s *afpacketSource) Close() {
+	s.tpacket.Close()
+}
+
+func newFrameSource(ifName string) (frameSource, error) {
+	filter, err := bpf.Assemble(discoveryFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	tpacket, err := afpacket.NewTPacket(
+		afpacket.OptInterface(ifName),
+		afpacket.OptNumBlocks(1),
+		afpacket.OptPollTimeout(pollTimeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tpacket.SetBPF(filter); err != nil {
+		tpacket.Close()
+		return nil, err
+	}
+
+	for _, addr := range []net.HardwareAddr{lldpMulticast, cdpMulticast} {
+		if err = tpacket.AddMulticastMembership(ifName, addr); err != nil {
+			tpacket.Close()
+			return nil, err
+		}
+	}
+
+	return &afpacketSource{tpacket: tpacket}, nil
+}
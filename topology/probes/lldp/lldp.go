@@ -0,0 +1,321 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package lldp
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// frameSource reads the discovery frames received by an interface
+type frameSource interface {
+	ReadFrame() ([]byte, error)
+	Close()
+}
+
+// openFrameSource opens the capture of an interface, can be overridden for
+// tests
+var openFrameSource = newFrameSource
+
+// Probe describes a probe listening for the LLDP and CDP frames received by
+// the physical interfaces of the host. Each neighbor is reported as a switch
+// port node linked to the interface, until its advertised TTL expires.
+type Probe struct {
+	sync.Mutex
+	graph.DefaultGraphListener
+	Graph      *graph.Graph
+	Root       *graph.Node
+	Interfaces []string
+	interfaces map[graph.Identifier]*ifProbe
+}
+
+// ifProbe reports the neighbors of an interface. The ports are protected by
+// the graph lock.
+type ifProbe struct {
+	probe *Probe
+	node  *graph.Node
+	name  string
+	ports map[graph.Identifier]time.Time
+	quit  chan struct{}
+}
+
+func portID(intf *graph.Node, n *Neighbor) graph.Identifier {
+	u, _ := uuid.NewV5(uuid.NamespaceOID, []byte("lldp"+string(intf.ID)+n.ChassisID+n.PortID))
+	return graph.Identifier(u.String())
+}
+
+func (p *ifProbe) update(n *Neighbor, now time.Time) {
+	g := p.probe.Graph
+	g.Lock()
+	defer g.Unlock()
+
+	// the interface may have been cleaned while the frame was parsed
+	select {
+	case <-p.quit:
+		return
+	default:
+	}
+
+	id := portID(p.node, n)
+	port := g.GetNode(id)
+
+	// a null TTL is sent when the port is shut down
+	if n.TTL == 0 {
+		if port != nil {
+			g.DelNode(port)
+		}
+		delete(p.ports, id)
+		return
+	}
+
+	metadata := n.Metadata()
+	if port == nil {
+		logging.GetLogger().Debugf("New %s neighbor %s/%s on %s", n.Protocol, n.ChassisID, n.PortID, p.name)
+		port = g.NewNode(id, metadata)
+	} else if !reflect.DeepEqual(port.Metadata(), metadata) {
+		g.SetMetadata(port, metadata)
+	}
+
+	if !topology.HaveLayer2Link(g, p.node, port, nil) {
+		topology.AddLayer2Link(g, p.node, port, nil)
+	}
+
+	p.ports[id] = now.Add(n.TTL)
+}
+
+// expire removes the ports not advertised anymore
+func (p *ifProbe) expire(now time.Time) {
+	g := p.probe.Graph
+	g.Lock()
+	defer g.Unlock()
+
+	for id, expireAt := range p.ports {
+		if now.Before(expireAt) {
+			continue
+		}
+		if port := g.GetNode(id); port != nil {
+			logging.GetLogger().Debugf("Neighbor %s of %s expired", id, p.name)
+			g.DelNode(port)
+		}
+		delete(p.ports, id)
+	}
+}
+
+// clean removes all the ports of the interface, the graph lock has to be held
+func (p *ifProbe) clean() {
+	g := p.probe.Graph
+	for id := range p.ports {
+		if port := g.GetNode(id); port != nil {
+			g.DelNode(port)
+		}
+	}
+	p.ports = make(map[graph.Identifier]time.Time)
+}
+
+// stop unregisters the interface after a capture failure and removes its
+// ports, unless it was already unregistered
+func (p *ifProbe) stop() {
+	probe := p.probe
+
+	probe.Lock()
+	registered := probe.interfaces[p.node.ID] == p
+	if registered {
+		close(p.quit)
+		delete(probe.interfaces, p.node.ID)
+	}
+	probe.Unlock()
+
+	if registered {
+		probe.Graph.Lock()
+		p.clean()
+		probe.Graph.Unlock()
+	}
+}
+
+func (p *ifProbe) run(source frameSource) {
+	defer source.Close()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+
+		data, err := source.ReadFrame()
+		if err != nil {
+			logging.GetLogger().Errorf("Failed to read discovery frames on %s: %s", p.name, err.Error())
+			p.stop()
+			return
+		}
+
+		now := time.Now().UTC()
+		if data != nil {
+			n, err := ParseFrame(data)
+			if err != nil {
+				logging.GetLogger().Debugf("Invalid discovery frame on %s: %s", p.name, err.Error())
+			} else if n != nil {
+				p.update(n, now)
+			}
+		}
+		p.expire(now)
+	}
+}
+
+func (p *Probe) register(n *graph.Node, name string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.interfaces[n.ID]; ok {
+		return
+	}
+
+	source, err := openFrameSource(name)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to capture discovery frames on %s: %s", name, err.Error())
+		return
+	}
+
+	intf := &ifProbe{
+		probe: p,
+		node:  n,
+		name:  name,
+		ports: make(map[graph.Identifier]time.Time),
+		quit:  make(chan struct{}),
+	}
+	p.interfaces[n.ID] = intf
+
+	go intf.run(source)
+}
+
+func (p *Probe) unregister(id graph.Identifier) *ifProbe {
+	p.Lock()
+	defer p.Unlock()
+
+	intf, ok := p.interfaces[id]
+	if ok {
+		close(intf.quit)
+		delete(p.interfaces, id)
+	}
+	return intf
+}
+
+// isPhysical returns the name of the interface if it is a physical
+// interface of the host to listen on
+func (p *Probe) isPhysical(n *graph.Node) (string, bool) {
+	if tp, _ := n.GetFieldString("Type"); tp != "device" || n.Host() != p.Root.Host() {
+		return "", false
+	}
+	if driver, _ := n.GetFieldString("Driver"); driver == "" {
+		return "", false
+	}
+	if mac, _ := n.GetFieldString("MAC"); mac == "" {
+		return "", false
+	}
+
+	name, _ := n.GetFieldString("Name")
+	if len(p.Interfaces) == 0 {
+		return name, name != ""
+	}
+	for _, intf := range p.Interfaces {
+		if intf == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// OnEdgeAdded event
+func (p *Probe) OnEdgeAdded(e *graph.Edge) {
+	// only the interfaces of the host network namespace are captured
+	if e.GetParent() != p.Root.ID {
+		return
+	}
+	if rt, _ := e.GetFieldString("RelationType"); rt != topology.OwnershipLink {
+		return
+	}
+	if n := p.Graph.GetNode(e.GetChild()); n != nil {
+		if name, ok := p.isPhysical(n); ok {
+			p.register(n, name)
+		}
+	}
+}
+
+// OnNodeDeleted event
+func (p *Probe) OnNodeDeleted(n *graph.Node) {
+	if intf := p.unregister(n.ID); intf != nil {
+		intf.clean()
+	}
+}
+
+// Start the probe
+func (p *Probe) Start() {
+	p.Graph.RLock()
+	children := p.Graph.LookupChildren(p.Root, nil, graph.Metadata{"RelationType": topology.OwnershipLink})
+	p.Graph.RUnlock()
+
+	for _, n := range children {
+		if name, ok := p.isPhysical(n); ok {
+			p.register(n, name)
+		}
+	}
+}
+
+// Stop the probe
+func (p *Probe) Stop() {
+	p.Graph.RemoveEventListener(p)
+
+	p.Lock()
+	interfaces := p.interfaces
+	p.interfaces = make(map[graph.Identifier]*ifProbe)
+	p.Unlock()
+
+	p.Graph.Lock()
+	defer p.Graph.Unlock()
+
+	for _, intf := range interfaces {
+		close(intf.quit)
+		intf.clean()
+	}
+}
+
+// NewProbe creates a new LLDP/CDP probe
+func NewProbe(g *graph.Graph, root *graph.Node) *Probe {
+	probe := &Probe{
+		Graph:      g,
+		Root:       root,
+		Interfaces: config.GetConfig().GetStringSlice("agent.topology.lldp.interfaces"),
+		interfaces: make(map[graph.Identifier]*ifProbe),
+	}
+	g.AddEventListener(probe)
+
+	return probe
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package lldp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// LLDP-MED frame of a ProCurve switch, from the lldpmed_civicloc.pcap
+// Wireshark sample capture
+var lldpFrame = []byte{
+	0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e, 0x00, 0x13, 0x21, 0x57, 0xca, 0x7f,
+	0x88, 0xcc, 0x02, 0x07, 0x04, 0x00, 0x13, 0x21, 0x57, 0xca, 0x40, 0x04,
+	0x02, 0x07, 0x31, 0x06, 0x02, 0x00, 0x78, 0x08, 0x01, 0x31, 0x0a, 0x1a,
+	0x50, 0x72, 0x6f, 0x43, 0x75, 0x72, 0x76, 0x65, 0x20, 0x53, 0x77, 0x69,
+	0x74, 0x63, 0x68, 0x20, 0x32, 0x36, 0x30, 0x30, 0x2d, 0x38, 0x2d, 0x50,
+	0x57, 0x52, 0x0c, 0x5f, 0x50, 0x72, 0x6f, 0x43, 0x75, 0x72, 0x76, 0x65,
+	0x20, 0x4a, 0x38, 0x37, 0x36, 0x32, 0x41, 0x20, 0x53, 0x77, 0x69, 0x74,
+	0x63, 0x68, 0x20, 0x32, 0x36, 0x30, 0x30, 0x2d, 0x38, 0x2d, 0x50, 0x57,
+	0x52, 0x2c, 0x20, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x20,
+	0x48, 0x2e, 0x30, 0x38, 0x2e, 0x38, 0x39, 0x2c, 0x20, 0x52, 0x4f, 0x4d,
+	0x20, 0x48, 0x2e, 0x30, 0x38, 0x2e, 0x35, 0x58, 0x20, 0x28, 0x2f, 0x73,
+	0x77, 0x2f, 0x63, 0x6f, 0x64, 0x65, 0x2f, 0x62, 0x75, 0x69, 0x6c, 0x64,
+	0x2f, 0x66, 0x69, 0x73, 0x68, 0x28, 0x74, 0x73, 0x5f, 0x30, 0x38, 0x5f,
+	0x35, 0x29, 0x29, 0x0e, 0x04, 0x00, 0x14, 0x00, 0x04, 0x10, 0x0c, 0x05,
+	0x01, 0x0f, 0xff, 0x7a, 0x94, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0xfe,
+	0x09, 0x00, 0x12, 0x0f, 0x01, 0x03, 0x6c, 0x00, 0x00, 0x10, 0xfe, 0x07,
+	0x00, 0x12, 0xbb, 0x01, 0x00, 0x0f, 0x04, 0xfe, 0x08, 0x00, 0x12, 0xbb,
+	0x02, 0x01, 0x40, 0x65, 0xae, 0xfe, 0x2e, 0x00, 0x12, 0xbb, 0x03, 0x02,
+	0x28, 0x02, 0x55, 0x53, 0x01, 0x02, 0x43, 0x41, 0x03, 0x09, 0x52, 0x6f,
+	0x73, 0x65, 0x76, 0x69, 0x6c, 0x6c, 0x65, 0x06, 0x09, 0x46, 0x6f, 0x6f,
+	0x74, 0x68, 0x69, 0x6c, 0x6c, 0x73, 0x13, 0x04, 0x38, 0x30, 0x30, 0x30,
+	0x1a, 0x03, 0x52, 0x33, 0x4c, 0xfe, 0x07, 0x00, 0x12, 0xbb, 0x04, 0x03,
+	0x00, 0x41, 0x00, 0x00,
+}
+
+// CDPv2 frame of a Catalyst switch, from the cdp_v2.pcap Wireshark sample
+// capture
+var cdpFrame = []byte{
+	0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc, 0x00, 0x0b, 0xbe, 0x18, 0x9a, 0x41,
+	0x01, 0xc3, 0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00, 0x02, 0xb4,
+	0x09, 0xa0, 0x00, 0x01, 0x00, 0x0c, 0x6d, 0x79, 0x73, 0x77, 0x69, 0x74,
+	0x63, 0x68, 0x00, 0x02, 0x00, 0x11, 0x00, 0x00, 0x00, 0x01, 0x01, 0x01,
+	0xcc, 0x00, 0x04, 0xc0, 0xa8, 0x00, 0xfd, 0x00, 0x03, 0x00, 0x13, 0x46,
+	0x61, 0x73, 0x74, 0x45, 0x74, 0x68, 0x65, 0x72, 0x6e, 0x65, 0x74, 0x30,
+	0x2f, 0x31, 0x00, 0x04, 0x00, 0x08, 0x00, 0x00, 0x00, 0x28, 0x00, 0x05,
+	0x01, 0x14, 0x43, 0x69, 0x73, 0x63, 0x6f, 0x20, 0x49, 0x6e, 0x74, 0x65,
+	0x72, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x20, 0x4f, 0x70, 0x65,
+	0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x20, 0x53, 0x79, 0x73, 0x74, 0x65,
+	0x6d, 0x20, 0x53, 0x6f, 0x66, 0x74, 0x77, 0x61, 0x72, 0x65, 0x20, 0x0a,
+	0x49, 0x4f, 0x53, 0x20, 0x28, 0x74, 0x6d, 0x29, 0x20, 0x43, 0x32, 0x39,
+	0x35, 0x30, 0x20, 0x53, 0x6f, 0x66, 0x74, 0x77, 0x61, 0x72, 0x65, 0x20,
+	0x28, 0x43, 0x32, 0x39, 0x35, 0x30, 0x2d, 0x49, 0x36, 0x4b, 0x32, 0x4c,
+	0x32, 0x51, 0x34, 0x2d, 0x4d, 0x29, 0x2c, 0x20, 0x56, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x20, 0x31, 0x32, 0x2e, 0x31, 0x28, 0x32, 0x32, 0x29,
+	0x45, 0x41, 0x31, 0x34, 0x2c, 0x20, 0x52, 0x45, 0x4c, 0x45, 0x41, 0x53,
+	0x45, 0x20, 0x53, 0x4f, 0x46, 0x54, 0x57, 0x41, 0x52, 0x45, 0x20, 0x28,
+	0x66, 0x63, 0x31, 0x29, 0x0a, 0x54, 0x65, 0x63, 0x68, 0x6e, 0x69, 0x63,
+	0x61, 0x6c, 0x20, 0x53, 0x75, 0x70, 0x70, 0x6f, 0x72, 0x74, 0x3a, 0x20,
+	0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x77, 0x77, 0x77, 0x2e, 0x63,
+	0x69, 0x73, 0x63, 0x6f, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x65, 0x63,
+	0x68, 0x73, 0x75, 0x70, 0x70, 0x6f, 0x72, 0x74, 0x0a, 0x43, 0x6f, 0x70,
+	0x79, 0x72, 0x69, 0x67, 0x68, 0x74, 0x20, 0x28, 0x63, 0x29, 0x20, 0x31,
+	0x39, 0x38, 0x36, 0x2d, 0x32, 0x30, 0x31, 0x30, 0x20, 0x62, 0x79, 0x20,
+	0x63, 0x69, 0x73, 0x63, 0x6f, 0x20, 0x53, 0x79, 0x73, 0x74, 0x65, 0x6d,
+	0x73, 0x2c, 0x20, 0x49, 0x6e, 0x63, 0x2e, 0x0a, 0x43, 0x6f, 0x6d, 0x70,
+	0x69, 0x6c, 0x65, 0x64, 0x20, 0x54, 0x75, 0x65, 0x20, 0x32, 0x36, 0x2d,
+	0x4f, 0x63, 0x74, 0x2d, 0x31, 0x30, 0x20, 0x31, 0x30, 0x3a, 0x33, 0x35,
+	0x20, 0x62, 0x79, 0x20, 0x6e, 0x62, 0x75, 0x72, 0x72, 0x61, 0x00, 0x06,
+	0x00, 0x15, 0x63, 0x69, 0x73, 0x63, 0x6f, 0x20, 0x57, 0x53, 0x2d, 0x43,
+	0x32, 0x39, 0x35, 0x30, 0x2d, 0x31, 0x32, 0x00, 0x08, 0x00, 0x24, 0x00,
+	0x00, 0x0c, 0x01, 0x12, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff,
+	0x01, 0x02, 0x20, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0b,
+	0xbe, 0x18, 0x9a, 0x40, 0xff, 0x00, 0x00, 0x00, 0x09, 0x00, 0x0c, 0x4d,
+	0x59, 0x44, 0x4f, 0x4d, 0x41, 0x49, 0x4e, 0x00, 0x0a, 0x00, 0x06, 0x00,
+	0x01, 0x00, 0x0b, 0x00, 0x05, 0x01, 0x00, 0x12, 0x00, 0x05, 0x00, 0x00,
+	0x13, 0x00, 0x05, 0x00, 0x00, 0x16, 0x00, 0x11, 0x00, 0x00, 0x00, 0x01,
+	0x01, 0x01, 0xcc, 0x00, 0x04, 0xc0, 0xa8, 0x00, 0xfd,
+}
+
+type fakeSource struct {
+	frames chan []byte
+	errors chan error
+}
+
+func (s *fakeSource) ReadFrame() ([]byte, error) {
+	select {
+	case data := <-s.frames:
+		return data, nil
+	case err := <-s.errors:
+		return nil, err
+	case <-time.After(10 * time.Millisecond):
+		return nil, nil
+	}
+}
+
+func (s *fakeSource) Close() {
+}
+
+func TestParseLLDPFrame(t *testing.T) {
+	n, err := ParseFrame(lldpFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Neighbor{
+		Protocol:        "lldp",
+		ChassisID:       "00:13:21:57:ca:40",
+		PortID:          "1",
+		PortDescription: "1",
+		SysName:         "ProCurve Switch 2600-8-PWR",
+		SysDescription:  "ProCurve J8762A Switch 2600-8-PWR, revision H.08.89, ROM H.08.5X (/sw/code/build/fish(ts_08_5))",
+		MgmtAddress:     "15.255.122.148",
+		TTL:             120 * time.Second,
+	}
+	if n == nil || *n != expected {
+		t.Errorf("Expected %+v, got %+v", expected, n)
+	}
+}
+
+func TestParseCDPFrame(t *testing.T) {
+	n, err := ParseFrame(cdpFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Neighbor{
+		Protocol:       "cdp",
+		ChassisID:      "myswitch",
+		PortID:         "FastEthernet0/1",
+		SysName:        "myswitch",
+		SysDescription: "cisco WS-C2950-12",
+		MgmtAddress:    "192.168.0.253",
+		TTL:            180 * time.Second,
+	}
+	if n == nil || *n != expected {
+		t.Errorf("Expected %+v, got %+v", expected, n)
+	}
+}
+
+func TestParseOtherFrame(t *testing.T) {
+	buffer := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{},
+		&layers.Ethernet{
+			SrcMAC:       []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			DstMAC:       []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			EthernetType: layers.EthernetTypeARP,
+		},
+		&layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			SourceProtAddress: []byte{192, 168, 0, 1},
+			DstHwAddress:      []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			DstProtAddress:    []byte{192, 168, 0, 2},
+		},
+	)
+
+	if n, err := ParseFrame(buffer.Bytes()); n != nil || err != nil {
+		t.Errorf("Expected no neighbor, got %+v, %v", n, err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	old := openFrameSource
+	defer func() { openFrameSource = old }()
+
+	source := &fakeSource{frames: make(chan []byte, 2)}
+	var opened []string
+	openFrameSource = func(ifName string) (frameSource, error) {
+		opened = append(opened, ifName)
+		return source, nil
+	}
+
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	g.Unlock()
+
+	probe := &Probe{
+		Graph:      g,
+		Root:       host,
+		interfaces: make(map[graph.Identifier]*ifProbe),
+	}
+	g.AddEventListener(probe)
+	NewSwitchProbe(g)
+
+	g.Lock()
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0", "Driver": "e1000e", "MAC": "00:01:02:03:04:05"})
+	topology.AddOwnershipLink(g, host, eth0, nil)
+	veth := g.NewNode(graph.GenID(), graph.Metadata{"Type": "veth", "Name": "veth0", "Driver": "veth", "MAC": "00:01:02:03:04:06"})
+	topology.AddOwnershipLink(g, host, veth, nil)
+	g.Unlock()
+
+	// only the physical interfaces are captured
+	if len(opened) != 1 || opened[0] != "eth0" {
+		t.Fatalf("Expected a capture on eth0, got %v", opened)
+	}
+
+	source.frames <- lldpFrame
+	source.frames <- cdpFrame
+
+	lookupPorts := func() []*graph.Node {
+		g.RLock()
+		defer g.RUnlock()
+		return g.LookupChildren(eth0, graph.Metadata{"Type": "switchport"}, nil)
+	}
+
+	var ports []*graph.Node
+	for i := 0; i < 100 && len(ports) != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		ports = lookupPorts()
+	}
+	if len(ports) != 2 {
+		t.Fatalf("Expected 2 switch ports, got %v", ports)
+	}
+
+	g.RLock()
+	for _, port := range ports {
+		chassisID, _ := port.GetFieldString("LLDP.ChassisID")
+		switches := g.LookupParents(port, graph.Metadata{"Type": "switch"}, nil)
+		if len(switches) != 1 {
+			t.Errorf("Expected a switch for %s, got %v", chassisID, switches)
+		} else if id, _ := switches[0].GetFieldString("LLDP.ChassisID"); id != chassisID {
+			t.Errorf("Expected the switch %s, got %s", chassisID, id)
+		}
+	}
+	g.RUnlock()
+
+	// the ports are removed once their TTL expired, along with their switches
+	probe.Lock()
+	intf := probe.interfaces[eth0.ID]
+	probe.Unlock()
+	intf.expire(time.Now().Add(150 * time.Second))
+
+	if ports := lookupPorts(); len(ports) != 1 {
+		t.Errorf("Expected the CDP port only, got %v", ports)
+	}
+
+	g.Lock()
+	g.DelNode(eth0)
+	g.Unlock()
+
+	g.RLock()
+	defer g.RUnlock()
+	if nodes := g.GetNodes(graph.Metadata{"Probe": "lldp"}); len(nodes) != 0 {
+		t.Errorf("Expected no switch nor switch port, got %v", nodes)
+	}
+}
+
+func TestProbeReadError(t *testing.T) {
+	old := openFrameSource
+	defer func() { openFrameSource = old }()
+
+	source := &fakeSource{frames: make(chan []byte, 1), errors: make(chan error, 1)}
+	openFrameSource = func(ifName string) (frameSource, error) {
+		return source, nil
+	}
+
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	g.Unlock()
+
+	probe := &Probe{
+		Graph:      g,
+		Root:       host,
+		interfaces: make(map[graph.Identifier]*ifProbe),
+	}
+	g.AddEventListener(probe)
+
+	g.Lock()
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0", "Driver": "e1000e", "MAC": "00:01:02:03:04:05"})
+	topology.AddOwnershipLink(g, host, eth0, nil)
+	g.Unlock()
+
+	source.frames <- lldpFrame
+
+	lookupPorts := func() []*graph.Node {
+		g.RLock()
+		defer g.RUnlock()
+		return g.LookupChildren(eth0, graph.Metadata{"Type": "switchport"}, nil)
+	}
+
+	for i := 0; i < 100 && len(lookupPorts()) != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ports := lookupPorts(); len(ports) != 1 {
+		t.Fatalf("Expected a switch port, got %v", ports)
+	}
+
+	// a failed capture unregisters the interface and removes its ports
+	source.errors <- errors.New("interface down")
+
+	registered := true
+	for i := 0; i < 100 && registered; i++ {
+		time.Sleep(10 * time.Millisecond)
+		probe.Lock()
+		_, registered = probe.interfaces[eth0.ID]
+		probe.Unlock()
+	}
+	if registered {
+		t.Fatal("Expected the interface to be unregistered")
+	}
+
+	if ports := lookupPorts(); len(ports) != 0 {
+		t.Errorf("Expected no switch port, got %v", ports)
+	}
+}
+
+func TestSwitchUpdate(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+	NewSwitchProbe(g)
+
+	g.Lock()
+	defer g.Unlock()
+
+	port := g.NewNode(graph.GenID(), graph.Metadata{
+		"Type":  "switchport",
+		"Probe": "lldp",
+		"LLDP":  map[string]interface{}{"ChassisID": "00:13:21:57:ca:40", "SysName": "switch1"},
+	})
+
+	g.SetMetadata(port, graph.Metadata{
+		"Type":  "switchport",
+		"Probe": "lldp",
+		"LLDP":  map[string]interface{}{"ChassisID": "00:13:21:57:ca:40", "SysName": "switch2", "MgmtAddress": "192.168.0.253"},
+	})
+
+	switches := g.LookupParents(port, graph.Metadata{"Type": "switch"}, nil)
+	if len(switches) != 1 {
+		t.Fatalf("Expected a switch, got %v", switches)
+	}
+
+	if name, _ := switches[0].GetFieldString("LLDP.SysName"); name != "switch2" {
+		t.Errorf("Expected the switch name to be updated, got %s", name)
+	}
+
+	if addr, _ := switches[0].GetFieldString("LLDP.MgmtAddress"); addr != "192.168.0.253" {
+		t.Errorf("Expected the switch management address to be updated, got %s", addr)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package lldp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Neighbor describes the switch port advertised by a LLDP or CDP frame
+type Neighbor struct {
+	Protocol        string
+	ChassisID       string
+	PortID          string
+	PortDescription string
+	SysName         string
+	SysDescription  string
+	MgmtAddress     string
+	TTL             time.Duration
+}
+
+// Metadata returns the metadata of the switch port node
+func (n *Neighbor) Metadata() graph.Metadata {
+	name := n.PortDescription
+	if name == "" {
+		name = n.PortID
+	}
+
+	lldp := map[string]interface{}{
+		"Protocol":  n.Protocol,
+		"ChassisID": n.ChassisID,
+		"PortID":    n.PortID,
+	}
+	for k, v := range map[string]string{
+		"PortDescription": n.PortDescription,
+		"SysName":         n.SysName,
+		"SysDescription":  n.SysDescription,
+		"MgmtAddress":     n.MgmtAddress,
+	} {
+		if v != "" {
+			lldp[k] = v
+		}
+	}
+
+	return graph.Metadata{
+		"Name":  name,
+		"Type":  "switchport",
+		"Probe": "lldp",
+		"LLDP":  lldp,
+	}
+}
+
+// formatAddress returns the string representation of a MAC or IP address,
+// other identifiers being returned as is
+func formatAddress(id []byte, mac bool, network bool) string {
+	switch {
+	case mac && len(id) == 6:
+		return net.HardwareAddr(id).String()
+	case network && (len(id) == net.IPv4len+1 || len(id) == net.IPv6len+1):
+		// the address is prefixed by its IANA address family
+		return net.IP(id[1:]).String()
+	}
+	return trimString(string(id))
+}
+
+// trimString removes the NUL terminators sent by some switches
+func trimString(s string) string {
+	return strings.TrimRight(s, "\x00")
+}
+
+func lldpNeighbor(lldp *layers.LinkLayerDiscovery, info *layers.LinkLayerDiscoveryInfo) (*Neighbor, error) {
+	if len(lldp.ChassisID.ID) == 0 || len(lldp.PortID.ID) == 0 {
+		return nil, errors.New("LLDP frame without chassis or port ID")
+	}
+
+	n := &Neighbor{
+		Protocol:  "lldp",
+		ChassisID: formatAddress(lldp.ChassisID.ID, lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeMACAddr, lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeNetworkAddr),
+		PortID:    formatAddress(lldp.PortID.ID, lldp.PortID.Subtype == layers.LLDPPortIDSubtypeMACAddr, lldp.PortID.Subtype == layers.LLDPPortIDSubtypeNetworkAddr),
+		TTL:       time.Duration(lldp.TTL) * time.Second,
+	}
+
+	if info != nil {
+		n.PortDescription = trimString(info.PortDescription)
+		n.SysName = trimString(info.SysName)
+		n.SysDescription = trimString(info.SysDescription)
+
+		switch addr := info.MgmtAddress; addr.Subtype {
+		case layers.IANAAddressFamilyIPV4, layers.IANAAddressFamilyIPV6:
+			n.MgmtAddress = net.IP(addr.Address).String()
+		}
+	}
+
+	return n, nil
+}
+
+func cdpNeighbor(cdp *layers.CiscoDiscovery, info *layers.CiscoDiscoveryInfo) (*Neighbor, error) {
+	if info.DeviceID == "" || info.PortID == "" {
+		return nil, errors.New("CDP frame without device or port ID")
+	}
+
+	n := &Neighbor{
+		Protocol:       "cdp",
+		ChassisID:      trimString(info.DeviceID),
+		PortID:         trimString(info.PortID),
+		SysName:        trimString(info.SysName),
+		SysDescription: trimString(info.Platform),
+		TTL:            time.Duration(cdp.TTL) * time.Second,
+	}
+
+	if n.SysName == "" {
+		n.SysName = n.ChassisID
+	}
+
+	if len(info.MgmtAddresses) > 0 {
+		n.MgmtAddress = info.MgmtAddresses[0].String()
+	} else if len(info.Addresses) > 0 {
+		n.MgmtAddress = info.Addresses[0].String()
+	}
+
+	return n, nil
+}
+
+// ParseFrame returns the neighbor advertised by an ethernet frame, nil if
+// the frame is neither a LLDP nor a CDP frame
+func ParseFrame(data []byte) (*Neighbor, error) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.DecodeOptions{NoCopy: true})
+
+	if lldp, ok := packet.Layer(layers.LayerTypeLinkLayerDiscovery).(*layers.LinkLayerDiscovery); ok {
+		info, _ := packet.Layer(layers.LayerTypeLinkLayerDiscoveryInfo).(*layers.LinkLayerDiscoveryInfo)
+		return lldpNeighbor(lldp, info)
+	}
+
+	if cdp, ok := packet.Layer(layers.LayerTypeCiscoDiscovery).(*layers.CiscoDiscovery); ok {
+		if info, ok := packet.Layer(layers.LayerTypeCiscoDiscoveryInfo).(*layers.CiscoDiscoveryInfo); ok {
+			return cdpNeighbor(cdp, info)
+		}
+	}
+
+	if layer := packet.ErrorLayer(); layer != nil {
+		return nil, layer.Error()
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package lldp
+
+import (
+	"reflect"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// SwitchProbe describes an analyzer probe grouping the switch ports reported
+// by the agents LLDP probes under a switch node per chassis ID, the switches
+// shared by several hosts thus building the physical fabric
+type SwitchProbe struct {
+	graph.DefaultGraphListener
+	graph *graph.Graph
+	ports map[graph.Identifier]graph.Identifier
+}
+
+func switchID(chassisID string) graph.Identifier {
+	u, _ := uuid.NewV5(uuid.NamespaceOID, []byte("lldpswitch"+chassisID))
+	return graph.Identifier(u.String())
+}
+
+func switchMetadata(port *graph.Node, chassisID string) graph.Metadata {
+	name, _ := port.GetFieldString("LLDP.SysName")
+	if name == "" {
+		name = chassisID
+	}
+
+	lldp := map[string]interface{}{"ChassisID": chassisID}
+	for _, k := range []string{"SysName", "SysDescription", "MgmtAddress"} {
+		if v, _ := port.GetFieldString("LLDP." + k); v != "" {
+			lldp[k] = v
+		}
+	}
+
+	return graph.Metadata{
+		"Name":  name,
+		"Type":  "switch",
+		"Probe": "lldp",
+		"LLDP":  lldp,
+	}
+}
+
+func (p *SwitchProbe) onNodeEvent(n *graph.Node) {
+	if tp, _ := n.GetFieldString("Type"); tp != "switchport" {
+		return
+	}
+	if probe, _ := n.GetFieldString("Probe"); probe != "lldp" {
+		return
+	}
+	chassisID, _ := n.GetFieldString("LLDP.ChassisID")
+	if chassisID == "" {
+		return
+	}
+
+	id := switchID(chassisID)
+	if previous, ok := p.ports[n.ID]; ok && previous != id {
+		p.release(n.ID)
+	}
+
+	// the switch reflects the last advertisement of its ports
+	metadata := switchMetadata(n, chassisID)
+	sw := p.graph.GetNode(id)
+	if sw == nil {
+		sw = p.graph.NewNode(id, metadata, "")
+	} else if !reflect.DeepEqual(sw.Metadata(), metadata) {
+		p.graph.SetMetadata(sw, metadata)
+	}
+
+	if !topology.HaveOwnershipLink(p.graph, sw, n, nil) {
+		topology.AddOwnershipLink(p.graph, sw, n, nil)
+	}
+	p.ports[n.ID] = id
+}
+
+// release removes the switch of a port once it has no more ports
+func (p *SwitchProbe) release(portID graph.Identifier) {
+	id, ok := p.ports[portID]
+	if !ok {
+		return
+	}
+	delete(p.ports, portID)
+
+	for _, other := range p.ports {
+		if other == id {
+			return
+		}
+	}
+	if sw := p.graph.GetNode(id); sw != nil {
+		p.graph.DelNode(sw)
+	}
+}
+
+// OnNodeAdded event
+func (p *SwitchProbe) OnNodeAdded(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeUpdated event
+func (p *SwitchProbe) OnNodeUpdated(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeDeleted event
+func (p *SwitchProbe) OnNodeDeleted(n *graph.Node) {
+	p.release(n.ID)
+}
+
+// Start the probe
+func (p *SwitchProbe) Start() {
+}
+
+// Stop the probe
+func (p *SwitchProbe) Stop() {
+	p.graph.RemoveEventListener(p)
+}
+
+// NewSwitchProbe creates a new probe grouping the switch ports by switch
+func NewSwitchProbe(g *graph.Graph) *SwitchProbe {
+	probe := &SwitchProbe{
+		graph: g,
+		ports: make(map[graph.Identifier]graph.Identifier),
+	}
+	g.AddEventListener(probe)
+
+	return probe
+}
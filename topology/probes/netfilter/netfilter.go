@@ -0,0 +1,398 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netfilter
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// delay between a ruleset change notification and the read of the ruleset
+const rulesetSettleDelay = 500 * time.Millisecond
+
+// Probe describes a probe reporting the iptables and nftables rulesets of
+// the network namespaces as chain and rule nodes
+type Probe struct {
+	sync.Mutex
+	graph.DefaultGraphListener
+	Graph      *graph.Graph
+	Root       *graph.Node
+	Tables     []string
+	Interval   time.Duration
+	commands   map[string]bool
+	namespaces map[graph.Identifier]*nsProbe
+}
+
+// nsProbe reports the rulesets of a network namespace. The chain and rule
+// nodes are protected by the graph lock.
+type nsProbe struct {
+	probe  *Probe
+	root   *graph.Node
+	path   string
+	chains map[string]*graph.Node
+	rules  map[string]*graph.Node
+	quit   chan struct{}
+}
+
+// runCommand executes a command within a network namespace, can be
+// overridden for tests
+var runCommand = func(nsPath string, command string, args ...string) ([]byte, error) {
+	if nsPath != "" {
+		args = append([]string{"--net=" + nsPath, command}, args...)
+		command = "nsenter"
+	}
+
+	/* #nosec */
+	return exec.Command(command, args...).Output()
+}
+
+// availableCommands returns the commands reading the rulesets found on the
+// host, the commands being executed in the network namespaces only
+func availableCommands() map[string]bool {
+	commands := make(map[string]bool)
+	for _, command := range []string{"iptables-save", "ip6tables-save", "nft"} {
+		if _, err := exec.LookPath(command); err == nil {
+			commands[command] = true
+		}
+	}
+	return commands
+}
+
+// readRuleset returns the chains of a network namespace using the available
+// commands
+func readRuleset(nsPath string, tables []string, commands map[string]bool) ([]*Chain, error) {
+	var chains []*Chain
+	var lastErr error
+	var found bool
+
+	for _, family := range []struct{ name, command string }{{"ipv4", "iptables-save"}, {"ipv6", "ip6tables-save"}} {
+		if !commands[family.command] {
+			continue
+		}
+
+		output, err := runCommand(nsPath, family.command, "-c")
+		if err != nil {
+			lastErr = fmt.Errorf("Unable to run %s: %s", family.command, err.Error())
+			continue
+		}
+		chains = append(chains, parseIptablesSave(string(output), family.name, tables)...)
+		found = true
+	}
+
+	if commands["nft"] {
+		if output, err := runCommand(nsPath, "nft", "-a", "list", "ruleset"); err == nil {
+			chains = append(chains, parseNftRuleset(string(output))...)
+			found = true
+		} else {
+			lastErr = fmt.Errorf("Unable to run nft: %s", err.Error())
+		}
+	}
+
+	if !found {
+		if lastErr == nil {
+			lastErr = errors.New("No iptables-save, ip6tables-save or nft command found")
+		}
+		return nil, lastErr
+	}
+	return chains, nil
+}
+
+func chainKey(chain *Chain) string {
+	return chain.Backend + "/" + chain.Family + "/" + chain.Table + "/" + chain.Name
+}
+
+// setMetric records the counters as metric of a node
+func setMetric(g *graph.Graph, n *graph.Node, packets, bytes int64, last, now time.Time) {
+	tr := g.StartMetadataTransaction(n)
+	if _, changed := topology.UpdateRuleMetric(tr, &topology.OfRuleMetric{Packets: packets, Bytes: bytes}, last, now); changed {
+		tr.Commit()
+	}
+}
+
+// sync updates the chain and rule nodes according to the ruleset. Rules are
+// identified by their specification and by their occurrence among the rules
+// of the chain having the same one, so that inserting or removing a rule
+// only updates the position of the following ones.
+func (p *nsProbe) sync(chains []*Chain, last, now time.Time) {
+	g := p.probe.Graph
+	g.Lock()
+	defer g.Unlock()
+
+	// the namespace has been removed in the meantime
+	if g.GetNode(p.root.ID) == nil {
+		return
+	}
+
+	chainNodes := make(map[string]*graph.Node)
+	ruleNodes := make(map[string]*graph.Node)
+
+	for _, chain := range chains {
+		key := chainKey(chain)
+
+		chainNode, ok := p.chains[key]
+		if !ok {
+			metadata := graph.Metadata{
+				"Type":    "fwchain",
+				"Name":    chain.Name,
+				"Backend": chain.Backend,
+				"Family":  chain.Family,
+				"Table":   chain.Table,
+			}
+			if chain.Hook != "" {
+				metadata["Hook"] = chain.Hook
+			}
+			chainNode = g.NewNode(graph.GenID(), metadata)
+			topology.AddOwnershipLink(g, p.root, chainNode, nil)
+		}
+		chainNodes[key] = chainNode
+
+		if policy, _ := chainNode.GetFieldString("Policy"); policy != chain.Policy {
+			if chain.Policy == "" {
+				g.DelMetadata(chainNode, "Policy")
+			} else {
+				g.AddMetadata(chainNode, "Policy", chain.Policy)
+			}
+		}
+
+		if chain.Backend == "iptables" && chain.Policy != "" {
+			setMetric(g, chainNode, chain.Packets, chain.Bytes, last, now)
+		}
+
+		occurrences := make(map[string]int)
+		for _, rule := range chain.Rules {
+			ruleKey := fmt.Sprintf("%s/%s/%d", key, rule.Spec, occurrences[rule.Spec])
+			occurrences[rule.Spec]++
+
+			ruleNode, ok := p.rules[ruleKey]
+			if !ok {
+				metadata := graph.Metadata{
+					"Type":     "fwrule",
+					"Name":     rule.Spec,
+					"Chain":    chain.Name,
+					"Position": rule.Position,
+				}
+				if rule.Target != "" {
+					metadata["Target"] = rule.Target
+				}
+				ruleNode = g.NewNode(graph.GenID(), metadata)
+				topology.AddOwnershipLink(g, chainNode, ruleNode, nil)
+			} else if position, _ := ruleNode.GetFieldInt64("Position"); position != rule.Position {
+				g.AddMetadata(ruleNode, "Position", rule.Position)
+			}
+			ruleNodes[ruleKey] = ruleNode
+
+			setMetric(g, ruleNode, rule.Packets, rule.Bytes, last, now)
+		}
+	}
+
+	for key, node := range p.rules {
+		if _, ok := ruleNodes[key]; !ok {
+			g.DelNode(node)
+		}
+	}
+
+	for key, node := range p.chains {
+		if _, ok := chainNodes[key]; !ok {
+			g.DelNode(node)
+		}
+	}
+
+	p.chains, p.rules = chainNodes, ruleNodes
+}
+
+// clean removes the chain and rule nodes, the graph lock has to be held
+func (p *nsProbe) clean() {
+	g := p.probe.Graph
+	for _, node := range p.rules {
+		if g.GetNode(node.ID) != nil {
+			g.DelNode(node)
+		}
+	}
+	for _, node := range p.chains {
+		if g.GetNode(node.ID) != nil {
+			g.DelNode(node)
+		}
+	}
+	p.chains = make(map[string]*graph.Node)
+	p.rules = make(map[string]*graph.Node)
+}
+
+func (p *nsProbe) update(last, now time.Time) {
+	chains, err := readRuleset(p.path, p.probe.Tables, p.probe.commands)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to read the firewall rules of %s: %s", p.root.ID, err.Error())
+		return
+	}
+	p.sync(chains, last, now)
+}
+
+// run reads the rulesets each time a change is notified, which is the case
+// for nftables and iptables-nft, and periodically to refresh the counters
+// and to report the changes of the legacy iptables
+func (p *nsProbe) run() {
+	ticker := time.NewTicker(p.probe.Interval)
+	defer ticker.Stop()
+
+	changed := make(chan struct{}, 1)
+	if err := watchRuleset(p.path, changed, p.quit); err != nil {
+		logging.GetLogger().Debugf("Unable to watch the ruleset changes of %s: %s", p.root.ID, err.Error())
+	}
+
+	last := time.Now().UTC()
+	p.update(last, last)
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-changed:
+			// wait for the whole change to be notified
+			if settle == nil {
+				settle = time.After(rulesetSettleDelay)
+			}
+			continue
+		case <-settle:
+			settle = nil
+		case <-p.quit:
+			return
+		}
+
+		now := time.Now().UTC()
+		p.update(last, now)
+		last = now
+	}
+}
+
+func (p *Probe) register(n *graph.Node, path string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.namespaces[n.ID]; ok {
+		return
+	}
+
+	ns := &nsProbe{
+		probe:  p,
+		root:   n,
+		path:   path,
+		chains: make(map[string]*graph.Node),
+		rules:  make(map[string]*graph.Node),
+		quit:   make(chan struct{}),
+	}
+	p.namespaces[n.ID] = ns
+
+	go ns.run()
+}
+
+func (p *Probe) unregister(id graph.Identifier) *nsProbe {
+	p.Lock()
+	defer p.Unlock()
+
+	ns, ok := p.namespaces[id]
+	if ok {
+		close(ns.quit)
+		delete(p.namespaces, id)
+	}
+	return ns
+}
+
+func (p *Probe) isNamespace(n *graph.Node) (string, bool) {
+	if tp, _ := n.GetFieldString("Type"); tp != "netns" || n.Host() != p.Root.Host() {
+		return "", false
+	}
+	path, err := n.GetFieldString("Path")
+	return path, err == nil && path != ""
+}
+
+// OnNodeAdded event
+func (p *Probe) OnNodeAdded(n *graph.Node) {
+	if path, ok := p.isNamespace(n); ok {
+		p.register(n, path)
+	}
+}
+
+// OnNodeDeleted event
+func (p *Probe) OnNodeDeleted(n *graph.Node) {
+	if ns := p.unregister(n.ID); ns != nil {
+		ns.clean()
+	}
+}
+
+// Start the probe
+func (p *Probe) Start() {
+	p.Graph.RLock()
+	namespaces := p.Graph.GetNodes(graph.Metadata{"Type": "netns"})
+	p.Graph.RUnlock()
+
+	p.register(p.Root, "")
+	for _, n := range namespaces {
+		if path, ok := p.isNamespace(n); ok {
+			p.register(n, path)
+		}
+	}
+}
+
+// Stop the probe
+func (p *Probe) Stop() {
+	p.Graph.RemoveEventListener(p)
+
+	p.Lock()
+	defer p.Unlock()
+
+	for id, ns := range p.namespaces {
+		close(ns.quit)
+		delete(p.namespaces, id)
+	}
+}
+
+// NewProbe creates a new firewall rules probe
+func NewProbe(g *graph.Graph, root *graph.Node) (*Probe, error) {
+	update := config.GetConfig().GetInt("agent.topology.netfilter.update")
+	if update <= 0 {
+		return nil, fmt.Errorf("Invalid netfilter update interval %d, it has to be a positive number of seconds", update)
+	}
+
+	probe := &Probe{
+		Graph:      g,
+		Root:       root,
+		Tables:     config.GetConfig().GetStringSlice("agent.topology.netfilter.tables"),
+		Interval:   time.Duration(update) * time.Second,
+		commands:   availableCommands(),
+		namespaces: make(map[graph.Identifier]*nsProbe),
+	}
+	g.AddEventListener(probe)
+
+	if len(probe.commands) == 0 {
+		logging.GetLogger().Warning("No iptables-save, ip6tables-save or nft command found, no firewall rule will be reported")
+	}
+
+	return probe, nil
+}
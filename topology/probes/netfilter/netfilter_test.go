@@ -0,0 +1,319 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netfilter
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const iptablesSave = `# Generated by iptables-save v1.6.1
+*nat
+:PREROUTING ACCEPT [12:720]
+:POSTROUTING ACCEPT [3:180]
+[2:120] -A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE
+COMMIT
+*raw
+:PREROUTING ACCEPT [100:6000]
+[0:0] -A PREROUTING -j CT --notrack
+COMMIT
+*filter
+:INPUT DROP [10:600]
+:FORWARD ACCEPT [0:0]
+:DOCKER - [0:0]
+[50:3000] -A INPUT -i lo -j ACCEPT
+[7:420] -A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+[0:0] -A FORWARD -o docker0 -j DOCKER
+COMMIT
+`
+
+const nftRuleset = `table inet filter { # handle 1
+	set blacklist { # handle 3
+		type ipv4_addr
+		elements = { 10.0.0.1,
+			     10.0.0.2 }
+	}
+
+	chain input { # handle 1
+		type filter hook input priority 0; policy drop;
+		iif "lo" accept # handle 4
+		tcp dport 22 counter packets 7 bytes 420 accept # handle 5
+		ip saddr @blacklist drop # handle 6
+	}
+
+	chain forward { # handle 2
+		type filter hook forward priority 0; policy accept;
+		jump custom # handle 7
+	}
+
+	chain custom { # handle 8
+	}
+}
+table ip nat { # handle 2
+	chain postrouting { # handle 1
+		type nat hook postrouting priority 100; policy accept;
+		oifname "eth0" counter packets 2 bytes 120 masquerade # handle 2
+	}
+}
+`
+
+func TestParseIptablesSave(t *testing.T) {
+	chains := parseIptablesSave(iptablesSave, "ipv4", []string{"filter", "nat"})
+	if len(chains) != 5 {
+		t.Fatalf("Expected 5 chains, got %d", len(chains))
+	}
+
+	nat := chains[1]
+	if nat.Table != "nat" || nat.Name != "POSTROUTING" || nat.Policy != "ACCEPT" || nat.Packets != 3 || nat.Bytes != 180 {
+		t.Errorf("Bad chain %+v", nat)
+	}
+	if len(nat.Rules) != 1 || nat.Rules[0].Target != "MASQUERADE" || nat.Rules[0].Spec != "-s 172.17.0.0/16 ! -o docker0 -j MASQUERADE" {
+		t.Errorf("Bad rules %+v", nat.Rules)
+	}
+
+	input := chains[2]
+	if input.Name != "INPUT" || input.Policy != "DROP" || len(input.Rules) != 2 {
+		t.Fatalf("Bad chain %+v", input)
+	}
+	if rule := input.Rules[1]; rule.Position != 2 || rule.Packets != 7 || rule.Bytes != 420 || rule.Target != "ACCEPT" {
+		t.Errorf("Bad rule %+v", rule)
+	}
+
+	if docker := chains[4]; docker.Name != "DOCKER" || docker.Policy != "" {
+		t.Errorf("User chains have no policy %+v", docker)
+	}
+}
+
+func TestParseNftRuleset(t *testing.T) {
+	chains := parseNftRuleset(nftRuleset)
+	if len(chains) != 4 {
+		t.Fatalf("Expected 4 chains, got %d", len(chains))
+	}
+
+	input := chains[0]
+	if input.Backend != "nftables" || input.Family != "inet" || input.Table != "filter" || input.Hook != "input" || input.Policy != "drop" {
+		t.Errorf("Bad chain %+v", input)
+	}
+	if len(input.Rules) != 3 {
+		t.Fatalf("Bad rules %+v", input.Rules)
+	}
+	if rule := input.Rules[1]; rule.Spec != "tcp dport 22 counter accept" || rule.Packets != 7 || rule.Bytes != 420 || rule.Target != "accept" {
+		t.Errorf("Bad rule %+v", rule)
+	}
+
+	if rule := chains[1].Rules[0]; rule.Target != "jump custom" {
+		t.Errorf("Bad jump rule %+v", rule)
+	}
+
+	if custom := chains[2]; custom.Name != "custom" || custom.Hook != "" || len(custom.Rules) != 0 {
+		t.Errorf("Bad chain %+v", custom)
+	}
+
+	if nat := chains[3]; nat.Family != "ip" || nat.Table != "nat" || nat.Rules[0].Target != "masquerade" || nat.Rules[0].Packets != 2 {
+		t.Errorf("Bad chain %+v", nat)
+	}
+}
+
+func TestSync(t *testing.T) {
+	old := runCommand
+	defer func() { runCommand = old }()
+
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	g.Unlock()
+
+	probe := &Probe{
+		Graph:      g,
+		Root:       host,
+		Tables:     []string{"filter"},
+		Interval:   time.Hour,
+		commands:   map[string]bool{"iptables-save": true, "nft": true},
+		namespaces: make(map[graph.Identifier]*nsProbe),
+	}
+	g.AddEventListener(probe)
+
+	var lock sync.Mutex
+	var paths []string
+	ruleset := iptablesSave
+	runCommand = func(nsPath string, command string, args ...string) ([]byte, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if command != "iptables-save" {
+			return nil, errors.New("not found")
+		}
+		paths = append(paths, nsPath)
+		return []byte(ruleset), nil
+	}
+
+	g.Lock()
+	ns := g.NewNode(graph.GenID(), graph.Metadata{"Type": "netns", "Name": "ns", "Path": "/var/run/netns/ns"})
+	topology.AddOwnershipLink(g, host, ns, nil)
+	g.Unlock()
+
+	// the probe is started as soon as the namespace is added
+	probe.Lock()
+	nsp := probe.namespaces[ns.ID]
+	probe.Unlock()
+	if nsp == nil {
+		t.Fatal("Namespace not registered")
+	}
+
+	lookupRules := func() map[string]*graph.Node {
+		g.RLock()
+		defer g.RUnlock()
+
+		rules := make(map[string]*graph.Node)
+		for _, chain := range g.LookupChildren(ns, graph.Metadata{"Type": "fwchain"}, nil) {
+			for _, rule := range g.LookupChildren(chain, graph.Metadata{"Type": "fwrule"}, nil) {
+				name, _ := rule.GetFieldString("Name")
+				rules[name] = rule
+			}
+		}
+		return rules
+	}
+
+	var rules map[string]*graph.Node
+	for i := 0; i < 50; i++ {
+		if rules = lookupRules(); len(rules) == 3 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %v", rules)
+	}
+
+	lock.Lock()
+	if len(paths) == 0 || paths[len(paths)-1] != "/var/run/netns/ns" {
+		t.Errorf("Commands should be executed in the namespace: %v", paths)
+	}
+
+	ssh := rules["-p tcp -m tcp --dport 22 -j ACCEPT"]
+	if ssh == nil {
+		t.Fatal("Rule not found")
+	}
+
+	ruleset = `*filter
+:INPUT DROP [20:1200]
+[60:3600] -A INPUT -i lo -j ACCEPT
+[9:540] -A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+COMMIT
+`
+	lock.Unlock()
+
+	start := time.Now()
+	now := start.Add(10 * time.Second)
+	nsp.update(start, now)
+
+	rules = lookupRules()
+	if len(rules) != 2 || rules["-p tcp -m tcp --dport 22 -j ACCEPT"] != ssh {
+		t.Errorf("Unchanged rules should be kept %v", rules)
+	}
+
+	g.RLock()
+	m, _ := ssh.GetField("LastUpdateMetric")
+	metric, ok := m.(*topology.OfRuleMetric)
+	if !ok || metric.Packets != 2 || metric.Bytes != 120 || metric.Last != int64(common.UnixMillis(now)) {
+		t.Errorf("Bad last update metric %+v", m)
+	}
+	if chains := g.LookupChildren(ns, graph.Metadata{"Type": "fwchain"}, nil); len(chains) != 1 {
+		t.Errorf("Removed chains should be deleted %v", chains)
+	}
+	g.RUnlock()
+
+	// inserting a rule only changes the position of the following ones
+	lock.Lock()
+	ruleset = `*filter
+:INPUT DROP [20:1200]
+[0:0] -A INPUT -s 10.0.0.1/32 -j DROP
+[60:3600] -A INPUT -i lo -j ACCEPT
+[9:540] -A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+COMMIT
+`
+	lock.Unlock()
+
+	nsp.update(now, now.Add(10*time.Second))
+
+	rules = lookupRules()
+	if len(rules) != 3 || rules["-p tcp -m tcp --dport 22 -j ACCEPT"] != ssh {
+		t.Errorf("Rules after the inserted one should be kept %v", rules)
+	}
+
+	g.RLock()
+	if position, _ := ssh.GetFieldInt64("Position"); position != 3 {
+		t.Errorf("Bad rule position %d", position)
+	}
+	g.RUnlock()
+
+	// chains and rules are removed along with the namespace
+	g.Lock()
+	g.DelNode(ns)
+	g.Unlock()
+
+	g.RLock()
+	if nodes := g.GetNodes(graph.Metadata{"Type": "fwrule"}); len(nodes) != 0 {
+		t.Errorf("Rules should be deleted with the namespace %v", nodes)
+	}
+	g.RUnlock()
+
+	probe.Lock()
+	if _, ok := probe.namespaces[ns.ID]; ok {
+		t.Error("Namespace should be unregistered")
+	}
+	probe.Unlock()
+}
+
+func TestNewProbeInterval(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	cfg := config.GetConfig()
+	defer cfg.Set("agent.topology.netfilter.update", cfg.GetInt("agent.topology.netfilter.update"))
+
+	for _, update := range []int{0, -1} {
+		cfg.Set("agent.topology.netfilter.update", update)
+		if _, err := NewProbe(g, nil); err == nil {
+			t.Errorf("Expected an error for an update interval of %d", update)
+		}
+	}
+
+	cfg.Set("agent.topology.netfilter.update", 10)
+	probe, err := NewProbe(g, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.Interval != 10*time.Second {
+		t.Errorf("Expected an interval of 10s, got %s", probe.Interval)
+	}
+}
@@ -0,0 +1,109 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netfilter
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// constant coming from include/uapi/linux/netfilter/nfnetlink.h
+const nfnlgrpNftables = 7
+
+const notifyRecvTimeout = time.Second
+
+// watchRuleset notifies the changes of the nftables rulesets of a network
+// namespace, including the ones done through iptables-nft, until quit is
+// closed. A change is usually notified as several messages.
+func watchRuleset(nsPath string, changed chan<- struct{}, quit <-chan struct{}) error {
+	fd, err := openNftablesSocket(nsPath)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 65536)
+		for {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+
+			if _, _, err := syscall.Recvfrom(fd, buf, 0); err != nil {
+				switch err {
+				case syscall.EAGAIN, syscall.EINTR:
+					continue
+				case syscall.ENOBUFS:
+					// messages lost, the ruleset has changed anyway
+				default:
+					logging.GetLogger().Errorf("Failed to receive nftables events of %s: %s", nsPath, err)
+					return
+				}
+			}
+
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return nil
+}
+
+func openNftablesSocket(nsPath string) (int, error) {
+	if nsPath != "" {
+		// closing a nil context still releases the locked OS thread
+		context, err := common.NewNetNsContext(nsPath)
+		defer context.Close()
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return -1, err
+	}
+
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1 << (nfnlgrpNftables - 1)}); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	tv := syscall.NsecToTimeval(int64(notifyRecvTimeout))
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netfilter
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule is a rule of a chain along with its counters
+type Rule struct {
+	Position int64
+	Spec     string
+	Target   string
+	Packets  int64
+	Bytes    int64
+}
+
+// Chain is a chain of a table, the counters being the ones of the policy
+// of the builtin iptables chains
+type Chain struct {
+	Backend string
+	Family  string
+	Table   string
+	Name    string
+	Hook    string
+	Policy  string
+	Packets int64
+	Bytes   int64
+	Rules   []*Rule
+}
+
+var (
+	countersRegexp   = regexp.MustCompile(`^\[(\d+):(\d+)\]$`)
+	nftCounterRegexp = regexp.MustCompile(`counter packets (\d+) bytes (\d+)\s*`)
+	nftHandleRegexp  = regexp.MustCompile(`\s*# handle \d+$`)
+	nftHookRegexp    = regexp.MustCompile(`hook (\S+)`)
+	nftPolicyRegexp  = regexp.MustCompile(`policy (\S+);`)
+
+	nftVerdicts = map[string]bool{
+		"accept": true, "drop": true, "reject": true, "return": true,
+		"queue": true, "continue": true, "jump": true, "goto": true,
+		"masquerade": true, "snat": true, "dnat": true, "redirect": true,
+	}
+)
+
+func parseCounters(s string) (int64, int64, bool) {
+	m := countersRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, false
+	}
+	packets, _ := strconv.ParseInt(m[1], 10, 64)
+	bytes, _ := strconv.ParseInt(m[2], 10, 64)
+	return packets, bytes, true
+}
+
+// iptablesTarget returns the target of a rule, a chain or an extension
+func iptablesTarget(args []string) string {
+	for i, arg := range args {
+		if (arg == "-j" || arg == "-g" || arg == "--jump" || arg == "--goto") && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// parseIptablesSave parses the output of iptables-save -c, keeping only the
+// given tables
+func parseIptablesSave(output string, family string, tables []string) []*Chain {
+	wanted := make(map[string]bool)
+	for _, table := range tables {
+		wanted[table] = true
+	}
+
+	var chains []*Chain
+	var table string
+	index := make(map[string]*Chain)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line == "COMMIT" {
+			continue
+		}
+
+		switch line[0] {
+		case '*':
+			table = line[1:]
+			index = make(map[string]*Chain)
+			continue
+		case ':':
+			if !wanted[table] {
+				continue
+			}
+
+			fields := strings.Fields(line[1:])
+			chain := &Chain{Backend: "iptables", Family: family, Table: table, Name: fields[0]}
+			if len(fields) > 1 && fields[1] != "-" {
+				chain.Policy = fields[1]
+			}
+			if len(fields) > 2 {
+				chain.Packets, chain.Bytes, _ = parseCounters(fields[2])
+			}
+			index[chain.Name] = chain
+			chains = append(chains, chain)
+			continue
+		}
+
+		if !wanted[table] {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		rule := &Rule{}
+		if packets, bytes, ok := parseCounters(fields[0]); ok {
+			rule.Packets, rule.Bytes = packets, bytes
+			fields = fields[1:]
+		}
+
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+
+		chain, ok := index[fields[1]]
+		if !ok {
+			continue
+		}
+
+		rule.Spec = strings.Join(fields[2:], " ")
+		rule.Target = iptablesTarget(fields[2:])
+		rule.Position = int64(len(chain.Rules) + 1)
+		chain.Rules = append(chain.Rules, rule)
+	}
+
+	return chains
+}
+
+// nftTarget returns the verdict or the NAT statement of a rule
+func nftTarget(spec string) string {
+	fields := strings.Fields(spec)
+	for i := len(fields) - 1; i >= 0; i-- {
+		if nftVerdicts[fields[i]] {
+			if (fields[i] == "jump" || fields[i] == "goto") && i+1 < len(fields) {
+				return fields[i] + " " + fields[i+1]
+			}
+			return fields[i]
+		}
+	}
+	return ""
+}
+
+// parseNftRuleset parses the output of nft -a list ruleset. Sets, maps and
+// other objects of the tables are ignored.
+func parseNftRuleset(output string) []*Chain {
+	var chains []*Chain
+	var family, table string
+	var chain *Chain
+	depth, skip := 0, 0
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(nftHandleRegexp.ReplaceAllString(scanner.Text(), ""))
+		if line == "" {
+			continue
+		}
+
+		if skip > 0 {
+			skip += strings.Count(line, "{") - strings.Count(line, "}")
+			continue
+		}
+
+		if line == "}" {
+			if depth == 2 {
+				chain = nil
+			}
+			depth--
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case depth == 0 && fields[0] == "table" && len(fields) >= 3:
+			if len(fields) >= 4 {
+				family, table = fields[1], fields[2]
+			} else {
+				family, table = "ip", fields[1]
+			}
+			depth++
+		case depth == 1 && fields[0] == "chain" && len(fields) >= 2:
+			chain = &Chain{Backend: "nftables", Family: family, Table: table, Name: fields[1]}
+			chains = append(chains, chain)
+			depth++
+		case strings.HasSuffix(line, "{"):
+			skip = 1
+		case depth == 2 && chain != nil:
+			if fields[0] == "type" && strings.Contains(line, " hook ") {
+				if m := nftHookRegexp.FindStringSubmatch(line); m != nil {
+					chain.Hook = m[1]
+				}
+				if m := nftPolicyRegexp.FindStringSubmatch(line); m != nil {
+					chain.Policy = m[1]
+				}
+				continue
+			}
+
+			rule := &Rule{Position: int64(len(chain.Rules) + 1)}
+			spec := line
+			if m := nftCounterRegexp.FindStringSubmatch(spec); m != nil {
+				rule.Packets, _ = strconv.ParseInt(m[1], 10, 64)
+				rule.Bytes, _ = strconv.ParseInt(m[2], 10, 64)
+				spec = strings.TrimSpace(nftCounterRegexp.ReplaceAllString(spec, "counter "))
+			}
+			rule.Spec = spec
+			rule.Target = nftTarget(spec)
+			chain.Rules = append(chain.Rules, rule)
+		}
+	}
+
+	return chains
+}
@@ -111,6 +111,20 @@ type NextHop struct {
 	IfIndex  int64  `json:"IfIndex,omitempty"`
 }
 
+// IPRule describes a routing policy rule
+type IPRule struct {
+	Family   string `json:"Family"`
+	Priority int64  `json:"Priority"`
+	Src      string `json:"Src,omitempty"`
+	Dst      string `json:"Dst,omitempty"`
+	IifName  string `json:"IifName,omitempty"`
+	OifName  string `json:"OifName,omitempty"`
+	Mark     int64  `json:"Mark,omitempty"`
+	Mask     int64  `json:"Mask,omitempty"`
+	Table    int64  `json:"Table,omitempty"`
+	Goto     int64  `json:"Goto,omitempty"`
+}
+
 func (u *NetNsNetLinkProbe) linkPendingChildren(intf *graph.Node, index int64) {
 	// ignore ovs-system interface as it doesn't make any sense according to
 	// the following thread:
@@ -362,6 +376,18 @@ func (u *NetNsNetLinkProbe) getNeighbors(index, family int) (neighbors []neighbo
 	return
 }
 
+func addGreMetadata(metadata graph.Metadata, local, remote net.IP, ikey, okey uint32) {
+	if local != nil && !local.IsUnspecified() {
+		metadata["LocalIP"] = local.String()
+	}
+	if remote != nil && !remote.IsUnspecified() {
+		metadata["RemoteIP"] = remote.String()
+	}
+	if ikey != 0 && ikey == okey {
+		metadata["Key"] = int64(ikey)
+	}
+}
+
 func newInterfaceMetricsFromNetlink(link netlink.Link) *topology.InterfaceMetric {
 	statistics := link.Attrs().Statistics
 	if statistics == nil {
@@ -476,6 +502,29 @@ func (u *NetNsNetLinkProbe) addLinkToTopology(link netlink.Link) {
 		}
 	}
 
+	switch tunnel := link.(type) {
+	case *netlink.Vxlan:
+		// flow based devices get their endpoints from the packets metadata
+		if tunnel.FlowBased {
+			break
+		}
+		metadata["VNI"] = int64(tunnel.VxlanId)
+		if tunnel.SrcAddr != nil && !tunnel.SrcAddr.IsUnspecified() {
+			metadata["LocalIP"] = tunnel.SrcAddr.String()
+		}
+		if tunnel.Group != nil && !tunnel.Group.IsUnspecified() {
+			if tunnel.Group.IsMulticast() {
+				metadata["Group"] = tunnel.Group.String()
+			} else {
+				metadata["RemoteIP"] = tunnel.Group.String()
+			}
+		}
+	case *netlink.Gretap:
+		addGreMetadata(metadata, tunnel.Local, tunnel.Remote, tunnel.IKey, tunnel.OKey)
+	case *netlink.Gretun:
+		addGreMetadata(metadata, tunnel.Local, tunnel.Remote, tunnel.IKey, tunnel.OKey)
+	}
+
 	if (attrs.Flags & net.FlagUp) > 0 {
 		metadata["State"] = "UP"
 	} else {
@@ -562,6 +611,62 @@ func (u *NetNsNetLinkProbe) getRoutingTable(link netlink.Link, table int) []Rout
 	return nil
 }
 
+func (u *NetNsNetLinkProbe) getIPRules() (rules []IPRule) {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		ruleList, err := u.handle.RuleList(family)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to list %s rules of %s: %s", getFamilyKey(family), u.Root.ID, err.Error())
+			continue
+		}
+
+		for _, r := range ruleList {
+			rule := IPRule{
+				Family:  getFamilyKey(family),
+				IifName: r.IifName,
+				OifName: r.OifName,
+				Table:   int64(r.Table),
+			}
+			if r.Priority > 0 {
+				rule.Priority = int64(r.Priority)
+			}
+			if r.Src != nil {
+				rule.Src = r.Src.String()
+			}
+			if r.Dst != nil {
+				rule.Dst = r.Dst.String()
+			}
+			if r.Mark > 0 {
+				rule.Mark = int64(r.Mark)
+			}
+			if r.Mask > 0 {
+				rule.Mask = int64(r.Mask)
+			}
+			if r.Goto > 0 {
+				rule.Goto = int64(r.Goto)
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return
+}
+
+// updateIPRules records the routing policy rules of the namespace in the
+// metadata of the root node
+func (u *NetNsNetLinkProbe) updateIPRules() {
+	rules := u.getIPRules()
+
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	if len(rules) == 0 {
+		if _, err := u.Root.GetField("IPRules"); err == nil {
+			u.Graph.DelMetadata(u.Root, "IPRules")
+		}
+		return
+	}
+	u.Graph.AddMetadata(u.Root, "IPRules", rules)
+}
+
 func (u *NetNsNetLinkProbe) onLinkAdded(link netlink.Link) {
 	if u.isRunning() == true {
 		// has been deleted
@@ -816,6 +921,7 @@ Ready:
 		return
 	}
 	u.initialize()
+	u.updateIPRules()
 
 	seconds := config.GetConfig().GetInt("agent.topology.netlink.metrics_update")
 	ticker := time.NewTicker(time.Duration(seconds) * time.Second)
@@ -925,6 +1031,8 @@ func (u *NetNsNetLinkProbe) onMessageAvailable() {
 				continue
 			}
 			u.onRouteChanged(int64(index), rt)
+		case syscall.RTM_NEWRULE, syscall.RTM_DELRULE:
+			u.updateIPRules()
 		}
 	}
 }
@@ -985,7 +1093,7 @@ func newNetNsNetLinkProbe(g *graph.Graph, root *graph.Node, nsPath string) (*Net
 		return errFnc(fmt.Errorf("Failed to create netlink handle: %s", err.Error()))
 	}
 
-	if probe.socket, err = nl.Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_LINK, syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR, syscall.RTNLGRP_IPV4_MROUTE, syscall.RTNLGRP_IPV4_ROUTE, syscall.RTNLGRP_IPV6_MROUTE, syscall.RTNLGRP_IPV6_ROUTE); err != nil {
+	if probe.socket, err = nl.Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_LINK, syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR, syscall.RTNLGRP_IPV4_MROUTE, syscall.RTNLGRP_IPV4_ROUTE, syscall.RTNLGRP_IPV6_MROUTE, syscall.RTNLGRP_IPV6_ROUTE, syscall.RTNLGRP_IPV4_RULE, syscall.RTNLGRP_IPV6_RULE); err != nil {
 		return errFnc(fmt.Errorf("Failed to subscribe to netlink messages: %s", err.Error()))
 	}
 
@@ -0,0 +1,492 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ovn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/socketplane/libovsdb"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/ovs"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	northboundDatabase = "OVN_Northbound"
+	southboundDatabase = "OVN_Southbound"
+)
+
+var (
+	northboundTables = []string{"Logical_Switch", "Logical_Switch_Port", "Logical_Router", "Logical_Router_Port", "ACL"}
+	southboundTables = []string{"Chassis"}
+)
+
+// Probe describes an OVN probe mapping the logical entities of the OVN
+// Northbound and Southbound databases into the graph. Logical switch ports
+// are linked to the OVS interfaces binding them, using the iface-id external
+// ID, and the chassis to the hosts running them.
+type Probe struct {
+	graph.DefaultGraphListener
+	graph          *graph.Graph
+	nbMonitor      *ovsdb.OvsMonitor
+	sbMonitor      *ovsdb.OvsMonitor
+	uuidToNode     map[string]*graph.Node
+	parents        map[string]string
+	children       map[string][]string
+	logicalPorts   map[string]*graph.Node
+	routerPorts    map[string]*graph.Node
+	routerPortPeer map[string]string
+	chassis        map[string]*graph.Node
+}
+
+// rowSet returns the values of a set column, a set of a single element
+// being encoded by OVSDB as the element itself
+func rowSet(row *libovsdb.Row, column string) []interface{} {
+	switch v := row.Fields[column].(type) {
+	case nil:
+		return nil
+	case libovsdb.OvsSet:
+		return v.GoSet
+	default:
+		return []interface{}{v}
+	}
+}
+
+func rowUUIDs(row *libovsdb.Row, column string) (uuids []string) {
+	for _, v := range rowSet(row, column) {
+		if u, ok := v.(libovsdb.UUID); ok {
+			uuids = append(uuids, u.GoUUID)
+		}
+	}
+	return
+}
+
+func rowStrings(row *libovsdb.Row, column string) (values []string) {
+	for _, v := range rowSet(row, column) {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return
+}
+
+// rowString returns the value of a string column, optional columns being
+// encoded as empty sets when not set
+func rowString(row *libovsdb.Row, column string) string {
+	if values := rowStrings(row, column); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func rowBool(row *libovsdb.Row, column string) (bool, bool) {
+	for _, v := range rowSet(row, column) {
+		if b, ok := v.(bool); ok {
+			return b, true
+		}
+	}
+	return false, false
+}
+
+func rowMap(row *libovsdb.Row, column string) map[string]interface{} {
+	m := make(map[string]interface{})
+	if ovsMap, ok := row.Fields[column].(libovsdb.OvsMap); ok {
+		for k, v := range ovsMap.GoMap {
+			if k, ok := k.(string); ok {
+				m[k] = v
+			}
+		}
+	}
+	return m
+}
+
+func (p *Probe) linkParent(parent *graph.Node, child *graph.Node) {
+	if !topology.HaveOwnershipLink(p.graph, parent, child, nil) {
+		topology.AddOwnershipLink(p.graph, parent, child, nil)
+	}
+}
+
+func (p *Probe) unlinkParent(parent *graph.Node, child *graph.Node) {
+	for _, e := range p.graph.GetNodeEdges(child, graph.Metadata{"RelationType": topology.OwnershipLink}) {
+		if e.GetParent() == parent.ID && e.GetChild() == child.ID {
+			p.graph.DelEdge(e)
+		}
+	}
+}
+
+// setChildren records the rows owned by a row, they are linked as soon as
+// both nodes exist whatever the order of the row updates. The rows no longer
+// owned are unlinked, unless they were already moved to another row.
+func (p *Probe) setChildren(parent *graph.Node, parentUUID string, children []string) {
+	owned := make(map[string]bool, len(children))
+	for _, child := range children {
+		owned[child] = true
+		p.parents[child] = parentUUID
+		if node, ok := p.uuidToNode[child]; ok {
+			p.linkParent(parent, node)
+		}
+	}
+
+	for _, child := range p.children[parentUUID] {
+		if owned[child] || p.parents[child] != parentUUID {
+			continue
+		}
+
+		delete(p.parents, child)
+		if node, ok := p.uuidToNode[child]; ok {
+			p.unlinkParent(parent, node)
+		}
+	}
+
+	p.children[parentUUID] = children
+}
+
+func (p *Probe) setNode(uuid string, row *libovsdb.Row, m graph.Metadata) *graph.Node {
+	m["UUID"] = uuid
+	m["Manager"] = "ovn"
+	if extIDs := rowMap(row, "external_ids"); len(extIDs) > 0 {
+		m["ExtID"] = extIDs
+	}
+
+	if node, ok := p.uuidToNode[uuid]; ok {
+		p.graph.SetMetadata(node, m)
+		return node
+	}
+
+	node := p.graph.NewNode(graph.GenID(), m)
+	p.uuidToNode[uuid] = node
+
+	if parent, ok := p.parents[uuid]; ok {
+		if parentNode, ok := p.uuidToNode[parent]; ok {
+			p.linkParent(parentNode, node)
+		}
+	}
+
+	return node
+}
+
+func (p *Probe) linkInterface(port *graph.Node, intf *graph.Node) {
+	installed, _ := intf.GetFieldString("ExtID.ovn-installed")
+
+	if !topology.HaveLayer2Link(p.graph, port, intf, nil) {
+		topology.AddLayer2Link(p.graph, port, intf, graph.Metadata{"OVNInstalled": installed == "true"})
+		return
+	}
+
+	for _, e := range p.graph.GetNodeEdges(intf, topology.Layer2Metadata) {
+		if e.GetParent() == port.ID {
+			if current, _ := e.GetField("OVNInstalled"); current != (installed == "true") {
+				p.graph.AddMetadata(e, "OVNInstalled", installed == "true")
+			}
+		}
+	}
+}
+
+func (p *Probe) linkRouterPort(switchPort *graph.Node, routerPort *graph.Node) {
+	if !topology.HaveLayer2Link(p.graph, switchPort, routerPort, nil) {
+		topology.AddLayer2Link(p.graph, switchPort, routerPort, nil)
+	}
+}
+
+func (p *Probe) onLogicalSwitch(uuid string, row *libovsdb.Row) {
+	ls := p.setNode(uuid, row, graph.Metadata{
+		"Type": "logical_switch",
+		"Name": rowString(row, "name"),
+	})
+
+	p.setChildren(ls, uuid, append(rowUUIDs(row, "ports"), rowUUIDs(row, "acls")...))
+}
+
+func (p *Probe) onLogicalSwitchPort(uuid string, row *libovsdb.Row) {
+	name := rowString(row, "name")
+	m := graph.Metadata{
+		"Type":     "logical_port",
+		"Name":     name,
+		"PortType": rowString(row, "type"),
+	}
+
+	if addresses := rowStrings(row, "addresses"); len(addresses) > 0 {
+		m["Addresses"] = addresses
+	}
+	if up, ok := rowBool(row, "up"); ok {
+		m["Up"] = up
+	}
+
+	lsp := p.setNode(uuid, row, m)
+	p.logicalPorts[name] = lsp
+
+	// ports of type router are connected to a logical router port
+	if peer, ok := rowMap(row, "options")["router-port"].(string); ok {
+		p.routerPortPeer[uuid] = peer
+		if lrp, ok := p.routerPorts[peer]; ok {
+			p.linkRouterPort(lsp, lrp)
+		}
+	}
+
+	filter := filters.NewTermStringFilter("ExtID.iface-id", name)
+	for _, intf := range p.graph.GetNodes(graph.NewGraphElementFilter(filter)) {
+		if manager, _ := intf.GetFieldString("Manager"); manager != "ovn" {
+			p.linkInterface(lsp, intf)
+		}
+	}
+}
+
+func (p *Probe) onLogicalRouter(uuid string, row *libovsdb.Row) {
+	lr := p.setNode(uuid, row, graph.Metadata{
+		"Type": "logical_router",
+		"Name": rowString(row, "name"),
+	})
+
+	p.setChildren(lr, uuid, rowUUIDs(row, "ports"))
+}
+
+func (p *Probe) onLogicalRouterPort(uuid string, row *libovsdb.Row) {
+	name := rowString(row, "name")
+	m := graph.Metadata{
+		"Type": "logical_port",
+		"Name": name,
+		"MAC":  rowString(row, "mac"),
+	}
+
+	if networks := rowStrings(row, "networks"); len(networks) > 0 {
+		m["Networks"] = networks
+	}
+
+	lrp := p.setNode(uuid, row, m)
+	p.routerPorts[name] = lrp
+
+	for lspUUID, peer := range p.routerPortPeer {
+		if lsp, ok := p.uuidToNode[lspUUID]; ok && peer == name {
+			p.linkRouterPort(lsp, lrp)
+		}
+	}
+}
+
+func (p *Probe) onACL(uuid string, row *libovsdb.Row) {
+	m := graph.Metadata{
+		"Type":      "acl",
+		"Name":      rowString(row, "match"),
+		"Direction": rowString(row, "direction"),
+		"Action":    rowString(row, "action"),
+	}
+
+	if priority, ok := row.Fields["priority"].(float64); ok {
+		m["Priority"] = int64(priority)
+	}
+
+	p.setNode(uuid, row, m)
+}
+
+func (p *Probe) linkChassis(chassis *graph.Node, host *graph.Node) {
+	if !topology.HaveOwnershipLink(p.graph, host, chassis, nil) {
+		topology.AddOwnershipLink(p.graph, host, chassis, nil)
+	}
+}
+
+func (p *Probe) onChassis(uuid string, row *libovsdb.Row) {
+	hostname := rowString(row, "hostname")
+	chassis := p.setNode(uuid, row, graph.Metadata{
+		"Type":     "chassis",
+		"Name":     rowString(row, "name"),
+		"Hostname": hostname,
+	})
+	p.chassis[hostname] = chassis
+
+	if host := p.graph.LookupFirstNode(graph.Metadata{"Type": "host", "Name": hostname}); host != nil {
+		p.linkChassis(chassis, host)
+	}
+}
+
+func (p *Probe) onRowUpdated(table string, uuid string, row *libovsdb.Row) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	switch table {
+	case "Logical_Switch":
+		p.onLogicalSwitch(uuid, row)
+	case "Logical_Switch_Port":
+		p.onLogicalSwitchPort(uuid, row)
+	case "Logical_Router":
+		p.onLogicalRouter(uuid, row)
+	case "Logical_Router_Port":
+		p.onLogicalRouterPort(uuid, row)
+	case "ACL":
+		p.onACL(uuid, row)
+	case "Chassis":
+		p.onChassis(uuid, row)
+	}
+}
+
+// OnOvsRowAdd event
+func (p *Probe) OnOvsRowAdd(monitor *ovsdb.OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	p.onRowUpdated(table, uuid, &row.New)
+}
+
+// OnOvsRowUpdate event
+func (p *Probe) OnOvsRowUpdate(monitor *ovsdb.OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	p.onRowUpdated(table, uuid, &row.New)
+}
+
+// OnOvsRowDel event
+func (p *Probe) OnOvsRowDel(monitor *ovsdb.OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	node, ok := p.uuidToNode[uuid]
+	if !ok {
+		return
+	}
+
+	name := rowString(&row.Old, "name")
+	switch table {
+	case "Logical_Switch_Port":
+		delete(p.logicalPorts, name)
+		delete(p.routerPortPeer, uuid)
+	case "Logical_Router_Port":
+		delete(p.routerPorts, name)
+	case "Chassis":
+		delete(p.chassis, rowString(&row.Old, "hostname"))
+	}
+
+	for _, child := range p.children[uuid] {
+		if p.parents[child] == uuid {
+			delete(p.parents, child)
+		}
+	}
+
+	p.graph.DelNode(node)
+	delete(p.uuidToNode, uuid)
+	delete(p.parents, uuid)
+	delete(p.children, uuid)
+}
+
+func (p *Probe) onNodeEvent(n *graph.Node) {
+	if manager, _ := n.GetFieldString("Manager"); manager == "ovn" {
+		return
+	}
+
+	if ifaceID, _ := n.GetFieldString("ExtID.iface-id"); ifaceID != "" {
+		if lsp, ok := p.logicalPorts[ifaceID]; ok {
+			p.linkInterface(lsp, n)
+		}
+	}
+
+	if nodeType, _ := n.GetFieldString("Type"); nodeType == "host" {
+		name, _ := n.GetFieldString("Name")
+		if chassis, ok := p.chassis[name]; ok {
+			p.linkChassis(chassis, n)
+		}
+	}
+}
+
+// OnNodeAdded event
+func (p *Probe) OnNodeAdded(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeUpdated event
+func (p *Probe) OnNodeUpdated(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// Start the probe
+func (p *Probe) Start() {
+	p.graph.AddEventListener(p)
+	p.nbMonitor.StartMonitoring()
+	p.sbMonitor.StartMonitoring()
+}
+
+// Stop the probe
+func (p *Probe) Stop() {
+	p.graph.RemoveEventListener(p)
+	p.nbMonitor.StopMonitoring()
+	p.sbMonitor.StopMonitoring()
+}
+
+func parseAddress(address string) (string, string, error) {
+	for _, protocol := range []string{"unix", "tcp"} {
+		if strings.HasPrefix(address, protocol+"://") {
+			return protocol, strings.TrimPrefix(address, protocol+"://"), nil
+		}
+	}
+	return "", "", fmt.Errorf("Unsupported OVSDB address %s, format: unix:///path or tcp://addr:port", address)
+}
+
+func newMonitor(address string, database string, tables []string) (*ovsdb.OvsMonitor, error) {
+	protocol, target, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	monitor := ovsdb.NewOvsMonitor(protocol, target)
+	monitor.Database = database
+	for _, table := range tables {
+		monitor.MonitorTable(table)
+	}
+
+	return monitor, nil
+}
+
+// NewProbe creates a new OVN probe connected to the given Northbound and
+// Southbound databases
+func NewProbe(g *graph.Graph, nbAddress string, sbAddress string) (*Probe, error) {
+	nbMonitor, err := newMonitor(nbAddress, northboundDatabase, northboundTables)
+	if err != nil {
+		return nil, err
+	}
+
+	sbMonitor, err := newMonitor(sbAddress, southboundDatabase, southboundTables)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Probe{
+		graph:          g,
+		nbMonitor:      nbMonitor,
+		sbMonitor:      sbMonitor,
+		uuidToNode:     make(map[string]*graph.Node),
+		parents:        make(map[string]string),
+		children:       make(map[string][]string),
+		logicalPorts:   make(map[string]*graph.Node),
+		routerPorts:    make(map[string]*graph.Node),
+		routerPortPeer: make(map[string]string),
+		chassis:        make(map[string]*graph.Node),
+	}
+
+	nbMonitor.AddTableMonitorHandler(p)
+	sbMonitor.AddTableMonitorHandler(p)
+
+	logging.GetLogger().Infof("OVN probe using Northbound %s and Southbound %s", nbAddress, sbAddress)
+	return p, nil
+}
+
+// NewProbeFromConfig creates a new OVN probe based on configuration
+func NewProbeFromConfig(g *graph.Graph) (*Probe, error) {
+	cfg := config.GetConfig()
+	return NewProbe(g, cfg.GetString("ovn.northbound"), cfg.GetString("ovn.southbound"))
+}
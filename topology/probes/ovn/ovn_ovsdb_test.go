@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ovn
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// ovsdbServer runs an ovsdb-server serving the OVN databases, created from
+// the schema files of the OVN installation
+type ovsdbServer struct {
+	dir    string
+	socket string
+	cmd    *exec.Cmd
+}
+
+// schemaDir returns the directory of the OVN schema files, it can be set
+// with the OVN_SCHEMA_DIR environment variable
+func schemaDir() string {
+	dirs := []string{"/usr/share/ovn", "/usr/share/openvswitch"}
+	if dir := os.Getenv("OVN_SCHEMA_DIR"); dir != "" {
+		dirs = []string{dir}
+	}
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "ovn-nb.ovsschema")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+func startOvsdbServer(t *testing.T) *ovsdbServer {
+	for _, command := range []string{"ovsdb-tool", "ovsdb-server", "ovsdb-client"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s not found", command)
+		}
+	}
+
+	schemas := schemaDir()
+	if schemas == "" {
+		t.Skip("OVN schema files not found")
+	}
+
+	dir, err := ioutil.TempDir("", "skydive-ovn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ovsdbServer{dir: dir, socket: filepath.Join(dir, "db.sock")}
+
+	var dbs []string
+	for _, name := range []string{"ovn-nb", "ovn-sb"} {
+		db := filepath.Join(dir, name+".db")
+		if out, err := exec.Command("ovsdb-tool", "create", db, filepath.Join(schemas, name+".ovsschema")).CombinedOutput(); err != nil {
+			os.RemoveAll(dir)
+			t.Fatalf("Unable to create %s: %s", db, string(out))
+		}
+		dbs = append(dbs, db)
+	}
+
+	args := append([]string{"--remote=punix:" + s.socket, "--unixctl=" + filepath.Join(dir, "db.ctl")}, dbs...)
+	s.cmd = exec.Command("ovsdb-server", args...)
+	if err := s.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	err = common.Retry(func() error {
+		_, err := os.Stat(s.socket)
+		return err
+	}, 50, 100*time.Millisecond)
+	if err != nil {
+		s.stop()
+		t.Fatalf("ovsdb-server did not start: %s", err)
+	}
+
+	return s
+}
+
+func (s *ovsdbServer) transact(t *testing.T, ops string) {
+	out, err := exec.Command("ovsdb-client", "transact", "unix:"+s.socket, `["OVN_Northbound", `+ops+`]`).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Transaction failed: %s", string(out))
+	}
+}
+
+func (s *ovsdbServer) stop() {
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	os.RemoveAll(s.dir)
+}
+
+func TestLogicalTopologyOvsdb(t *testing.T) {
+	server := startOvsdbServer(t)
+	defer server.stop()
+
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	p, err := NewProbe(g, "unix://"+server.socket, "unix://"+server.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	defer p.Stop()
+
+	server.transact(t, `
+		{"op": "insert", "table": "Logical_Switch_Port", "row": {"name": "lsp1"}, "uuid-name": "lsp1"},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1", "ports": ["named-uuid", "lsp1"]}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2"}}`)
+
+	lookup := func(name string) *graph.Node {
+		return g.LookupFirstNode(graph.Metadata{"Manager": "ovn", "Name": name})
+	}
+
+	// owner returns the name of the switch owning the port
+	owner := func(port string) (string, error) {
+		g.RLock()
+		defer g.RUnlock()
+
+		lsp := lookup(port)
+		if lsp == nil {
+			return "", fmt.Errorf("Port %s not found", port)
+		}
+
+		var owners []string
+		for _, name := range []string{"ls1", "ls2"} {
+			if ls := lookup(name); ls != nil && topology.HaveOwnershipLink(g, ls, lsp, nil) {
+				owners = append(owners, name)
+			}
+		}
+
+		if len(owners) != 1 {
+			return "", fmt.Errorf("Port %s owned by %v", port, owners)
+		}
+		return owners[0], nil
+	}
+
+	expectOwner := func(expected string) {
+		err := common.Retry(func() error {
+			name, err := owner("lsp1")
+			if err != nil {
+				return err
+			}
+			if name != expected {
+				return errors.New("Port lsp1 owned by " + name)
+			}
+			return nil
+		}, 50, 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Expected lsp1 to be owned by %s: %s", expected, err)
+		}
+	}
+
+	expectOwner("ls1")
+
+	g.RLock()
+	uuid, _ := lookup("lsp1").GetFieldString("UUID")
+	g.RUnlock()
+
+	// move the port to the other switch, the stale link has to be removed
+	server.transact(t, fmt.Sprintf(`
+		{"op": "update", "table": "Logical_Switch", "where": [["name", "==", "ls1"]], "row": {"ports": ["set", []]}},
+		{"op": "update", "table": "Logical_Switch", "where": [["name", "==", "ls2"]], "row": {"ports": ["uuid", "%s"]}}`, uuid))
+
+	expectOwner("ls2")
+}
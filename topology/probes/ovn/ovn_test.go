@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ovn
+
+import (
+	"testing"
+
+	"github.com/socketplane/libovsdb"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newRow(fields map[string]interface{}) *libovsdb.RowUpdate {
+	return &libovsdb.RowUpdate{New: libovsdb.Row{Fields: fields}}
+}
+
+func uuidSet(uuids ...string) libovsdb.OvsSet {
+	var set libovsdb.OvsSet
+	for _, uuid := range uuids {
+		set.GoSet = append(set.GoSet, libovsdb.UUID{GoUUID: uuid})
+	}
+	return set
+}
+
+func TestLogicalTopology(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	p, err := NewProbe(g, "unix:///tmp/ovnnb_db.sock", "tcp://127.0.0.1:6642")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.AddEventListener(p)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "compute1"})
+	intf := g.NewNode(graph.GenID(), graph.Metadata{"Type": "internal", "Name": "tap1", "ExtID": map[string]interface{}{"iface-id": "lsp1", "ovn-installed": "true"}})
+	g.Unlock()
+
+	// ports are received before the switch referencing them
+	p.OnOvsRowAdd(nil, "Logical_Switch_Port", "lsp1-uuid", newRow(map[string]interface{}{
+		"name":      "lsp1",
+		"type":      "",
+		"addresses": "00:00:00:00:00:01 10.0.0.1",
+	}))
+	p.OnOvsRowAdd(nil, "Logical_Switch_Port", "lsp2-uuid", newRow(map[string]interface{}{
+		"name":    "lsp2",
+		"type":    "router",
+		"options": libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"router-port": "lrp1"}},
+	}))
+	p.OnOvsRowAdd(nil, "ACL", "acl1-uuid", newRow(map[string]interface{}{
+		"priority":  float64(1000),
+		"direction": "to-lport",
+		"match":     "ip4",
+		"action":    "allow",
+	}))
+	p.OnOvsRowAdd(nil, "Logical_Switch", "ls1-uuid", newRow(map[string]interface{}{
+		"name":  "ls1",
+		"ports": uuidSet("lsp1-uuid", "lsp2-uuid"),
+		"acls":  libovsdb.UUID{GoUUID: "acl1-uuid"},
+	}))
+	p.OnOvsRowAdd(nil, "Logical_Router", "lr1-uuid", newRow(map[string]interface{}{
+		"name":  "lr1",
+		"ports": libovsdb.UUID{GoUUID: "lrp1-uuid"},
+	}))
+	p.OnOvsRowAdd(nil, "Logical_Router_Port", "lrp1-uuid", newRow(map[string]interface{}{
+		"name":     "lrp1",
+		"mac":      "00:00:00:00:00:ff",
+		"networks": "10.0.0.254/24",
+	}))
+	p.OnOvsRowAdd(nil, "Chassis", "chassis1-uuid", newRow(map[string]interface{}{
+		"name":     "chassis1",
+		"hostname": "compute1",
+	}))
+
+	g.RLock()
+	node := func(uuid string) *graph.Node {
+		n := g.LookupFirstNode(graph.Metadata{"UUID": uuid})
+		if n == nil {
+			t.Fatalf("Node %s not found", uuid)
+		}
+		return n
+	}
+
+	ls1, lsp1, lsp2, lr1, lrp1 := node("ls1-uuid"), node("lsp1-uuid"), node("lsp2-uuid"), node("lr1-uuid"), node("lrp1-uuid")
+
+	for _, link := range [][2]*graph.Node{{ls1, lsp1}, {ls1, lsp2}, {ls1, node("acl1-uuid")}, {lr1, lrp1}, {host, node("chassis1-uuid")}} {
+		if !topology.HaveOwnershipLink(g, link[0], link[1], nil) {
+			t.Errorf("Missing ownership link between %s and %s", link[0], link[1])
+		}
+	}
+
+	if !topology.HaveLayer2Link(g, lsp2, lrp1, nil) {
+		t.Error("Missing link between the switch and router ports")
+	}
+
+	if !topology.HaveLayer2Link(g, lsp1, intf, graph.Metadata{"OVNInstalled": true}) {
+		t.Error("Missing link between the logical port and the interface")
+	}
+
+	acl1 := node("acl1-uuid")
+	g.RUnlock()
+
+	// the rows no longer referenced by the switch are unlinked
+	p.OnOvsRowUpdate(nil, "Logical_Switch", "ls1-uuid", newRow(map[string]interface{}{
+		"name":  "ls1",
+		"ports": uuidSet("lsp2-uuid"),
+	}))
+
+	g.RLock()
+	if topology.HaveOwnershipLink(g, ls1, lsp1, nil) || topology.HaveOwnershipLink(g, ls1, acl1, nil) {
+		t.Error("The port and the ACL removed from the switch should have been unlinked")
+	}
+	if !topology.HaveOwnershipLink(g, ls1, lsp2, nil) {
+		t.Error("The port kept by the switch should still be linked")
+	}
+	g.RUnlock()
+
+	if _, ok := p.parents["lsp1-uuid"]; ok {
+		t.Error("The port removed from the switch should not have a parent anymore")
+	}
+
+	p.OnOvsRowDel(nil, "Logical_Switch_Port", "lsp1-uuid", &libovsdb.RowUpdate{Old: libovsdb.Row{Fields: map[string]interface{}{"name": "lsp1"}}})
+	g.RLock()
+	defer g.RUnlock()
+
+	if g.LookupFirstNode(graph.Metadata{"UUID": "lsp1-uuid"}) != nil {
+		t.Error("Logical port should have been deleted")
+	}
+}
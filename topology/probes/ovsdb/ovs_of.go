@@ -36,23 +36,28 @@ import (
 	"golang.org/x/net/context"
 
 	uuid "github.com/nu7hatch/gouuid"
+	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/ovs/openflow"
+	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 )
 
 // OvsOfProbe is the type of the probe retrieving Openflow rules on an Open Vswitch
 type OvsOfProbe struct {
 	sync.Mutex
-	Host         string                    // The host
-	Graph        *graph.Graph              // The graph that will receive the rules found
-	Root         *graph.Node               // The root node of the host in the graph
-	BridgeProbes map[string]*BridgeOfProbe // The table of probes associated to each bridge
-	Translation  map[string]string         // A translation table to find the url for a given bridge knowing its name
-	Certificate  string                    // Path to the certificate used for authenticated communication with bridges
-	PrivateKey   string                    // Path of the private key authenticating the probe.
-	CA           string                    // Path of the certicate of the Certificate authority used for authenticated communication with bridges
-	sslOk        bool                      // cert private key and ca are provisionned.
+	Host          string                    // The host
+	Graph         *graph.Graph              // The graph that will receive the rules found
+	Root          *graph.Node               // The root node of the host in the graph
+	BridgeProbes  map[string]*BridgeOfProbe // The table of probes associated to each bridge
+	Translation   map[string]string         // A translation table to find the url for a given bridge knowing its name
+	Certificate   string                    // Path to the certificate used for authenticated communication with bridges
+	PrivateKey    string                    // Path of the private key authenticating the probe.
+	CA            string                    // Path of the certicate of the Certificate authority used for authenticated communication with bridges
+	sslOk         bool                      // cert private key and ca are provisionned.
+	MetricsUpdate time.Duration             // Interval between two updates of the rule metrics, 0 disables them
+	Native        bool                      // Use an OpenFlow connection instead of ovs-ofctl
 }
 
 // BridgeOfProbe is the type of the probe retrieving Openflow rules on a Bridge.
@@ -70,6 +75,9 @@ type BridgeOfProbe struct {
 	OvsOfProbe *OvsOfProbe        // Back pointer to the probe
 	Rules      map[string][]*Rule // The set of rules found so far grouped by rawUUID
 	cancel     context.CancelFunc
+	client     *openflow.Client     // OpenFlow connection used in native mode
+	clientLock sync.RWMutex         // Protects the OpenFlow connection
+	hits       map[string]*ruleHits // Counters of the rules used to compute their idle age in native mode
 }
 
 // Rule is an OpenFlow rule in a switch
@@ -81,6 +89,9 @@ type Rule struct {
 	Actions  string // all the actions (comma separated)
 	InPort   int    // -1 is any
 	UUID     string // Unique id
+	Packets  int64  // number of packets matched
+	Bytes    int64  // number of bytes matched
+	IdleAge  int64  // seconds since the last packet matched or since the rule was added
 }
 
 // Event is an event as monitored by ovs-ofctl monitor <br> watch:
@@ -141,6 +152,20 @@ func fillIn(components []string, rule *Rule, event *Event) {
 				} else {
 					logging.GetLogger().Errorf("Error while parsing cookie of rule: %s", err.Error())
 				}
+			case "n_packets", "n_bytes", "idle_age":
+				v, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					logging.GetLogger().Errorf("Error while parsing %s of rule: %s", key, err.Error())
+					continue
+				}
+				switch key {
+				case "n_packets":
+					rule.Packets = v
+				case "n_bytes":
+					rule.Bytes = v
+				default:
+					rule.IdleAge = v
+				}
 			}
 		}
 	}
@@ -379,6 +404,16 @@ func completeEvent(ctx context.Context, o *OvsOfProbe, event *Event, prefix stri
 	return nil
 }
 
+// ruleLastHit returns the time of the last packet matched by a rule given
+// its idle age, false if the rule never matched any packet as the idle age
+// is then the time since the rule was added.
+func ruleLastHit(rule *Rule, now time.Time) (int64, bool) {
+	if rule.Packets == 0 {
+		return 0, false
+	}
+	return int64(common.UnixMillis(now.Add(-time.Duration(rule.IdleAge) * time.Second))), true
+}
+
 // addRule adds a rule to the graph and links it to the bridge.
 func (probe *BridgeOfProbe) addRule(rule *Rule) {
 	logging.GetLogger().Infof("New rule %v added", rule.UUID)
@@ -395,10 +430,32 @@ func (probe *BridgeOfProbe) addRule(rule *Rule) {
 		"priority": rule.Priority,
 		"UUID":     rule.UUID,
 	}
+	// the metrics may be disabled, LastHit is then only known from the
+	// idle age reported when the rule is added or updated
+	if lastHit, ok := ruleLastHit(rule, time.Now().UTC()); ok {
+		metadata["LastHit"] = lastHit
+	}
 	ruleNode := g.NewNode(graph.GenID(), metadata)
 	g.Link(bridgeNode, ruleNode, graph.Metadata{"RelationType": "ownership"})
 }
 
+// updateRule updates the actions of a rule in the graph.
+func (probe *BridgeOfProbe) updateRule(rule *Rule) {
+	logging.GetLogger().Infof("Rule %v modified", rule.UUID)
+	g := probe.OvsOfProbe.Graph
+	g.Lock()
+	defer g.Unlock()
+
+	if ruleNode := g.LookupFirstNode(graph.Metadata{"UUID": rule.UUID}); ruleNode != nil {
+		tr := g.StartMetadataTransaction(ruleNode)
+		tr.AddMetadata("actions", rule.Actions)
+		if lastHit, ok := ruleLastHit(rule, time.Now().UTC()); ok {
+			tr.AddMetadata("LastHit", lastHit)
+		}
+		tr.Commit()
+	}
+}
+
 // delRule deletes a rule from the the graph.
 func (probe *BridgeOfProbe) delRule(rule *Rule) {
 	logging.GetLogger().Infof("Rule %v deleted", rule.UUID)
@@ -421,9 +478,58 @@ func containsRule(rules []*Rule, searched *Rule) bool {
 	return false
 }
 
+// handleEvent updates the rules of the bridge according to an event
+func (probe *BridgeOfProbe) handleEvent(event *Event) {
+	rawUUID := event.RawRule.UUID
+	oldRules := probe.Rules[rawUUID]
+	switch event.Action {
+	case "ADDED":
+		for _, rule := range event.Rules {
+			if !containsRule(oldRules, rule) {
+				oldRules = append(oldRules, rule)
+				probe.addRule(rule)
+			}
+		}
+		probe.Rules[rawUUID] = oldRules
+	case "MODIFIED":
+		for _, rule := range event.Rules {
+			found := false
+			for i, oldRule := range oldRules {
+				if oldRule.UUID == rule.UUID {
+					if oldRule.Actions != rule.Actions {
+						probe.updateRule(rule)
+					}
+					oldRules[i], found = rule, true
+				}
+			}
+			if !found {
+				oldRules = append(oldRules, rule)
+				probe.addRule(rule)
+			}
+		}
+		probe.Rules[rawUUID] = oldRules
+	case "DELETED":
+		for _, oldRule := range oldRules {
+			if !containsRule(event.Rules, oldRule) {
+				probe.delRule(oldRule)
+			}
+		}
+		if len(event.Rules) == 0 {
+			delete(probe.Rules, rawUUID)
+		} else {
+			probe.Rules[rawUUID] = event.Rules
+		}
+	}
+}
+
 // monitor monitors the openflow rules of a bridge by launching a goroutine. The context is used to control the execution of the routine.
 func (probe *BridgeOfProbe) monitor(ctx context.Context) error {
 	ofp := probe.OvsOfProbe
+	if ofp.Native {
+		go probe.monitorNative(ctx)
+		return nil
+	}
+
 	command, err1 := ofp.makeCommand([]string{"ovs-ofctl", "monitor"}, probe.Bridge, "watch:")
 	if err1 != nil {
 		return err1
@@ -444,29 +550,7 @@ func (probe *BridgeOfProbe) monitor(ctx context.Context) error {
 				if err != nil {
 					logging.GetLogger().Error(err.Error())
 				}
-				rawUUID := event.RawRule.UUID
-				oldRules := probe.Rules[rawUUID]
-				switch event.Action {
-				case "ADDED":
-					for _, rule := range event.Rules {
-						if !containsRule(oldRules, rule) {
-							oldRules = append(oldRules, rule)
-							probe.addRule(rule)
-						}
-					}
-					probe.Rules[rawUUID] = oldRules
-				case "DELETED":
-					for _, oldRule := range oldRules {
-						if !containsRule(event.Rules, oldRule) {
-							probe.delRule(oldRule)
-						}
-					}
-					if len(event.Rules) == 0 {
-						delete(probe.Rules, rawUUID)
-					} else {
-						probe.Rules[rawUUID] = event.Rules
-					}
-				}
+				probe.handleEvent(&event)
 			} else {
 				if _, ok := err.(*noEventError); !ok {
 					logging.GetLogger().Errorf("Error while monitoring %s@%s: %s", probe.Bridge, probe.Host, err.Error())
@@ -478,6 +562,110 @@ func (probe *BridgeOfProbe) monitor(ctx context.Context) error {
 	return nil
 }
 
+// dumpRules returns the rules of the bridge along with their counters
+func (probe *BridgeOfProbe) dumpRules(ctx context.Context) ([]*Rule, error) {
+	if probe.OvsOfProbe.Native {
+		return probe.dumpNativeRules(ctx)
+	}
+
+	command, err := probe.OvsOfProbe.makeCommand([]string{"ovs-ofctl", "dump-flows"}, probe.Bridge)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := launchOnSwitch(command)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot launch ovs-ofctl dump-flows on %s@%s: %s", probe.Bridge, probe.Host, err.Error())
+	}
+
+	var rules []*Rule
+	prefix := probe.Host + "-" + probe.Bridge + "-"
+	for _, line := range strings.Split(lines, "\n") {
+		if rule, err := parseRule(line); err == nil {
+			fillUUID(rule, prefix)
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// setMetrics records the counters of the rules as metrics of the rule nodes.
+// The LastHit metadata keeps the time of the last packet matched by a rule,
+// it is updated each time the counters of the rule increase.
+func (probe *BridgeOfProbe) setMetrics(rules []*Rule, last time.Time, now time.Time) {
+	g := probe.OvsOfProbe.Graph
+	g.Lock()
+	defer g.Unlock()
+
+	nodes := make(map[string]*graph.Node)
+	for _, node := range g.LookupChildren(probe.BridgeNode, graph.Metadata{"Type": "ofrule"}, nil) {
+		if uuid, err := node.GetFieldString("UUID"); err == nil {
+			nodes[uuid] = node
+		}
+	}
+
+	for _, rule := range rules {
+		node, ok := nodes[rule.UUID]
+		if !ok {
+			continue
+		}
+
+		tr := g.StartMetadataTransaction(node)
+
+		currMetric := &topology.OfRuleMetric{
+			Packets: rule.Packets,
+			Bytes:   rule.Bytes,
+			IdleAge: rule.IdleAge,
+		}
+
+		lastUpdateMetric, changed := topology.UpdateRuleMetric(tr, currMetric, last, now)
+		if !changed {
+			continue
+		}
+
+		if lastUpdateMetric != nil {
+			// the counters increased, the rule was hit since the last update
+			// even if its idle age was not refreshed yet
+			lastHit := now.Add(-time.Duration(rule.IdleAge) * time.Second)
+			if lastHit.Before(last) {
+				lastHit = now
+			}
+			tr.AddMetadata("LastHit", int64(common.UnixMillis(lastHit)))
+		} else if lastHit, ok := ruleLastHit(rule, now); ok {
+			tr.AddMetadata("LastHit", lastHit)
+		}
+
+		tr.Commit()
+	}
+}
+
+// updateMetrics periodically updates the metrics of the rules of the bridge
+// until the context is cancelled
+func (probe *BridgeOfProbe) updateMetrics(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now().UTC()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now().UTC()
+
+			rules, err := probe.dumpRules(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.GetLogger().Error(err.Error())
+				}
+				continue
+			}
+
+			probe.setMetrics(rules, last, now)
+			last = now
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // NewBridgeProbe creates a probe and launch the active process
 func (o *OvsOfProbe) NewBridgeProbe(host string, bridge string, uuid string, bridgeNode *graph.Node) (*BridgeOfProbe, error) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -494,8 +682,13 @@ func (o *OvsOfProbe) NewBridgeProbe(host string, bridge string, uuid string, bri
 		OvsOfProbe: o,
 		Rules:      make(map[string][]*Rule),
 		cancel:     cancel}
-	err := probe.monitor(ctx)
-	return probe, err
+	if err := probe.monitor(ctx); err != nil {
+		return probe, err
+	}
+	if o.MetricsUpdate > 0 {
+		go probe.updateMetrics(ctx, o.MetricsUpdate)
+	}
+	return probe, nil
 }
 
 func (o *OvsOfProbe) makeCommand(commands []string, bridge string, args ...string) ([]string, error) {
@@ -567,16 +760,20 @@ func NewOvsOfProbe(g *graph.Graph, root *graph.Node, host string) *OvsOfProbe {
 	pk := config.GetConfig().GetString("ovs.oflow.key")
 	ca := config.GetConfig().GetString("ovs.oflow.ca")
 	sslOk := (pk != "") && (ca != "") && (cert != "")
+	metricsUpdate := time.Duration(config.GetConfig().GetInt("ovs.oflow.metrics_update")) * time.Second
+	native := config.GetConfig().GetBool("ovs.oflow.native")
 	o := &OvsOfProbe{
-		Host:         host,
-		Graph:        g,
-		Root:         root,
-		BridgeProbes: make(map[string]*BridgeOfProbe),
-		Translation:  translate,
-		Certificate:  cert,
-		PrivateKey:   pk,
-		CA:           ca,
-		sslOk:        sslOk,
+		Host:          host,
+		Graph:         g,
+		Root:          root,
+		BridgeProbes:  make(map[string]*BridgeOfProbe),
+		Translation:   translate,
+		Certificate:   cert,
+		PrivateKey:    pk,
+		CA:            ca,
+		sslOk:         sslOk,
+		MetricsUpdate: metricsUpdate,
+		Native:        native,
 	}
 	return o
 }
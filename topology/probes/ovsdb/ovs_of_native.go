@@ -0,0 +1,307 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ovsdb
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/ovs/openflow"
+)
+
+const (
+	nativeRetryDelay   = 5 * time.Second
+	nativePollInterval = 10 * time.Second
+	nativeTimeout      = 10 * time.Second
+)
+
+// ruleHits keeps the packet counter of a rule and the time it last changed
+type ruleHits struct {
+	packets int64
+	last    time.Time
+}
+
+// nativeAddress returns the OpenFlow address of the bridge, by default the
+// management socket created by Open vSwitch for every bridge
+func (probe *BridgeOfProbe) nativeAddress() string {
+	if address, ok := probe.OvsOfProbe.Translation[probe.Bridge]; ok {
+		return address
+	}
+	return fmt.Sprintf("unix:/var/run/openvswitch/%s.mgmt", probe.Bridge)
+}
+
+func (o *OvsOfProbe) tlsConfig(address string) (*tls.Config, error) {
+	if !strings.HasPrefix(address, "ssl:") {
+		return nil, nil
+	}
+	if !o.sslOk {
+		return nil, errors.New("Certificate, CA and private keys are necessary for communication with switch over SSL")
+	}
+
+	tlsConfig := common.SetupTLSClientConfig(o.Certificate, o.PrivateKey)
+	tlsConfig.RootCAs = common.SetupTLSLoadCertificate(o.CA)
+	return tlsConfig, nil
+}
+
+// flowToRule converts a flow in a rule using the ovs-ofctl syntax. The raw
+// rule, without priority, is returned as well.
+func flowToRule(flow *openflow.Flow, prefix string) (*Rule, *Rule) {
+	filter := protectCommas(flow.Match)
+	raw := &Rule{
+		Cookie: flow.Cookie,
+		Table:  int(flow.Table),
+		Filter: filter,
+	}
+	fillUUID(raw, prefix)
+
+	if flow.Priority != openflow.DefaultPriority {
+		if filter == "" {
+			filter = fmt.Sprintf("priority=%d", flow.Priority)
+		} else {
+			filter = fmt.Sprintf("priority=%d,%s", flow.Priority, filter)
+		}
+	}
+
+	rule := &Rule{
+		Cookie:   flow.Cookie,
+		Table:    int(flow.Table),
+		Priority: int(flow.Priority),
+		Filter:   filter,
+		Actions:  protectCommas(flow.Actions),
+		Packets:  int64(flow.Packets),
+		Bytes:    int64(flow.Bytes),
+	}
+	fillUUID(rule, prefix)
+
+	return rule, raw
+}
+
+func (probe *BridgeOfProbe) prefix() string {
+	return probe.Host + "-" + probe.Bridge + "-"
+}
+
+func (probe *BridgeOfProbe) setClient(client *openflow.Client) {
+	probe.clientLock.Lock()
+	probe.client = client
+	probe.clientLock.Unlock()
+}
+
+func (probe *BridgeOfProbe) getClient() *openflow.Client {
+	probe.clientLock.RLock()
+	defer probe.clientLock.RUnlock()
+	return probe.client
+}
+
+// syncRules replaces the rules of the bridge by the given flows
+func (probe *BridgeOfProbe) syncRules(flows []*openflow.Flow) {
+	prefix := probe.prefix()
+	now := time.Now().Unix()
+
+	events := make(map[string]*Event)
+	for _, flow := range flows {
+		rule, raw := flowToRule(flow, prefix)
+		if event, ok := events[raw.UUID]; ok {
+			event.Rules = append(event.Rules, rule)
+		} else {
+			events[raw.UUID] = &Event{RawRule: raw, Rules: []*Rule{rule}, Date: now, Bridge: probe.Bridge}
+		}
+	}
+
+	for _, event := range events {
+		event.Action = "MODIFIED"
+		probe.handleEvent(event)
+	}
+
+	for rawUUID, rules := range probe.Rules {
+		event := &Event{RawRule: &Rule{UUID: rawUUID}, Date: now, Action: "DELETED", Bridge: probe.Bridge}
+		if e, ok := events[rawUUID]; ok {
+			event.Rules = e.Rules
+		}
+		if len(event.Rules) != len(rules) {
+			probe.handleEvent(event)
+		}
+	}
+}
+
+// handleFlowUpdate updates the rules of the bridge according to a change
+// reported by the switch
+func (probe *BridgeOfProbe) handleFlowUpdate(update *openflow.FlowUpdate) {
+	rule, raw := flowToRule(update.Flow, probe.prefix())
+	event := &Event{RawRule: raw, Rules: []*Rule{rule}, Date: time.Now().Unix(), Bridge: probe.Bridge}
+
+	switch update.Event {
+	case openflow.FlowInitial, openflow.FlowAdded:
+		event.Action = "ADDED"
+	case openflow.FlowModified:
+		event.Action = "MODIFIED"
+	case openflow.FlowRemoved:
+		event.Action = "DELETED"
+		event.Rules = nil
+		for _, oldRule := range probe.Rules[raw.UUID] {
+			if oldRule.UUID != rule.UUID {
+				event.Rules = append(event.Rules, oldRule)
+			}
+		}
+	}
+
+	probe.handleEvent(event)
+}
+
+func (probe *BridgeOfProbe) dumpFlows(ctx context.Context, client *openflow.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, nativeTimeout)
+	defer cancel()
+
+	flows, err := client.FlowStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	probe.syncRules(flows)
+	return nil
+}
+
+// connectNative connects to the bridge and keeps the rules up to date until
+// the connection is closed
+func (probe *BridgeOfProbe) connectNative(ctx context.Context) error {
+	address := probe.nativeAddress()
+	tlsConfig, err := probe.OvsOfProbe.tlsConfig(address)
+	if err != nil {
+		return err
+	}
+
+	client, err := openflow.Dial(address, tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	logging.GetLogger().Infof("OpenFlow connection to %s@%s established using %s", probe.Bridge, probe.Host, address)
+
+	probe.setClient(client)
+	defer probe.setClient(nil)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-client.Closed():
+		}
+	}()
+
+	monitorCtx, cancel := context.WithTimeout(ctx, nativeTimeout)
+	updates, err := client.Monitor(monitorCtx)
+	cancel()
+	if err != nil && err != openflow.ErrMonitorNotSupported {
+		return err
+	}
+
+	if err := probe.dumpFlows(ctx, client); err != nil {
+		return err
+	}
+
+	if updates != nil {
+		for update := range updates {
+			probe.handleFlowUpdate(update)
+		}
+		return client.Err()
+	}
+
+	// changes are not reported before OpenFlow 1.4, poll the flows instead
+	ticker := time.NewTicker(nativePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := probe.dumpFlows(ctx, client); err != nil {
+				return err
+			}
+		case <-client.Closed():
+			return client.Err()
+		}
+	}
+}
+
+// monitorNative monitors the OpenFlow rules of a bridge using an OpenFlow
+// connection, reconnecting until the context is cancelled
+func (probe *BridgeOfProbe) monitorNative(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := probe.connectNative(ctx); err != nil && ctx.Err() == nil {
+			logging.GetLogger().Errorf("Error while monitoring %s@%s: %s", probe.Bridge, probe.Host, err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(nativeRetryDelay):
+		}
+	}
+}
+
+// dumpNativeRules returns the rules of the bridge along with their counters
+// using the OpenFlow connection. The idle age is computed from the changes
+// of the packet counters.
+func (probe *BridgeOfProbe) dumpNativeRules(ctx context.Context) ([]*Rule, error) {
+	client := probe.getClient()
+	if client == nil {
+		return nil, fmt.Errorf("No OpenFlow connection to %s@%s", probe.Bridge, probe.Host)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, nativeTimeout)
+	defer cancel()
+
+	flows, err := client.FlowStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	prefix := probe.prefix()
+	hits := make(map[string]*ruleHits)
+
+	var rules []*Rule
+	for _, flow := range flows {
+		rule, _ := flowToRule(flow, prefix)
+
+		h, ok := probe.hits[rule.UUID]
+		switch {
+		case !ok && rule.Packets == 0:
+			h = &ruleHits{last: now.Add(-flow.Duration)}
+		case !ok || h.packets != rule.Packets:
+			h = &ruleHits{packets: rule.Packets, last: now}
+		}
+		hits[rule.UUID] = h
+
+		rule.IdleAge = int64(now.Sub(h.last) / time.Second)
+		rules = append(rules, rule)
+	}
+	probe.hits = hits
+
+	return rules, nil
+}
@@ -29,8 +29,14 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/ovs/openflow"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
 )
 
 func TestProtectCommas(t *testing.T) {
@@ -263,3 +269,188 @@ func TestCompleteRule(t *testing.T) {
 		t.Errorf("completeRule: fails action=%s, filter=%s", rule.Actions, rule.Filter)
 	}
 }
+
+func TestRuleMetrics(t *testing.T) {
+	old := executor
+	defer func() { executor = old }()
+
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	bridgeNode := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge", "Name": "br"})
+	g.Unlock()
+
+	probe := &BridgeOfProbe{
+		Host:       "host",
+		Bridge:     "br",
+		BridgeNode: bridgeNode,
+		OvsOfProbe: &OvsOfProbe{Host: "host", Graph: g},
+		Rules:      make(map[string][]*Rule),
+	}
+
+	executor = ExecuteForTest{Results: []string{"NXST_FLOW reply (xid=0x4):\n cookie=0x20, duration=60.249s, table=21, n_packets=10, n_bytes=1000, idle_age=5, priority=1,in_port=1 actions=drop"}}
+	rules, err := probe.dumpRules(context.Background())
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("dumpRules: unexpected result %v: %v", rules, err)
+	}
+
+	rule := rules[0]
+	if rule.Packets != 10 || rule.Bytes != 1000 || rule.IdleAge != 5 {
+		t.Errorf("Bad rule counters %+v", rule)
+	}
+	probe.addRule(rule)
+
+	start := time.Now()
+	probe.setMetrics(rules, start, start)
+
+	g.RLock()
+	node := g.LookupFirstNode(graph.Metadata{"UUID": rule.UUID})
+	if lastHit, _ := node.GetFieldInt64("LastHit"); lastHit != int64(common.UnixMillis(start.Add(-5*time.Second))) {
+		t.Errorf("Bad last hit %d", lastHit)
+	}
+	if _, err := node.GetField("LastUpdateMetric"); err == nil {
+		t.Error("No last update metric expected on first update")
+	}
+	g.RUnlock()
+
+	now := start.Add(30 * time.Second)
+	probe.setMetrics([]*Rule{{UUID: rule.UUID, Packets: 15, Bytes: 1500, IdleAge: 2}}, start, now)
+
+	g.RLock()
+	m, _ := node.GetField("LastUpdateMetric")
+	metric, ok := m.(*topology.OfRuleMetric)
+	if !ok || metric.Packets != 5 || metric.Bytes != 500 || metric.Last != int64(common.UnixMillis(now)) {
+		t.Errorf("Bad last update metric %+v", m)
+	}
+	if lastHit, _ := node.GetFieldInt64("LastHit"); lastHit != int64(common.UnixMillis(now.Add(-2*time.Second))) {
+		t.Errorf("Bad last hit %d", lastHit)
+	}
+	g.RUnlock()
+
+	// a zero length packet matched while the idle age was not refreshed
+	later := now.Add(30 * time.Second)
+	probe.setMetrics([]*Rule{{UUID: rule.UUID, Packets: 16, Bytes: 1500, IdleAge: 40}}, now, later)
+
+	g.RLock()
+	if lastHit, _ := node.GetFieldInt64("LastHit"); lastHit != int64(common.UnixMillis(later)) {
+		t.Errorf("Bad last hit %d", lastHit)
+	}
+	g.RUnlock()
+}
+
+func TestNativeRules(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	bridgeNode := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge", "Name": "br"})
+	g.Unlock()
+
+	probe := &BridgeOfProbe{
+		Host:       "host",
+		Bridge:     "br",
+		BridgeNode: bridgeNode,
+		OvsOfProbe: &OvsOfProbe{Host: "host", Graph: g, Native: true},
+		Rules:      make(map[string][]*Rule),
+	}
+
+	flow1 := &openflow.Flow{Table: 1, Priority: 100, Cookie: 0x20, Match: "in_port=1", Actions: "resubmit(,2),output:3"}
+	flow2 := &openflow.Flow{Table: 1, Priority: openflow.DefaultPriority, Cookie: 0x20, Match: "in_port=1", Actions: "drop"}
+	flow3 := &openflow.Flow{Table: 2, Priority: 0, Actions: "NORMAL"}
+
+	rule, raw := flowToRule(flow1, probe.prefix())
+	if rule.Filter != "priority=100,in_port=1" || rule.Actions != "resubmit(;2),output:3" || rule.Priority != 100 {
+		t.Errorf("Bad rule %+v", rule)
+	}
+	if raw.Filter != "in_port=1" {
+		t.Errorf("Bad raw rule %+v", raw)
+	}
+
+	// the same flow dumped by ovs-ofctl gives the same rule
+	parsed, _ := parseRule(" cookie=0x20, duration=1.2s, table=1, n_packets=0, n_bytes=0, priority=100,in_port=1 actions=resubmit(,2),output:3")
+	fillUUID(parsed, probe.prefix())
+	if parsed.UUID != rule.UUID {
+		t.Errorf("Rule UUID mismatch %+v %+v", parsed, rule)
+	}
+
+	if rule, _ := flowToRule(flow2, probe.prefix()); rule.Filter != "in_port=1" {
+		t.Errorf("Default priority should be omitted: %+v", rule)
+	}
+	if rule, _ := flowToRule(flow3, probe.prefix()); rule.Filter != "priority=0" {
+		t.Errorf("Bad filter of an empty match %+v", rule)
+	}
+
+	ruleNodes := func() map[string]string {
+		g.RLock()
+		defer g.RUnlock()
+
+		actions := make(map[string]string)
+		for _, node := range g.LookupChildren(bridgeNode, graph.Metadata{"Type": "ofrule"}, nil) {
+			filters, _ := node.GetFieldString("filters")
+			actions[filters], _ = node.GetFieldString("actions")
+		}
+		return actions
+	}
+
+	probe.syncRules([]*openflow.Flow{flow1, flow2, flow3})
+	if nodes := ruleNodes(); len(nodes) != 3 || nodes["priority=0"] != "NORMAL" {
+		t.Errorf("Bad rules after sync %v", nodes)
+	}
+
+	probe.handleFlowUpdate(&openflow.FlowUpdate{Event: openflow.FlowRemoved, Flow: flow1})
+	if nodes := ruleNodes(); len(nodes) != 2 || nodes["in_port=1"] != "drop" {
+		t.Errorf("Bad rules after removal %v", nodes)
+	}
+
+	flow4 := *flow3
+	flow4.Actions = "output:1"
+	probe.handleFlowUpdate(&openflow.FlowUpdate{Event: openflow.FlowModified, Flow: &flow4})
+	if nodes := ruleNodes(); len(nodes) != 2 || nodes["priority=0"] != "output:1" {
+		t.Errorf("Bad rules after modification %v", nodes)
+	}
+
+	probe.syncRules([]*openflow.Flow{flow1})
+	if nodes := ruleNodes(); len(nodes) != 1 || nodes["priority=100,in_port=1"] != "resubmit(;2),output:3" {
+		t.Errorf("Bad rules after resync %v", nodes)
+	}
+	if len(probe.Rules) != 1 {
+		t.Errorf("Rules of deleted flows should be forgotten %v", probe.Rules)
+	}
+}
+
+func TestRuleLastHitWithoutMetrics(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	bridgeNode := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge", "Name": "br"})
+	g.Unlock()
+
+	probe := &BridgeOfProbe{
+		Host:       "host",
+		Bridge:     "br",
+		BridgeNode: bridgeNode,
+		OvsOfProbe: &OvsOfProbe{Host: "host", Graph: g},
+		Rules:      make(map[string][]*Rule),
+	}
+
+	before := time.Now()
+	probe.addRule(&Rule{UUID: "hit", Actions: "drop", Packets: 10, IdleAge: 5})
+	probe.addRule(&Rule{UUID: "never-hit", Actions: "drop", IdleAge: 5})
+	after := time.Now()
+
+	g.RLock()
+	defer g.RUnlock()
+
+	node := g.LookupFirstNode(graph.Metadata{"UUID": "hit"})
+	lastHit, err := node.GetFieldInt64("LastHit")
+	if err != nil || lastHit < int64(common.UnixMillis(before.Add(-5*time.Second))) || lastHit > int64(common.UnixMillis(after.Add(-5*time.Second))) {
+		t.Errorf("Bad last hit %d: %v", lastHit, err)
+	}
+
+	node = g.LookupFirstNode(graph.Metadata{"UUID": "never-hit"})
+	if _, err := node.GetField("LastHit"); err == nil {
+		t.Error("No last hit expected for a rule that never matched")
+	}
+}
@@ -24,6 +24,7 @@ package ovsdb
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -279,6 +280,18 @@ func (o *OvsdbProbe) OnOvsInterfaceAdd(monitor *ovsdb.OvsMonitor, uuid string, r
 		if ip, ok := m.GoMap["remote_ip"]; ok {
 			tr.AddMetadata("RemoteIP", ip.(string))
 		}
+		// the key is set per flow when "flow", 0 when not specified
+		key := "0"
+		if k, ok := m.GoMap["key"]; ok {
+			key = k.(string)
+		}
+		if k, err := strconv.ParseInt(key, 0, 64); err == nil {
+			if itype == "gre" {
+				tr.AddMetadata("Key", k)
+			} else {
+				tr.AddMetadata("VNI", k)
+			}
+		}
 		m = row.New.Fields["status"].(libovsdb.OvsMap)
 		if iface, ok := m.GoMap["tunnel_egress_iface"]; ok {
 			tr.AddMetadata("TunEgressIface", iface.(string))
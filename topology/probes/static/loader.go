@@ -0,0 +1,299 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package static
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/nu7hatch/gouuid"
+	"gopkg.in/yaml.v2"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// includeDef describes a file included with its own variables, given either
+// as a path or as a file and vars pair
+type includeDef struct {
+	File string
+	Vars map[string]interface{}
+}
+
+// UnmarshalYAML decodes an include given as a single path
+func (i *includeDef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&i.File); err == nil {
+		return nil
+	}
+
+	type plain includeDef
+	return unmarshal((*plain)(i))
+}
+
+type nodeDef struct {
+	ID       string
+	Metadata map[string]interface{}
+}
+
+type edgeDef struct {
+	Parent   string
+	Child    string
+	Metadata map[string]interface{}
+}
+
+// topologyFile describes the content of a static topology file
+type topologyFile struct {
+	Include []includeDef
+	Nodes   []nodeDef
+	Edges   []edgeDef
+}
+
+type staticEdge struct {
+	parent   graph.Identifier
+	child    graph.Identifier
+	metadata graph.Metadata
+}
+
+// loader loads the nodes and edges of a set of static topology files
+type loader struct {
+	nodes    map[graph.Identifier]graph.Metadata
+	edges    map[graph.Identifier]*staticEdge
+	names    map[string]graph.Identifier
+	patterns []string
+	loading  map[string]bool
+	edgeDefs []edgeDef
+}
+
+var templateFuncs = template.FuncMap{
+	// seq returns the integers from 1 to n
+	"seq": func(n int64) []int64 {
+		s := make([]int64, n)
+		for i := range s {
+			s[i] = int64(i) + 1
+		}
+		return s
+	},
+	"add": func(a, b int64) int64 {
+		return a + b
+	},
+}
+
+func nodeID(name string) graph.Identifier {
+	u, _ := uuid.NewV5(uuid.NamespaceOID, []byte("static"+name))
+	return graph.Identifier(u.String())
+}
+
+func edgeID(parent, child graph.Identifier, relationType interface{}) graph.Identifier {
+	u, _ := uuid.NewV5(uuid.NamespaceOID, []byte(fmt.Sprintf("static%s%s%v", parent, child, relationType)))
+	return graph.Identifier(u.String())
+}
+
+// normalize converts the maps decoded from YAML to string keyed maps and the
+// integers to int64 as for the other metadata
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprintf("%v", k)] = normalize(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = normalize(e)
+		}
+		return l
+	case int:
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+func normalizeMetadata(m map[string]interface{}) graph.Metadata {
+	metadata := graph.Metadata{}
+	for k, v := range m {
+		metadata[k] = normalize(v)
+	}
+	return metadata
+}
+
+// load loads the files matching the pattern. When required, a path without
+// wildcard has to exist, a pattern may still match no file.
+func (l *loader) load(pattern string, vars map[string]interface{}, required bool) error {
+	pattern, err := filepath.Abs(pattern)
+	if err != nil {
+		return err
+	}
+	l.patterns = append(l.patterns, pattern)
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 && required && !strings.ContainsAny(pattern, `*?[\`) {
+		return fmt.Errorf("No such file %s", pattern)
+	}
+
+	for _, file := range files {
+		if err := l.loadFile(file, vars); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFile renders the file as a template with the variables and loads its
+// nodes and edges, the includes being relative to the file
+func (l *loader) loadFile(file string, vars map[string]interface{}) error {
+	if l.loading[file] {
+		return fmt.Errorf("Include loop detected on %s", file)
+	}
+	l.loading[file] = true
+	defer delete(l.loading, file)
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(filepath.Base(file)).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return err
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, vars); err != nil {
+		return err
+	}
+
+	var tf topologyFile
+	if err := yaml.Unmarshal(rendered.Bytes(), &tf); err != nil {
+		return fmt.Errorf("Unable to parse %s: %s", file, err)
+	}
+
+	for _, include := range tf.Include {
+		path := include.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+
+		includeVars := make(map[string]interface{})
+		for k, v := range vars {
+			includeVars[k] = v
+		}
+		for k, v := range include.Vars {
+			includeVars[k] = normalize(v)
+		}
+
+		if err := l.load(path, includeVars, true); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range tf.Nodes {
+		if n.ID == "" {
+			return fmt.Errorf("Node without ID in %s", file)
+		}
+		if _, found := l.names[n.ID]; found {
+			return fmt.Errorf("Node %s defined twice, in %s", n.ID, file)
+		}
+
+		metadata := normalizeMetadata(n.Metadata)
+		if _, ok := metadata["Name"]; !ok {
+			metadata["Name"] = n.ID
+		}
+		if _, ok := metadata["Type"]; !ok {
+			metadata["Type"] = "device"
+		}
+		metadata["Probe"] = "static"
+
+		id := nodeID(n.ID)
+		l.names[n.ID] = id
+		l.nodes[id] = metadata
+	}
+
+	l.edgeDefs = append(l.edgeDefs, tf.Edges...)
+
+	return nil
+}
+
+// resolveEdges resolves the nodes of the edges, once all the files loaded
+func (l *loader) resolveEdges() error {
+	for _, e := range l.edgeDefs {
+		parent, found := l.names[e.Parent]
+		if !found {
+			return fmt.Errorf("Unknown parent node %s of edge %s -> %s", e.Parent, e.Parent, e.Child)
+		}
+
+		child, found := l.names[e.Child]
+		if !found {
+			return fmt.Errorf("Unknown child node %s of edge %s -> %s", e.Child, e.Parent, e.Child)
+		}
+
+		metadata := normalizeMetadata(e.Metadata)
+		if _, ok := metadata["RelationType"]; !ok {
+			metadata["RelationType"] = "layer2"
+		}
+
+		id := edgeID(parent, child, metadata["RelationType"])
+		if _, found := l.edges[id]; found {
+			return fmt.Errorf("Edge %s -> %s defined twice", e.Parent, e.Child)
+		}
+		l.edges[id] = &staticEdge{parent: parent, child: child, metadata: metadata}
+	}
+
+	return nil
+}
+
+// matches returns whether a file is one of the loaded ones or a new one
+// matching their patterns
+func (l *loader) matches(file string) bool {
+	for _, pattern := range l.patterns {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newLoader() *loader {
+	return &loader{
+		nodes:   make(map[graph.Identifier]graph.Metadata),
+		edges:   make(map[graph.Identifier]*staticEdge),
+		names:   make(map[string]graph.Identifier),
+		loading: make(map[string]bool),
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package static
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// delay before reloading the files, several events being usually triggered
+// by a single modification
+const reloadDelay = 500 * time.Millisecond
+
+// Probe describes a probe loading static nodes and edges from YAML or JSON
+// files. The files are rendered as Go templates and can include other files,
+// they are watched and only the differences with the previous load are
+// applied to the graph.
+type Probe struct {
+	sync.Mutex
+	graph    *graph.Graph
+	files    []string
+	vars     map[string]interface{}
+	nodes    map[graph.Identifier]graph.Metadata
+	edges    map[graph.Identifier]*staticEdge
+	loader   *loader
+	failed   bool
+	watcher  *fsnotify.Watcher
+	watching map[string]bool
+	quit     chan bool
+	wg       sync.WaitGroup
+}
+
+// load loads the files, the loader returned holds the patterns of the files
+// read even if the load failed
+func (p *Probe) load() (*loader, error) {
+	l := newLoader()
+	for _, file := range p.files {
+		if err := l.load(file, p.vars, false); err != nil {
+			return l, err
+		}
+	}
+
+	return l, l.resolveEdges()
+}
+
+// apply updates the graph with the differences between the previous load
+// and the new one
+func (p *Probe) apply(l *loader) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	for id, metadata := range l.nodes {
+		if node := p.graph.GetNode(id); node == nil {
+			p.graph.NewNode(id, metadata, "")
+		} else if !reflect.DeepEqual(node.Metadata(), metadata) {
+			p.graph.SetMetadata(node, metadata)
+		}
+	}
+
+	for id, e := range l.edges {
+		if edge := p.graph.GetEdge(id); edge == nil {
+			parent, child := p.graph.GetNode(e.parent), p.graph.GetNode(e.child)
+			p.graph.NewEdge(id, parent, child, e.metadata)
+		} else if !reflect.DeepEqual(edge.Metadata(), e.metadata) {
+			p.graph.SetMetadata(edge, e.metadata)
+		}
+	}
+
+	for id := range p.edges {
+		if _, found := l.edges[id]; !found {
+			if edge := p.graph.GetEdge(id); edge != nil {
+				p.graph.DelEdge(edge)
+			}
+		}
+	}
+
+	for id := range p.nodes {
+		if _, found := l.nodes[id]; !found {
+			if node := p.graph.GetNode(id); node != nil {
+				p.graph.DelNode(node)
+			}
+		}
+	}
+
+	p.nodes, p.edges = l.nodes, l.edges
+}
+
+// watch adds the directories of the patterns to the watcher, so that the
+// new files are loaded as well
+func (p *Probe) watch(patterns []string) {
+	if p.watcher == nil {
+		return
+	}
+
+	for _, pattern := range patterns {
+		dir := filepath.Dir(pattern)
+		if p.watching[dir] {
+			continue
+		}
+		if err := p.watcher.Add(dir); err != nil {
+			logging.GetLogger().Errorf("Unable to watch %s: %s", dir, err)
+			continue
+		}
+		p.watching[dir] = true
+	}
+}
+
+// sync loads the files and applies them to the graph, the graph is left
+// untouched if one of the files is invalid
+func (p *Probe) sync() error {
+	p.Lock()
+	defer p.Unlock()
+
+	l, err := p.load()
+	p.watch(l.patterns)
+
+	if err != nil {
+		// until the files are fixed, any modification triggers a reload
+		p.failed = true
+		return err
+	}
+	p.failed = false
+
+	p.apply(l)
+	p.loader = l
+
+	return nil
+}
+
+func (p *Probe) matches(file string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.loader == nil || p.failed || p.loader.matches(file)
+}
+
+func (p *Probe) run() {
+	defer p.wg.Done()
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case ev := <-p.watcher.Events:
+			if p.matches(ev.Name) {
+				reload = time.After(reloadDelay)
+			}
+		case err := <-p.watcher.Errors:
+			logging.GetLogger().Errorf("Error while watching static topology files: %s", err)
+		case <-reload:
+			reload = nil
+			if err := p.sync(); err != nil {
+				logging.GetLogger().Errorf("Unable to reload static topology: %s", err)
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *Probe) Start() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to watch static topology files: %s", err)
+	}
+	p.watcher = watcher
+
+	// the configured files are watched even if the first load fails
+	var patterns []string
+	for _, file := range p.files {
+		if pattern, err := filepath.Abs(file); err == nil {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	p.Lock()
+	p.watch(patterns)
+	p.Unlock()
+
+	if err := p.sync(); err != nil {
+		logging.GetLogger().Errorf("Unable to load static topology: %s", err)
+	}
+
+	if p.watcher != nil {
+		p.wg.Add(1)
+		go p.run()
+	}
+}
+
+// Stop the probe
+func (p *Probe) Stop() {
+	if p.watcher != nil {
+		close(p.quit)
+		p.wg.Wait()
+		p.watcher.Close()
+	}
+}
+
+// NewProbe creates a new static topology probe loading the files matching
+// the given patterns, rendered with the variables
+func NewProbe(g *graph.Graph, files []string, vars map[string]interface{}) *Probe {
+	return &Probe{
+		graph:    g,
+		files:    files,
+		vars:     normalize(vars).(map[string]interface{}),
+		nodes:    make(map[graph.Identifier]graph.Metadata),
+		edges:    make(map[graph.Identifier]*staticEdge),
+		watching: make(map[string]bool),
+		quit:     make(chan bool),
+	}
+}
+
+// NewProbeFromConfig creates a new static topology probe using the
+// configuration
+func NewProbeFromConfig(g *graph.Graph) *Probe {
+	files := config.GetConfig().GetStringSlice("analyzer.topology.static.files")
+	vars := config.GetConfig().GetStringMap("analyzer.topology.static.vars")
+	return NewProbe(g, files, vars)
+}
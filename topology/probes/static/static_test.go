@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package static
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newGraph(t *testing.T) *graph.Graph {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph.NewGraphFromConfig(b)
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const torFile = `
+include:
+  - ports.yml
+  - file: ports.yml
+    vars:
+      switch: tor2
+      ports: 1
+nodes:
+  - id: tor1
+    metadata:
+      Type: switch
+      Rack: {{ .rack }}
+  - id: tor2
+    metadata:
+      Type: switch
+edges:
+  - parent: tor1
+    child: tor2
+    metadata:
+      Speed: 10000
+`
+
+const portsFile = `
+nodes:
+{{- range $i := seq .ports }}
+  - id: {{ $.switch }}-port{{ $i }}
+{{- end }}
+edges:
+{{- range $i := seq .ports }}
+  - parent: {{ $.switch }}
+    child: {{ $.switch }}-port{{ $i }}
+    metadata:
+      RelationType: ownership
+{{- end }}
+`
+
+func TestStaticTopology(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "tor.yml", torFile)
+	writeFile(t, dir, "ports.yml", portsFile)
+
+	g := newGraph(t)
+	p := NewProbe(g, []string{filepath.Join(dir, "tor.yml")}, map[string]interface{}{"rack": "r1", "switch": "tor1", "ports": 3})
+	if err := p.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	g.RLock()
+	nodes, edges := len(g.GetNodes(nil)), len(g.GetEdges(nil))
+	g.RUnlock()
+	if nodes != 6 || edges != 5 {
+		t.Fatalf("Expected 6 nodes and 5 edges, got %d and %d", nodes, edges)
+	}
+
+	tor1 := g.GetNode(nodeID("tor1"))
+	if tor1 == nil {
+		t.Fatal("tor1 node not found")
+	}
+	if rack, _ := tor1.GetFieldString("Rack"); rack != "r1" {
+		t.Errorf("Template variable not rendered: %s", tor1)
+	}
+
+	e := g.GetEdge(edgeID(nodeID("tor1"), nodeID("tor2"), "layer2"))
+	if e == nil {
+		t.Fatal("tor1 -> tor2 edge not found")
+	}
+	if speed, _ := e.GetFieldInt64("Speed"); speed != 10000 {
+		t.Errorf("Wrong edge metadata: %s", e)
+	}
+
+	// update the rack and drop a port, the other nodes have to be kept
+	p.vars["rack"], p.vars["ports"] = "r2", int64(2)
+	port1 := g.GetNode(nodeID("tor1-port1"))
+	if err := p.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.GetNode(nodeID("tor1-port3")) != nil {
+		t.Error("tor1-port3 should have been removed")
+	}
+	if g.GetNode(nodeID("tor1-port1")) != port1 {
+		t.Error("tor1-port1 should have been kept")
+	}
+	if rack, _ := g.GetNode(nodeID("tor1")).GetFieldString("Rack"); rack != "r2" {
+		t.Errorf("tor1 metadata should have been updated, got rack %s", rack)
+	}
+
+	// an invalid file leaves the graph untouched
+	writeFile(t, dir, "ports.yml", portsFile+"\n  - parent: unknown\n    child: tor1\n")
+	if err := p.sync(); err == nil {
+		t.Error("Edge to an unknown node should be rejected")
+	}
+	if g.GetNode(nodeID("tor1-port2")) == nil {
+		t.Error("Graph should be left untouched on error")
+	}
+
+	writeFile(t, dir, "ports.yml", "include:\n  - tor.yml\n")
+	if err := p.sync(); err == nil {
+		t.Error("Include loop should be detected")
+	}
+
+	writeFile(t, dir, "ports.yml", "include:\n  - missing.yml\n")
+	if err := p.sync(); err == nil {
+		t.Error("Include of a missing file should be rejected")
+	}
+
+	writeFile(t, dir, "ports.yml", "include:\n  - missing-*.yml\n")
+	if err := p.sync(); err != nil {
+		t.Errorf("A pattern matching no file should be accepted: %s", err)
+	}
+}
+
+func TestStaticTopologyReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the first load fails as the included file does not exist yet
+	writeFile(t, dir, "tor.yml", torFile)
+
+	g := newGraph(t)
+	p := NewProbe(g, []string{filepath.Join(dir, "tor.yml")}, map[string]interface{}{"rack": "r1", "switch": "tor1", "ports": 3})
+	p.Start()
+	defer p.Stop()
+
+	writeFile(t, dir, "ports.yml", portsFile)
+
+	for i := 0; i < 50; i++ {
+		g.RLock()
+		nodes := len(g.GetNodes(nil))
+		g.RUnlock()
+
+		if nodes == 6 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("The topology should have been reloaded once the included file was written")
+}
@@ -0,0 +1,402 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package tunnel
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// families of the tunnel interface types, only interfaces of a same family
+// can be the endpoints of a tunnel
+var families = map[string]string{
+	"vxlan":     "vxlan",
+	"geneve":    "geneve",
+	"gre":       "gre",
+	"gretap":    "gre",
+	"ip6gre":    "gre",
+	"ip6gretap": "gre",
+}
+
+// Probe creates the tunnel links between the tunnel interfaces of
+// different hosts. The endpoints are matched using the LocalIP and RemoteIP
+// metadata of the interfaces, or the addresses of their host and the
+// forwarding database of VXLAN interfaces when not specified. The VNI or key
+// of the tunnel, when known, has to be the same on both ends. Only the
+// addresses of the interfaces of the root namespace of the hosts are indexed,
+// the ones of the containers and network namespaces not being endpoints.
+type Probe struct {
+	graph.DefaultGraphListener
+	graph     *graph.Graph
+	tunnels   map[graph.Identifier]*graph.Node
+	remotes   map[graph.Identifier]map[string]bool
+	addresses map[graph.Identifier][]string
+	hosts     map[string]map[string]int
+}
+
+type fdbEntry struct {
+	IP string `json:"IP,omitempty"`
+}
+
+func decodeField(n *graph.Node, key string, i interface{}) bool {
+	v, err := n.GetField(key)
+	if err != nil {
+		return false
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, i) == nil
+}
+
+func isEndpoint(ip net.IP) bool {
+	return ip != nil && !ip.IsUnspecified() && !ip.IsMulticast() && !ip.IsLoopback()
+}
+
+func family(n *graph.Node) string {
+	tp, _ := n.GetFieldString("Type")
+	return families[tp]
+}
+
+// isTunnel returns whether a node is a tunnel interface with at least an
+// endpoint or a key, the fallback and flow based devices having none
+func isTunnel(n *graph.Node) bool {
+	if family(n) == "" {
+		return false
+	}
+
+	for _, field := range []string{"LocalIP", "RemoteIP", "VNI", "Key", "FDB"} {
+		if _, err := n.GetField(field); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the VNI or the key of the tunnel, false if unknown
+func key(n *graph.Node) (int64, string, bool) {
+	for _, field := range []string{"VNI", "Key"} {
+		if k, err := n.GetFieldInt64(field); err == nil {
+			return k, field, true
+		}
+	}
+	return 0, "", false
+}
+
+// nodeAddresses returns the IP addresses of an interface
+func nodeAddresses(n *graph.Node) (addresses []string) {
+	for _, field := range []string{"IPV4", "IPV6"} {
+		cidrs, _ := n.GetFieldStringList(field)
+		for _, cidr := range cidrs {
+			if ip, _, err := net.ParseCIDR(cidr); err == nil && isEndpoint(ip) {
+				addresses = append(addresses, ip.String())
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return
+}
+
+// inRootNamespace returns whether an interface is owned by its host
+func (p *Probe) inRootNamespace(n *graph.Node) bool {
+	for _, parent := range p.graph.LookupParents(n, nil, topology.OwnershipMetadata) {
+		if tp, _ := parent.GetFieldString("Type"); tp == "host" {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIPs(n *graph.Node) map[string]bool {
+	ips := make(map[string]bool)
+
+	if remote, _ := n.GetFieldString("RemoteIP"); remote != "" {
+		if ip := net.ParseIP(remote); isEndpoint(ip) {
+			ips[ip.String()] = true
+		}
+	}
+
+	// VXLAN interfaces without remote get their remote endpoints from their
+	// forwarding database
+	var fdb []fdbEntry
+	if decodeField(n, "FDB", &fdb) {
+		for _, entry := range fdb {
+			if ip := net.ParseIP(entry.IP); isEndpoint(ip) {
+				ips[ip.String()] = true
+			}
+		}
+	}
+
+	return ips
+}
+
+func (p *Probe) localIPs(n *graph.Node) map[string]bool {
+	ips := make(map[string]bool)
+
+	if local, _ := n.GetFieldString("LocalIP"); local != "" {
+		if ip := net.ParseIP(local); isEndpoint(ip) {
+			ips[ip.String()] = true
+			return ips
+		}
+	}
+
+	// any address of the host may be the source of the tunnel
+	for ip := range p.hosts[n.Host()] {
+		ips[ip] = true
+	}
+
+	return ips
+}
+
+func intersects(a, b map[string]bool) bool {
+	for ip := range a {
+		if b[ip] {
+			return true
+		}
+	}
+	return false
+}
+
+// arePeers returns whether two tunnel interfaces are the endpoints of a same
+// tunnel, at least one of them having to point to the other one while the
+// other one has either to point to the first one or to have no remote
+func (p *Probe) arePeers(n1, n2 *graph.Node) bool {
+	if n1.Host() == n2.Host() || !isTunnel(n1) || !isTunnel(n2) || family(n1) != family(n2) {
+		return false
+	}
+
+	k1, _, ok1 := key(n1)
+	k2, _, ok2 := key(n2)
+	if ok1 && ok2 && k1 != k2 {
+		return false
+	}
+
+	remote1, remote2 := p.remotes[n1.ID], p.remotes[n2.ID]
+	to2 := intersects(remote1, p.localIPs(n2))
+	to1 := intersects(remote2, p.localIPs(n1))
+
+	return (to1 || to2) && (to2 || len(remote1) == 0) && (to1 || len(remote2) == 0)
+}
+
+func (p *Probe) linkMetadata(n1, n2 *graph.Node) graph.Metadata {
+	m := graph.Metadata{
+		"RelationType": topology.TunnelLink,
+		"TunnelType":   family(n1),
+	}
+
+	k, field, ok := key(n1)
+	if !ok {
+		k, field, ok = key(n2)
+	}
+	if ok {
+		m[field] = k
+	}
+
+	return m
+}
+
+func (p *Probe) addLink(n1, n2 *graph.Node) {
+	if strings.Compare(string(n1.ID), string(n2.ID)) > 0 {
+		n1, n2 = n2, n1
+	}
+
+	id, _ := uuid.NewV5(uuid.NamespaceOID, []byte(n1.ID+n2.ID+topology.TunnelLink))
+	p.graph.NewEdge(graph.Identifier(id.String()), n1, n2, p.linkMetadata(n1, n2))
+}
+
+// updateTunnel adds the missing links of a tunnel interface and removes the
+// ones to interfaces that are not its peers anymore
+func (p *Probe) updateTunnel(n *graph.Node) {
+	peers := make(map[graph.Identifier]*graph.Node)
+	for id, tunnel := range p.tunnels {
+		if id != n.ID && p.arePeers(n, tunnel) {
+			peers[id] = tunnel
+		}
+	}
+
+	for _, e := range p.graph.GetNodeEdges(n, topology.TunnelMetadata) {
+		peer := e.GetChild()
+		if peer == n.ID {
+			peer = e.GetParent()
+		}
+
+		if _, ok := peers[peer]; ok {
+			delete(peers, peer)
+		} else {
+			p.graph.DelEdge(e)
+		}
+	}
+
+	for _, peer := range peers {
+		p.addLink(n, peer)
+	}
+}
+
+// updateTunnels updates the tunnels of a host of which the addresses changed
+// and the ones pointing to the changed addresses
+func (p *Probe) updateTunnels(host string, changed map[string]bool) {
+	if len(changed) == 0 {
+		return
+	}
+
+	for id, tunnel := range p.tunnels {
+		if tunnel.Host() == host || intersects(p.remotes[id], changed) {
+			p.updateTunnel(tunnel)
+		}
+	}
+}
+
+// setAddresses records the addresses of a node in the index of its host and
+// returns the addresses of the host that changed
+func (p *Probe) setAddresses(n *graph.Node, addresses []string) map[string]bool {
+	changed := make(map[string]bool)
+
+	host := n.Host()
+	ips := p.hosts[host]
+	if ips == nil {
+		ips = make(map[string]int)
+		p.hosts[host] = ips
+	}
+
+	for _, ip := range p.addresses[n.ID] {
+		if ips[ip]--; ips[ip] == 0 {
+			delete(ips, ip)
+			changed[ip] = true
+		}
+	}
+	for _, ip := range addresses {
+		if ips[ip]++; ips[ip] == 1 {
+			if changed[ip] {
+				delete(changed, ip)
+			} else {
+				changed[ip] = true
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		delete(p.hosts, host)
+	}
+	if len(addresses) == 0 {
+		delete(p.addresses, n.ID)
+	} else {
+		p.addresses[n.ID] = addresses
+	}
+
+	return changed
+}
+
+func (p *Probe) updateAddresses(n *graph.Node) map[string]bool {
+	var addresses []string
+	if p.inRootNamespace(n) {
+		addresses = nodeAddresses(n)
+	}
+	return p.setAddresses(n, addresses)
+}
+
+func (p *Probe) onNodeEvent(n *graph.Node) {
+	changed := p.updateAddresses(n)
+
+	if isTunnel(n) {
+		p.tunnels[n.ID] = n
+		p.remotes[n.ID] = remoteIPs(n)
+		p.updateTunnel(n)
+	} else if _, ok := p.tunnels[n.ID]; ok {
+		delete(p.tunnels, n.ID)
+		delete(p.remotes, n.ID)
+		p.updateTunnel(n)
+	}
+
+	p.updateTunnels(n.Host(), changed)
+}
+
+func (p *Probe) onEdgeEvent(e *graph.Edge) {
+	if rt, _ := e.GetFieldString("RelationType"); rt != topology.OwnershipLink {
+		return
+	}
+
+	// the interface may have been moved to or from the root namespace
+	if n := p.graph.GetNode(e.GetChild()); n != nil {
+		p.updateTunnels(n.Host(), p.updateAddresses(n))
+	}
+}
+
+// OnNodeUpdated event
+func (p *Probe) OnNodeUpdated(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeAdded event
+func (p *Probe) OnNodeAdded(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeDeleted event
+func (p *Probe) OnNodeDeleted(n *graph.Node) {
+	delete(p.tunnels, n.ID)
+	delete(p.remotes, n.ID)
+
+	p.updateTunnels(n.Host(), p.setAddresses(n, nil))
+}
+
+// OnEdgeAdded event
+func (p *Probe) OnEdgeAdded(e *graph.Edge) {
+	p.onEdgeEvent(e)
+}
+
+// OnEdgeDeleted event
+func (p *Probe) OnEdgeDeleted(e *graph.Edge) {
+	p.onEdgeEvent(e)
+}
+
+// Start the tunnel probe
+func (p *Probe) Start() {
+}
+
+// Stop the probe
+func (p *Probe) Stop() {
+	p.graph.RemoveEventListener(p)
+}
+
+// NewProbe creates a new tunnel probe
+func NewProbe(g *graph.Graph) *Probe {
+	probe := &Probe{
+		graph:     g,
+		tunnels:   make(map[graph.Identifier]*graph.Node),
+		remotes:   make(map[graph.Identifier]map[string]bool),
+		addresses: make(map[graph.Identifier][]string),
+		hosts:     make(map[string]map[string]int),
+	}
+	g.AddEventListener(probe)
+
+	return probe
+}
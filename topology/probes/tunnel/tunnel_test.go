@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newGraph(t *testing.T) *graph.Graph {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph.NewGraphFromConfig(b)
+}
+
+func tunnelEdges(g *graph.Graph) []*graph.Edge {
+	return g.GetEdges(topology.TunnelMetadata)
+}
+
+// newInterface adds an interface owned by the given parent node
+func newInterface(g *graph.Graph, parent *graph.Node, m graph.Metadata) *graph.Node {
+	n := g.NewNode(graph.GenID(), m, parent.Host())
+	topology.AddOwnershipLink(g, parent, n, nil)
+	return n
+}
+
+func newHost(g *graph.Graph, host string) *graph.Node {
+	return g.NewNode(graph.GenID(), graph.Metadata{"Name": host, "Type": "host"}, host)
+}
+
+func TestTunnelLinks(t *testing.T) {
+	g := newGraph(t)
+	NewProbe(g)
+
+	host1, host2 := newHost(g, "host1"), newHost(g, "host2")
+	newInterface(g, host1, graph.Metadata{"Name": "eth0", "Type": "device", "IPV4": []string{"192.168.0.1/24"}})
+	newInterface(g, host2, graph.Metadata{"Name": "eth0", "Type": "device", "IPV4": []string{"192.168.0.2/24"}})
+
+	vxlan1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "vxlan1", "Type": "vxlan", "VNI": int64(42), "RemoteIP": "192.168.0.2"}, "host1")
+	if len(tunnelEdges(g)) != 0 {
+		t.Fatalf("No tunnel link expected without remote endpoint: %v", tunnelEdges(g))
+	}
+
+	// remote endpoint learnt through the forwarding database
+	fdb := []interface{}{map[string]interface{}{"MAC": "00:00:00:00:00:00", "IP": "192.168.0.1"}}
+	vxlan2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "vxlan2", "Type": "vxlan", "VNI": int64(42), "FDB": fdb}, "host2")
+
+	// fallback device without endpoint
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "gre0", "Type": "gre"}, "host2")
+
+	edges := tunnelEdges(g)
+	if len(edges) != 1 {
+		t.Fatalf("Expected one tunnel link, got: %v", edges)
+	}
+	if !g.AreLinked(vxlan1, vxlan2, topology.TunnelMetadata) {
+		t.Errorf("vxlan1 and vxlan2 should be linked: %v", edges)
+	}
+	if vni, _ := edges[0].GetFieldInt64("VNI"); vni != 42 {
+		t.Errorf("Tunnel link should have the VNI: %v", edges[0])
+	}
+	if tp, _ := edges[0].GetFieldString("TunnelType"); tp != "vxlan" {
+		t.Errorf("Tunnel link should have the tunnel type: %v", edges[0])
+	}
+
+	g.AddMetadata(vxlan2, "VNI", int64(43))
+	if len(tunnelEdges(g)) != 0 {
+		t.Errorf("Tunnel link should be removed when VNIs differ: %v", tunnelEdges(g))
+	}
+}
+
+func TestTunnelLocalIP(t *testing.T) {
+	g := newGraph(t)
+	NewProbe(g)
+
+	gre1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "gre1", "Type": "gre", "LocalIP": "10.0.0.1", "RemoteIP": "10.0.0.2"}, "host1")
+	gre2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "gre2", "Type": "gretap", "LocalIP": "10.0.0.2", "RemoteIP": "10.0.0.3"}, "host2")
+
+	if len(tunnelEdges(g)) != 0 {
+		t.Fatalf("No tunnel link expected when gre2 points to another host: %v", tunnelEdges(g))
+	}
+
+	g.AddMetadata(gre2, "RemoteIP", "10.0.0.1")
+	if !g.AreLinked(gre1, gre2, topology.TunnelMetadata) {
+		t.Fatalf("gre1 and gre2 should be linked: %v", tunnelEdges(g))
+	}
+
+	g.DelNode(gre1)
+	if len(tunnelEdges(g)) != 0 {
+		t.Errorf("Tunnel link should be removed with its endpoint: %v", tunnelEdges(g))
+	}
+}
+
+func TestTunnelRootNamespace(t *testing.T) {
+	g := newGraph(t)
+	NewProbe(g)
+
+	host1, host2 := newHost(g, "host1"), newHost(g, "host2")
+	netns := g.NewNode(graph.GenID(), graph.Metadata{"Name": "ns", "Type": "netns"}, "host2")
+	topology.AddOwnershipLink(g, host2, netns, nil)
+
+	// the address of a namespace of host2 is not an endpoint of host2
+	eth0 := newInterface(g, netns, graph.Metadata{"Name": "eth0", "Type": "veth", "IPV4": []string{"192.168.0.2/24"}})
+
+	vxlan1 := newInterface(g, host1, graph.Metadata{"Name": "vxlan1", "Type": "vxlan", "VNI": int64(42), "RemoteIP": "192.168.0.2"})
+	vxlan2 := newInterface(g, host2, graph.Metadata{"Name": "vxlan2", "Type": "vxlan", "VNI": int64(42)})
+
+	if len(tunnelEdges(g)) != 0 {
+		t.Fatalf("No tunnel link expected to a namespace address: %v", tunnelEdges(g))
+	}
+
+	// moving the interface to the root namespace makes it an endpoint
+	topology.AddOwnershipLink(g, host2, eth0, nil)
+	if !g.AreLinked(vxlan1, vxlan2, topology.TunnelMetadata) {
+		t.Fatalf("vxlan1 and vxlan2 should be linked: %v", tunnelEdges(g))
+	}
+
+	g.DelNode(eth0)
+	if len(tunnelEdges(g)) != 0 {
+		t.Errorf("Tunnel link should be removed with the endpoint address: %v", tunnelEdges(g))
+	}
+}
@@ -0,0 +1,499 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	// DefaultMaxHops is the maximum number of namespaces crossed by a route
+	DefaultMaxHops = 32
+
+	maxLayer2Depth = 16
+
+	tableLocal   = 255
+	tableMain    = 254
+	tableDefault = 253
+)
+
+var (
+	ownershipMetadata = graph.Metadata{"RelationType": "ownership"}
+	layer2Metadata    = graph.Metadata{"RelationType": "layer2"}
+)
+
+// Hop is a node of the forwarding path. The routing decision is reported on
+// the interface the packet leaves a namespace from.
+type Hop struct {
+	Node    *graph.Node
+	Table   int64  `json:"Table,omitempty"`
+	Prefix  string `json:"Prefix,omitempty"`
+	Gateway string `json:"Gateway,omitempty"`
+	MAC     string `json:"MAC,omitempty"`
+}
+
+// Route is the forwarding path expected for a destination
+type Route struct {
+	Destination string
+	Hops        []*Hop
+	Reached     bool
+	Error       string `json:"Error,omitempty"`
+}
+
+// Path returns the nodes of the route
+func (r *Route) Path() []*graph.Node {
+	nodes := make([]*graph.Node, len(r.Hops))
+	for i, hop := range r.Hops {
+		nodes[i] = hop.Node
+	}
+	return nodes
+}
+
+// the following types decode the metadata recorded by the netlink probe
+
+type nextHop struct {
+	Priority int64
+	IP       net.IP `json:"Src,omitempty"`
+	IfIndex  int64
+}
+
+type routeEntry struct {
+	Prefix   string
+	Nexthops []nextHop
+}
+
+type routingTable struct {
+	ID     int64 `json:"Id"`
+	Routes []routeEntry
+}
+
+type neighbor struct {
+	MAC string
+	IP  string
+}
+
+type ipRule struct {
+	Family   string
+	Priority int64
+	Src      string
+	Dst      string
+	IifName  string
+	OifName  string
+	Mark     int64
+	Table    int64
+	Goto     int64
+}
+
+// decodeField decodes a metadata field whether it holds the structures of
+// the probe or their JSON representation
+func decodeField(n *graph.Node, key string, i interface{}) bool {
+	v, err := n.GetField(key)
+	if err != nil {
+		return false
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, i) == nil
+}
+
+// Resolver computes the L3 forwarding path of packets using the routing
+// tables, policy rules and neighbors recorded in the graph
+type Resolver struct {
+	Graph   *graph.Graph
+	MaxHops int
+}
+
+// NewResolver returns a new resolver for the given graph
+func NewResolver(g *graph.Graph) *Resolver {
+	return &Resolver{Graph: g, MaxHops: DefaultMaxHops}
+}
+
+// namespace returns the netns or host node the node belongs to
+func (r *Resolver) namespace(n *graph.Node) *graph.Node {
+	if t, _ := n.GetFieldString("Type"); t == "netns" || t == "host" {
+		return n
+	}
+
+	for _, parent := range r.Graph.LookupParents(n, nil, ownershipMetadata) {
+		if t, _ := parent.GetFieldString("Type"); t == "netns" || t == "host" {
+			return parent
+		}
+	}
+	return nil
+}
+
+// interfaces returns the interfaces of a namespace
+func (r *Resolver) interfaces(ns *graph.Node) (intfs []*graph.Node) {
+	for _, child := range r.Graph.LookupChildren(ns, nil, ownershipMetadata) {
+		if _, err := child.GetFieldInt64("IfIndex"); err == nil {
+			intfs = append(intfs, child)
+		}
+	}
+	return
+}
+
+// hasIP returns whether one of the addresses of the node is ip
+func hasIP(n *graph.Node, ip net.IP) bool {
+	for _, key := range []string{"IPV4", "IPV6"} {
+		addrs, _ := n.GetFieldStringList(key)
+		for _, addr := range addrs {
+			if a, _, err := net.ParseCIDR(addr); err == nil && a.Equal(ip) {
+				return true
+			} else if a := net.ParseIP(addr); a != nil && a.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// firstIP returns the first address of the node of the family of ip
+func firstIP(n *graph.Node, ip net.IP) net.IP {
+	key := "IPV6"
+	if ip.To4() != nil {
+		key = "IPV4"
+	}
+
+	addrs, _ := n.GetFieldStringList(key)
+	for _, addr := range addrs {
+		if a, _, err := net.ParseCIDR(addr); err == nil {
+			return a
+		}
+	}
+	return nil
+}
+
+func sameFamily(a, b net.IP) bool {
+	return (a.To4() == nil) == (b.To4() == nil)
+}
+
+// matchPrefix returns whether the prefix matches ip along with the length
+// of the prefix, an empty prefix being a default route
+func matchPrefix(prefix string, ip net.IP) (bool, int) {
+	if prefix == "" || prefix == "default" {
+		return true, 0
+	}
+
+	_, ipnet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		if a := net.ParseIP(prefix); a != nil && a.Equal(ip) {
+			return true, len(ip) * 8
+		}
+		return false, 0
+	}
+
+	if !sameFamily(ipnet.IP, ip) || !ipnet.Contains(ip) {
+		return false, 0
+	}
+
+	ones, _ := ipnet.Mask.Size()
+	return true, ones
+}
+
+// rules returns the policy rules of the namespace for the family of ip
+// sorted by priority, the default rules being used when unknown
+func (r *Resolver) rules(ns *graph.Node, ip net.IP) []ipRule {
+	family := "IPV6"
+	if ip.To4() != nil {
+		family = "IPV4"
+	}
+
+	var all, rules []ipRule
+	if !decodeField(ns, "IPRules", &all) {
+		return []ipRule{{Table: tableLocal}, {Priority: 32766, Table: tableMain}, {Priority: 32767, Table: tableDefault}}
+	}
+
+	for _, rule := range all {
+		if rule.Family == "" || rule.Family == family {
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+
+	return rules
+}
+
+func (rule *ipRule) match(src, dst net.IP, iif string) bool {
+	if rule.Src != "" {
+		if src == nil {
+			return false
+		}
+		if ok, _ := matchPrefix(rule.Src, src); !ok {
+			return false
+		}
+	}
+	if rule.Dst != "" {
+		if ok, _ := matchPrefix(rule.Dst, dst); !ok {
+			return false
+		}
+	}
+	if rule.IifName != "" && rule.IifName != iif {
+		return false
+	}
+
+	// marks and output interfaces depend on the socket, not on the packet
+	return rule.Mark == 0 && rule.OifName == ""
+}
+
+type lookupResult struct {
+	intf    *graph.Node
+	table   int64
+	prefix  string
+	gateway net.IP
+}
+
+// lookupTable returns the longest prefix match of ip in a routing table of
+// the namespace, the lowest metric being used between routes of the same
+// prefix length
+func (r *Resolver) lookupTable(intfs []*graph.Node, table int64, ip net.IP) *lookupResult {
+	var best *lookupResult
+	bestLen, bestPriority := -1, int64(0)
+
+	for _, intf := range intfs {
+		var tables []routingTable
+		if !decodeField(intf, "RoutingTable", &tables) {
+			continue
+		}
+
+		for _, rt := range tables {
+			if rt.ID != table {
+				continue
+			}
+
+			for _, route := range rt.Routes {
+				if len(route.Nexthops) == 0 {
+					continue
+				}
+
+				// multipath routes always use their first next hop
+				nh := route.Nexthops[0]
+				if route.Prefix == "" && nh.IP != nil && !sameFamily(nh.IP, ip) {
+					continue
+				}
+
+				ok, length := matchPrefix(route.Prefix, ip)
+				if !ok || length < bestLen || (length == bestLen && nh.Priority >= bestPriority) {
+					continue
+				}
+
+				result := &lookupResult{intf: intf, table: table, prefix: route.Prefix}
+				if nh.IP != nil && !nh.IP.IsUnspecified() {
+					result.gateway = nh.IP
+				}
+				if nh.IfIndex != 0 {
+					for _, i := range intfs {
+						if index, _ := i.GetFieldInt64("IfIndex"); index == nh.IfIndex {
+							result.intf = i
+							break
+						}
+					}
+				}
+
+				best, bestLen, bestPriority = result, length, nh.Priority
+			}
+		}
+	}
+
+	return best
+}
+
+// lookup applies the policy rules of the namespace to find the route to dst
+func (r *Resolver) lookup(ns *graph.Node, src, dst net.IP, iif string) *lookupResult {
+	intfs := r.interfaces(ns)
+
+	var gotoPriority int64 = -1
+	for _, rule := range r.rules(ns, dst) {
+		if rule.Priority < gotoPriority || !rule.match(src, dst, iif) {
+			continue
+		}
+
+		if rule.Goto != 0 {
+			gotoPriority = rule.Goto
+			continue
+		}
+
+		// local addresses are handled before the lookup
+		if rule.Table == tableLocal || rule.Table == 0 {
+			continue
+		}
+
+		if result := r.lookupTable(intfs, rule.Table, dst); result != nil {
+			return result
+		}
+	}
+	return nil
+}
+
+// neighborMAC returns the MAC address of ip in the neighbors of the node
+func neighborMAC(n *graph.Node, ip net.IP) string {
+	var neighbors []neighbor
+	if !decodeField(n, "Neighbors", &neighbors) {
+		return ""
+	}
+
+	for _, neighbor := range neighbors {
+		if a := net.ParseIP(neighbor.IP); a != nil && a.Equal(ip) {
+			return neighbor.MAC
+		}
+	}
+	return ""
+}
+
+// layer2Path walks the layer2 links from the node to find the interface
+// owning ip, returning the nodes crossed to reach it. The interface with
+// the MAC address of the neighbor entry is preferred when known.
+func (r *Resolver) layer2Path(from *graph.Node, ip net.IP, mac string) []*graph.Node {
+	previous := map[graph.Identifier]*graph.Node{from.ID: nil}
+	queue := []*graph.Node{from}
+
+	var target, fallback *graph.Node
+	for depth := 0; depth < maxLayer2Depth && len(queue) > 0 && target == nil; depth++ {
+		var next []*graph.Node
+		for _, node := range queue {
+			for _, edge := range r.Graph.GetNodeEdges(node, layer2Metadata) {
+				id := edge.GetChild()
+				if id == node.ID {
+					id = edge.GetParent()
+				}
+				if _, ok := previous[id]; ok {
+					continue
+				}
+
+				peer := r.Graph.GetNode(id)
+				if peer == nil {
+					continue
+				}
+				previous[id] = node
+				next = append(next, peer)
+
+				if hasIP(peer, ip) {
+					if m, _ := peer.GetFieldString("MAC"); mac == "" || strings.EqualFold(m, mac) {
+						if target == nil {
+							target = peer
+						}
+					} else if fallback == nil {
+						fallback = peer
+					}
+				}
+			}
+		}
+		queue = next
+	}
+
+	if target == nil {
+		target = fallback
+	}
+	if target == nil {
+		return nil
+	}
+
+	var path []*graph.Node
+	for node := target; node != nil && node.ID != from.ID; node = previous[node.ID] {
+		path = append([]*graph.Node{node}, path...)
+	}
+	return path
+}
+
+// Resolve returns the forwarding path of packets sent from the node to the
+// destination. The node is either an interface, whose first address is used
+// as source address, or a namespace. The graph lock has to be held.
+func (r *Resolver) Resolve(from *graph.Node, dst net.IP) (*Route, error) {
+	ns := r.namespace(from)
+	if ns == nil {
+		return nil, fmt.Errorf("Unable to find the namespace of node %s", from.ID)
+	}
+
+	src := firstIP(from, dst)
+	iif := "lo"
+
+	route := &Route{Destination: dst.String(), Hops: []*Hop{{Node: from}}}
+	last := func() *graph.Node {
+		return route.Hops[len(route.Hops)-1].Node
+	}
+
+	for i := 0; i < r.MaxHops; i++ {
+		if last() != from && hasIP(last(), dst) {
+			route.Reached = true
+			return route, nil
+		}
+
+		// local delivery
+		for _, intf := range r.interfaces(ns) {
+			if hasIP(intf, dst) {
+				if intf.ID != last().ID {
+					route.Hops = append(route.Hops, &Hop{Node: intf})
+				}
+				route.Reached = true
+				return route, nil
+			}
+		}
+
+		result := r.lookup(ns, src, dst, iif)
+		if result == nil {
+			route.Error = fmt.Sprintf("No route to %s", dst)
+			return route, nil
+		}
+
+		hop := &Hop{Node: result.intf, Table: result.table, Prefix: result.prefix}
+		if result.intf.ID == last().ID {
+			route.Hops[len(route.Hops)-1] = hop
+		} else {
+			route.Hops = append(route.Hops, hop)
+		}
+
+		nextIP := dst
+		if result.gateway != nil {
+			nextIP = result.gateway
+			hop.Gateway = nextIP.String()
+		}
+		hop.MAC = neighborMAC(result.intf, nextIP)
+
+		path := r.layer2Path(result.intf, nextIP, hop.MAC)
+		if len(path) == 0 {
+			route.Error = fmt.Sprintf("Unable to find %s in the topology", nextIP)
+			return route, nil
+		}
+		for _, node := range path {
+			route.Hops = append(route.Hops, &Hop{Node: node})
+		}
+
+		ingress := last()
+		if ns = r.namespace(ingress); ns == nil {
+			route.Error = fmt.Sprintf("Unable to find the namespace of node %s", ingress.ID)
+			return route, nil
+		}
+		iif, _ = ingress.GetFieldString("Name")
+	}
+
+	route.Error = fmt.Sprintf("Too many hops to reach %s", dst)
+	return route, nil
+}
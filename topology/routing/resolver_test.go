@@ -0,0 +1,383 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package routing
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func jsonValue(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func newNode(g *graph.Graph, parent *graph.Node, m graph.Metadata) *graph.Node {
+	n := g.NewNode(graph.GenID(), m)
+	if parent != nil {
+		topology.AddOwnershipLink(g, parent, n, nil)
+	}
+	return n
+}
+
+// newTestGraph creates a host with a bridge connected to a namespace
+// through a veth pair, a default route via an unknown gateway and a policy
+// rule sending the traffic of the namespace to a gateway of the graph
+func newTestGraph(t *testing.T) (*graph.Graph, map[string]*graph.Node) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	defer g.Unlock()
+
+	nodes := make(map[string]*graph.Node)
+
+	host := newNode(g, nil, graph.Metadata{"Type": "host", "Name": "host",
+		"IPRules": jsonValue(t, `[
+			{"Family": "IPV4", "Priority": 0, "Table": 255},
+			{"Family": "IPV4", "Priority": 100, "Src": "10.0.0.0/24", "IifName": "br0", "Table": 100},
+			{"Family": "IPV4", "Priority": 32766, "Table": 254},
+			{"Family": "IPV4", "Priority": 32767, "Table": 253}
+		]`),
+	})
+	nodes["host"] = host
+
+	nodes["eth0"] = newNode(g, host, graph.Metadata{"Type": "device", "Name": "eth0", "IfIndex": int64(2),
+		"IPV4": []string{"192.168.0.10/24"},
+		"RoutingTable": jsonValue(t, `[{"Id": 254, "Routes": [
+			{"Prefix": "192.168.0.0/24", "Nexthops": [{"IfIndex": 2}]},
+			{"Nexthops": [{"Src": "192.168.0.1", "IfIndex": 2}]}
+		]}]`),
+	})
+
+	nodes["eth1"] = newNode(g, host, graph.Metadata{"Type": "device", "Name": "eth1", "IfIndex": int64(3),
+		"IPV4": []string{"172.16.0.10/24"},
+		"RoutingTable": jsonValue(t, `[
+			{"Id": 254, "Routes": [{"Prefix": "172.16.0.0/24", "Nexthops": [{"IfIndex": 3}]}]},
+			{"Id": 100, "Routes": [{"Nexthops": [{"Src": "172.16.0.1", "IfIndex": 3}]}]}
+		]`),
+		"Neighbors": jsonValue(t, `[{"IP": "172.16.0.1", "MAC": "00:00:00:00:00:02"}]`),
+	})
+
+	nodes["br0"] = newNode(g, host, graph.Metadata{"Type": "bridge", "Name": "br0", "IfIndex": int64(4),
+		"IPV4": []string{"10.0.0.1/24"},
+		"RoutingTable": jsonValue(t, `[{"Id": 254, "Routes": [
+			{"Prefix": "10.0.0.0/24", "Nexthops": [{"IfIndex": 4}]}
+		]}]`),
+	})
+
+	nodes["veth0"] = newNode(g, host, graph.Metadata{"Type": "veth", "Name": "veth0", "IfIndex": int64(5)})
+	topology.AddLayer2Link(g, nodes["br0"], nodes["veth0"], nil)
+
+	ns := newNode(g, host, graph.Metadata{"Type": "netns", "Name": "ns"})
+	nodes["ns"] = ns
+
+	nodes["ns-eth0"] = newNode(g, ns, graph.Metadata{"Type": "veth", "Name": "eth0", "IfIndex": int64(2),
+		"IPV4": []string{"10.0.0.2/24"},
+		"RoutingTable": jsonValue(t, `[{"Id": 254, "Routes": [
+			{"Prefix": "10.0.0.0/24", "Nexthops": [{"IfIndex": 2, "Priority": 10}]},
+			{"Nexthops": [{"Src": "10.0.0.1", "IfIndex": 2}]}
+		]}]`),
+		"Neighbors": jsonValue(t, `[{"IP": "10.0.0.1", "MAC": "00:00:00:00:00:01"}]`),
+	})
+	topology.AddLayer2Link(g, nodes["veth0"], nodes["ns-eth0"], graph.Metadata{"Type": "veth"})
+
+	// two candidates for the gateway, only one with the MAC address of
+	// the neighbor entry
+	gw := newNode(g, nil, graph.Metadata{"Type": "host", "Name": "gw"})
+	nodes["gw-eth0"] = newNode(g, gw, graph.Metadata{"Type": "device", "Name": "eth0", "IfIndex": int64(2),
+		"IPV4": []string{"172.16.0.1/24"}, "MAC": "00:00:00:00:00:02",
+	})
+	other := newNode(g, gw, graph.Metadata{"Type": "device", "Name": "eth1", "IfIndex": int64(3),
+		"IPV4": []string{"172.16.0.1/24"}, "MAC": "00:00:00:00:00:03",
+	})
+	fabric := newNode(g, nil, graph.Metadata{"Type": "switch", "Name": "switch"})
+	nodes["switch"] = fabric
+	topology.AddLayer2Link(g, nodes["eth1"], fabric, nil)
+	topology.AddLayer2Link(g, fabric, other, nil)
+	topology.AddLayer2Link(g, fabric, nodes["gw-eth0"], nil)
+
+	return g, nodes
+}
+
+func checkPath(t *testing.T, route *Route, nodes map[string]*graph.Node, expected ...string) {
+	path := route.Path()
+	if len(path) != len(expected) {
+		var names []string
+		for _, node := range path {
+			name, _ := node.GetFieldString("Name")
+			names = append(names, name)
+		}
+		t.Fatalf("Expected path %v, got %v", expected, names)
+	}
+
+	for i, name := range expected {
+		if path[i].ID != nodes[name].ID {
+			t.Errorf("Expected %s as hop %d, got %s", name, i, path[i].ID)
+		}
+	}
+}
+
+func TestRouteLocalDelivery(t *testing.T) {
+	g, nodes := newTestGraph(t)
+	resolver := NewResolver(g)
+
+	g.RLock()
+	defer g.RUnlock()
+
+	route, err := resolver.Resolve(nodes["ns-eth0"], net.ParseIP("192.168.0.10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !route.Reached || route.Error != "" {
+		t.Errorf("Destination should be reached: %+v", route)
+	}
+	checkPath(t, route, nodes, "ns-eth0", "veth0", "br0", "eth0")
+
+	first := route.Hops[0]
+	if first.Table != 254 || first.Prefix != "" || first.Gateway != "10.0.0.1" || first.MAC != "00:00:00:00:00:01" {
+		t.Errorf("Wrong routing decision %+v", first)
+	}
+
+	// longest prefix match from the host to the namespace
+	if route, err = resolver.Resolve(nodes["host"], net.ParseIP("10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !route.Reached {
+		t.Errorf("Destination should be reached: %+v", route)
+	}
+	checkPath(t, route, nodes, "host", "br0", "veth0", "ns-eth0")
+}
+
+func TestRoutePolicy(t *testing.T) {
+	g, nodes := newTestGraph(t)
+	resolver := NewResolver(g)
+
+	g.RLock()
+	defer g.RUnlock()
+
+	// traffic from the namespace uses the table 100
+	route, err := resolver.Resolve(nodes["ns-eth0"], net.ParseIP("8.8.8.8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if route.Reached || route.Error != "No route to 8.8.8.8" {
+		t.Errorf("Destination should not be reached: %+v", route)
+	}
+	checkPath(t, route, nodes, "ns-eth0", "veth0", "br0", "eth1", "switch", "gw-eth0")
+
+	hop := route.Hops[3]
+	if hop.Table != 100 || hop.Gateway != "172.16.0.1" || hop.MAC != "00:00:00:00:00:02" {
+		t.Errorf("Wrong routing decision %+v", hop)
+	}
+
+	// traffic of the host uses the main table and an unknown gateway
+	if route, err = resolver.Resolve(nodes["eth0"], net.ParseIP("8.8.8.8")); err != nil {
+		t.Fatal(err)
+	}
+
+	if route.Reached || route.Error != "Unable to find 192.168.0.1 in the topology" {
+		t.Errorf("Gateway should not be found: %+v", route)
+	}
+	checkPath(t, route, nodes, "eth0")
+
+	if hop := route.Hops[0]; hop.Table != 254 || hop.Gateway != "192.168.0.1" {
+		t.Errorf("Wrong routing decision %+v", hop)
+	}
+}
+
+// newPolicyGraph creates a router with two uplinks, each one connected to a
+// gateway owning the destination 192.0.2.1. The table 10 routes through the
+// first uplink, the table 20 through the second one, the table 30 has no
+// route to the destination and the table 40 uses a gateway not in the graph.
+func newPolicyGraph(t *testing.T, rules string) (*graph.Graph, map[string]*graph.Node) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	g.Lock()
+	defer g.Unlock()
+
+	nodes := make(map[string]*graph.Node)
+
+	router := newNode(g, nil, graph.Metadata{"Type": "host", "Name": "router", "IPRules": jsonValue(t, rules)})
+	nodes["router"] = router
+
+	nodes["a"] = newNode(g, router, graph.Metadata{"Type": "device", "Name": "a", "IfIndex": int64(2),
+		"IPV4": []string{"10.1.0.1/24"},
+		"RoutingTable": jsonValue(t, `[
+			{"Id": 10, "Routes": [{"Nexthops": [{"Src": "10.1.0.2", "IfIndex": 2}]}]},
+			{"Id": 30, "Routes": [{"Prefix": "203.0.113.0/24", "Nexthops": [{"Src": "10.1.0.2", "IfIndex": 2}]}]},
+			{"Id": 40, "Routes": [{"Nexthops": [{"Src": "10.1.0.99", "IfIndex": 2}]}]}
+		]`),
+	})
+
+	nodes["b"] = newNode(g, router, graph.Metadata{"Type": "device", "Name": "b", "IfIndex": int64(3),
+		"IPV4": []string{"10.2.0.1/24"},
+		"RoutingTable": jsonValue(t, `[
+			{"Id": 20, "Routes": [{"Nexthops": [{"Src": "10.2.0.2", "IfIndex": 3}]}]}
+		]`),
+	})
+
+	for name, addr := range map[string]string{"a": "10.1.0.2/24", "b": "10.2.0.2/24"} {
+		gw := newNode(g, nil, graph.Metadata{"Type": "host", "Name": "host-" + name})
+		nodes["gw-"+name] = newNode(g, gw, graph.Metadata{"Type": "device", "Name": "eth0", "IfIndex": int64(2),
+			"IPV4": []string{addr, "192.0.2.1/32"},
+		})
+		topology.AddLayer2Link(g, nodes[name], nodes["gw-"+name], nil)
+	}
+
+	return g, nodes
+}
+
+func TestRouteRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		path  []string
+		table int64
+		err   string
+	}{
+		{
+			name:  "lowest priority first",
+			rules: `[{"Priority": 200, "Table": 20}, {"Priority": 100, "Table": 10}]`,
+			path:  []string{"router", "a", "gw-a"},
+			table: 10,
+		},
+		{
+			name:  "no route in table",
+			rules: `[{"Priority": 100, "Table": 30}, {"Priority": 200, "Table": 20}]`,
+			path:  []string{"router", "b", "gw-b"},
+			table: 20,
+		},
+		{
+			name:  "rule not matching",
+			rules: `[{"Priority": 100, "Dst": "203.0.113.0/24", "Table": 10}, {"Priority": 200, "Table": 20}]`,
+			path:  []string{"router", "b", "gw-b"},
+			table: 20,
+		},
+		{
+			name:  "goto",
+			rules: `[{"Priority": 50, "Goto": 150}, {"Priority": 100, "Table": 10}, {"Priority": 150, "Table": 20}]`,
+			path:  []string{"router", "b", "gw-b"},
+			table: 20,
+		},
+		{
+			name:  "goto not matching",
+			rules: `[{"Priority": 50, "Dst": "203.0.113.0/24", "Goto": 150}, {"Priority": 100, "Table": 10}, {"Priority": 150, "Table": 20}]`,
+			path:  []string{"router", "a", "gw-a"},
+			table: 10,
+		},
+		{
+			name:  "no route",
+			rules: `[{"Priority": 100, "Table": 30}]`,
+			path:  []string{"router"},
+			err:   "No route to 192.0.2.1",
+		},
+		{
+			name:  "goto past all the routes",
+			rules: `[{"Priority": 50, "Goto": 300}, {"Priority": 100, "Table": 10}, {"Priority": 200, "Table": 20}]`,
+			path:  []string{"router"},
+			err:   "No route to 192.0.2.1",
+		},
+		{
+			name:  "unknown gateway",
+			rules: `[{"Priority": 100, "Table": 40}]`,
+			path:  []string{"router", "a"},
+			table: 40,
+			err:   "Unable to find 10.1.0.99 in the topology",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, nodes := newPolicyGraph(t, test.rules)
+
+			g.RLock()
+			defer g.RUnlock()
+
+			route, err := NewResolver(g).Resolve(nodes["router"], net.ParseIP("192.0.2.1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if route.Error != test.err || route.Reached != (test.err == "") {
+				t.Errorf("Expected error %q, got: %+v", test.err, route)
+			}
+			checkPath(t, route, nodes, test.path...)
+
+			if len(route.Hops) > 1 && route.Hops[1].Table != test.table {
+				t.Errorf("Expected table %d, got %d", test.table, route.Hops[1].Table)
+			}
+		})
+	}
+}
+
+func TestRouteLoop(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraphFromConfig(b)
+
+	// two hosts using each other as default gateway
+	g.Lock()
+	nodes := make(map[string]*graph.Node)
+	for _, peer := range [][]string{{"x", "10.3.0.1", "10.3.0.2"}, {"y", "10.3.0.2", "10.3.0.1"}} {
+		host := newNode(g, nil, graph.Metadata{"Type": "host", "Name": peer[0]})
+		nodes[peer[0]] = newNode(g, host, graph.Metadata{"Type": "device", "Name": "eth0", "IfIndex": int64(2),
+			"IPV4":         []string{peer[1] + "/24"},
+			"RoutingTable": jsonValue(t, `[{"Id": 254, "Routes": [{"Nexthops": [{"Src": "`+peer[2]+`", "IfIndex": 2}]}]}]`),
+		})
+	}
+	topology.AddLayer2Link(g, nodes["x"], nodes["y"], nil)
+	g.Unlock()
+
+	g.RLock()
+	defer g.RUnlock()
+
+	for _, maxHops := range []int{1, 4, DefaultMaxHops} {
+		resolver := NewResolver(g)
+		resolver.MaxHops = maxHops
+
+		route, err := resolver.Resolve(nodes["x"], net.ParseIP("192.0.2.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if route.Reached || route.Error != "Too many hops to reach 192.0.2.1" {
+			t.Errorf("Expected the loop to be detected: %+v", route)
+		}
+
+		// each namespace crossed adds the interface of the next one
+		if len(route.Hops) != maxHops+1 {
+			t.Errorf("Expected %d hops, got %d", maxHops+1, len(route.Hops))
+		}
+	}
+}
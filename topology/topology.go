@@ -35,12 +35,14 @@ import (
 const (
 	OwnershipLink = "ownership"
 	Layer2Link    = "layer2"
+	TunnelLink    = "tunnel"
 )
 
 // Describe the relation type between nodes in the graph
 var (
 	OwnershipMetadata = graph.Metadata{"RelationType": OwnershipLink}
 	Layer2Metadata    = graph.Metadata{"RelationType": Layer2Link}
+	TunnelMetadata    = graph.Metadata{"RelationType": TunnelLink}
 )
 
 // NamespaceFromNode returns the namespace name and the path of a node in the graph
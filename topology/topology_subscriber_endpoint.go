@@ -38,6 +38,7 @@ type topologySubscriber struct {
 	graph         *graph.Graph
 	gremlinFilter string
 	ts            *traversal.GremlinTraversalSequence
+	incremental   *traversal.IncrementalQuery
 }
 
 // TopologySubscriberEndpoint sends all the modifications to its subscribers.
@@ -49,6 +50,7 @@ type TopologySubscriberEndpoint struct {
 	wg            sync.WaitGroup
 	gremlinParser *traversal.GremlinTraversalParser
 	subscribers   map[string]*topologySubscriber
+	partial       map[string]bool
 }
 
 func (t *TopologySubscriberEndpoint) getGraph(gremlinQuery string, ts *traversal.GremlinTraversalSequence, lockGraph bool) (*graph.Graph, error) {
@@ -76,11 +78,38 @@ func (t *TopologySubscriberEndpoint) newTopologySubscriber(host string, gremlinF
 		return nil, err
 	}
 
-	return &topologySubscriber{graph: g, ts: ts, gremlinFilter: gremlinFilter}, nil
+	subscriber := &topologySubscriber{graph: g, ts: ts, gremlinFilter: gremlinFilter}
+
+	// filters of the form G.V().Has(...).SubGraph() are evaluated only
+	// against the elements touched by the graph events
+	if q := traversal.NewIncrementalQuery(ts); q != nil && q.SubGraph() {
+		if lockGraph {
+			t.Graph.RLock()
+			defer t.Graph.RUnlock()
+		}
+
+		q.Reset(t.Graph)
+		subscriber.incremental = q
+	}
+
+	return subscriber, nil
 }
 
 // OnConnected called when a subscriber got connected.
 func (t *TopologySubscriberEndpoint) OnConnected(c shttp.WSSpeaker) {
+	// clients have to ask for partial updates of the metadata, otherwise
+	// the whole updated node or edge is sent
+	partial := c.GetHeaders().Get("X-Graph-Partial-Updates")
+	if partial == "" {
+		partial = c.GetURL().Query().Get("x-graph-partial-updates")
+	}
+
+	if partial == "true" {
+		t.Lock()
+		t.partial[c.GetHost()] = true
+		t.Unlock()
+	}
+
 	gremlinFilter := c.GetHeaders().Get("X-Gremlin-Filter")
 	if gremlinFilter == "" {
 		gremlinFilter = c.GetURL().Query().Get("x-gremlin-filter")
@@ -102,11 +131,13 @@ func (t *TopologySubscriberEndpoint) OnConnected(c shttp.WSSpeaker) {
 func (t *TopologySubscriberEndpoint) OnDisconnected(c shttp.WSSpeaker) {
 	t.Lock()
 	delete(t.subscribers, c.GetHost())
+	delete(t.partial, c.GetHost())
 	t.Unlock()
 }
 
 // OnWSJSONMessage is triggered when receiving a message from a subscriber.
-// It only responds to SyncRequestMsgType messages
+// It only responds to SyncRequestMsgType messages and to the requests of the
+// whole elements a subscriber missed partial updates of.
 func (t *TopologySubscriberEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *shttp.WSJSONMessage) {
 	msgType, obj, err := graph.UnmarshalWSMessage(msg)
 
@@ -115,6 +146,15 @@ func (t *TopologySubscriberEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *sht
 		return
 	}
 
+	if msgType == graph.NodeRequestMsgType || msgType == graph.EdgeRequestMsgType {
+		t.Graph.RLock()
+		if reply := graph.NewElementReplyMsg(t.Graph, msgType, obj.(graph.ElementRequestMsg)); reply != nil {
+			c.SendMessage(reply)
+		}
+		t.Graph.RUnlock()
+		return
+	}
+
 	// this kind of message usually comes from external clients like the WebUI
 	if msgType == graph.SyncRequestMsgType {
 		t.Graph.RLock()
@@ -150,14 +190,20 @@ func (t *TopologySubscriberEndpoint) OnWSJSONMessage(c shttp.WSSpeaker, msg *sht
 
 // notifyClients forwards local graph modification to subscribers. If a subscriber
 // specified a Gremlin filter, a 'Diff' is applied between the previous graph state
-// for this subscriber and the current graph state.
-func (t *TopologySubscriberEndpoint) notifyClients(msg *shttp.WSJSONMessage) {
+// for this subscriber and the current graph state, unless the filter can be
+// evaluated incrementally against the element of the event. The partial
+// message, if any, is sent instead of msg to the subscribers supporting it.
+func (t *TopologySubscriberEndpoint) notifyClients(msg *shttp.WSJSONMessage, partialMsg *shttp.WSJSONMessage, update func(q *traversal.IncrementalQuery) traversal.IncrementalDelta) {
 	for _, c := range t.pool.GetSpeakers() {
 		t.RLock()
 		subscriber, found := t.subscribers[c.GetHost()]
+		partial := t.partial[c.GetHost()]
 		t.RUnlock()
 
-		if found {
+		if found && subscriber.incremental != nil {
+			delta := update(subscriber.incremental)
+			t.sendDelta(c, delta.AddedNodes, delta.RemovedNodes, delta.AddedEdges, delta.RemovedEdges)
+		} else if found {
 			g, err := t.getGraph(subscriber.gremlinFilter, subscriber.ts, false)
 			if err != nil {
 				logging.GetLogger().Error(err)
@@ -165,58 +211,92 @@ func (t *TopologySubscriberEndpoint) notifyClients(msg *shttp.WSJSONMessage) {
 			}
 
 			addedNodes, removedNodes, addedEdges, removedEdges := subscriber.graph.Diff(g)
-
-			for _, n := range addedNodes {
-				c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeAddedMsgType, n))
-			}
-
-			for _, n := range removedNodes {
-				c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeDeletedMsgType, n))
-			}
-
-			for _, e := range addedEdges {
-				c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeAddedMsgType, e))
-			}
-
-			for _, e := range removedEdges {
-				c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeDeletedMsgType, e))
-			}
-
+			t.sendDelta(c, addedNodes, removedNodes, addedEdges, removedEdges)
 			subscriber.graph = g
+		} else if partial && partialMsg != nil {
+			c.SendMessage(partialMsg)
 		} else {
 			c.SendMessage(msg)
 		}
 	}
 }
 
+func (t *TopologySubscriberEndpoint) sendDelta(c shttp.WSSpeaker, addedNodes []*graph.Node, removedNodes []*graph.Node, addedEdges []*graph.Edge, removedEdges []*graph.Edge) {
+	for _, n := range addedNodes {
+		c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeAddedMsgType, n))
+	}
+
+	for _, n := range removedNodes {
+		c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeDeletedMsgType, n))
+	}
+
+	for _, e := range addedEdges {
+		c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeAddedMsgType, e))
+	}
+
+	for _, e := range removedEdges {
+		c.SendMessage(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeDeletedMsgType, e))
+	}
+}
+
 // OnNodeUpdated graph node updated event. Implements the GraphEventListener interface.
 func (t *TopologySubscriberEndpoint) OnNodeUpdated(n *graph.Node) {
-	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeUpdatedMsgType, n))
+	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeUpdatedMsgType, n), nil, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnNodeUpdated(t.Graph, n)
+	})
+}
+
+// OnNodePartiallyUpdated graph node partially updated event. Implements the GraphPartialUpdateListener interface.
+func (t *TopologySubscriberEndpoint) OnNodePartiallyUpdated(n *graph.Node, ops []graph.PartialUpdate) {
+	msg := shttp.NewWSJSONMessage(graph.Namespace, graph.NodeUpdatedMsgType, n)
+	partialMsg := shttp.NewWSJSONMessage(graph.Namespace, graph.NodePartiallyUpdatedMsgType, graph.NewPartiallyUpdatedMsg(n, ops))
+	t.notifyClients(msg, partialMsg, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnNodeUpdated(t.Graph, n)
+	})
 }
 
 // OnNodeAdded graph node added event. Implements the GraphEventListener interface.
 func (t *TopologySubscriberEndpoint) OnNodeAdded(n *graph.Node) {
-	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeAddedMsgType, n))
+	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeAddedMsgType, n), nil, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnNodeAdded(t.Graph, n)
+	})
 }
 
 // OnNodeDeleted graph node deleted event. Implements the GraphEventListener interface.
 func (t *TopologySubscriberEndpoint) OnNodeDeleted(n *graph.Node) {
-	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeDeletedMsgType, n))
+	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.NodeDeletedMsgType, n), nil, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnNodeDeleted(t.Graph, n)
+	})
 }
 
 // OnEdgeUpdated graph edge updated event. Implements the GraphEventListener interface.
 func (t *TopologySubscriberEndpoint) OnEdgeUpdated(e *graph.Edge) {
-	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeUpdatedMsgType, e))
+	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeUpdatedMsgType, e), nil, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnEdgeUpdated(t.Graph, e)
+	})
+}
+
+// OnEdgePartiallyUpdated graph edge partially updated event. Implements the GraphPartialUpdateListener interface.
+func (t *TopologySubscriberEndpoint) OnEdgePartiallyUpdated(e *graph.Edge, ops []graph.PartialUpdate) {
+	msg := shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeUpdatedMsgType, e)
+	partialMsg := shttp.NewWSJSONMessage(graph.Namespace, graph.EdgePartiallyUpdatedMsgType, graph.NewPartiallyUpdatedMsg(e, ops))
+	t.notifyClients(msg, partialMsg, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnEdgeUpdated(t.Graph, e)
+	})
 }
 
 // OnEdgeAdded graph edge added event. Implements the GraphEventListener interface.
 func (t *TopologySubscriberEndpoint) OnEdgeAdded(e *graph.Edge) {
-	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeAddedMsgType, e))
+	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeAddedMsgType, e), nil, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnEdgeAdded(t.Graph, e)
+	})
 }
 
 // OnEdgeDeleted graph edge deleted event. Implements the GraphEventListener interface.
 func (t *TopologySubscriberEndpoint) OnEdgeDeleted(e *graph.Edge) {
-	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeDeletedMsgType, e))
+	t.notifyClients(shttp.NewWSJSONMessage(graph.Namespace, graph.EdgeDeletedMsgType, e), nil, func(q *traversal.IncrementalQuery) traversal.IncrementalDelta {
+		return q.OnEdgeDeleted(t.Graph, e)
+	})
 }
 
 // NewTopologySubscriberEndpoint returns a new server to be used by external subscribers,
@@ -226,6 +306,7 @@ func NewTopologySubscriberEndpoint(pool shttp.WSJSONSpeakerPool, auth *shttp.Aut
 		Graph:         g,
 		pool:          pool,
 		subscribers:   make(map[string]*topologySubscriber),
+		partial:       make(map[string]bool),
 		gremlinParser: traversal.NewGremlinTraversalParser(),
 	}
 
@@ -87,6 +87,9 @@ func isGremlinExpr(v interface{}, param string) error {
 	tr := traversal.NewGremlinTraversalParser()
 	tr.AddTraversalExtension(ge.NewMetricsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewFlowTraversalExtension(nil, nil))
+	tr.AddTraversalExtension(ge.NewOfRuleTraversalExtension())
+	tr.AddTraversalExtension(ge.NewRouteToTraversalExtension())
+	tr.AddTraversalExtension(ge.NewCorrelatedTraversalExtension(nil))
 
 	if _, err := tr.Parse(strings.NewReader(query)); err != nil {
 		return GremlinNotValid(err)